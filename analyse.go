@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"file-inspector/files"
+)
+
+// analyseFile runs a file through the same steps for both the UI and the command line:
+// process it as whatever its content says it is, then get its properties from the result.
+// An error is only returned if we couldn't get as far as processing the file
+func analyseFile(ctx context.Context, filePath string) (*files.FileProperties, *files.ProcessResult, error) {
+	// process the file
+	result := files.ProcessFileContext(ctx, filePath)
+
+	// get the file properties
+	properties, err := files.GetResultProperties(filePath, result)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if result.Error != nil {
+		log.Printf("Processing complete with error: %q\n", result.Error.Error())
+	}
+
+	log.Println("File processing done")
+
+	return properties, result, nil
+}
+
+// isUnsupported returns true if the result is for a file type we don't have an analyzer for
+func isUnsupported(result *files.ProcessResult) bool {
+	return errors.Is(result.Error, files.ErrUnsupportedFileType)
+}
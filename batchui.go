@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"file-inspector/files/batch"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	scanWindowWidth  = 1200
+	scanWindowHeight = 800
+
+	scanDetailText = "Select a file to see its analysis..."
+)
+
+// columns of the scan results table, the first row is the headings
+var scanColumns = []struct {
+	heading string
+	width   float32
+	sortBy  batch.SortColumn
+}{
+	{"Path", 350, batch.SortPath},
+	{"Verdict", 100, batch.SortScore},
+	{"Score", 70, batch.SortScore},
+	{"Type", 200, batch.SortType},
+	{"SHA256", 200, batch.SortHash},
+	{"Top Findings", 500, batch.SortScore},
+}
+
+func onSelectFolderButtonClicked() {
+	log.Println("Select folder was clicked!")
+
+	dialog.ShowFolderOpen(onFolderChosen, window)
+}
+
+func onFolderChosen(uri fyne.ListableURI, err error) {
+	if err != nil {
+		log.Printf("Error from folder picker: %s\n", err.Error())
+		return
+	}
+	if uri == nil {
+		log.Println("Nil result from folder picker")
+		return
+	}
+
+	log.Printf("chosen folder: %v", uri)
+
+	// lock the buttons until the scan is done
+	openButton.Disable()
+	selectFolderButton.Disable()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// cancelling shows the results for the files done so far
+	progressBar := widget.NewProgressBar()
+	progress := launchProgressDialog("Scanning folder...", progressBar, &window, cancel)
+
+	// folders can take a while, so don't block the UI
+	go func() {
+		defer cancel()
+
+		summary, err := batch.Scan(ctx, uri.Path(), batch.DefaultWorkers, func(done, total int) {
+			progressBar.SetValue(float64(done) / float64(total))
+		})
+
+		progress.Hide()
+		openButton.Enable()
+		selectFolderButton.Enable()
+
+		if summary == nil {
+			launchErrorDialog(err, window)
+			return
+		}
+
+		log.Println(summary.String())
+		recordScanHistory(summary)
+		showScanResults(summary)
+	}()
+}
+
+// showScanResults opens a window with a sortable table of the files scanned, and
+// the full analysis of whichever file is selected
+func showScanResults(summary *batch.Summary) {
+	resultsWindow := fyne.CurrentApp().NewWindow(fmt.Sprintf("Scan Results - %s", summary.Root))
+
+	detailBox := getScrollContainer(scanDetailText, binding.NewString())
+	detailText := detailBox.Content.(*widget.Label)
+
+	sortColumn := batch.SortPath
+	descending := false
+
+	var table *widget.Table
+
+	table = widget.NewTable(
+		func() (int, int) {
+			return len(summary.Items) + 1, len(scanColumns)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("Results")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+
+			if id.Row == 0 {
+				label.TextStyle.Bold = true
+				label.SetText(scanColumns[id.Col].heading)
+				return
+			}
+
+			label.TextStyle.Bold = false
+			label.SetText(getScanCellText(summary.Items[id.Row-1], id.Col))
+		},
+	)
+
+	for i, column := range scanColumns {
+		table.SetColumnWidth(i, column.width)
+	}
+
+	table.OnSelected = func(id widget.TableCellID) {
+		// clicking a heading sorts by it, clicking it again reverses the order
+		if id.Row == 0 {
+			column := scanColumns[id.Col].sortBy
+
+			if column == sortColumn {
+				descending = !descending
+			} else {
+				sortColumn = column
+				descending = false
+			}
+
+			summary.Sort(sortColumn, descending)
+			table.UnselectAll()
+			table.Refresh()
+			detailText.SetText(scanDetailText)
+			return
+		}
+
+		item := summary.Items[id.Row-1]
+
+		if item.Result.Error != nil {
+			detailText.SetText(fmt.Sprintf("%s\n\nError: %s\n\n%s", item.Path, item.Result.Error.Error(), item.Result.Analysis))
+		} else {
+			detailText.SetText(fmt.Sprintf("%s\n\n%s", item.Path, item.Result.Analysis))
+		}
+	}
+
+	split := container.NewVSplit(table, detailBox)
+	split.Offset = 0.6
+
+	content := container.NewBorder(widget.NewLabel(summary.String()), nil, nil, nil, split)
+
+	resultsWindow.SetContent(content)
+	resultsWindow.Resize(fyne.NewSize(scanWindowWidth, scanWindowHeight))
+	resultsWindow.Show()
+}
+
+func getScanCellText(item *batch.Item, column int) string {
+	switch scanColumns[column].heading {
+	case "Path":
+		return item.Path
+	case "Verdict":
+		return item.Verdict()
+	case "Score":
+		return strconv.Itoa(max(item.Score(), 0))
+	case "Type":
+		return item.MimeType
+	case "SHA256":
+		return item.SHA256
+	default:
+		var top []string
+
+		for _, f := range item.TopFindings(scanTopFindings) {
+			top = append(top, f.String())
+		}
+
+		return strings.Join(top, "; ")
+	}
+}
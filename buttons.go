@@ -1,10 +1,12 @@
 package main
 
 import (
-	"file-inspector/files"
+	"context"
 	"fmt"
 	"log"
-	"path/filepath"
+
+	"file-inspector/files"
+	"file-inspector/files/verdict"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/dialog"
@@ -42,80 +44,107 @@ func onFileChosen(f fyne.URIReadCloser, err error) {
 	log.Printf("chosen: %v", f.URI())
 
 	filePathString := f.URI().Path()
-	fileExtension := filepath.Ext(filePathString)
 
-	// check chosen file
-	if !fileOkayToProcess(fileExtension) {
-		launchInfoDialog("Unsupported File Type", "File is not currently supported", &window)
-		openButton.Enable()
-		return
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// launch progress dialog, cancelling it stops the analysis and shows what we have so far
+	progress := launchProcessingDialog(&window, cancel)
+
+	// process in the background so the UI stays responsive
+	go func() {
+		defer cancel()
 
-	// launch progress bad dialog
-	progress := launchProcessingDialog(&window)
+		// get the file properties and process the file, keeping the attachments to extract
+		properties, result, err := analyseFile(files.WithAttachmentData(ctx), filePathString)
 
-	// get and set the file properties
-	properties, err := files.GetFileProperties(filePathString)
+		progress.Hide()
+		showFileResult(properties, result, err)
+		openButton.Enable()
+	}()
+}
 
+// showFileResult fills in the UI from the analysis
+func showFileResult(properties *files.FileProperties, result *files.ProcessResult, err error) {
 	if err != nil {
 		analysisTextBS.Set(fmt.Sprintf("Error processing file: %q\n", err.Error()))
 		errorLabel.Show()
 		errorIcon.Show()
 	} else {
+		// keep them for the report
+		reportProperties = properties
+		reportResult = result
+		saveReportButton.Enable()
+		compareButton.Enable()
+		recordHistory(properties, result)
+
 		// set the values
 		fileNameBS.Set(properties.FileName)
 		fileTypeBS.Set(properties.FileType)
 		fileHashBS.Set(properties.Hash)
+		fileMD5BS.Set(properties.MD5)
+		fileSHA1BS.Set(properties.SHA1)
+		fileSHA512BS.Set(properties.SHA512)
+		fileSSDeepBS.Set(properties.SSDeep)
+		fileTLSHBS.Set(properties.TLSH)
 		fileSizeBS.Set(properties.Size)
 
-		matches, explanation := files.CheckMime(fileExtension, properties.FileType)
-
-		if !matches {
-			showIconAndLabel(dangerIcon, dangerLabel, dangerSeparator)
-
-			log.Printf("Mismatched extension and MIME type. %s", explanation)
-			analysisTextBS.Set(fmt.Sprintf("Mismatched extension and MIME type.\n\n%s", explanation))
-
-			// tidy up and return, as we don't want to parse the file as the wrong type
-			progress.Hide()
-			openButton.Enable()
-			return
-		}
-
-		// process the file and show the analysis
-		result := files.ProcessFile(filePathString)
-
 		if result.Completed {
 			showIconAndLabel(processedIcon, processedLabel, processedSeparator)
 		}
 
-		if result.Error != nil {
-			log.Printf("Processing complete with error: %q\n", result.Error.Error())
-
+		if isUnsupported(result) {
+			launchInfoDialog("Unsupported File Type", result.Error.Error(), &window)
+		} else if result.TimedOut {
+			launchInfoDialog("Analysis Timed Out", fmt.Sprintf("The analysis ran out of time, so the results are incomplete.\n\n%s", result.Error.Error()), &window)
+			showIconAndLabel(errorIcon, errorLabel, errorSeparator)
+		} else if result.LimitExceeded {
+			launchInfoDialog("Resource Limit Exceeded", "The file reached a resource limit, e.g. it decompresses to too much data, so the results are incomplete. See the findings for details.", &window)
+			showIconAndLabel(errorIcon, errorLabel, errorSeparator)
+		} else if result.IsCancelled() {
+			launchInfoDialog("Analysis Cancelled", "The analysis was cancelled, so the results are incomplete.", &window)
+			showIconAndLabel(errorIcon, errorLabel, errorSeparator)
+		} else if result.Error != nil {
 			// notify the user
 			launchErrorDialog(result.Error, window)
 			analysisTextBS.Set(result.Error.Error())
 			showIconAndLabel(errorIcon, errorLabel, errorSeparator)
 		}
 
-		log.Println("File processing done")
-
 		// update the table
 		metadataTableData = append(metadataTableData, result.Metadata...)
 		metadataTable.Refresh()
 
+		// update the indicators
+		iocTableData = getIOCTableData(result)
+		iocTable.Refresh()
+
+		// update the attachment tree
+		attachmentResults = result.Children
+		extractSelection = map[string]bool{}
+		attachmentTree.Refresh()
+		attachmentTree.OpenAllBranches()
+
+		if len(result.Children) > 0 {
+			extractButton.Enable()
+		}
+
 		// update the analysis box
 		analysisTextBS.Set(result.Analysis)
 
+		if result.Assessment != nil {
+			verdictBS.Set(result.Assessment.String())
+
+			if result.Assessment.Verdict == verdict.Suspicious {
+				showIconAndLabel(suspiciousIcon, suspiciousLabel, suspiciousSeparator)
+			}
+		}
+
 		// set the flags
 		if result.Dangerous {
 			showIconAndLabel(dangerIcon, dangerLabel, dangerSeparator)
 			launchInfoDialog("Potentially Dangerous File", "Warning: dangerous file found", &window)
 		}
 	}
-
-	progress.Hide()
-	openButton.Enable()
 }
 
 func onResetButtonClicked() {
@@ -127,14 +156,33 @@ func onResetButtonClicked() {
 		{"Field", "Value"},
 	}
 	metadataTable.Refresh()
+	iocTableData = getIOCTableData(nil)
+	iocTable.Refresh()
+	attachmentResults = nil
+	extractSelection = map[string]bool{}
+	extractButton.Disable()
+	reportProperties = nil
+	reportResult = nil
+	saveReportButton.Disable()
+	compareButton.Disable()
+	notesButton.Disable()
+	attachmentTree.Refresh()
 	fileNameBS.Set("")
 	fileTypeBS.Set("")
 	fileHashBS.Set("")
+	fileMD5BS.Set("")
+	fileSHA1BS.Set("")
+	fileSHA512BS.Set("")
+	fileSSDeepBS.Set("")
+	fileTLSHBS.Set("")
 	fileSizeBS.Set("")
+	verdictBS.Set("")
+	historyBS.Set("")
 
 	// clear and hide icons
 	iconSeparator.Hide()
 	hideIconAndLabel(processedIcon, processedLabel, processedSeparator)
 	hideIconAndLabel(errorIcon, errorLabel, errorSeparator)
+	hideIconAndLabel(suspiciousIcon, suspiciousLabel, suspiciousSeparator)
 	hideIconAndLabel(dangerIcon, dangerLabel, dangerSeparator)
 }
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/findings"
+	"file-inspector/files/hashsets"
+	"file-inspector/files/ioc"
+	"file-inspector/files/misp"
+	"file-inspector/files/stix"
+	"file-inspector/files/verdict"
+)
+
+const (
+	analyseCommand = "analyse"
+	scanCommand    = "scan"
+	serveCommand   = "serve"
+	watchCommand   = "watch"
+	extractCommand = "extract"
+	compareCommand = "compare"
+
+	outputJSON  = "json"
+	outputText  = "text"
+	outputTable = "table"
+	outputSTIX  = "stix"
+	outputMISP  = "misp"
+
+	statusCompleted   = "completed"
+	statusError       = "error"
+	statusSuspicious  = "suspicious"
+	statusDangerous   = "dangerous"
+	statusUnsupported = "unsupported"
+	statusTimedOut    = "timed-out"
+	statusLimited     = "limit-exceeded"
+)
+
+// Exit codes for the command line, the worst result across all files wins
+const (
+	exitCompleted  = 0
+	exitUsage      = 1
+	exitError      = 2
+	exitSuspicious = 3
+	exitDangerous  = 4
+)
+
+const cliUsage = `Usage:
+  %[1]s                                   launch the user interface
+  %[1]s analyse [options] <path>...       analyse files without the user interface
+  %[1]s scan [options] <folder>           analyse every supported file in a folder
+  %[1]s serve [options]                   analyse files uploaded to a REST API
+  %[1]s watch [options] <folder>...       analyse files dropped into folders, then file them away
+  %[1]s extract [options] <email> [n]...  zip attachments, or those given e.g. 1 2/1, with the password "infected"
+  %[1]s compare [options] <a> <b>         show what two files share and what's unique to each
+
+API, for the serve command:
+  POST /api/files                          upload a file in the "file" multipart form field
+  GET  /api/jobs                           list the jobs
+  GET  /api/jobs/<id>                      the state of a job
+  GET  /api/jobs/<id>/result               the JSON report, once the job is done
+  GET  /api/jobs/<id>/attachments          list the attachments found
+  GET  /api/jobs/<id>/attachments/<path>   download an attachment, e.g. "1/2" for the second attachment of the first
+  GET  /api/history?q=<query>              list the files analysed before
+
+Settings:
+  Defaults for the options, enabled analyzers, severities, domain lists and integrations
+  are read from config.toml in the file-inspector directory of the user's config, e.g.
+  ~/.config/file-inspector/config.toml. Options given on the command line take precedence
+
+Exit codes:
+  0  all files processed and nothing dangerous found
+  1  bad arguments
+  2  at least one file could not be processed, or ran out of time
+  3  at least one file is suspicious, and none are dangerous
+  4  at least one file is potentially dangerous, i.e. its verdict is Malicious
+
+Options:
+`
+
+// fileReport is the machine readable result for a single file
+type fileReport struct {
+	Path          string             `json:"path,omitempty"`
+	Status        string             `json:"status"`
+	FileName      string             `json:"fileName,omitempty"`
+	FileType      string             `json:"fileType,omitempty"`
+	Size          string             `json:"size,omitempty"`
+	MD5           string             `json:"md5,omitempty"`
+	SHA1          string             `json:"sha1,omitempty"`
+	SHA256        string             `json:"sha256,omitempty"`
+	SHA512        string             `json:"sha512,omitempty"`
+	SSDeep        string             `json:"ssdeep,omitempty"`
+	TLSH          string             `json:"tlsh,omitempty"`
+	Parsed        bool               `json:"parsed"`
+	Completed     bool               `json:"completed"`
+	TimedOut      bool               `json:"timedOut,omitempty"`
+	LimitExceeded bool               `json:"limitExceeded,omitempty"`
+	Dangerous     bool               `json:"dangerous"`
+	Score         int                `json:"score"`
+	Verdict       string             `json:"verdict,omitempty"`
+	Signals       []verdict.Signal   `json:"signals,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	Metadata      [][]string         `json:"metadata,omitempty"`
+	Findings      []findings.Finding `json:"findings,omitempty"`
+	Analysis      string             `json:"analysis,omitempty"`
+	IOCs          []ioc.IOC          `json:"iocs,omitempty"`
+
+	// Attachments are the results for each attachment, nested as they are in the file
+	Attachments []*fileReport `json:"attachments,omitempty"`
+
+	// kept for the formats that are built straight from the analysis
+	properties *files.FileProperties
+	result     *files.ProcessResult
+}
+
+// analyseOptions holds the flags for the analyse command
+type analyseOptions struct {
+	format        string
+	verbose       bool
+	weightsPath   string
+	timeout       time.Duration
+	mispUpload    bool
+	rulesDir      string
+	emailRulesDir string
+	allowHashes   string
+	denyHashes    string
+}
+
+// isCommandLine returns true if we've been asked to run a command rather than launch the UI.
+// The only flags are those asking for help, as others are passed by the OS when launching
+// the app, e.g. "-psn_0_12345" on macOS. Other words are commands, so a mistyped one gets
+// the usage rather than the UI
+func isCommandLine(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+
+	switch args[1] {
+	case "-h", "-help", "--help":
+		return true
+	}
+
+	return !strings.HasPrefix(args[1], "-")
+}
+
+// runCommandLine runs the requested subcommand and returns the process exit code
+func runCommandLine(args []string) int {
+	command := args[1]
+
+	// the flags' defaults come from the settings, so they're loaded first
+	if err := loadSettings(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading settings: %s\n", err.Error())
+		return exitUsage
+	}
+
+	switch command {
+	case analyseCommand:
+		return runAnalyseCommand(args[0], args[2:])
+	case scanCommand:
+		return runScanCommand(args[0], args[2:])
+	case serveCommand:
+		return runServeCommand(args[0], args[2:])
+	case watchCommand:
+		return runWatchCommand(args[0], args[2:])
+	case extractCommand:
+		return runExtractCommand(args[0], args[2:])
+	case compareCommand:
+		return runCompareCommand(args[0], args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprintf(os.Stdout, cliUsage, args[0])
+		newCommandsFlagSet(args[0], os.Stdout).PrintDefaults()
+		return exitCompleted
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		fmt.Fprintf(os.Stderr, cliUsage, args[0])
+		newCommandsFlagSet(args[0], os.Stderr).PrintDefaults()
+		return exitUsage
+	}
+}
+
+// newCommandsFlagSet has the flags for every command, for the usage
+func newCommandsFlagSet(programName string, output io.Writer) *flag.FlagSet {
+	flags := newServeFlagSet(programName, output, &serveOptions{})
+	addWatchFlags(flags, &watchOptions{})
+	addExtractFlags(flags, &extractOptions{})
+
+	return flags
+}
+
+func newAnalyseFlagSet(programName string, output io.Writer, opts *analyseOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(analyseCommand, flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		fmt.Fprintf(output, cliUsage, programName)
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&opts.format, "format", outputText, "output format: json, text, table, stix or misp")
+	flags.BoolVar(&opts.verbose, "v", false, "verbose, write processing logs to stderr")
+	flags.StringVar(&opts.weightsPath, "weights", "", "TOML file of verdict weights and thresholds")
+	flags.DurationVar(&opts.timeout, "timeout", settings.Limits.Timeout, "how long to spend analysing each file, e.g. 10s")
+	flags.BoolVar(&opts.mispUpload, "misp-upload", false, "upload the results as an event to the MISP server in $MISP_URL, using the key in $MISP_API_KEY")
+	flags.StringVar(&opts.rulesDir, "rules", settings.Rules.YARA, "directory of YARA rules to scan with, instead of the rules directory in the user's config")
+	flags.StringVar(&opts.emailRulesDir, "email-rules", settings.Rules.Email, "directory of TOML email rules to check emails with, instead of the email-rules directory in the user's config")
+	flags.StringVar(&opts.allowHashes, "allow-hashes", settings.Rules.AllowHashes, "hash list, or directory of them, of known good files, instead of the hashsets/allow directory in the user's config")
+	flags.StringVar(&opts.denyHashes, "deny-hashes", settings.Rules.DenyHashes, "hash list, or directory of them, of known bad files, instead of the hashsets/deny directory in the user's config")
+
+	return flags
+}
+
+func runAnalyseCommand(programName string, args []string) int {
+	var opts analyseOptions
+	flags := newAnalyseFlagSet(programName, os.Stderr, &opts)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if !isOutputFormat(opts.format) {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", opts.format)
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No files provided to analyse")
+		flags.Usage()
+		return exitUsage
+	}
+
+	if err := applyAnalyseOptions(&opts); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	// stop on Ctrl-C, reporting on what we've done so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var reports []*fileReport
+	exitCode := exitCompleted
+
+	for _, filePath := range flags.Args() {
+		if ctx.Err() != nil {
+			break
+		}
+
+		report := buildFileReport(ctx, filePath)
+		reports = append(reports, report)
+		exitCode = worstExitCode(exitCode, statusExitCode(report.Status))
+	}
+
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Analysis stopped early: %s\n", err.Error())
+		exitCode = worstExitCode(exitCode, exitError)
+	}
+
+	var err error
+
+	switch opts.format {
+	case outputJSON:
+		err = writeJSONReports(os.Stdout, reports)
+	case outputTable:
+		err = writeTableReports(os.Stdout, reports)
+	case outputSTIX:
+		err = writeSTIXReports(os.Stdout, reports)
+	case outputMISP:
+		err = buildMISPEvent(reports).Write(os.Stdout)
+	default:
+		err = writeTextReports(os.Stdout, reports)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %s\n", err.Error())
+		return exitError
+	}
+
+	if opts.mispUpload {
+		exitCode = worstExitCode(exitCode, uploadToMISP(reports))
+	}
+
+	return exitCode
+}
+
+func isOutputFormat(format string) bool {
+	switch format {
+	case outputJSON, outputText, outputTable, outputSTIX, outputMISP:
+		return true
+	default:
+		return false
+	}
+}
+
+// applyAnalyseOptions sets up the pipeline from the options shared by the commands
+func applyAnalyseOptions(opts *analyseOptions) error {
+	if opts.weightsPath != "" {
+		config, err := verdict.LoadConfig(opts.weightsPath)
+
+		if err != nil {
+			return err
+		}
+
+		files.SetVerdictConfig(config)
+	}
+
+	if opts.timeout <= 0 {
+		return fmt.Errorf("timeout must be more than zero, not %s", opts.timeout)
+	}
+
+	files.SetDefaultAnalyzerTimeout(opts.timeout)
+
+	// the parsers log as they go, which we don't want mixed in with the output
+	if !opts.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	if err := loadRules(ruleKinds.yara, opts.rulesDir); err != nil {
+		return err
+	}
+
+	if err := loadRules(ruleKinds.email, opts.emailRulesDir); err != nil {
+		return err
+	}
+
+	if err := loadRules(ruleKinds.allow, opts.allowHashes); err != nil {
+		return err
+	}
+
+	return loadRules(ruleKinds.deny, opts.denyHashes)
+}
+
+// ruleKind is a kind of rules we load, from a directory in the user's config by default
+type ruleKind struct {
+	name       string
+	defaultDir func() (string, error)
+	load       func(dir string) (int, error)
+}
+
+var ruleKinds = struct {
+	yara  ruleKind
+	email ruleKind
+	allow ruleKind
+	deny  ruleKind
+}{
+	yara:  ruleKind{name: "YARA rules", defaultDir: files.DefaultRulesDir, load: files.LoadRules},
+	email: ruleKind{name: "email rules", defaultDir: files.DefaultEmailRulesDir, load: files.LoadEmailRules},
+	allow: hashListKind(hashsets.Allow),
+	deny:  hashListKind(hashsets.Deny),
+}
+
+// hashListKind loads allow or deny hash lists, which can be a single file as well as a directory
+func hashListKind(kind hashsets.Kind) ruleKind {
+	return ruleKind{
+		name: fmt.Sprintf("%s hash lists", kind),
+		defaultDir: func() (string, error) {
+			return files.DefaultHashListDir(kind)
+		},
+		load: func(path string) (int, error) {
+			return files.LoadHashLists(path, kind)
+		},
+	}
+}
+
+// existingDefaultDir returns the default directory for the rules, or "" if it doesn't exist
+func (k ruleKind) existingDefaultDir() string {
+	dir, err := k.defaultDir()
+
+	if err != nil {
+		return ""
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return ""
+	}
+
+	return dir
+}
+
+// loadRules loads the rules in the directory, or the default one if it exists. Rules
+// files with errors are skipped with a warning, it's only an error if nothing loads
+func loadRules(kind ruleKind, dir string) error {
+	if dir == "" {
+		if dir = kind.existingDefaultDir(); dir == "" {
+			return nil
+		}
+	}
+
+	count, err := kind.load(dir)
+
+	if err != nil && count == 0 {
+		return fmt.Errorf("error loading %s from %q: %s", kind.name, dir, err.Error())
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Skipped some %s: %s\n", kind.name, err.Error())
+	}
+
+	return nil
+}
+
+// buildFileReport runs the file through the pipeline and collects the results
+func buildFileReport(ctx context.Context, filePath string) *fileReport {
+	report := &fileReport{
+		Path: filePath,
+	}
+
+	properties, result, err := analyseFile(ctx, filePath)
+
+	if err != nil {
+		report.Status = statusError
+		report.Error = err.Error()
+		return report
+	}
+
+	report.FileName = properties.FileName
+	report.FileType = properties.FileType
+	report.Size = properties.Size
+	report.MD5 = properties.MD5
+	report.SHA1 = properties.SHA1
+	report.SHA256 = properties.Hash
+	report.SHA512 = properties.SHA512
+	report.SSDeep = properties.SSDeep
+	report.TLSH = properties.TLSH
+	report.properties = properties
+
+	addResultToReport(report, result)
+
+	return report
+}
+
+// addResultToReport copies the processing result into the report, including its attachments
+func addResultToReport(report *fileReport, result *files.ProcessResult) {
+	report.result = result
+	report.Parsed = result.Parsed
+	report.Completed = result.Completed
+	report.TimedOut = result.TimedOut
+	report.LimitExceeded = result.LimitExceeded
+	report.Dangerous = result.Dangerous
+	report.Metadata = result.Metadata
+	report.Findings = result.Findings
+	report.IOCs = result.IOCs
+
+	if result.Assessment != nil {
+		report.Score = result.Assessment.Score
+		report.Verdict = string(result.Assessment.Verdict)
+		report.Signals = result.Assessment.Signals
+	}
+	report.Analysis = result.Analysis
+
+	if result.Error != nil {
+		report.Error = result.Error.Error()
+	}
+
+	report.Status = getResultStatus(result)
+
+	if report.Status == statusError && isUnsupported(result) {
+		report.Status = statusUnsupported
+	}
+
+	for _, child := range result.Children {
+		childReport := &fileReport{
+			FileName: child.Name,
+			FileType: child.MimeType,
+			MD5:      child.MD5,
+			SHA1:     child.SHA1,
+			SHA256:   child.SHA256,
+			SHA512:   child.SHA512,
+			SSDeep:   child.SSDeep,
+			TLSH:     child.TLSH,
+		}
+
+		addResultToReport(childReport, child)
+		report.Attachments = append(report.Attachments, childReport)
+	}
+}
+
+// dangerous and suspicious trump errors, as that's what we most need to know about
+func getResultStatus(result *files.ProcessResult) string {
+	if result.Dangerous {
+		return statusDangerous
+	}
+
+	if result.Assessment != nil && result.Assessment.Verdict == verdict.Suspicious {
+		return statusSuspicious
+	}
+
+	if result.TimedOut {
+		return statusTimedOut
+	}
+
+	if result.LimitExceeded {
+		return statusLimited
+	}
+
+	if result.Error != nil || !result.Completed {
+		return statusError
+	}
+
+	return statusCompleted
+}
+
+func statusExitCode(status string) int {
+	switch status {
+	case statusCompleted:
+		return exitCompleted
+	case statusSuspicious:
+		return exitSuspicious
+	case statusDangerous:
+		return exitDangerous
+	default:
+		return exitError
+	}
+}
+
+func worstExitCode(a, b int) int {
+	if b > a {
+		return b
+	}
+
+	return a
+}
+
+func writeJSONReports(w io.Writer, reports []*fileReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(reports)
+}
+
+// writeSTIXReports writes a single STIX bundle covering all the files analysed
+func writeSTIXReports(w io.Writer, reports []*fileReport) error {
+	bundle := stix.NewBundle()
+
+	for _, report := range reports {
+		// files we couldn't open have nothing to share
+		if report.result != nil {
+			bundle.Add(report.properties, report.result)
+		}
+	}
+
+	return bundle.Write(w)
+}
+
+// buildMISPEvent builds a single MISP event covering all the files analysed
+func buildMISPEvent(reports []*fileReport) *misp.Event {
+	event := misp.NewEvent()
+
+	for _, report := range reports {
+		if report.result != nil {
+			event.Add(report.properties, report.result)
+		}
+	}
+
+	return event
+}
+
+// uploadToMISP adds the results as an event on the MISP server, returning the exit code
+func uploadToMISP(reports []*fileReport) int {
+	client, err := misp.NewClient(settings.Integrations.MISP.URL, settings.Integrations.MISP.APIKey)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	id, err := client.Upload(context.Background(), buildMISPEvent(reports))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	fmt.Fprintf(os.Stderr, "Uploaded to MISP as event %s\n", id)
+
+	return exitCompleted
+}
+
+func writeTableReports(w io.Writer, reports []*fileReport) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "PATH\tSTATUS\tSCORE\tTYPE\tSIZE\tSHA256")
+
+	for _, report := range reports {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", report.Path, report.Status, report.Score, report.FileType, report.Size, report.SHA256)
+	}
+
+	return tw.Flush()
+}
+
+func writeTextReports(w io.Writer, reports []*fileReport) error {
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Fprintln(w, strings.Repeat("-", 80))
+		}
+
+		tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "Path:\t%s\n", report.Path)
+		fmt.Fprintf(tw, "Status:\t%s\n", report.Status)
+
+		if report.Verdict != "" {
+			fmt.Fprintf(tw, "Verdict:\t%s (%d/%d)\n", report.Verdict, report.Score, verdict.MaxScore)
+		}
+
+		if report.SHA256 != "" {
+			fmt.Fprintf(tw, "SHA256 Hash:\t%s\n", report.SHA256)
+			fmt.Fprintf(tw, "MD5 Hash:\t%s\n", report.MD5)
+			fmt.Fprintf(tw, "SHA1 Hash:\t%s\n", report.SHA1)
+			fmt.Fprintf(tw, "SHA512 Hash:\t%s\n", report.SHA512)
+
+			if report.SSDeep != "" {
+				fmt.Fprintf(tw, "SSDeep:\t%s\n", report.SSDeep)
+			}
+
+			if report.TLSH != "" {
+				fmt.Fprintf(tw, "TLSH:\t%s\n", report.TLSH)
+			}
+
+			fmt.Fprintf(tw, "File Type:\t%s\n", report.FileType)
+			fmt.Fprintf(tw, "File Size:\t%s\n", report.Size)
+		}
+
+		if report.Error != "" {
+			fmt.Fprintf(tw, "Error:\t%s\n", report.Error)
+		}
+
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if len(report.Metadata) > 0 {
+			fmt.Fprintln(w, "\nMetadata:")
+			tw = tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+			for _, row := range report.Metadata {
+				if len(row) == 2 {
+					fmt.Fprintf(tw, "\t%s\t%s\n", row[0], row[1])
+				}
+			}
+
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if report.Analysis != "" {
+			fmt.Fprintf(w, "\nAnalysis:\n%s\n", report.Analysis)
+		}
+
+		if len(report.IOCs) > 0 {
+			fmt.Fprintln(w, "\nIndicators of compromise:")
+			tw = tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+			for _, found := range report.IOCs {
+				fmt.Fprintf(tw, "\t%s\t%s\t%s\n", found.Type.Title(), found.Value, strings.Join(found.Sources, ", "))
+			}
+
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"file-inspector/files/compare"
+	"file-inspector/files/verdict"
+)
+
+// how each status is marked in the text output
+var compareMarkers = map[compare.Status]string{
+	compare.Shared:     "=",
+	compare.Different:  "~",
+	compare.OnlyFirst:  "<",
+	compare.OnlySecond: ">",
+}
+
+func runCompareCommand(programName string, args []string) int {
+	var opts analyseOptions
+	flags := newAnalyseFlagSet(programName, os.Stderr, &opts)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if opts.format != outputText && opts.format != outputJSON {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q, compare can write json or text\n", opts.format)
+		return exitUsage
+	}
+
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Provide the two files to compare")
+		flags.Usage()
+		return exitUsage
+	}
+
+	if err := applyAnalyseOptions(&opts); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var compared [2]compare.File
+
+	for i, filePath := range flags.Args() {
+		properties, result, err := analyseFile(ctx, filePath)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitError
+		}
+
+		if result.Error != nil && !result.Parsed {
+			fmt.Fprintf(os.Stderr, "Error analysing %s: %s\n", filePath, result.Error.Error())
+			return exitError
+		}
+
+		compared[i] = compare.File{Properties: properties, Result: result}
+	}
+
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Comparison stopped: %s\n", err.Error())
+		return exitError
+	}
+
+	comparison := compare.Compare(compared[0], compared[1])
+
+	var err error
+
+	if opts.format == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(comparison)
+	} else {
+		err = writeComparison(os.Stdout, comparison)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %s\n", err.Error())
+		return exitError
+	}
+
+	return exitCompleted
+}
+
+// writeComparison writes each section with a marker saying if the item's in both files,
+// differs between them or is only in one
+func writeComparison(w io.Writer, comparison *compare.Comparison) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "First (<):\t%s\n", describeSide(comparison.First))
+	fmt.Fprintf(tw, "Second (>):\t%s\n", describeSide(comparison.Second))
+
+	if similarity := describeSimilarity(comparison); similarity != "" {
+		fmt.Fprintf(tw, "Similarity:\t%s\n", similarity)
+	}
+
+	fmt.Fprintf(tw, "Items:\t%s\n", describeCounts(comparison))
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, section := range comparison.Sections {
+		fmt.Fprintf(w, "\n%s:\n", section.Title)
+
+		if len(section.Items) == 0 {
+			fmt.Fprintln(w, "  none in either file")
+			continue
+		}
+
+		tw = tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+		for _, item := range section.Items {
+			if values := describeItemValues(item); values != "" {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\n", compareMarkers[item.Status], item.Name, values)
+			} else {
+				fmt.Fprintf(tw, "  %s\t%s\n", compareMarkers[item.Status], item.Name)
+			}
+		}
+
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// e.g. "ssdeep score 97, TLSH distance 12, similar", empty if the fuzzy hashes couldn't be compared
+func describeSimilarity(comparison *compare.Comparison) string {
+	switch {
+	case comparison.Identical:
+		return "the files are identical"
+	case comparison.Similarity == "":
+		return ""
+	case comparison.Similar:
+		return comparison.Similarity + ", similar"
+	default:
+		return comparison.Similarity + ", not similar"
+	}
+}
+
+func describeCounts(comparison *compare.Comparison) string {
+	return fmt.Sprintf("%d shared (=), %d different (~), %d only in the first (<), %d only in the second (>)",
+		comparison.Count(compare.Shared), comparison.Count(compare.Different), comparison.Count(compare.OnlyFirst), comparison.Count(compare.OnlySecond))
+}
+
+// e.g. "phish.eml, Malicious (80/100), SHA256 68de2bd3..."
+func describeSide(side compare.Side) string {
+	parts := []string{side.Name}
+
+	if side.Verdict != "" {
+		parts = append(parts, fmt.Sprintf("%s (%d/%d)", side.Verdict, side.Score, verdict.MaxScore))
+	}
+
+	if side.SHA256 != "" {
+		parts = append(parts, "SHA256 "+side.SHA256)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// describeItemValues shows both values where they differ, otherwise the one there is
+func describeItemValues(item compare.Item) string {
+	switch {
+	case item.First != "" && item.Second != "" && item.First != item.Second:
+		return fmt.Sprintf("%s | %s", oneLine(item.First), oneLine(item.Second))
+	case item.First != "":
+		return oneLine(item.First)
+	default:
+		return oneLine(item.Second)
+	}
+}
+
+// oneLine stops folded headers and evidence breaking the columns
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"file-inspector/files/compare"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	compareWindowWidth  = 1200
+	compareWindowHeight = 800
+)
+
+// columns of the comparison table, the first row is the headings
+var compareColumns = []struct {
+	heading string
+	width   float32
+}{
+	{"", 40},
+	{"Item", 380},
+	{"First", 360},
+	{"Second", 360},
+}
+
+// how each status is highlighted in the comparison table
+var compareImportance = map[compare.Status]widget.Importance{
+	compare.Shared:     widget.SuccessImportance,
+	compare.Different:  widget.WarningImportance,
+	compare.OnlyFirst:  widget.HighImportance,
+	compare.OnlySecond: widget.HighImportance,
+}
+
+// compareRow is a row of the comparison table, either a section's title or an item in it
+type compareRow struct {
+	title string
+	item  compare.Item
+}
+
+// onCompareButtonClicked asks for another file to compare the one shown with
+func onCompareButtonClicked() {
+	log.Println("Compare was clicked!")
+
+	if reportResult == nil {
+		return
+	}
+
+	first := compare.File{Properties: reportProperties, Result: reportResult}
+
+	dialog.ShowFileOpen(func(f fyne.URIReadCloser, err error) {
+		if err != nil {
+			log.Printf("Error from file picker: %s\n", err.Error())
+			return
+		}
+		if f == nil {
+			log.Println("Nil result from file picker")
+			return
+		}
+		f.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		progress := launchProcessingDialog(&window, cancel)
+
+		// process in the background so the UI stays responsive
+		go func() {
+			defer cancel()
+
+			properties, result, err := analyseFile(ctx, f.URI().Path())
+			progress.Hide()
+
+			if err != nil {
+				launchErrorDialog(err, window)
+				return
+			}
+
+			if ctx.Err() != nil {
+				launchInfoDialog("Comparison Cancelled", "The analysis was cancelled, so there's nothing to compare.", &window)
+				return
+			}
+
+			showComparison(compare.Compare(first, compare.File{Properties: properties, Result: result}))
+		}()
+	}, window)
+}
+
+// showComparison opens a window with the files side by side, each item highlighted by
+// whether it's shared, different or only in one of the files
+func showComparison(comparison *compare.Comparison) {
+	compareWindow := fyne.CurrentApp().NewWindow(fmt.Sprintf("Compare %s and %s", comparison.First.Name, comparison.Second.Name))
+
+	var rows []compareRow
+
+	for _, section := range comparison.Sections {
+		rows = append(rows, compareRow{title: section.Title})
+
+		for _, item := range section.Items {
+			rows = append(rows, compareRow{item: item})
+		}
+	}
+
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(rows) + 1, len(compareColumns)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("Compare")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+			label.TextStyle.Bold = false
+			label.Importance = widget.MediumImportance
+
+			if id.Row == 0 {
+				label.TextStyle.Bold = true
+				label.SetText(getCompareHeading(comparison, id.Col))
+				return
+			}
+
+			row := rows[id.Row-1]
+
+			if row.title != "" {
+				label.TextStyle.Bold = true
+
+				if id.Col == 1 {
+					label.SetText(row.title)
+				} else {
+					label.SetText("")
+				}
+
+				return
+			}
+
+			label.Importance = compareImportance[row.item.Status]
+			label.SetText(getCompareCellText(row.item, id.Col))
+		},
+	)
+
+	for i, column := range compareColumns {
+		table.SetColumnWidth(i, column.width)
+	}
+
+	// selecting an item shows it in full, as long values are cut off in the table
+	table.OnSelected = func(id widget.TableCellID) {
+		table.UnselectAll()
+
+		if id.Row == 0 || rows[id.Row-1].title != "" {
+			return
+		}
+
+		item := rows[id.Row-1].item
+		launchInfoDialog(item.Name, fmt.Sprintf("First:\n%s\n\nSecond:\n%s", item.First, item.Second), &compareWindow)
+	}
+
+	summary := widget.NewLabel(describeComparison(comparison))
+
+	compareWindow.SetContent(container.NewBorder(summary, nil, nil, nil, table))
+	compareWindow.Resize(fyne.NewSize(compareWindowWidth, compareWindowHeight))
+	compareWindow.Show()
+}
+
+// the file names head their columns
+func getCompareHeading(comparison *compare.Comparison, column int) string {
+	switch column {
+	case 2:
+		return comparison.First.Name
+	case 3:
+		return comparison.Second.Name
+	default:
+		return compareColumns[column].heading
+	}
+}
+
+func getCompareCellText(item compare.Item, column int) string {
+	switch column {
+	case 0:
+		return compareMarkers[item.Status]
+	case 1:
+		return item.Name
+	case 2:
+		return oneLine(item.First)
+	default:
+		return oneLine(item.Second)
+	}
+}
+
+// describeComparison is the summary above the table
+func describeComparison(comparison *compare.Comparison) string {
+	text := fmt.Sprintf("First:\t\t%s\nSecond:\t%s\n", describeSide(comparison.First), describeSide(comparison.Second))
+
+	if similarity := describeSimilarity(comparison); similarity != "" {
+		text += fmt.Sprintf("Similarity:\t%s\n", similarity)
+	}
+
+	return text + describeCounts(comparison)
+}
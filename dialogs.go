@@ -17,16 +17,34 @@ func launchInfoDialog(title, message string, window *fyne.Window) {
 	d.Show()
 }
 
-func launchProcessingDialog(window *fyne.Window) *dialog.CustomDialog {
+func launchProcessingDialog(window *fyne.Window, onCancel func()) *dialog.CustomDialog {
 	// just label above a progress bar
-	content := container.NewVBox()
-	content.Add(widget.NewLabel("Please wait..."))
 	progressBar := widget.NewProgressBarInfinite()
+	d := launchProgressDialog("Please wait...", progressBar, window, onCancel)
+
+	// start the progress bar
+	progressBar.Start()
+
+	return d
+}
+
+// launchProgressDialog shows the message above the progress bar, with a Cancel button.
+// The caller hides the dialog when the work has stopped
+func launchProgressDialog(message string, progressBar fyne.CanvasObject, window *fyne.Window, onCancel func()) *dialog.CustomDialog {
+	content := container.NewVBox()
+	label := widget.NewLabel(message)
+	content.Add(label)
 	content.Add(progressBar)
 	d := dialog.NewCustomWithoutButtons("Processing", content, *window)
 
-	// start the progress bar and show it
-	progressBar.Start()
+	var cancelButton *widget.Button
+	cancelButton = widget.NewButton("Cancel", func() {
+		cancelButton.Disable()
+		label.SetText("Cancelling...")
+		onCancel()
+	})
+
+	d.SetButtons([]fyne.CanvasObject{cancelButton})
 	d.Show()
 
 	return d
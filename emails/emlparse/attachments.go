@@ -2,7 +2,9 @@ package emlparse
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/mail"
@@ -11,13 +13,14 @@ import (
 	"mime"
 
 	"file-inspector/emails/msgparse"
+	"file-inspector/utils/limits"
 )
 
 const (
 	NoAttachments = "content type is not multipart"
 )
 
-func extractAllAttachments(message *mail.Message, bodyString string) ([]msgparse.Attachment, error) {
+func extractAllAttachments(ctx context.Context, message *mail.Message, bodyString string, budget *limits.Budget) ([]msgparse.Attachment, error) {
 	
 	// get the details from teh message header
 	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
@@ -32,17 +35,17 @@ func extractAllAttachments(message *mail.Message, bodyString string) ([]msgparse
 	}
 
 	// pull out the attachments
-	attachments, err := extractAttachmentsFromBoundary(bodyString, params["boundary"])
+	attachments, err := extractAttachmentsFromBoundary(ctx, bodyString, params["boundary"], budget)
 
 	if err != nil {
-		return nil, err
+		return attachments, err
 	}
 
 	return attachments, nil
 }
 
 // Bit hacky, could instead use multipart.NewReader()
-func extractAttachmentsFromBoundary(bodyString string, boundary string) ([]msgparse.Attachment, error) {
+func extractAttachmentsFromBoundary(ctx context.Context, bodyString string, boundary string, budget *limits.Budget) ([]msgparse.Attachment, error) {
 	var attachments []msgparse.Attachment
 
 	lines := strings.Split(bodyString, "\n")
@@ -67,6 +70,11 @@ func extractAttachmentsFromBoundary(bodyString string, boundary string) ([]msgpa
 		//
 		// --=-XNI3F2P8aCdwwxXQDLdRmw==--   											<--- Boundary again
 		if strings.Contains(line, boundary) {
+			// return what we have so far if we've been stopped
+			if err := ctx.Err(); err != nil {
+				return attachments, err
+			}
+
 			const ct = "Content-Type: "
 			const name = "name="
 			const b64 = "Content-Transfer-Encoding: base64"
@@ -88,7 +96,19 @@ func extractAttachmentsFromBoundary(bodyString string, boundary string) ([]msgpa
 
 						// send off the rest of the lines to parse it out - start two off to account for the empty line
 						// TODO could use the location of the next boundary instead
-						rawBytes, err := getAttachmentBytes(lines[i+j+2:], boundary)
+						// decoded attachments count towards the budget, as a body can be full of them
+						var rawBytes []byte
+						err := budget.CheckAttachments(len(attachments)+1, filename)
+
+						if err == nil {
+							rawBytes, err = getAttachmentBytes(lines[i+j+2:], boundary, filename, budget)
+						}
+
+						// keep the attachments we have, the budget records why we stopped
+						if errors.Is(err, limits.ErrExceeded) {
+							log.Printf("Not extracting any more attachments: %s", err.Error())
+							return attachments, nil
+						}
 
 						if err != nil {
 							log.Printf("Error extracting attachment %q: %s", filename, err.Error())
@@ -106,7 +126,9 @@ func extractAttachmentsFromBoundary(bodyString string, boundary string) ([]msgpa
 	return attachments, nil
 }
 
-func getAttachmentBytes(lines []string, boundary string) ([]byte, error) {
+// getAttachmentBytes decodes the attachment, taking its size from the budget first so
+// one that's too big is never decoded
+func getAttachmentBytes(lines []string, boundary string, filename string, budget *limits.Budget) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// stitch all the lines together
@@ -126,6 +148,10 @@ func getAttachmentBytes(lines []string, boundary string) ([]byte, error) {
 
 	trimmed := strings.TrimSpace(buf.String())
 
+	if err := budget.Allocate(int64(base64.StdEncoding.DecodedLen(len(trimmed))), filename); err != nil {
+		return nil, err
+	}
+
 	// decode Base64
 	decoded, err := base64.StdEncoding.DecodeString(trimmed)
 
@@ -2,6 +2,8 @@ package emlparse
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +11,7 @@ import (
 	"os"
 
 	"file-inspector/emails/msgparse"
+	"file-inspector/utils/limits"
 )
 
 type Eml struct {
@@ -17,9 +20,9 @@ type Eml struct {
 	Attachments []msgparse.Attachment
 }
 
-func ReadFromFile(filePath string) (*Eml, error) {
-	var emlFile Eml
-
+// ReadFromFile parses the .eml file. If the context is done while the attachments are
+// being extracted, the email is returned with the attachments found so far and the context's error
+func ReadFromFile(ctx context.Context, filePath string) (*Eml, error) {
 	// read the contents and parse them
 	file, err := os.Open(filePath)
 
@@ -27,7 +30,18 @@ func ReadFromFile(filePath string) (*Eml, error) {
 		return nil, fmt.Errorf("error opening file: %s", err.Error())
 	}
 
-	email, err := mail.ReadMessage(file)
+	// done processing so close the file
+	defer file.Close()
+
+	return ReadFromReader(ctx, file)
+}
+
+// ReadFromReader is ReadFromFile for an email that's already open or in memory. The body
+// is only read up to the limits in the context's budget, see limits.FromContext
+func ReadFromReader(ctx context.Context, r io.Reader) (*Eml, error) {
+	var emlFile Eml
+
+	email, err := mail.ReadMessage(r)
 
 	if err != nil {
 		return nil, fmt.Errorf("error reading eml message: %s", err.Error())
@@ -36,12 +50,16 @@ func ReadFromFile(filePath string) (*Eml, error) {
 	// parsed fine so put it into the struct
 	emlFile.Message = email
 
-	// Accessing the body reader won't work after we close the file so 
-	// read the body bytes out to a buffer then store them
+	// Accessing the body reader won't work after we close the file so
+	// read the body bytes out to a buffer then store them. If it's too big
+	// we keep what we read, and the budget records it was cut off
+	budget := limits.FromContext(ctx)
 	buf := new(bytes.Buffer)
-	numRead, err := buf.ReadFrom(email.Body)
+	numRead, err := buf.ReadFrom(budget.Reader(email.Body, "body"))
 
-	if err != nil && err != io.EOF {
+	if errors.Is(err, limits.ErrExceeded) {
+		log.Printf("body cut off: %s", err.Error())
+	} else if err != nil && err != io.EOF {
 		log.Printf("error reading body bytes: %s", err.Error())
 	} else if numRead == 0 {
 		log.Printf("failed to read any body bytes")
@@ -52,18 +70,18 @@ func ReadFromFile(filePath string) (*Eml, error) {
 
 	// get any attachments out if there's body content
 	if len(emlFile.Body) > 0 {
-		attachments, err := extractAllAttachments(email, emlFile.Body)
+		attachments, err := extractAllAttachments(ctx, email, emlFile.Body, budget)
 
-		if err != nil && err.Error() != NoAttachments {
+		if err != nil && ctx.Err() != nil {
+			emlFile.Attachments = attachments
+			return &emlFile, err
+		} else if err != nil && err.Error() != NoAttachments {
 			return nil, err
 		}
-	
+
 		// parsed fine so put it into the struct
 		emlFile.Attachments = attachments
 	}
 
-	// done processing so close the file
-	file.Close()
-
 	return &emlFile, nil
 }
@@ -4,16 +4,22 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 
 	"github.com/richardlehane/mscfb"
+
+	"file-inspector/utils/limits"
 )
 
 // Process an attachment entry and add the content to the passed attachment instance
-func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
+func addEntryToAttachment(entry *mscfb.File, attachment *Attachment, budget *limits.Budget) error {
 	switch entry.Name {
 	case attachmentName:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return err
+		}
+
 		entry.Read(rawBytes)
 
 		decoded, err := decodeUTF16LE(rawBytes)
@@ -24,7 +30,12 @@ func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
 			attachment.Filename = string(decoded)
 		}
 	case attachmentLongName:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return err
+		}
+
 		entry.Read(rawBytes)
 
 		decoded, err := decodeUTF16LE(rawBytes)
@@ -35,7 +46,12 @@ func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
 			attachment.LongFilename = string(decoded)
 		}
 	case attachmentUnicodeExtension:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return err
+		}
+
 		entry.Read(rawBytes)
 
 		decoded, err := decodeUTF16LE(rawBytes)
@@ -46,7 +62,12 @@ func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
 			attachment.UnicodeExtension = string(decoded)
 		}
 	case attachmentMimeTag:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return err
+		}
+
 		entry.Read(rawBytes)
 
 		decoded, err := decodeUTF16LE(rawBytes)
@@ -59,7 +80,12 @@ func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
 	case attachmentFolder:
 		// don't care here
 	case attachmentData:
-		bytes := make([]byte, entry.Size)
+		bytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return err
+		}
+
 		read, err := entry.Read(bytes)
 
 		if err != nil {
@@ -79,7 +105,12 @@ func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
 	case attachmentOtherBinData3:
 		fallthrough
 	case attachmentOtherBinData4:
-		bytes := make([]byte, entry.Size)
+		bytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return err
+		}
+
 		read, err := entry.Read(bytes)
 
 		if err != nil && err != io.EOF {
@@ -99,26 +130,3 @@ func addEntryToAttachment(entry *mscfb.File, attachment *Attachment) error {
 
 	return nil
 }
-
-// bit risky if we don't trust the attachments
-func DumpBinaryAttachment(attachment Attachment) {
-	filename := fmt.Sprintf("att_%s", attachment.LongFilename)
-	out, err := os.Create(filename)
-
-	if err != nil {
-		log.Fatalf(err.Error())
-	}
-
-	written, err := out.Write(attachment.Bytes)
-
-	if written != len(attachment.Bytes) {
-		log.Printf("Only wrote %d bytes, not full amount of  %d", written, len(attachment.Bytes))
-	} else if written != attachment.Size {
-		log.Printf("Only wrote %d bytes, not expected size of %d", written, attachment.Size)
-	} else if err != nil {
-		log.Fatalf(err.Error())
-	}
-
-	out.Close()
-	log.Printf("Attachment data written to %s\n", filename)
-}
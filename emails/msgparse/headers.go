@@ -8,16 +8,27 @@ import (
 )
 
 func GetHeaderByName(headers, name string) (string, error) {
+	hdr, err := ParseHeaders(headers)
+
+	if err != nil {
+		return "", err
+	}
+
+	auth := hdr.Get(name)
+
+	return auth, nil
+}
+
+// ParseHeaders parses the "Message Headers" property. Headers up to a malformed line are kept
+func ParseHeaders(headers string) (textproto.MIMEHeader, error) {
 	reader := strings.NewReader(headers)
 	tpReader := textproto.NewReader(bufio.NewReader(reader))
 
 	hdr, err := tpReader.ReadMIMEHeader()
 
 	if err != nil && err != io.EOF {
-		return "", err
+		return hdr, err
 	}
 
-	auth := hdr.Get(name)
-
-	return auth, nil
+	return hdr, nil
 }
@@ -1,16 +1,22 @@
 package msgparse
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/richardlehane/mscfb"
+
+	"file-inspector/utils/limits"
 )
 
-func ReadMsgFile(filePath string, verbose bool) (*Message, error) {
+// ReadMsgFile parses the .msg file. If the context is done part way through, what's been
+// read so far is returned with the context's error
+func ReadMsgFile(ctx context.Context, filePath string, verbose bool) (*Message, error) {
 
 	// open the file
 	f, err := os.Open(filePath)
@@ -21,8 +27,14 @@ func ReadMsgFile(filePath string, verbose bool) (*Message, error) {
 
 	defer f.Close()
 
+	return ReadMsg(ctx, f, verbose)
+}
+
+// ReadMsg is ReadMsgFile for a message that's already open or in memory. Entries past the
+// limits in the context's budget are skipped, see limits.FromContext
+func ReadMsg(ctx context.Context, r io.ReaderAt, verbose bool) (*Message, error) {
 	// parse it as an OLE doc
-	doc, err := mscfb.New(f)
+	doc, err := mscfb.New(r)
 
 	if err != nil {
 		return nil, err
@@ -34,14 +46,18 @@ func ReadMsgFile(filePath string, verbose bool) (*Message, error) {
 	msg.UnknownProperties = make(map[int64]UnknownProperty)
 
 	// extract the message content
-	processDocEntries(doc, msg, verbose)
+	err = processDocEntries(ctx, doc, msg, limits.FromContext(ctx), verbose)
 
-	return msg, nil
+	return msg, err
 }
 
 // Process each entry successively
-func processDocEntries(doc *mscfb.Reader, msg *Message, verbose bool) {
+func processDocEntries(ctx context.Context, doc *mscfb.Reader, msg *Message, budget *limits.Budget, verbose bool) error {
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// get the next entry from the doc
 		entry, err := doc.Next()
 
@@ -51,12 +67,18 @@ func processDocEntries(doc *mscfb.Reader, msg *Message, verbose bool) {
 			break
 		}
 
+		// keep what we have, the budget records why we stopped
+		if err := budget.AddObject(entry.Name); err != nil {
+			log.Printf("\tStopped reading entries: %s\n", err.Error())
+			break
+		}
+
 		// for any of the attachment prefixes
 		if strings.Contains(entry.Name, attachmentPrefix) {
 			var currentAttachment Attachment
 
 			// add this first entry
-			err := addEntryToAttachment(entry, &currentAttachment)
+			err := addEntryToAttachment(entry, &currentAttachment, budget)
 
 			if err != nil {
 				log.Printf("\tError processing attachment entry: %s\n", err.Error())
@@ -68,20 +90,19 @@ func processDocEntries(doc *mscfb.Reader, msg *Message, verbose bool) {
 				// get the next entry from the doc
 				entry, err = doc.Next()
 
-				// if there are no more entries or it's not attachment related
-				if err != nil || !strings.HasPrefix(entry.Name, attachmentPrefix) {
+				// if there are no more entries, it's not attachment related or there are too many
+				if err != nil || !strings.HasPrefix(entry.Name, attachmentPrefix) || budget.AddObject(entry.Name) != nil {
 					// done processing this attachment, save it and break
-					msg.Attachments = append(msg.Attachments, currentAttachment)
-
-					// TODO add this in as an option
-					//DumpBinaryAttachment(currentAttachment)
+					if err := budget.CheckAttachments(len(msg.Attachments)+1, currentAttachment.Filename); err == nil {
+						msg.Attachments = append(msg.Attachments, currentAttachment)
+					}
 
 					// reset it
 					currentAttachment = Attachment{}
 
 					break
 				} else {
-					err = addEntryToAttachment(entry, &currentAttachment)
+					err = addEntryToAttachment(entry, &currentAttachment, budget)
 
 					if err != nil {
 						log.Printf("\tError processing attachment entry: %s\n", err.Error())
@@ -90,7 +111,7 @@ func processDocEntries(doc *mscfb.Reader, msg *Message, verbose bool) {
 			}
 		} else if strings.Contains(entry.Name, propertyStreamPrefix) {
 			// for other properties
-			prop, err := extractEntryProperty(entry)
+			prop, err := extractEntryProperty(entry, budget)
 
 			if err != nil {
 				// print them if verbose
@@ -108,16 +129,18 @@ func processDocEntries(doc *mscfb.Reader, msg *Message, verbose bool) {
 			log.Printf("\tUnknown entry type: %q", entry.Name)
 		}
 	}
+
+	return nil
 }
 
-func extractEntryProperty(entry *mscfb.File) (*EntryProperty, error) {
+func extractEntryProperty(entry *mscfb.File, budget *limits.Budget) (*EntryProperty, error) {
 	properties, err := determineEntryProperties(entry)
 
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := decodeDataFromProperty(entry, *properties)
+	data, err := decodeDataFromProperty(entry, *properties, budget)
 
 	if err != nil {
 		return nil, err
@@ -133,7 +156,7 @@ func extractEntryProperty(entry *mscfb.File) (*EntryProperty, error) {
 	return &messageProperty, nil
 }
 
-func decodeDataFromProperty(entry *mscfb.File, info EntryProperty) (interface{}, error) {
+func decodeDataFromProperty(entry *mscfb.File, info EntryProperty, budget *limits.Budget) (interface{}, error) {
 
 	if info.PropertyType == "" {
 		return nil, fmt.Errorf("empty property type")
@@ -142,7 +165,12 @@ func decodeDataFromProperty(entry *mscfb.File, info EntryProperty) (interface{},
 	switch info.Encoding {
 	// ASCII
 	case AsciiEncoding:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return nil, err
+		}
+
 		entry.Read(rawBytes)
 
 		decoded, err := decodeACSII(rawBytes)
@@ -154,7 +182,12 @@ func decodeDataFromProperty(entry *mscfb.File, info EntryProperty) (interface{},
 		return decoded, nil
 	// UNICODE
 	case UnicodeEncoding:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return nil, err
+		}
+
 		entry.Read(rawBytes)
 
 		decoded, err := decodeUTF16LE(rawBytes)
@@ -166,7 +199,12 @@ func decodeDataFromProperty(entry *mscfb.File, info EntryProperty) (interface{},
 		return decoded, nil
 	// Binary
 	case BinaryEncoding:
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return nil, err
+		}
+
 		entry.Read(rawBytes)
 		return rawBytes, nil
 	// Other
@@ -179,7 +217,12 @@ func decodeDataFromProperty(entry *mscfb.File, info EntryProperty) (interface{},
 			log.Printf("\tFound unknown field of unknown type %s, ID: 0x%s\n", info.Encoding, info.PropertyType)
 		}
 
-		rawBytes := make([]byte, entry.Size)
+		rawBytes, err := allocateEntry(entry, budget)
+
+		if err != nil {
+			return nil, err
+		}
+
 		entry.Read(rawBytes)
 		return rawBytes, nil
 	}
@@ -5,11 +5,25 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/richardlehane/mscfb"
+
 	"golang.org/x/net/html/charset"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
+
+	"file-inspector/utils/limits"
 )
 
+// allocateEntry returns a buffer for the entry's content, as long as the size in its
+// header is within the budget. It comes from the file, so can't be trusted
+func allocateEntry(entry *mscfb.File, budget *limits.Budget) ([]byte, error) {
+	if err := budget.Allocate(entry.Size, entry.Name); err != nil {
+		return nil, err
+	}
+
+	return make([]byte, entry.Size), nil
+}
+
 func decodeUTF16LE(rawBytes []byte) (string, error) {
 	// Make an transformer that converts MS-Win default to UTF8:
 	win16be := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
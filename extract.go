@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"file-inspector/files"
+	"file-inspector/files/extract"
+)
+
+// extractOptions holds the flags for the extract command
+type extractOptions struct {
+	analyseOptions
+	zipPath  string
+	password string
+	list     bool
+}
+
+func newExtractFlagSet(programName string, output io.Writer, opts *extractOptions) *flag.FlagSet {
+	flags := newAnalyseFlagSet(programName, output, &opts.analyseOptions)
+	addExtractFlags(flags, opts)
+
+	return flags
+}
+
+// addExtractFlags adds the flags only the extract command has
+func addExtractFlags(flags *flag.FlagSet, opts *extractOptions) {
+	flags.StringVar(&opts.zipPath, "zip", "", "extract: zip file to write, instead of <file>-attachments.zip in the current folder")
+	flags.StringVar(&opts.password, "password", extract.DefaultPassword, "extract: password for the zip file")
+	flags.BoolVar(&opts.list, "list", false, "extract: list the attachments rather than extracting them")
+}
+
+func runExtractCommand(programName string, args []string) int {
+	var opts extractOptions
+	flags := newExtractFlagSet(programName, os.Stderr, &opts)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Provide an email to extract attachments from, then optionally the attachments to extract, e.g. 1 2/1")
+		flags.Usage()
+		return exitUsage
+	}
+
+	if err := applyAnalyseOptions(&opts.analyseOptions); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// the same analysis as everywhere else, keeping the attachments' content
+	filePath := flags.Arg(0)
+	_, result, err := analyseFile(files.WithAttachmentData(ctx), filePath)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if err := ctx.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Extraction stopped: %s\n", err.Error())
+		return exitError
+	}
+
+	samples, err := extract.Samples(result, flags.Args()[1:])
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if opts.list {
+		if err := writeSampleList(os.Stdout, result, samples); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %s\n", err.Error())
+			return exitError
+		}
+
+		return exitCompleted
+	}
+
+	zipPath := opts.zipPath
+
+	if zipPath == "" {
+		zipPath = getExtractFileName(filepath.Base(filePath))
+	}
+
+	entries, err := extract.Create(zipPath, samples, opts.password)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if err := writeExtractEntries(os.Stdout, entries); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %s\n", err.Error())
+		return exitError
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d attachments to %s with the password %q\n", len(entries), zipPath, opts.password)
+
+	return exitCompleted
+}
+
+// e.g. "phish.eml" gives "phish-attachments.zip"
+func getExtractFileName(name string) string {
+	return fmt.Sprintf("%s-attachments.zip", strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+func writeSampleList(w io.Writer, result *files.ProcessResult, samples []extract.Sample) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ATTACHMENT\tNAME\tSIZE\tVERDICT")
+
+	for _, sample := range samples {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", sample.Path, sample.Name, len(sample.Data), result.Attachment(sample.Path).Summary())
+	}
+
+	return tw.Flush()
+}
+
+func writeExtractEntries(w io.Writer, entries []extract.Entry) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ATTACHMENT\tNAME\tSIZE\tSHA256")
+
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", entry.Path, entry.Name, entry.Size, entry.SHA256)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"file-inspector/files/extract"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+func onExtractButtonClicked() {
+	log.Println("Extract attachments was clicked!")
+
+	if reportResult == nil {
+		return
+	}
+
+	samples, err := extract.Samples(reportResult, getExtractPaths())
+
+	if err != nil {
+		launchErrorDialog(err, window)
+		return
+	}
+
+	// the archive's always a new file in the chosen folder, so nothing's overwritten
+	dialog.ShowFolderOpen(func(dir fyne.ListableURI, err error) {
+		if err != nil {
+			log.Printf("Error from folder picker: %s\n", err.Error())
+			return
+		}
+		if dir == nil {
+			log.Println("Nil result from folder picker")
+			return
+		}
+
+		zipPath := filepath.Join(dir.Path(), getExtractFileName(reportResult.Name))
+		entries, err := extract.Create(zipPath, samples, extract.DefaultPassword)
+
+		if err != nil {
+			launchErrorDialog(fmt.Errorf("error extracting attachments: %s", err.Error()), window)
+			return
+		}
+
+		launchInfoDialog("Attachments Extracted", fmt.Sprintf("%d attachments saved to %s\n\nThe password is %q", len(entries), zipPath, extract.DefaultPassword), &window)
+	}, window)
+}
+
+// getExtractPaths returns the ticked attachments as paths counting from 1, as the
+// command line takes them, or none to extract them all
+func getExtractPaths() []string {
+	var ids []widget.TreeNodeID
+
+	for id, checked := range extractSelection {
+		if checked {
+			ids = append(ids, id)
+		}
+	}
+
+	// keep them in the order they're shown
+	sort.Slice(ids, func(i, j int) bool {
+		return compareTreeNodeIDs(ids[i], ids[j]) < 0
+	})
+
+	paths := make([]string, len(ids))
+
+	for i, id := range ids {
+		parts := strings.Split(id, "/")
+
+		for k, part := range parts {
+			index, _ := strconv.Atoi(part)
+			parts[k] = strconv.Itoa(index + 1)
+		}
+
+		paths[i] = strings.Join(parts, "/")
+	}
+
+	return paths
+}
+
+// compareTreeNodeIDs compares attachment tree IDs index by index, so "0/2" comes before "0/10"
+func compareTreeNodeIDs(a, b widget.TreeNodeID) int {
+	aParts := strings.Split(a, "/")
+	bParts := strings.Split(b, "/")
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aIndex, _ := strconv.Atoi(aParts[i])
+		bIndex, _ := strconv.Atoi(bParts[i])
+
+		if aIndex != bIndex {
+			return aIndex - bIndex
+		}
+	}
+
+	return len(aParts) - len(bParts)
+}
@@ -0,0 +1,205 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Input is what an analyzer is given to work on. Analyzers read the content from
+// the reader, as it may not be on disk, e.g. an email attachment
+type Input struct {
+	// FilePath is empty if the content isn't from a file
+	FilePath  string
+	Name      string
+	Extension string
+	MimeType  string
+
+	Reader io.ReaderAt
+	Size   int64
+
+	// Depth is how deeply nested the file is, e.g. 1 for an email attachment
+	Depth int
+
+	// detected is the file's type if it's already known, so it isn't sniffed again
+	detected *Detection
+}
+
+// Analyzer processes a single file format. Formats are added by implementing this
+// and calling RegisterAnalyzer, usually from the init function of their package
+type Analyzer interface {
+	// Name is a short, unique name for the analyzer, e.g. "pdf"
+	Name() string
+
+	// MimeTypes are the MIME types, as detected by details.GetFileType, the analyzer accepts
+	MimeTypes() []string
+
+	// Extensions are the file extensions, including the dot, the analyzer accepts
+	Extensions() []string
+
+	// Analyze processes the input and returns the result. When the context is done it
+	// should stop and return what it has so far, along with the context's error
+	Analyze(ctx context.Context, input *Input) (*ProcessResult, error)
+}
+
+// ContentMatcher can optionally be implemented by an analyzer to check the first bytes
+// of a file. It's used to confirm a MIME type match, e.g. that a text file is really an
+// email, and to pick up files the MIME detection doesn't recognise
+type ContentMatcher interface {
+	MatchContent(header []byte) bool
+}
+
+var (
+	analyzersMu sync.RWMutex
+	analyzers   = make(map[string]Analyzer)
+	byExtension = make(map[string]Analyzer)
+	byMimeType  = make(map[string]Analyzer)
+
+	// analyzers that have been turned off, which are still used to detect file types
+	disabled = make(map[string]bool)
+)
+
+// RegisterAnalyzer makes an analyzer available to ProcessFile.
+// It panics if the name or any of the extensions or MIME types are already registered
+func RegisterAnalyzer(analyzer Analyzer) {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	if analyzer == nil {
+		panic("files: RegisterAnalyzer analyzer is nil")
+	}
+
+	name := analyzer.Name()
+
+	if _, exists := analyzers[name]; exists {
+		panic(fmt.Sprintf("files: RegisterAnalyzer called twice for analyzer %q", name))
+	}
+
+	for _, ext := range analyzer.Extensions() {
+		ext = strings.ToLower(ext)
+
+		if existing, exists := byExtension[ext]; exists {
+			panic(fmt.Sprintf("files: extension %q registered by both %q and %q", ext, existing.Name(), name))
+		}
+
+		byExtension[ext] = analyzer
+	}
+
+	for _, mime := range analyzer.MimeTypes() {
+		if existing, exists := byMimeType[mime]; exists {
+			panic(fmt.Sprintf("files: MIME type %q registered by both %q and %q", mime, existing.Name(), name))
+		}
+
+		byMimeType[mime] = analyzer
+	}
+
+	analyzers[name] = analyzer
+}
+
+// GetAnalyzerForExtension returns the analyzer for the file extension, or nil if there isn't one
+func GetAnalyzerForExtension(extension string) Analyzer {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	return byExtension[strings.ToLower(extension)]
+}
+
+// GetAnalyzerForContent returns the analyzer for the file's content, using the detected
+// MIME type and the first bytes of the file, or nil if there isn't one
+func GetAnalyzerForContent(mime string, header []byte) Analyzer {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	// if the MIME type matches, the analyzer still gets a say on the content
+	if analyzer, ok := byMimeType[mime]; ok {
+		if matcher, ok := analyzer.(ContentMatcher); !ok || matcher.MatchContent(header) {
+			return analyzer
+		}
+	}
+
+	// fall back to the magic bytes. Check in name order so the result doesn't change between runs
+	names := make([]string, 0, len(analyzers))
+
+	for name := range analyzers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if matcher, ok := analyzers[name].(ContentMatcher); ok && matcher.MatchContent(header) {
+			return analyzers[name]
+		}
+	}
+
+	return nil
+}
+
+// SetDisabledAnalyzers turns off the named analyzers, e.g. "docx", turning the rest on.
+// Files they'd analyse are reported as unsupported, but are still checked against the
+// rules and hash lists
+func SetDisabledAnalyzers(names []string) error {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	turnedOff := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		if _, ok := analyzers[name]; !ok {
+			return fmt.Errorf("no analyzer called %q", name)
+		}
+
+		turnedOff[name] = true
+	}
+
+	disabled = turnedOff
+
+	return nil
+}
+
+// AnalyzerEnabled returns false if the named analyzer has been turned off
+func AnalyzerEnabled(name string) bool {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	return !disabled[name]
+}
+
+// SupportedMimeTypes returns the MIME types of all the registered analyzers that are turned on
+func SupportedMimeTypes() []string {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	mimes := make([]string, 0, len(byMimeType))
+
+	for mime, analyzer := range byMimeType {
+		if !disabled[analyzer.Name()] {
+			mimes = append(mimes, mime)
+		}
+	}
+
+	sort.Strings(mimes)
+
+	return mimes
+}
+
+// Analyzers returns all the registered analyzers, sorted by name
+func Analyzers() []Analyzer {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	list := make([]Analyzer, 0, len(analyzers))
+
+	for _, analyzer := range analyzers {
+		list = append(list, analyzer)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+
+	return list
+}
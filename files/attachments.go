@@ -0,0 +1,194 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"file-inspector/emails/msgparse"
+	"file-inspector/files/findings"
+	"file-inspector/files/ioc"
+	"file-inspector/utils/limits"
+)
+
+type attachmentDataKey struct{}
+
+// WithAttachmentData returns a context that keeps each attachment's content in its
+// result, so it can be handed out afterwards, e.g. by the server. It's off by default
+// as attachments can be large
+func WithAttachmentData(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attachmentDataKey{}, true)
+}
+
+func keepAttachmentData(ctx context.Context) bool {
+	keep, _ := ctx.Value(attachmentDataKey{}).(bool)
+	return keep
+}
+
+// Data returns the attachment's content, or nil if it wasn't kept with WithAttachmentData
+func (r *ProcessResult) Data() []byte {
+	return r.data
+}
+
+// ClearData drops the attachment's content, e.g. once it's been written somewhere else
+func (r *ProcessResult) ClearData() {
+	r.data = nil
+}
+
+// Attachment returns the attachment at the path of 1-based indexes, e.g. "1/2" for the
+// second attachment of the first, or nil if there isn't one
+func (r *ProcessResult) Attachment(path string) *ProcessResult {
+	result := r
+
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		index, err := strconv.Atoi(part)
+
+		if err != nil || index < 1 || index > len(result.Children) {
+			return nil
+		}
+
+		result = result.Children[index-1]
+	}
+
+	return result
+}
+
+// analyseAttachments runs each attachment back through the pipeline, adding the
+// results as children and rolling their findings up into the parent. Attachments past
+// the limits are left out, see limits.Limits
+func analyseAttachments(ctx context.Context, attachments []msgparse.Attachment, depth int, result *ProcessResult) {
+	budget := limits.FromContext(ctx)
+
+	if err := budget.CheckDepth(depth+1, result.Name); err != nil {
+		log.Printf("Not analysing attachments: %s\n", err.Error())
+		return
+	}
+
+	analysed := 0
+
+	for i, attachment := range attachments {
+		// the caller checks why we stopped
+		if ctx.Err() != nil {
+			break
+		}
+
+		// lots of empty entries in .msg files
+		if len(attachment.Bytes) == 0 {
+			continue
+		}
+
+		index := i + 1
+		name := getAttachmentName(attachment, index)
+
+		if err := budget.CheckAttachments(analysed+1, name); err != nil {
+			log.Printf("Not analysing the rest of the attachments: %s\n", err.Error())
+			break
+		}
+
+		analysed++
+		log.Printf("Analysing attachment %d: %q\n", index, name)
+
+		// analysed in memory, so the untrusted content never touches the disk
+		child := processBytes(ctx, name, attachment.Bytes, depth+1)
+
+		if keepAttachmentData(ctx) {
+			child.data = attachment.Bytes
+		}
+
+		result.addChild(child, index)
+	}
+}
+
+// addChild adds an attachment's result and copies its findings up, so they count
+// towards this result's score. Informational findings stay with the attachment, but
+// all of its indicators are copied up
+func (r *ProcessResult) addChild(child *ProcessResult, index int) {
+	r.Children = append(r.Children, child)
+
+	source := fmt.Sprintf("attachment %d", index)
+	r.AddIOC(ioc.TypeFileName, child.Name, source)
+
+	if child.SHA256 != "" {
+		r.AddIOC(ioc.TypeSHA256, child.SHA256, source)
+		r.AddIOC(ioc.TypeSHA1, child.SHA1, source)
+		r.AddIOC(ioc.TypeMD5, child.MD5, source)
+	}
+
+	r.iocs.AddAll(child.IOCs, fmt.Sprintf("%s (%s)", source, child.Name))
+
+	for _, f := range child.Findings {
+		if f.Severity == findings.SeverityInfo {
+			continue
+		}
+
+		f.Title = fmt.Sprintf("Attachment %d (%s): %s", index, child.Name, f.Title)
+
+		if f.Location.Attachment == 0 {
+			f.Location.Attachment = index
+		}
+
+		r.AddFinding(f)
+	}
+
+	// it's only a problem if we don't support a type we should, so just note it
+	if child.Error != nil && !errors.Is(child.Error, ErrUnsupportedFileType) {
+		r.AddFinding(findings.Finding{
+			ID:       "attachment.error",
+			Title:    fmt.Sprintf("Failed to analyse attachment %d (%s)", index, child.Name),
+			Severity: findings.SeverityLow,
+			Category: findings.CategoryParsing,
+			Evidence: child.Error.Error(),
+			Location: findings.Location{Attachment: index},
+		})
+	}
+}
+
+func getAttachmentName(attachment msgparse.Attachment, index int) string {
+	if attachment.LongFilename != "" {
+		return attachment.LongFilename
+	} else if attachment.Filename != "" {
+		return attachment.Filename
+	}
+
+	return fmt.Sprintf("attachment %d", index)
+}
+
+// renderAttachmentTree builds the text view of the attachment results, nested as they are in the file
+func renderAttachmentTree(children []*ProcessResult) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("Attachment analysis:\n")
+	writeAttachmentTree(&buffer, children, 1)
+	buffer.WriteString("\n")
+
+	return buffer.String()
+}
+
+func writeAttachmentTree(buffer *bytes.Buffer, children []*ProcessResult, indent int) {
+	tabs := strings.Repeat("\t", indent)
+
+	for _, child := range children {
+		buffer.WriteString(fmt.Sprintf("%s%s: %s\n", tabs, child.Name, child.Summary()))
+
+		if len(child.Children) > 0 {
+			writeAttachmentTree(buffer, child.Children, indent+1)
+		}
+	}
+}
+
+// Summary returns a short description of the outcome, e.g. "Malicious (100/100)"
+func (r *ProcessResult) Summary() string {
+	if errors.Is(r.Error, ErrUnsupportedFileType) {
+		return fmt.Sprintf("not analysed, %q isn't supported", r.MimeType)
+	} else if r.Error != nil {
+		return fmt.Sprintf("error: %s", r.Error.Error())
+	} else if r.Assessment != nil {
+		return r.Assessment.String()
+	}
+
+	return "not analysed"
+}
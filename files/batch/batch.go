@@ -0,0 +1,295 @@
+// Package batch scans folders for supported files, processes them concurrently and summarises the results
+package batch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"file-inspector/files"
+	"file-inspector/files/checks"
+	"file-inspector/files/findings"
+	"file-inspector/files/verdict"
+)
+
+const (
+	// DefaultWorkers is how many files are processed at once if not specified
+	DefaultWorkers = 4
+)
+
+// SortColumn is a column of the summary table the items can be sorted by
+type SortColumn string
+
+const (
+	SortPath  SortColumn = "path"
+	SortType  SortColumn = "type"
+	SortHash  SortColumn = "hash"
+	SortScore SortColumn = "score"
+)
+
+// Item is the result for a single file in the folder
+type Item struct {
+	Path     string
+	MimeType string
+	SHA256   string
+	Size     string
+	Result   *files.ProcessResult
+}
+
+// Summary is the result of scanning a folder
+type Summary struct {
+	Root     string
+	Items    []*Item
+	Folders  map[string]int
+	Skipped  int
+	Duration time.Duration
+}
+
+// Progress is called each time a file has been processed
+type Progress func(done, total int)
+
+// Scan walks the folder, finds every file we have an analyzer for and processes them
+// using the number of workers given. Files are picked by their content, as they are when
+// analysed on their own, and the rest are counted as skipped. Progress can be nil. If the
+// context is done the files processed so far are returned with the context's error
+func Scan(ctx context.Context, root string, workers int, progress Progress) (*Summary, error) {
+	started := time.Now()
+
+	if !checks.FolderExists(root) {
+		return nil, fmt.Errorf("folder %q doesn't exist", root)
+	}
+
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	paths, err := findFiles(root)
+
+	if err != nil {
+		return nil, fmt.Errorf("error walking folder %q: %s", root, err.Error())
+	}
+
+	summary := Summary{
+		Root:    root,
+		Folders: make(map[string]int),
+	}
+
+	log.Printf("Found %d files in %q\n", len(paths), root)
+
+	jobs := make(chan string, len(paths))
+	results := make(chan *Item, len(paths))
+
+	var wg sync.WaitGroup
+
+	for w := 1; w <= workers; w++ {
+		wg.Add(1)
+		go processWorker(ctx, jobs, results, &wg)
+	}
+
+	for _, path := range paths {
+		jobs <- path
+	}
+
+	// all jobs sent - close the channel
+	close(jobs)
+
+	// collect results as they come in so progress can be reported
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	done := 0
+
+	for item := range results {
+		done++
+
+		if item == nil {
+			summary.Skipped++
+		} else {
+			summary.Items = append(summary.Items, item)
+			summary.Folders[filepath.Dir(item.Path)]++
+		}
+
+		if progress != nil {
+			progress(done, len(paths))
+		}
+	}
+
+	summary.Sort(SortPath, false)
+	summary.Duration = time.Since(started)
+
+	return &summary, ctx.Err()
+}
+
+// findFiles returns every regular file in the folder and its subfolders
+func findFiles(root string) ([]string, error) {
+	var paths []string
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+
+	return paths, err
+}
+
+// processWorker detects each file's type and processes it if there's an analyzer for it,
+// sending nil for those that are skipped
+func processWorker(ctx context.Context, jobs <-chan string, results chan<- *Item, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for path := range jobs {
+		// skip whatever's left if we've been stopped
+		if ctx.Err() != nil {
+			continue
+		}
+
+		// the MIME type alone isn't enough, e.g. most text files aren't emails
+		detected, err := files.DetectType(path)
+
+		if err != nil || detected.Analyzer == nil || !files.AnalyzerEnabled(detected.Analyzer.Name()) {
+			results <- nil
+			continue
+		}
+
+		result := files.ProcessDetectedFile(ctx, path, detected)
+
+		results <- &Item{
+			Path:     path,
+			MimeType: detected.MimeType,
+			SHA256:   result.SHA256,
+			Size:     getSize(path),
+			Result:   result,
+		}
+	}
+}
+
+// getSize returns the file's size for display, e.g. "1.2 kB"
+func getSize(path string) string {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return ""
+	}
+
+	return humanize.Bytes(uint64(info.Size()))
+}
+
+// failed returns true if the file couldn't be scored. Timed out files are scored on what was found
+func (i *Item) failed() bool {
+	return i.Result == nil || i.Result.Assessment == nil || (i.Result.Error != nil && !i.Result.TimedOut)
+}
+
+// Score returns the file's score, or -1 if it couldn't be scored, so errors sort to the bottom
+func (i *Item) Score() int {
+	if i.failed() {
+		return -1
+	}
+
+	return i.Result.Assessment.Score
+}
+
+// Verdict returns the file's verdict, or "Error" if processing failed
+func (i *Item) Verdict() string {
+	if i.failed() {
+		return "Error"
+	}
+
+	return string(i.Result.Assessment.Verdict)
+}
+
+// TopFindings returns up to the number of findings requested, most severe first, leaving
+// out informational ones
+func (i *Item) TopFindings(count int) []findings.Finding {
+	if i.Result == nil {
+		return nil
+	}
+
+	top := findings.AtLeast(i.Result.Findings, findings.SeverityLow)
+
+	sort.SliceStable(top, func(a, b int) bool {
+		return top[a].Severity > top[b].Severity
+	})
+
+	if len(top) > count {
+		top = top[:count]
+	}
+
+	return top
+}
+
+// Sort orders the items by the column
+func (s *Summary) Sort(column SortColumn, descending bool) {
+	less := func(a, b *Item) bool {
+		switch column {
+		case SortType:
+			return a.MimeType < b.MimeType
+		case SortHash:
+			return a.SHA256 < b.SHA256
+		case SortScore:
+			return a.Score() < b.Score()
+		default:
+			return a.Path < b.Path
+		}
+	}
+
+	sort.SliceStable(s.Items, func(i, j int) bool {
+		if descending {
+			return less(s.Items[j], s.Items[i])
+		}
+
+		return less(s.Items[i], s.Items[j])
+	})
+}
+
+// CountByVerdict returns how many files got each verdict, including "Error"
+func (s *Summary) CountByVerdict() map[string]int {
+	counts := make(map[string]int)
+
+	for _, item := range s.Items {
+		counts[item.Verdict()]++
+	}
+
+	return counts
+}
+
+// CountTimedOut returns how many files ran out of time, so only have partial results
+func (s *Summary) CountTimedOut() int {
+	count := 0
+
+	for _, item := range s.Items {
+		if item.Result != nil && item.Result.TimedOut {
+			count++
+		}
+	}
+
+	return count
+}
+
+// String returns a one line summary of the scan
+func (s *Summary) String() string {
+	counts := s.CountByVerdict()
+
+	return fmt.Sprintf("%d files scanned in %s: %d %s, %d %s, %d %s, %d errors, %d timed out. %d unsupported files skipped.",
+		len(s.Items), s.Duration.Round(time.Millisecond),
+		counts[string(verdict.Malicious)], verdict.Malicious,
+		counts[string(verdict.Suspicious)], verdict.Suspicious,
+		counts[string(verdict.Clean)], verdict.Clean,
+		counts["Error"], s.CountTimedOut(), s.Skipped)
+}
@@ -0,0 +1,373 @@
+// Package compare lines up the results of two analyses, showing what they share and
+// what's unique to each, e.g. to decide if a reported email is part of a known campaign
+package compare
+
+import (
+	"strings"
+
+	"file-inspector/files"
+	"file-inspector/files/findings"
+	"file-inspector/files/hashing"
+	"file-inspector/files/ioc"
+)
+
+// Status is how an item compares between the two files
+type Status string
+
+const (
+	// Shared items are in both files, with the same value where there is one
+	Shared Status = "shared"
+
+	// Different items are in both files but with different values, e.g. a header
+	Different Status = "different"
+
+	OnlyFirst  Status = "only-first"
+	OnlySecond Status = "only-second"
+)
+
+// the order the sections are compared in
+const (
+	SectionMetadata       = "Metadata"
+	SectionAuthentication = "Authentication"
+	SectionHeaders        = "Header Indicators"
+	SectionURLs           = "URLs"
+	SectionAttachments    = "Attachments"
+	SectionFindings       = "Findings"
+)
+
+// the properties of an authentication result that say who it's for
+var authProperties = []string{"header.d=", "header.i=", "header.from=", "smtp.mailfrom=", "smtp.helo="}
+
+// File is an analysed file to compare
+type File struct {
+	Properties *files.FileProperties
+	Result     *files.ProcessResult
+}
+
+// Side describes one of the files compared
+type Side struct {
+	Name    string `json:"name"`
+	SHA256  string `json:"sha256,omitempty"`
+	Verdict string `json:"verdict,omitempty"`
+	Score   int    `json:"score"`
+}
+
+// Item is something found in either file, e.g. a header, URL or attachment
+type Item struct {
+	// Name identifies the item, e.g. "Subject" or the attachment's hash
+	Name string `json:"name"`
+
+	// First and Second are its values in each file, empty if it's not in that file
+	First  string `json:"first,omitempty"`
+	Second string `json:"second,omitempty"`
+	Status Status `json:"status"`
+}
+
+// Section is a group of items of the same kind
+type Section struct {
+	Title string `json:"title"`
+	Items []Item `json:"items"`
+}
+
+// Comparison is the difference between two analyses
+type Comparison struct {
+	First  Side `json:"first"`
+	Second Side `json:"second"`
+
+	// Identical is true if the files have the same SHA-256 hash
+	Identical bool `json:"identical"`
+
+	// Similarity describes how alike the files are by their fuzzy hashes, empty if they
+	// couldn't be compared, and Similar is true if that's within the thresholds
+	Similarity string `json:"similarity,omitempty"`
+	Similar    bool   `json:"similar"`
+
+	Sections []Section `json:"sections"`
+}
+
+// an item in one file, before it's compared
+type entry struct {
+	key   string
+	name  string
+	value string
+}
+
+// Compare compares the files' metadata, authentication results, the indicators in their
+// headers, URLs, attachment hashes and findings
+func Compare(first, second File) *Comparison {
+	comparison := &Comparison{
+		First:  newSide(first),
+		Second: newSide(second),
+	}
+
+	if first.Properties != nil && second.Properties != nil {
+		comparison.Identical = first.Properties.Hash == second.Properties.Hash
+
+		similarity := hashing.CompareFuzzy(
+			&hashing.Hashes{SSDeep: first.Properties.SSDeep, TLSH: first.Properties.TLSH},
+			&hashing.Hashes{SSDeep: second.Properties.SSDeep, TLSH: second.Properties.TLSH},
+		)
+
+		comparison.Similarity = similarity.String()
+		comparison.Similar = similarity.Similar()
+	}
+
+	comparison.Sections = []Section{
+		compareEntries(SectionMetadata, metadataEntries(first.Result), metadataEntries(second.Result), true),
+		compareEntries(SectionAuthentication, authEntries(first.Result), authEntries(second.Result), true),
+		compareEntries(SectionHeaders, headerEntries(first.Result), headerEntries(second.Result), false),
+		compareEntries(SectionURLs, urlEntries(first.Result), urlEntries(second.Result), false),
+		compareEntries(SectionAttachments, attachmentEntries(nil, first.Result), attachmentEntries(nil, second.Result), false),
+		compareEntries(SectionFindings, findingEntries(first.Result), findingEntries(second.Result), false),
+	}
+
+	return comparison
+}
+
+// Count returns the number of items with the status across every section
+func (c *Comparison) Count(status Status) int {
+	count := 0
+
+	for _, section := range c.Sections {
+		for _, item := range section.Items {
+			if item.Status == status {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func newSide(file File) Side {
+	var side Side
+
+	if file.Properties != nil {
+		side.Name = file.Properties.FileName
+		side.SHA256 = file.Properties.Hash
+	}
+
+	if file.Result != nil && file.Result.Assessment != nil {
+		side.Verdict = string(file.Result.Assessment.Verdict)
+		side.Score = file.Result.Assessment.Score
+	}
+
+	return side
+}
+
+// compareEntries lines up the entries by key, in the order they're in the first file
+// then those only in the second. If byValue is false only whether an entry's in both
+// files matters, not its value, e.g. an attachment with the same hash but another name
+func compareEntries(title string, first, second []entry, byValue bool) Section {
+	section := Section{Title: title, Items: []Item{}}
+	firstEntries := mergeEntries(first)
+	secondEntries := mergeEntries(second)
+	secondIndex := make(map[string]entry, len(secondEntries))
+
+	for _, e := range secondEntries {
+		secondIndex[e.key] = e
+	}
+
+	inFirst := make(map[string]bool, len(firstEntries))
+
+	for _, e := range firstEntries {
+		inFirst[e.key] = true
+		item := Item{Name: e.name, First: e.value, Status: OnlyFirst}
+
+		if other, ok := secondIndex[e.key]; ok {
+			item.Second = other.value
+			item.Status = Shared
+
+			if byValue && e.value != other.value {
+				item.Status = Different
+			}
+		}
+
+		section.Items = append(section.Items, item)
+	}
+
+	for _, e := range secondEntries {
+		if !inFirst[e.key] {
+			section.Items = append(section.Items, Item{Name: e.name, Second: e.value, Status: OnlySecond})
+		}
+	}
+
+	return section
+}
+
+// mergeEntries combines the values of entries with the same key, keeping the first's place
+func mergeEntries(entries []entry) []entry {
+	var merged []entry
+	index := make(map[string]int)
+	seen := make(map[entry]bool)
+
+	for _, e := range entries {
+		if seen[e] {
+			continue
+		}
+
+		seen[e] = true
+		i, ok := index[e.key]
+
+		if !ok {
+			index[e.key] = len(merged)
+			merged = append(merged, e)
+		} else if merged[i].value == "" {
+			merged[i].value = e.value
+		} else if e.value != "" {
+			merged[i].value += ", " + e.value
+		}
+	}
+
+	return merged
+}
+
+// the metadata table, which for emails is the key headers
+func metadataEntries(result *files.ProcessResult) []entry {
+	var entries []entry
+
+	if result == nil {
+		return entries
+	}
+
+	for _, row := range result.Metadata {
+		if len(row) < 2 {
+			continue
+		}
+
+		entries = append(entries, entry{key: strings.ToLower(row[0]), name: row[0], value: row[1]})
+	}
+
+	return entries
+}
+
+// the DKIM, SPF and DMARC results, e.g. "DKIM" with "dkim=pass header.d=example.com"
+func authEntries(result *files.ProcessResult) []entry {
+	var entries []entry
+
+	if result == nil {
+		return entries
+	}
+
+	for _, f := range result.Findings {
+		if f.Category != findings.CategoryAuthentication {
+			continue
+		}
+
+		// e.g. "auth.dkim.pass"
+		parts := strings.Split(f.ID, ".")
+
+		if len(parts) < 2 {
+			continue
+		}
+
+		entries = append(entries, entry{key: parts[1], name: strings.ToUpper(parts[1]), value: authValue(f)})
+	}
+
+	return entries
+}
+
+// authValue is the result and the domain it's for, e.g. "dkim=pass header.d=example.com",
+// leaving out the signature and comments that differ for every email
+func authValue(f findings.Finding) string {
+	fields := strings.Fields(f.Evidence)
+
+	if len(fields) == 0 {
+		return f.Title
+	}
+
+	kept := fields[:1]
+
+	for _, field := range fields[1:] {
+		for _, property := range authProperties {
+			if strings.HasPrefix(field, property) {
+				kept = append(kept, field)
+				break
+			}
+		}
+	}
+
+	return strings.Join(kept, " ")
+}
+
+// the indicators found in the headers, e.g. the addresses and hosts in Received headers
+func headerEntries(result *files.ProcessResult) []entry {
+	var entries []entry
+
+	if result == nil {
+		return entries
+	}
+
+	for _, found := range result.IOCs {
+		for _, source := range found.Sources {
+			if strings.HasPrefix(source, "header") {
+				entries = append(entries, iocEntry(found))
+				break
+			}
+		}
+	}
+
+	return entries
+}
+
+// the URLs found anywhere in the file, including its attachments
+func urlEntries(result *files.ProcessResult) []entry {
+	var entries []entry
+
+	if result == nil {
+		return entries
+	}
+
+	for _, found := range result.IOCs {
+		if found.Type == ioc.TypeURL {
+			entries = append(entries, entry{key: found.Value, name: found.Value})
+		}
+	}
+
+	return entries
+}
+
+func iocEntry(found ioc.IOC) entry {
+	return entry{
+		key:  string(found.Type) + "|" + found.Value,
+		name: found.Type.Title() + ": " + found.Value,
+	}
+}
+
+// every attachment, however deeply nested, by its hash with its name as the value as the
+// same attachment is often sent under different names
+func attachmentEntries(entries []entry, result *files.ProcessResult) []entry {
+	if result == nil {
+		return entries
+	}
+
+	for _, child := range result.Children {
+		if child.SHA256 != "" {
+			entries = append(entries, entry{key: child.SHA256, name: child.SHA256, value: child.Name})
+		}
+
+		entries = attachmentEntries(entries, child)
+	}
+
+	return entries
+}
+
+// the findings, apart from those with their own section. The details of each attachment
+// are left out too, as the attachments are compared by hash
+func findingEntries(result *files.ProcessResult) []entry {
+	var entries []entry
+
+	if result == nil {
+		return entries
+	}
+
+	for _, f := range result.Findings {
+		if f.Category == findings.CategoryAuthentication || f.ID == "attachment.details" {
+			continue
+		}
+
+		entries = append(entries, entry{key: f.ID + "|" + f.Title, name: f.String(), value: f.Evidence})
+	}
+
+	return entries
+}
@@ -0,0 +1,358 @@
+// Package config holds the settings teams tune for themselves without a separate build:
+// which analyzers run, finding severities, limits, domain lists, rule directories and
+// integrations. They're kept in a TOML file in the user's config, e.g.
+//
+//	[analyzers]
+//	disabled = ["docx"]
+//
+//	[limits]
+//	timeout = "1m"
+//
+//	[severities]
+//	"url.uncommon-domain" = "high"
+//
+//	[domains]
+//	common = ["example.com"]
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"file-inspector/files"
+	"file-inspector/files/findings"
+	"file-inspector/files/pdf"
+	"file-inspector/files/server"
+	"file-inspector/files/verdict"
+	"file-inspector/utils/limits"
+	"file-inspector/utils/urls"
+)
+
+const (
+	// DefaultWindowWidth and DefaultWindowHeight are the size of the main window
+	DefaultWindowWidth  = 700
+	DefaultWindowHeight = 900
+
+	// the smallest the main window can be set to
+	minWindowSize = 200
+)
+
+// Config is everything in the settings file. Anything not in the file has its default value
+type Config struct {
+	Analyzers    Analyzers         `toml:"analyzers"`
+	Limits       Limits            `toml:"limits"`
+	Severities   map[string]string `toml:"severities,omitempty"`
+	Domains      Domains           `toml:"domains"`
+	PDF          PDF               `toml:"pdf"`
+	Rules        Rules             `toml:"rules"`
+	Integrations Integrations      `toml:"integrations"`
+	UI           UI                `toml:"ui"`
+
+	// Verdict overrides the built in weights and thresholds, as in a -weights file
+	Verdict *verdict.Config `toml:"verdict,omitempty"`
+}
+
+// Analyzers turns analyzers off and changes how long they get
+type Analyzers struct {
+	// Disabled are the names of the analyzers that don't run, e.g. "docx"
+	Disabled []string `toml:"disabled"`
+
+	// Timeouts are for analyzers that need longer, or less, than the limit for every file
+	Timeouts map[string]time.Duration `toml:"timeouts,omitempty"`
+}
+
+// Limits are how far the analysis goes
+type Limits struct {
+	// Timeout is how long to spend analysing each file
+	Timeout time.Duration `toml:"timeout"`
+
+	// MaxAttachmentDepth is how deeply nested attachments are analysed
+	MaxAttachmentDepth int `toml:"max_attachment_depth"`
+
+	// MaxUploadMB is the largest file the serve command accepts
+	MaxUploadMB int64 `toml:"max_upload_mb"`
+
+	// MaxInputMB is the largest file, or attachment, that's parsed. Bigger ones are still
+	// hashed and scanned
+	MaxInputMB int64 `toml:"max_input_mb"`
+
+	// MaxDecompressedMB is the most that's decompressed or decoded from a file and its
+	// attachments, e.g. zip entries and PDF streams, so a zip bomb can't use up all the memory
+	MaxDecompressedMB int64 `toml:"max_decompressed_mb"`
+
+	// MaxAttachments is the most attachments analysed in each file
+	MaxAttachments int `toml:"max_attachments"`
+
+	// MaxObjects is the most entries, e.g. zip entries or PDF objects, read from a file and its attachments
+	MaxObjects int `toml:"max_objects"`
+}
+
+// Domains changes which domains links are counted as common for
+type Domains struct {
+	// Common domains, and their subdomains, are trusted, e.g. your own
+	Common []string `toml:"common"`
+
+	// Uncommon domains, and their subdomains, are never trusted, e.g. file sharing sites
+	Uncommon []string `toml:"uncommon"`
+
+	// UseBuiltinList is false to only trust the Common domains, not the 100,000 most popular
+	UseBuiltinList bool `toml:"use_builtin_list"`
+}
+
+// PDF is what's looked for in PDFs
+type PDF struct {
+	// Keywords are the names in objects that mark active content, e.g. "/JavaScript"
+	Keywords []pdf.Keyword `toml:"keywords"`
+}
+
+// Rules are the directories rules and hash lists are loaded from, instead of those in
+// the user's config. The command line flags take precedence
+type Rules struct {
+	YARA        string `toml:"yara"`
+	Email       string `toml:"email"`
+	AllowHashes string `toml:"allow_hashes"`
+	DenyHashes  string `toml:"deny_hashes"`
+}
+
+// Integrations are the services results can be sent to or checked with. Environment
+// variables, e.g. MISP_URL, take precedence so keys can be kept out of the file
+type Integrations struct {
+	MISP               MISP   `toml:"misp"`
+	GoogleSafeBrowsing APIKey `toml:"google_safe_browsing"`
+	URLScan            APIKey `toml:"urlscan"`
+}
+
+// MISP is the server events are uploaded to
+type MISP struct {
+	URL    string `toml:"url"`
+	APIKey string `toml:"api_key"`
+}
+
+// APIKey is the key for a service
+type APIKey struct {
+	APIKey string `toml:"api_key"`
+}
+
+// UI is the user interface's settings
+type UI struct {
+	WindowWidth  float32 `toml:"window_width"`
+	WindowHeight float32 `toml:"window_height"`
+}
+
+// Default returns the built in settings
+func Default() *Config {
+	return &Config{
+		Analyzers: Analyzers{Disabled: []string{}},
+		Limits: Limits{
+			Timeout:            files.DefaultAnalyzerTimeout,
+			MaxAttachmentDepth: limits.DefaultMaxDepth,
+			MaxUploadMB:        server.DefaultMaxUploadSize >> 20,
+			MaxInputMB:         limits.DefaultMaxInputSize >> 20,
+			MaxDecompressedMB:  limits.DefaultMaxDecompressedSize >> 20,
+			MaxAttachments:     limits.DefaultMaxAttachments,
+			MaxObjects:         limits.DefaultMaxObjects,
+		},
+		Domains: Domains{
+			Common:         []string{},
+			Uncommon:       []string{},
+			UseBuiltinList: true,
+		},
+		PDF: PDF{Keywords: pdf.DefaultKeywords()},
+		UI: UI{
+			WindowWidth:  DefaultWindowWidth,
+			WindowHeight: DefaultWindowHeight,
+		},
+	}
+}
+
+// DefaultPath returns where the settings are kept, e.g. "~/.config/file-inspector/config.toml" on Linux
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "file-inspector", "config.toml"), nil
+}
+
+// Load reads the settings from the file, returning the defaults if there isn't one.
+// Settings it doesn't know, e.g. a misspelt one, are an error rather than being ignored
+func Load(path string) (*Config, error) {
+	config := Default()
+	metadata, err := toml.DecodeFile(path, config)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return Default(), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading settings %q: %s", path, err.Error())
+	}
+
+	if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+
+		return nil, fmt.Errorf("unknown settings in %q: %s", path, strings.Join(keys, ", "))
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("error in settings %q: %s", path, err.Error())
+	}
+
+	return config, nil
+}
+
+// Save writes the settings to a temporary file then renames it, so a crash part way
+// through can't lose them. Only the user can read it, as it can hold API keys
+func (c *Config) Save(path string) error {
+	var buffer bytes.Buffer
+
+	if err := toml.NewEncoder(&buffer).Encode(c); err != nil {
+		return fmt.Errorf("error encoding settings: %s", err.Error())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error saving settings: %s", err.Error())
+	}
+
+	temp := path + ".tmp"
+
+	if err := os.WriteFile(temp, buffer.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("error saving settings: %s", err.Error())
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		return fmt.Errorf("error saving settings: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Validate checks the settings make sense
+func (c *Config) Validate() error {
+	for _, name := range c.Analyzers.Disabled {
+		if !isAnalyzer(name) {
+			return fmt.Errorf("no analyzer called %q to turn off", name)
+		}
+	}
+
+	for name, timeout := range c.Analyzers.Timeouts {
+		if !isAnalyzer(name) {
+			return fmt.Errorf("no analyzer called %q to set the timeout for", name)
+		}
+
+		if timeout <= 0 {
+			return fmt.Errorf("the timeout for %s must be more than zero, not %s", name, timeout)
+		}
+	}
+
+	if c.Limits.Timeout <= 0 {
+		return fmt.Errorf("timeout must be more than zero, not %s", c.Limits.Timeout)
+	}
+
+	if c.Limits.MaxUploadMB < 1 {
+		return fmt.Errorf("max upload size must be at least 1 MB, not %d", c.Limits.MaxUploadMB)
+	}
+
+	if err := c.Limits.resourceLimits().Validate(); err != nil {
+		return err
+	}
+
+	if _, err := findings.ParseOverrides(c.Severities); err != nil {
+		return err
+	}
+
+	for _, keyword := range c.PDF.Keywords {
+		if !strings.HasPrefix(keyword.Keyword, "/") || len(keyword.Keyword) < 2 {
+			return fmt.Errorf("PDF keywords are names starting with a slash, e.g. \"/JavaScript\", not %q", keyword.Keyword)
+		}
+	}
+
+	if c.UI.WindowWidth < minWindowSize || c.UI.WindowHeight < minWindowSize {
+		return fmt.Errorf("the window must be at least %d by %d", minWindowSize, minWindowSize)
+	}
+
+	_, err := c.verdictConfig()
+	return err
+}
+
+// Apply sets up the analysis with the settings. Rule directories are left to the
+// caller, as command line flags can override them
+func (c *Config) Apply() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if err := files.SetDisabledAnalyzers(c.Analyzers.Disabled); err != nil {
+		return err
+	}
+
+	files.SetAnalyzerTimeouts(c.Analyzers.Timeouts)
+	files.SetDefaultAnalyzerTimeout(c.Limits.Timeout)
+	limits.Set(c.Limits.resourceLimits())
+
+	overrides, _ := findings.ParseOverrides(c.Severities)
+	files.SetSeverityOverrides(overrides)
+
+	verdictConfig, _ := c.verdictConfig()
+	files.SetVerdictConfig(verdictConfig)
+
+	urls.SetDomainLists(urls.DomainLists{
+		Common:    c.Domains.Common,
+		Uncommon:  c.Domains.Uncommon,
+		NoBuiltin: !c.Domains.UseBuiltinList,
+	})
+
+	pdf.SetKeywords(c.PDF.Keywords)
+	urls.SetGoogleAPIKey(c.Integrations.GoogleSafeBrowsing.APIKey)
+	urls.SetURLScanAPIKey(c.Integrations.URLScan.APIKey)
+
+	return nil
+}
+
+// resourceLimits are the limits the parsers are held to
+func (l Limits) resourceLimits() limits.Limits {
+	return limits.Limits{
+		MaxInputSize:        l.MaxInputMB << 20,
+		MaxDecompressedSize: l.MaxDecompressedMB << 20,
+		MaxAttachments:      l.MaxAttachments,
+		MaxDepth:            l.MaxAttachmentDepth,
+		MaxObjects:          l.MaxObjects,
+	}
+}
+
+// verdictConfig is the built in weights and thresholds with the overrides applied
+func (c *Config) verdictConfig() (*verdict.Config, error) {
+	config := verdict.DefaultConfig()
+
+	if c.Verdict != nil {
+		config.Merge(c.Verdict)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("verdict settings: %s", err.Error())
+	}
+
+	return config, nil
+}
+
+func isAnalyzer(name string) bool {
+	for _, analyzer := range files.Analyzers() {
+		if analyzer.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,6 +2,7 @@ package details
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 
@@ -13,7 +14,12 @@ import (
 // FileDetails contains details about a file. Duh.
 type FileDetails struct {
 	Path       string
+	MD5        string
+	SHA1       string
 	SHA256     string
+	SHA512     string
+	SSDeep     string
+	TLSH       string
 	Size       int64
 	SizeString string
 	Mimetype   string
@@ -55,7 +61,8 @@ func GetFileSizeString(fileString string) (string, error) {
 	return humanize.Bytes(uint64(info.Size())), nil
 }
 
-// GetFileDetails returns the file details
+// GetFileDetails returns the file details. The file is only read once, it's hashed with
+// every algorithm while the start of it is kept to sniff the MIME type
 func GetFileDetails(fileString string) (*FileDetails, error) {
 
 	var details FileDetails
@@ -68,7 +75,7 @@ func GetFileDetails(fileString string) (*FileDetails, error) {
 	}
 	defer f.Close()
 
-	info, err := os.Stat(fileString)
+	info, err := f.Stat()
 
 	if err != nil {
 		return nil, err
@@ -76,12 +83,39 @@ func GetFileDetails(fileString string) (*FileDetails, error) {
 
 	details.Size = info.Size()
 	details.SizeString = humanize.Bytes(uint64(info.Size()))
-	details.SHA256 = hashing.GetFileSHA256HashString(fileString)
-	details.Mimetype, err = GetFileType(fileString)
 
-	if err != nil {
-		return nil, err
+	hasher := hashing.NewHasher()
+	head := headBuffer{limit: sniffLength}
+
+	if _, err := io.Copy(io.MultiWriter(hasher, &head), f); err != nil {
+		return nil, fmt.Errorf("error reading file %q: %s", fileString, err.Error())
 	}
 
+	details.setHashes(hasher.Sum())
+	details.Mimetype = GetBytesType(head.data)
+
 	return &details, nil
 }
+
+func (d *FileDetails) setHashes(hashes *hashing.Hashes) {
+	d.MD5 = hashes.MD5
+	d.SHA1 = hashes.SHA1
+	d.SHA256 = hashes.SHA256
+	d.SHA512 = hashes.SHA512
+	d.SSDeep = hashes.SSDeep
+	d.TLSH = hashes.TLSH
+}
+
+// headBuffer keeps the start of everything written to it
+type headBuffer struct {
+	data  []byte
+	limit int
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if room := h.limit - len(h.data); room > 0 {
+		h.data = append(h.data, p[:min(room, len(p))]...)
+	}
+
+	return len(p), nil
+}
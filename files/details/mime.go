@@ -1,21 +1,25 @@
 package details
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"sync"
 
 	"file-inspector/files/hashing"
 
+	"github.com/dustin/go-humanize"
 	"github.com/gabriel-vasile/mimetype"
 )
 
 // FindFoldersWithFileTypes walks the provided rootpath and returns
-// folders containing files matching the filetype
-func FindFoldersWithFileTypes(rootPath string, mimetypes []string) ([]*FileDetails, map[string]int) {
+// files matching the filetypes, plus a count of them per folder
+func FindFoldersWithFileTypes(rootPath string, mimetypes []string) ([]*FileDetails, map[string]int, error) {
 
 	folders := make(map[string]int)
 	var fileCount int
@@ -52,6 +56,7 @@ func FindFoldersWithFileTypes(rootPath string, mimetypes []string) ([]*FileDetai
 			var details FileDetails
 			details.Path = thisFilePath
 			details.Size = info.Size()
+			details.SizeString = humanize.Bytes(uint64(info.Size()))
 
 			// send it to be identified
 			jobs <- &details
@@ -63,14 +68,14 @@ func FindFoldersWithFileTypes(rootPath string, mimetypes []string) ([]*FileDetai
 	// all jobs sent - close the channel
 	close(jobs)
 
-	// panic if the walker returned an error
-	if err != nil {
-		log.Panicf("%s\n", err)
-	}
-
 	// wait for them all to finish
 	wg.Wait()
 
+	// return if the walker returned an error, now the workers are done
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// results received, close the channel
 	close(results)
 
@@ -84,7 +89,7 @@ func FindFoldersWithFileTypes(rootPath string, mimetypes []string) ([]*FileDetai
 
 	folders = countFiles(files, folders)
 
-	return files, folders
+	return files, folders, nil
 }
 
 // FindFoldersWithFileType walks the provided rootpath and returns
@@ -164,6 +169,9 @@ func FindFilesInFolderByType(rootPath, mimetype string) []string {
 	return files
 }
 
+// how much of the start of a file the mimetype package reads to detect its type
+const sniffLength = 3072
+
 // GetFileType returns the mime type of the file
 func GetFileType(filePath string) (string, error) {
 
@@ -176,27 +184,61 @@ func GetFileType(filePath string) (string, error) {
 	return mime.String(), nil
 }
 
+// GetBytesType returns the MIME type of data that's already in memory. Only the
+// start of the data is needed, as with GetFileType
+func GetBytesType(data []byte) string {
+	return mimetype.Detect(data).String()
+}
+
 func getFileTypeWorker(mimetypes []string, jobs <-chan *FileDetails, results chan<- *FileDetails, wg *sync.WaitGroup) {
 
 	defer wg.Done()
 
 	for details := range jobs {
-		// list of types here: https://github.com/gabriel-vasile/mimetype/blob/master/supported_mimes.md
-		mime, err := mimetype.DetectFile(details.Path)
-
-		if err == nil {
-			for _, mimetype := range mimetypes {
-				if mime.String() == mimetype {
-					details.Mimetype = mime.String()
-					details.SHA256 = hashing.GetFileSHA256HashString(details.Path)
-					results <- details
-					continue
-				}
-			}
+		if err := identifyFile(details, mimetypes); err != nil {
+			log.Printf("Error trying to type file %q: %s", details.Path, err.Error())
+		} else if details.Mimetype != "" {
+			results <- details
 		}
 	}
 }
 
+// identifyFile sets the file's type if it's one of the MIME types, hashing it too. The
+// file is only read once, and only the start of it if it isn't one of the types
+func identifyFile(details *FileDetails, mimetypes []string) error {
+	f, err := os.Open(details.Path)
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	// list of types here: https://github.com/gabriel-vasile/mimetype/blob/master/supported_mimes.md
+	mime := GetBytesType(head[:n])
+
+	if !slices.Contains(mimetypes, mime) {
+		return nil
+	}
+
+	hashes, err := hashing.GetReaderHashes(io.MultiReader(bytes.NewReader(head[:n]), f))
+
+	if err != nil {
+		return err
+	}
+
+	details.Mimetype = mime
+	details.setHashes(hashes)
+
+	return nil
+}
+
 func countFiles(files []*FileDetails, folders map[string]int) map[string]int {
 
 	for _, file := range files {
@@ -0,0 +1,108 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"file-inspector/files/details"
+	"file-inspector/files/findings"
+)
+
+const (
+	// how much of the start of the file analyzers get to check for magic bytes
+	contentHeaderSize = 3072
+)
+
+var (
+	ErrUnsupportedFileType = errors.New("file type is not currently supported")
+)
+
+// Detection is what we think a file is from its content
+type Detection struct {
+	MimeType string
+	Analyzer Analyzer
+}
+
+// DetectType works out the file type from its content rather than its extension
+func DetectType(filePath string) (*Detection, error) {
+	f, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	detection, err := DetectReaderType(f)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading the start of file %q: %s", filePath, err.Error())
+	}
+
+	return detection, nil
+}
+
+// DetectReaderType is DetectType for content that's already open or in memory
+func DetectReaderType(r io.ReaderAt) (*Detection, error) {
+	header, err := readHeader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mime := details.GetBytesType(header)
+
+	detection := Detection{
+		MimeType: mime,
+		Analyzer: GetAnalyzerForContent(mime, header),
+	}
+
+	return &detection, nil
+}
+
+func readHeader(r io.ReaderAt) ([]byte, error) {
+	header := make([]byte, contentHeaderSize)
+	read, err := r.ReadAt(header, 0)
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return header[:read], nil
+}
+
+// checkExtension returns a finding if the extension belongs to a different type to the content
+func checkExtension(extension string, detected *Detection) *findings.Finding {
+	// nothing to compare
+	if extension == "" {
+		return nil
+	}
+
+	expected := GetAnalyzerForExtension(extension)
+
+	if expected == detected.Analyzer {
+		return nil
+	}
+
+	var evidence string
+
+	switch {
+	case expected != nil && detected.Analyzer != nil:
+		evidence = fmt.Sprintf("The file has a %s extension, but its content is %s (%q).", extension, detected.Analyzer.Name(), detected.MimeType)
+	case expected != nil:
+		evidence = fmt.Sprintf("We expect %q for files with %s extensions, but found %q.", strings.Join(expected.MimeTypes(), " or "), extension, detected.MimeType)
+	default:
+		evidence = fmt.Sprintf("The file has a %s extension, but its content is %s (%q), which should have a %s extension.", extension, detected.Analyzer.Name(), detected.MimeType, strings.Join(detected.Analyzer.Extensions(), " or "))
+	}
+
+	return &findings.Finding{
+		ID:          "file.mime-mismatch",
+		Title:       "Mismatched extension and MIME type",
+		Severity:    findings.SeverityHigh,
+		Category:    findings.CategoryFileType,
+		Evidence:    evidence,
+		Remediation: "Files are often renamed to get them past filters. Treat it as the detected type, not the extension.",
+	}
+}
@@ -1,17 +1,38 @@
 package files
 
 import (
+	"context"
 	"file-inspector/files/docx"
+	"file-inspector/files/findings"
+	"fmt"
 	"log"
 	"strings"
 )
 
-func processDocxFile(result *ProcessResult) {
+func init() {
+	RegisterAnalyzer(docxAnalyzer{})
+}
+
+// docxAnalyzer handles Word .docx documents
+type docxAnalyzer struct{}
+
+func (docxAnalyzer) Name() string         { return "docx" }
+func (docxAnalyzer) MimeTypes() []string  { return []string{docxMimeType} }
+func (docxAnalyzer) Extensions() []string { return []string{".docx"} }
+
+func (docxAnalyzer) Analyze(ctx context.Context, input *Input) (*ProcessResult, error) {
+	log.Println("Parsing document file")
+	res := ProcessResult{FilePath: input.FilePath}
+	processDocxFile(ctx, input, &res)
+	return &res, res.Error
+}
+
+func processDocxFile(ctx context.Context, input *Input, result *ProcessResult) {
 	//var analysisText bytes.Buffer
 	var metadata [][]string
 
 	// get metadata
-	coreProps, customProps, err := docx.GetDocProperties(result.FilePath)
+	coreProps, customProps, err := docx.GetDocPropertiesReader(ctx, input.Reader, input.Size)
 
 	if err != nil && !strings.Contains(err.Error(), "docProps/custom.xml not found") {
 		result.Completed = false
@@ -34,6 +55,7 @@ func processDocxFile(result *ProcessResult) {
 		for key, value := range coreMap {
 			if len(value) > 0 {
 				metadata = append(metadata, []string{key, value})
+				result.ExtractIOCs(value, "metadata "+key)
 			}
 		}
 	}
@@ -41,6 +63,16 @@ func processDocxFile(result *ProcessResult) {
 	if customProps != nil {
 		customMap := make(map[string]string)
 
+		if len(customProps.Properties) > 0 {
+			result.AddFinding(findings.Finding{
+				ID:       "docx.custom-properties",
+				Title:    fmt.Sprintf("Document has %d custom properties", len(customProps.Properties)),
+				Severity: findings.SeverityInfo,
+				Category: findings.CategoryMetadata,
+				Location: findings.Location{Field: "docProps/custom.xml"},
+			})
+		}
+
 		for _, prop := range customProps.Properties {
 			customMap[prop.Name] = prop.Value
 		}
@@ -48,14 +80,47 @@ func processDocxFile(result *ProcessResult) {
 		for key, value := range customMap {
 			if len(value) > 0 {
 				metadata = append(metadata, []string{key, value})
+				result.ExtractIOCs(value, "metadata "+key)
 			}
 		}
 	}
 
+	// the document's text and external links
+	parts, err := docx.GetTextPartsReader(ctx, input.Reader, input.Size)
+
+	if err != nil {
+		result.Completed = false
+		result.Error = err
+		return
+	}
+
+	for _, part := range parts {
+		result.ExtractIOCs(part.Text, part.Name)
+	}
+
+	// the parts are compressed in the zip, so scan each of them
+	if hasRules() {
+		err := docx.ReadEntries(ctx, input.Reader, input.Size, func(name string, data []byte) {
+			scanWithRules(ctx, data, findings.Location{Field: name}, result)
+		})
+
+		if err != nil {
+			log.Printf("Error reading document parts for YARA scanning: %s\n", err.Error())
+		}
+	}
+
+	result.Metadata = metadata
+
+	// ran out of time scanning the parts, so keep what we found
+	if err := ctx.Err(); err != nil {
+		result.Completed = false
+		result.Error = err
+		return
+	}
+
 	log.Println("Docx processing done")
 	result.Completed = true
 
 	//result.Analysis = analysis.String()
-	result.Metadata = metadata
 
 }
@@ -0,0 +1,170 @@
+package docx
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"file-inspector/utils/limits"
+)
+
+// parts bigger than this are only read up to the limit, so a zip bomb can't use up all the
+// memory. Everything read also counts towards the file's budget, see limits.Budget
+const maxPartSize = 8 * 1024 * 1024
+
+// Part is the text of one of the XML parts in a document, e.g. "word/document.xml"
+type Part struct {
+	Name string
+	Text string
+}
+
+// GetTextPartsReader returns the text of every XML part in the document. For relationship
+// parts, e.g. "word/_rels/document.xml.rels", it's the external targets, as that's where
+// hyperlinks and remote templates are. Parts that can't be read are skipped, as are those
+// past the limits in the context's budget. If the context is done part way through, the
+// parts read so far are returned with the context's error
+func GetTextPartsReader(ctx context.Context, data io.ReaderAt, size int64) ([]Part, error) {
+	r, err := zip.NewReader(data, size)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for zip reader: %s", err.Error())
+	}
+
+	budget := limits.FromContext(ctx)
+	var parts []Part
+
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return parts, err
+		}
+
+		// the budget records why we stopped
+		if budget.AddObject(f.Name) != nil {
+			break
+		}
+
+		isRels := strings.HasSuffix(f.Name, ".rels")
+
+		if !isRels && !strings.HasSuffix(f.Name, ".xml") {
+			continue
+		}
+
+		text, err := readPartText(f, isRels, budget)
+
+		if err != nil || strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		parts = append(parts, Part{Name: f.Name, Text: text})
+	}
+
+	return parts, nil
+}
+
+// readPartText gets the character data from the part, with a new line after each paragraph.
+// Tags and attributes are dropped, as they're full of schema URLs
+func readPartText(f *zip.File, externalTargets bool, budget *limits.Budget) (string, error) {
+	rc, err := f.Open()
+
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(io.LimitReader(budget.Reader(rc, f.Name), maxPartSize))
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// keep what we managed to read
+			return text.String(), nil
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if externalTargets && t.Name.Local == "Relationship" {
+				writeExternalTarget(&text, t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "p" {
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if !externalTargets {
+				text.Write(t)
+			}
+		}
+	}
+
+	return text.String(), nil
+}
+
+func writeExternalTarget(text *strings.Builder, relationship xml.StartElement) {
+	var target string
+	external := false
+
+	for _, attr := range relationship.Attr {
+		switch attr.Name.Local {
+		case "Target":
+			target = attr.Value
+		case "TargetMode":
+			external = attr.Value == "External"
+		}
+	}
+
+	if external && target != "" {
+		text.WriteString(target + "\n")
+	}
+}
+
+// ReadEntries passes the contents of every file in the document's zip to fn, e.g. so it
+// can be scanned. Entries are cut off at maxPartSize, and ones that can't be read are
+// skipped, as are those past the limits in the context's budget. If the context is done
+// part way through, the context's error is returned
+func ReadEntries(ctx context.Context, data io.ReaderAt, size int64, fn func(name string, data []byte)) error {
+	r, err := zip.NewReader(data, size)
+
+	if err != nil {
+		return fmt.Errorf("failed to open file for zip reader: %s", err.Error())
+	}
+
+	budget := limits.FromContext(ctx)
+
+	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// counted again, as they're read again, with the file's attachments sharing the
+		// budget. The budget records why we stopped
+		if budget.AddObject(f.Name) != nil {
+			break
+		}
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := f.Open()
+
+		if err != nil {
+			continue
+		}
+
+		contents, _ := budget.ReadAll(rc, maxPartSize, f.Name)
+		rc.Close()
+
+		if len(contents) > 0 {
+			fn(f.Name, contents)
+		}
+	}
+
+	return nil
+}
@@ -2,8 +2,12 @@ package docx
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/xml"
 	"fmt"
+	"io"
+
+	"file-inspector/utils/limits"
 )
 
 // CoreProperties represents the core properties XML structure
@@ -41,15 +45,31 @@ func GetDocProperties(filePath string) (*CoreProperties, *CustomProperties, erro
 
 	defer r.Close()
 
+	return getDocProperties(&r.Reader, limits.NewBudget())
+}
+
+// GetDocPropertiesReader is GetDocProperties for a document that's already open or in memory.
+// The properties are only decompressed up to the limits in the context's budget
+func GetDocPropertiesReader(ctx context.Context, data io.ReaderAt, size int64) (*CoreProperties, *CustomProperties, error) {
+	r, err := zip.NewReader(data, size)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file for zip reader: %s", err.Error())
+	}
+
+	return getDocProperties(r, limits.FromContext(ctx))
+}
+
+func getDocProperties(r *zip.Reader, budget *limits.Budget) (*CoreProperties, *CustomProperties, error) {
 	// Core properties
-	coreProps, err := extractCoreProperties(&r.Reader)
+	coreProps, err := extractCoreProperties(r, budget)
 
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to extract core properties: %s", err.Error())
 	}
 
 	// Custom Properties
-	customProps, err := extractCustomProperties(&r.Reader)
+	customProps, err := extractCustomProperties(r, budget)
 
 	if err != nil {
 		return coreProps, nil, fmt.Errorf("error getting custom properties: %s", err)
@@ -59,7 +79,7 @@ func GetDocProperties(filePath string) (*CoreProperties, *CustomProperties, erro
 }
 
 // extractXML reads and decodes XML from a .docx ZIP entry
-func extractXML(r *zip.Reader, filePath string, v interface{}) error {
+func extractXML(r *zip.Reader, filePath string, v interface{}, budget *limits.Budget) error {
 	for _, f := range r.File {
 		if f.Name == filePath {
 			rc, err := f.Open()
@@ -67,16 +87,16 @@ func extractXML(r *zip.Reader, filePath string, v interface{}) error {
 				return err
 			}
 			defer rc.Close()
-			return xml.NewDecoder(rc).Decode(v)
+			return xml.NewDecoder(budget.Reader(rc, filePath)).Decode(v)
 		}
 	}
 	return fmt.Errorf("%s not found", filePath)
 }
 
 // extractCoreProperties retrieves core properties
-func extractCoreProperties(r *zip.Reader) (*CoreProperties, error) {
+func extractCoreProperties(r *zip.Reader, budget *limits.Budget) (*CoreProperties, error) {
 	var coreProps CoreProperties
-	err := extractXML(r, "docProps/core.xml", &coreProps)
+	err := extractXML(r, "docProps/core.xml", &coreProps, budget)
 	if err != nil {
 		return nil, err
 	}
@@ -84,9 +104,9 @@ func extractCoreProperties(r *zip.Reader) (*CoreProperties, error) {
 }
 
 // extractCustomProperties retrieves custom properties
-func extractCustomProperties(r *zip.Reader) (*CustomProperties, error) {
+func extractCustomProperties(r *zip.Reader, budget *limits.Budget) (*CustomProperties, error) {
 	var customProps CustomProperties
-	err := extractXML(r, "docProps/custom.xml", &customProps)
+	err := extractXML(r, "docProps/custom.xml", &customProps, budget)
 	if err != nil {
 		return nil, err
 	}
@@ -1,17 +1,20 @@
 package files
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"sort"
 	"strings"
 
 	"mvdan.cc/xurls/v2"
 
 	"file-inspector/emails/emlparse"
 	"file-inspector/emails/msgparse"
+	"file-inspector/files/findings"
 
 	"file-inspector/utils/safelinks"
 	"file-inspector/utils/urls"
@@ -42,10 +45,116 @@ const (
 	msgMSIPLabel     = "Microsoft Information Protection (MSIP) Label"
 )
 
-func processMsgFile(result *ProcessResult) {
-	msg, err := msgparse.ReadMsgFile(result.FilePath, false)
+func init() {
+	RegisterAnalyzer(msgAnalyzer{})
+	RegisterAnalyzer(emlAnalyzer{})
+}
 
-	if err != nil {
+// msgAnalyzer handles Outlook .msg files
+type msgAnalyzer struct{}
+
+func (msgAnalyzer) Name() string         { return "msg" }
+func (msgAnalyzer) MimeTypes() []string  { return []string{msgMimeType} }
+func (msgAnalyzer) Extensions() []string { return []string{".msg"} }
+
+func (msgAnalyzer) Analyze(ctx context.Context, input *Input) (*ProcessResult, error) {
+	log.Println("Parsing email file")
+	res := ProcessResult{FilePath: input.FilePath}
+	processMsgFile(ctx, input, &res)
+	return &res, res.Error
+}
+
+// emlAnalyzer handles RFC 822 .eml files
+type emlAnalyzer struct{}
+
+func (emlAnalyzer) Name() string         { return "eml" }
+func (emlAnalyzer) MimeTypes() []string  { return []string{emlMimeType, emlAltMimeType} }
+func (emlAnalyzer) Extensions() []string { return []string{".eml"} }
+
+// lots of things are plain text, so check it starts with email headers
+func (emlAnalyzer) MatchContent(header []byte) bool {
+	return looksLikeEmail(header)
+}
+
+func (emlAnalyzer) Analyze(ctx context.Context, input *Input) (*ProcessResult, error) {
+	log.Println("Parsing email file")
+	res := ProcessResult{FilePath: input.FilePath}
+	processEmlFile(ctx, input, &res)
+	return &res, res.Error
+}
+
+// headers we'd expect at least one of near the top of an email
+var knownEmailHeaders = map[string]bool{
+	"from":                   true,
+	"to":                     true,
+	"subject":                true,
+	"date":                   true,
+	"received":               true,
+	"return-path":            true,
+	"message-id":             true,
+	"mime-version":           true,
+	"delivered-to":           true,
+	"reply-to":               true,
+	"authentication-results": true,
+}
+
+// check the content starts with "Name: value" headers, at least one of which we'd expect in an email
+func looksLikeEmail(header []byte) bool {
+	lines := strings.Split(string(header), "\n")
+
+	// the last line may have been cut off part way through
+	if len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+
+	// some clients export with an mbox style "From " line first
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "From ") {
+		lines = lines[1:]
+	}
+
+	headerCount := 0
+	knownCount := 0
+
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+
+		// a blank line ends the headers
+		if line == "" {
+			break
+		}
+
+		// folded continuation of the previous header
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+
+		if colon <= 0 {
+			return false
+		}
+
+		name := strings.ToLower(line[:colon])
+
+		if strings.ContainsAny(name, " \t") {
+			return false
+		}
+
+		headerCount++
+
+		if knownEmailHeaders[name] {
+			knownCount++
+		}
+	}
+
+	return headerCount >= 2 && knownCount >= 1
+}
+
+func processMsgFile(ctx context.Context, input *Input, result *ProcessResult) {
+	msg, err := msgparse.ReadMsg(ctx, input.Reader, false)
+
+	// if we were stopped part way through there's still a partial message to report on
+	if err != nil && ctx.Err() == nil {
 		result.Parsed = false
 		result.Completed = false
 		result.Error = err
@@ -58,7 +167,6 @@ func processMsgFile(result *ProcessResult) {
 	// print key fields
 	keyFieldNames := []string{msgSender, msgDisplayName, msgSenderSMTP, msgSenderEmail, msgSenderEmail2, msgReceivedName, msgReceivedSMTP, msg7bitEmail, msgReceivedEmail, subject, messageTopic, msgMessageID}
 
-	var analysis bytes.Buffer
 	var metadata [][]string
 
 	// Print values
@@ -70,37 +178,50 @@ func processMsgFile(result *ProcessResult) {
 		}
 	}
 
+	headers := msg.GetPropertyByName("Message Headers")
+	result.ExtractIOCs(headers, "headers")
+
 	// add details on authentication
-	authHeader, err := msgparse.GetHeaderByName(msg.GetPropertyByName("Message Headers"), authResults)
-	result.Dangerous = false
+	authHeader, err := msgparse.GetHeaderByName(headers, authResults)
 
 	if err != nil {
 		result.Completed = false
 		result.Error = err
 		return
 	} else if authHeader != "" {
-		result.Dangerous = parseAuthResults(authHeader, &analysis)
+		parseAuthResults(authHeader, result)
 	}
 
 	// body details
-	inspectBody(msg.GetPropertyByName("Message body"), &analysis)
+	body := msg.GetPropertyByName("Message body")
+	inspectBody(ctx, body, result)
 
 	// add attachment details, if there are any
 	if len(msg.Attachments) > 0 {
-		addAttachmentDetails(msg.Attachments, &analysis)
+		addAttachmentDetails(msg.Attachments, result)
+		analyseAttachments(ctx, msg.Attachments, input.Depth, result)
 	}
 
-	log.Println("Msg processing done")
+	applyEmailRules(newRulesEmail(getMsgHeader(msg, headers), body, msg.Attachments), result)
+
 	result.Metadata = metadata
-	result.Analysis = analysis.String()
+
+	// stopped part way through, so the results are partial
+	if err := ctx.Err(); err != nil {
+		result.Completed = false
+		result.Error = err
+		return
+	}
+
+	log.Println("Msg processing done")
 	result.Completed = true
 }
 
-// return true if dangerous
-func processEmlFile(result *ProcessResult) {
-	emlFile, err := emlparse.ReadFromFile(result.FilePath)
+func processEmlFile(ctx context.Context, input *Input, result *ProcessResult) {
+	emlFile, err := emlparse.ReadFromReader(ctx, io.NewSectionReader(input.Reader, 0, input.Size))
 
-	if err != nil {
+	// if we were stopped part way through there's still a partial email to report on
+	if err != nil && ctx.Err() == nil {
 		result.Parsed = false
 		result.Completed = false
 		result.Error = err
@@ -108,7 +229,6 @@ func processEmlFile(result *ProcessResult) {
 	}
 
 	keyHeaders := []string{emlFrom, emlReturnPath, emlTo, emlDate, subject, emlMessageID, emlContentType}
-	var analysis bytes.Buffer
 	var metadata [][]string
 
 	// Print values
@@ -120,91 +240,136 @@ func processEmlFile(result *ProcessResult) {
 		}
 	}
 
+	// sorted so the sources are always listed in the same order
+	var headerNames []string
+
+	for name := range emlFile.Message.Header {
+		headerNames = append(headerNames, name)
+	}
+
+	sort.Strings(headerNames)
+
+	for _, name := range headerNames {
+		for _, value := range emlFile.Message.Header[name] {
+			result.ExtractIOCs(value, "header "+name)
+		}
+	}
+
 	// get the auth results and parse them
 	authHeader := (emlFile.Message.Header.Get(authResults))
-	result.Dangerous = false
 
 	if authHeader != "" {
-		result.Dangerous = parseAuthResults(authHeader, &analysis)
+		parseAuthResults(authHeader, result)
 	}
 
 	// add attachment details, if there are any
 	if len(emlFile.Attachments) > 0 {
-		addAttachmentDetails(emlFile.Attachments, &analysis)
+		addAttachmentDetails(emlFile.Attachments, result)
+		analyseAttachments(ctx, emlFile.Attachments, input.Depth, result)
 	}
 
 	// body details
-	inspectBody(emlFile.Body, &analysis)
+	inspectBody(ctx, emlFile.Body, result)
+
+	applyEmailRules(newRulesEmail(emlFile.Message.Header, emlFile.Body, emlFile.Attachments), result)
 
-	log.Println("Eml processing done")
 	result.Metadata = metadata
-	result.Analysis = analysis.String()
+
+	// stopped part way through, so the results are partial
+	if err := ctx.Err(); err != nil {
+		result.Completed = false
+		result.Error = err
+		return
+	}
+
+	log.Println("Eml processing done")
 	result.Completed = true
 }
 
-// return true if dangerous
-func parseAuthResults(authHeader string, buffer *bytes.Buffer) bool {
+// add a finding for each of the DKIM, SPF and DMARC results
+func parseAuthResults(authHeader string, result *ProcessResult) {
 	fields := strings.Split(authHeader, ";")
 
-	if len(fields) == 0 {
-		return true
-	}
-
-	buffer.WriteString("\nAuthentication results:\n")
-	dangerous := false
-
 	for _, field := range fields {
 		field = strings.TrimSpace(field)
 
 		if strings.HasPrefix(field, "dkim=") || strings.HasPrefix(field, "spf=") || strings.HasPrefix(field, "dmarc=") {
+			// e.g. "dkim"
+			mechanism := field[:strings.Index(field, "=")]
+			name := strings.ToUpper(mechanism)
+
 			if strings.Contains(field, "=pass") {
-				buffer.WriteString(fmt.Sprintf("\tGOOD: %s\n", field))
+				result.AddFinding(findings.Finding{
+					ID:       fmt.Sprintf("auth.%s.pass", mechanism),
+					Title:    fmt.Sprintf("%s check passed", name),
+					Severity: findings.SeverityInfo,
+					Category: findings.CategoryAuthentication,
+					Evidence: field,
+					Location: findings.Location{Field: authResults},
+				})
 			} else {
-				buffer.WriteString(fmt.Sprintf("\tBAD: %s\n", field))
-				dangerous = true
+				result.AddFinding(findings.Finding{
+					ID:          fmt.Sprintf("auth.%s.fail", mechanism),
+					Title:       fmt.Sprintf("%s check did not pass", name),
+					Severity:    findings.SeverityMedium,
+					Category:    findings.CategoryAuthentication,
+					Evidence:    field,
+					Location:    findings.Location{Field: authResults},
+					Remediation: "The sender may be spoofed. Check the sending domain and return path before trusting the content.",
+				})
 			}
 		}
 	}
 
 	log.Println("Auth processing done")
-	return dangerous
 }
 
-// TODO return result
-func addAttachmentDetails(attachments []msgparse.Attachment, analysis *bytes.Buffer) {
+func addAttachmentDetails(attachments []msgparse.Attachment, result *ProcessResult) {
 	log.Println("Parsing attachments")
-	analysis.WriteString(fmt.Sprintf("\nEmail has %d attachments:\n", len(attachments)))
 
 	for i, a := range attachments {
-		analysis.WriteString(fmt.Sprintf("\tAttachment %d:\n", i+1))
+		var details []string
 
 		if len(a.Filename) > 0 {
-			analysis.WriteString(fmt.Sprintf("\tFilename: %q\n", a.Filename))
+			details = append(details, fmt.Sprintf("Filename: %q", a.Filename))
 		}
 
 		if len(a.LongFilename) > 0 {
-			analysis.WriteString(fmt.Sprintf("\tLong Filename: %q\n", a.LongFilename))
+			details = append(details, fmt.Sprintf("Long Filename: %q", a.LongFilename))
 		}
 
 		if len(a.MimeTag) > 0 {
-			analysis.WriteString(fmt.Sprintf("\tMIME tag: %q\n", a.MimeTag))
+			details = append(details, fmt.Sprintf("MIME tag: %q", a.MimeTag))
 		}
 
-		analysis.WriteString(fmt.Sprintf("\tSize: %d bytes\n", len(a.Bytes)))
+		details = append(details, fmt.Sprintf("Size: %d bytes", len(a.Bytes)))
 
 		hash := sha256.New()
 		hash.Write(a.Bytes)
-		analysis.WriteString(fmt.Sprintf("\tSHA-256 hash: %q\n\n", hex.EncodeToString(hash.Sum(nil))))
+		details = append(details, fmt.Sprintf("SHA-256 hash: %q", hex.EncodeToString(hash.Sum(nil))))
+
+		result.AddFinding(findings.Finding{
+			ID:       "attachment.details",
+			Title:    fmt.Sprintf("Attachment %d of %d", i+1, len(attachments)),
+			Severity: findings.SeverityInfo,
+			Category: findings.CategoryAttachment,
+			Evidence: strings.Join(details, ", "),
+			Location: findings.Location{Attachment: i + 1},
+		})
 	}
 }
 
-// TODO return result
-func inspectBody(body string, analysis *bytes.Buffer) {
+func inspectBody(ctx context.Context, body string, result *ProcessResult) {
 	log.Println("Inspecting email body")
-	analysis.WriteString("\nBody Details:\n")
+	result.ExtractIOCs(body, "body")
 
 	if len(body) == 0 {
-		analysis.WriteString("\tEmpty body\n")
+		result.AddFinding(findings.Finding{
+			ID:       "body.empty",
+			Title:    "Empty body",
+			Severity: findings.SeverityInfo,
+			Category: findings.CategoryBody,
+		})
 		return
 	} else {
 		// count lines
@@ -212,19 +377,28 @@ func inspectBody(body string, analysis *bytes.Buffer) {
 		// lines := strings.Split(body, "\r")
 		// fmt.Printf("\tBody has %d lines of content\n", len(lines))
 
-		analysis.WriteString("\tEmail body has content.\n")
+		result.AddFinding(findings.Finding{
+			ID:       "body.content",
+			Title:    "Email body has content",
+			Severity: findings.SeverityInfo,
+			Category: findings.CategoryBody,
+		})
 	}
 
-	err := inspectLinks(body, analysis)
+	err := inspectLinks(ctx, body, result)
 
 	if err != nil {
-		analysis.WriteString(fmt.Sprintf("\tError inspecting body for links: %s.", err.Error()))
+		result.AddFinding(findings.Finding{
+			ID:       "body.link-error",
+			Title:    "Error inspecting body for links",
+			Severity: findings.SeverityLow,
+			Category: findings.CategoryParsing,
+			Evidence: err.Error(),
+		})
 	}
-
-	analysis.WriteString("\n")
 }
 
-func inspectLinks(body string, analysis *bytes.Buffer) error {
+func inspectLinks(ctx context.Context, body string, result *ProcessResult) error {
 	log.Println("Looking for links")
 
 	// find all URLs in the body
@@ -235,8 +409,6 @@ func inspectLinks(body string, analysis *bytes.Buffer) error {
 
 	// if we found any, process them one by one
 	if len(res) > 0 {
-		analysis.WriteString(fmt.Sprintf("\n\tFound %d URLs in the email body:\n", len(res)))
-
 		// check Alexa common 100k domains
 		log.Println("Loading common URLs")
 		commonChecker, err := urls.GetCommonURLChecker()
@@ -248,6 +420,11 @@ func inspectLinks(body string, analysis *bytes.Buffer) error {
 		log.Printf("Loaded %d common Alexa domains\n", commonChecker.CountKnownDomains())
 
 		for _, entry := range res {
+			// the caller checks why we stopped
+			if ctx.Err() != nil {
+				break
+			}
+
 			entry = strings.ToLower(entry)
 
 			// skip email links, empty strings
@@ -266,19 +443,38 @@ func inspectLinks(body string, analysis *bytes.Buffer) error {
 				original, err := safelinks.ExtractOriginalURL(entry)
 
 				if err != nil {
-					analysis.WriteString(fmt.Sprintf("\t\tError extracting URL: %s\n", err.Error()))
+					result.AddFinding(findings.Finding{
+						ID:       "url.safelink-error",
+						Title:    "Error extracting URL from safelink",
+						Severity: findings.SeverityLow,
+						Category: findings.CategoryURL,
+						Evidence: fmt.Sprintf("%q: %s", entry, err.Error()),
+					})
 				} else {
 					// check if it's from a common (most popular 100k) domain
-					isCommon, err := commonChecker.Check(entry)
+					isCommon, err := commonChecker.Check(original)
 
 					if err != nil {
 						return err
 					}
 
 					if isCommon {
-						analysis.WriteString(fmt.Sprintf("\t\tSafelink redirects to common domain %q\n", original))
+						result.AddFinding(findings.Finding{
+							ID:       "url.safelink.common-domain",
+							Title:    "Safelink redirects to common domain",
+							Severity: findings.SeverityInfo,
+							Category: findings.CategoryURL,
+							Evidence: original,
+						})
 					} else {
-						analysis.WriteString(fmt.Sprintf("\t\tSafelink redirects to *uncommon* domain %q\n", original))
+						result.AddFinding(findings.Finding{
+							ID:          "url.safelink.uncommon-domain",
+							Title:       "Safelink redirects to *uncommon* domain",
+							Severity:    findings.SeverityMedium,
+							Category:    findings.CategoryURL,
+							Evidence:    original,
+							Remediation: "Check the domain's reputation before visiting it.",
+						})
 					}
 				}
 			} else {
@@ -291,9 +487,22 @@ func inspectLinks(body string, analysis *bytes.Buffer) error {
 				}
 
 				if isCommon {
-					analysis.WriteString(fmt.Sprintf("\t\tURL from common domain: %q\n", entry))
+					result.AddFinding(findings.Finding{
+						ID:       "url.common-domain",
+						Title:    "URL from common domain",
+						Severity: findings.SeverityInfo,
+						Category: findings.CategoryURL,
+						Evidence: entry,
+					})
 				} else {
-					analysis.WriteString(fmt.Sprintf("\t\tURL from *uncommon* domain: %q\n", entry))
+					result.AddFinding(findings.Finding{
+						ID:          "url.uncommon-domain",
+						Title:       "URL from *uncommon* domain",
+						Severity:    findings.SeverityMedium,
+						Category:    findings.CategoryURL,
+						Evidence:    entry,
+						Remediation: "Check the domain's reputation before visiting it.",
+					})
 				}
 			}
 		}
@@ -0,0 +1,130 @@
+package files
+
+import (
+	"log"
+	"net/mail"
+	"strings"
+	"sync"
+
+	"file-inspector/emails/msgparse"
+	"file-inspector/files/emailrules"
+	"file-inspector/files/findings"
+)
+
+var (
+	emailRulesMu sync.RWMutex
+	emailRules   *emailrules.RuleSet
+)
+
+// DefaultEmailRulesDir returns where email rules are loaded from if no other directory
+// is given, e.g. "~/.config/file-inspector/email-rules" on Linux
+func DefaultEmailRulesDir() (string, error) {
+	return configPath("email-rules")
+}
+
+// SetEmailRules sets the rules every email is checked against. Nil turns them off
+func SetEmailRules(r *emailrules.RuleSet) {
+	emailRulesMu.Lock()
+	defer emailRulesMu.Unlock()
+
+	emailRules = r
+}
+
+// LoadEmailRules loads the .toml files in the directory and checks every email against
+// them, returning how many rules were loaded. Files with errors are skipped, so rules can
+// be loaded even when the error isn't nil
+func LoadEmailRules(dir string) (int, error) {
+	loaded, err := emailrules.LoadDir(dir)
+
+	if loaded.Len() > 0 {
+		SetEmailRules(loaded)
+	}
+
+	log.Printf("Loaded %d email rules from %q\n", loaded.Len(), dir)
+
+	return loaded.Len(), err
+}
+
+func getEmailRules() *emailrules.RuleSet {
+	emailRulesMu.RLock()
+	defer emailRulesMu.RUnlock()
+
+	return emailRules
+}
+
+// applyEmailRules adds a finding for each rule the email matches
+func applyEmailRules(email *emailrules.Email, result *ProcessResult) {
+	loaded := getEmailRules()
+
+	if loaded == nil {
+		return
+	}
+
+	for _, match := range loaded.Evaluate(email) {
+		rule := match.Rule
+		evidence := match.Evidence
+
+		if rule.Description != "" {
+			evidence = append([]string{rule.Description}, evidence...)
+		}
+
+		remediation := rule.Remediation
+
+		if remediation == "" {
+			remediation = "The email matches a rule written to detect known phishing campaigns. Don't act on it until it's been checked."
+		}
+
+		result.AddFinding(findings.Finding{
+			ID:          "email-rule." + rule.ID,
+			Title:       rule.Title,
+			Severity:    rule.SeverityLevel(),
+			Category:    findings.CategorySignature,
+			Evidence:    strings.Join(evidence, ". "),
+			Remediation: remediation,
+		})
+	}
+}
+
+// newRulesEmail gathers the fields the email rules match on
+func newRulesEmail(header mail.Header, body string, attachments []msgparse.Attachment) *emailrules.Email {
+	email := emailrules.Email{Header: header, Body: body}
+
+	for i, attachment := range attachments {
+		email.Attachments = append(email.Attachments, emailrules.Attachment{
+			Name:     getAttachmentName(attachment, i+1),
+			MimeType: attachment.MimeTag,
+		})
+	}
+
+	return &email
+}
+
+// getMsgHeader returns the transport headers of a .msg file, with the sender and subject
+// filled in from the message's properties if the headers are missing, e.g. for drafts
+func getMsgHeader(msg *msgparse.Message, headers string) mail.Header {
+	parsed, err := msgparse.ParseHeaders(headers)
+
+	if err != nil {
+		log.Printf("Error parsing message headers: %s\n", err.Error())
+	}
+
+	header := mail.Header(parsed)
+
+	if header == nil {
+		header = make(mail.Header)
+	}
+
+	if header.Get("From") == "" {
+		if sender := msg.GetPropertyByName(msgSenderSMTP); sender != "" {
+			header["From"] = []string{(&mail.Address{Name: msg.GetPropertyByName(msgSender), Address: sender}).String()}
+		}
+	}
+
+	if header.Get(subject) == "" {
+		if value := msg.GetPropertyByName(subject); value != "" {
+			header[subject] = []string{value}
+		}
+	}
+
+	return header
+}
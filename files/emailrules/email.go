@@ -0,0 +1,145 @@
+package emailrules
+
+import (
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+
+	"mvdan.cc/xurls/v2"
+)
+
+// Email is the parsed email the rules are matched against. The analyzers fill it in from
+// the .eml or .msg file
+type Email struct {
+	Header      mail.Header
+	Body        string
+	Attachments []Attachment
+
+	fields map[string][]string
+}
+
+// Attachment is the name and type of an attachment
+type Attachment struct {
+	Name     string
+	MimeType string
+}
+
+// values returns the values of the field, working them out the first time
+func (e *Email) values(field string) []string {
+	if e.fields == nil {
+		e.fields = e.buildFields()
+	}
+
+	if name, ok := strings.CutPrefix(field, headerPrefix); ok {
+		return e.Header[textproto.CanonicalMIMEHeaderKey(name)]
+	}
+
+	return e.fields[field]
+}
+
+func (e *Email) buildFields() map[string][]string {
+	fields := make(map[string][]string)
+	add := func(field string, values ...string) {
+		for _, value := range values {
+			if value = strings.TrimSpace(value); value != "" {
+				fields[field] = append(fields[field], value)
+			}
+		}
+	}
+
+	add("subject", e.Header.Get("Subject"))
+	add("body", e.Body)
+
+	for _, sender := range parseAddresses(e.Header.Get("From")) {
+		add("sender.address", sender.Address)
+		add("sender.name", sender.Name)
+		add("sender.domain", domainOf(sender.Address))
+	}
+
+	for _, replyTo := range parseAddresses(e.Header.Get("Reply-To")) {
+		add("reply_to.address", replyTo.Address)
+		add("reply_to.domain", domainOf(replyTo.Address))
+	}
+
+	for _, returnPath := range parseAddresses(e.Header.Get("Return-Path")) {
+		add("return_path.domain", domainOf(returnPath.Address))
+	}
+
+	for _, header := range []string{"To", "Cc"} {
+		for _, recipient := range parseAddresses(e.Header.Get(header)) {
+			add("recipient.address", recipient.Address)
+			add("recipient.domain", domainOf(recipient.Address))
+		}
+	}
+
+	for _, results := range e.Header["Authentication-Results"] {
+		for _, part := range strings.Split(results, ";") {
+			mechanism, result, found := strings.Cut(strings.TrimSpace(part), "=")
+
+			if !found {
+				continue
+			}
+
+			switch mechanism = strings.ToLower(mechanism); mechanism {
+			case "spf", "dkim", "dmarc":
+				// e.g. "fail (signature did not verify)" or "pass header.d=example.com"
+				if words := strings.Fields(result); len(words) > 0 {
+					add("auth."+mechanism, words[0])
+				}
+			}
+		}
+	}
+
+	for _, attachment := range e.Attachments {
+		add("attachment.name", attachment.Name)
+		add("attachment.extension", strings.TrimPrefix(path.Ext(attachment.Name), "."))
+		add("attachment.type", attachment.MimeType)
+	}
+
+	for _, link := range xurls.Strict().FindAllString(e.Body, -1) {
+		add("url", link)
+
+		if parsed, err := url.Parse(link); err == nil {
+			add("url.domain", parsed.Hostname())
+		}
+	}
+
+	return fields
+}
+
+// parseAddresses parses an address list, falling back to a bare address as .msg files
+// and spam often have addresses net/mail won't accept
+func parseAddresses(list string) []*mail.Address {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+
+	addresses, err := mail.ParseAddressList(list)
+
+	if err == nil {
+		return addresses
+	}
+
+	if start, end := strings.LastIndex(list, "<"), strings.LastIndex(list, ">"); start >= 0 && end > start {
+		return []*mail.Address{{
+			Name:    strings.Trim(strings.TrimSpace(list[:start]), `"`),
+			Address: list[start+1 : end],
+		}}
+	}
+
+	if strings.Contains(list, "@") {
+		return []*mail.Address{{Address: strings.TrimSpace(list)}}
+	}
+
+	return nil
+}
+
+func domainOf(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return strings.ToLower(address[at+1:])
+	}
+
+	return ""
+}
@@ -0,0 +1,124 @@
+package emailrules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the most of a matched value shown in the evidence, as bodies can be long
+const maxEvidenceValue = 100
+
+// Match is a rule an email matched, with the field values that made it match
+type Match struct {
+	Rule     *Rule
+	Evidence []string
+}
+
+// Evaluate returns the rules the email matches, in the order they were loaded
+func (s *RuleSet) Evaluate(email *Email) []Match {
+	var matches []Match
+
+	for _, rule := range s.Rules {
+		var evidence []string
+
+		if rule.Match.eval(email, &evidence) {
+			matches = append(matches, Match{Rule: rule, Evidence: evidence})
+		}
+	}
+
+	return matches
+}
+
+// eval checks the condition, adding what matched to the evidence. Evidence from inside a
+// "not" or a failed branch isn't kept
+func (c *Condition) eval(email *Email, evidence *[]string) bool {
+	switch {
+	case c.All != nil:
+		var found []string
+
+		for i := range c.All {
+			if !c.All[i].eval(email, &found) {
+				return false
+			}
+		}
+
+		*evidence = append(*evidence, found...)
+		return true
+	case c.Any != nil:
+		matched := false
+
+		// keep going so the evidence shows everything that matched
+		for i := range c.Any {
+			if c.Any[i].eval(email, evidence) {
+				matched = true
+			}
+		}
+
+		return matched
+	case c.Not != nil:
+		var ignored []string
+		return !c.Not.eval(email, &ignored)
+	}
+
+	values := email.values(c.Field)
+
+	if c.Exists != nil {
+		return (len(values) > 0) == *c.Exists
+	}
+
+	for _, value := range values {
+		if test, ok := c.test(value); ok {
+			*evidence = append(*evidence, fmt.Sprintf("%s %q %s", c.Field, shorten(value), test))
+			return true
+		}
+	}
+
+	return false
+}
+
+// test checks one value, returning a description of the test that passed
+func (c *Condition) test(value string) (string, bool) {
+	lower := strings.ToLower(value)
+
+	for _, want := range c.Equals {
+		if strings.EqualFold(value, want) {
+			return fmt.Sprintf("equals %q", want), true
+		}
+	}
+
+	for _, want := range c.Contains {
+		if strings.Contains(lower, strings.ToLower(want)) {
+			return fmt.Sprintf("contains %q", want), true
+		}
+	}
+
+	for _, want := range c.StartsWith {
+		if strings.HasPrefix(lower, strings.ToLower(want)) {
+			return fmt.Sprintf("starts with %q", want), true
+		}
+	}
+
+	for _, want := range c.EndsWith {
+		if strings.HasSuffix(lower, strings.ToLower(want)) {
+			return fmt.Sprintf("ends with %q", want), true
+		}
+	}
+
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(value) {
+			return fmt.Sprintf("matches %q", strings.TrimPrefix(pattern.String(), "(?i)")), true
+		}
+	}
+
+	return "", false
+}
+
+func shorten(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+
+	if len(value) > maxEvidenceValue {
+		return value[:maxEvidenceValue] + "..."
+	}
+
+	return value
+}
@@ -0,0 +1,322 @@
+// Package emailrules matches emails against rules written in TOML, so new phishing
+// patterns can be detected without a new release. A rules file looks like:
+//
+//	[[rule]]
+//	id = "paypal-lookalike"
+//	title = "Invoice from a PayPal lookalike domain"
+//	severity = "high"
+//
+//	[rule.match]
+//	all = [
+//	  { field = "sender.domain", matches = "^paypa[l1][-.]" },
+//	  { not = { field = "sender.domain", equals = "paypal.com" } },
+//	  { any = [
+//	    { field = "subject", contains = ["invoice", "payment"] },
+//	    { field = "attachment.extension", equals = ["html", "htm"] },
+//	  ] },
+//	]
+//
+// Matching ignores case. See Fields for what can be matched
+package emailrules
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"file-inspector/files/findings"
+)
+
+// Fields describes the fields rules can match on. Fields with more than one value, e.g.
+// every URL in the body, match if any of the values do
+var Fields = map[string]string{
+	"subject":              "the subject",
+	"body":                 "the body text",
+	"sender.address":       "the From address",
+	"sender.name":          "the From display name",
+	"sender.domain":        "the domain of the From address",
+	"reply_to.address":     "the Reply-To addresses",
+	"reply_to.domain":      "the domains of the Reply-To addresses",
+	"return_path.domain":   "the domain of the Return-Path",
+	"recipient.address":    "the To and Cc addresses",
+	"recipient.domain":     "the domains of the To and Cc addresses",
+	"auth.spf":             "the SPF result, e.g. \"pass\" or \"fail\"",
+	"auth.dkim":            "the DKIM result",
+	"auth.dmarc":           "the DMARC result",
+	"attachment.name":      "the attachment file names",
+	"attachment.extension": "the attachment extensions, without the dot, e.g. \"html\"",
+	"attachment.type":      "the attachment MIME types",
+	"url":                  "the URLs in the body",
+	"url.domain":           "the domains of the URLs in the body",
+	"header.<Name>":        "the values of any header, e.g. \"header.X-Mailer\"",
+}
+
+const headerPrefix = "header."
+
+// RuleSet is the rules loaded from one or more files
+type RuleSet struct {
+	Rules []*Rule
+
+	ids map[string]bool
+}
+
+// Rule is a named condition, which becomes a finding when an email matches it
+type Rule struct {
+	ID          string    `toml:"id"`
+	Title       string    `toml:"title"`
+	Description string    `toml:"description"`
+	Severity    string    `toml:"severity"`
+	Remediation string    `toml:"remediation"`
+	Match       Condition `toml:"match"`
+
+	severity findings.Severity
+}
+
+// Condition is either a combination of other conditions with all, any or not, or a test
+// of a field. Field tests use one of equals, contains, starts_with, ends_with, matches (a
+// regular expression) or exists. The string tests take a string or a list of strings,
+// and match if any of them do
+type Condition struct {
+	All []Condition `toml:"all"`
+	Any []Condition `toml:"any"`
+	Not *Condition  `toml:"not"`
+
+	Field      string `toml:"field"`
+	Equals     Values `toml:"equals"`
+	Contains   Values `toml:"contains"`
+	StartsWith Values `toml:"starts_with"`
+	EndsWith   Values `toml:"ends_with"`
+	Matches    Values `toml:"matches"`
+	Exists     *bool  `toml:"exists"`
+
+	patterns []*regexp.Regexp
+}
+
+// Values is one or more strings, so rules can use `equals = "html"` or `equals = ["html", "htm"]`
+type Values []string
+
+// UnmarshalTOML reads a string or a list of strings
+func (v *Values) UnmarshalTOML(data any) error {
+	switch value := data.(type) {
+	case string:
+		*v = Values{value}
+	case []any:
+		for _, item := range value {
+			text, ok := item.(string)
+
+			if !ok {
+				return fmt.Errorf("expected a string, not %v", item)
+			}
+
+			*v = append(*v, text)
+		}
+	default:
+		return fmt.Errorf("expected a string or list of strings, not %v", data)
+	}
+
+	return nil
+}
+
+type rulesFile struct {
+	Rules []*Rule `toml:"rule"`
+}
+
+// NewRuleSet returns an empty rule set
+func NewRuleSet() *RuleSet {
+	return &RuleSet{ids: make(map[string]bool)}
+}
+
+// LoadDir loads every .toml file under the directory. Files with errors are skipped, so
+// the rules returned are still usable when the error isn't nil
+func LoadDir(dir string) (*RuleSet, error) {
+	set := NewRuleSet()
+	var errs []error
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || strings.ToLower(filepath.Ext(path)) != ".toml" {
+			return nil
+		}
+
+		if err := set.AddFile(path); err != nil {
+			errs = append(errs, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return set, err
+	}
+
+	return set, errors.Join(errs...)
+}
+
+// AddFile adds the rules in a file. If any of them are invalid none are added
+func (s *RuleSet) AddFile(path string) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.Add(string(data)); err != nil {
+		return fmt.Errorf("%s: %s", filepath.Base(path), err.Error())
+	}
+
+	return nil
+}
+
+// Add adds the rules in the TOML source. If any of them are invalid none are added
+func (s *RuleSet) Add(source string) error {
+	var file rulesFile
+
+	if _, err := toml.Decode(source, &file); err != nil {
+		return err
+	}
+
+	ids := make(map[string]bool)
+
+	for i, rule := range file.Rules {
+		if err := rule.compile(); err != nil {
+			if rule.ID == "" {
+				return fmt.Errorf("rule %d: %s", i+1, err.Error())
+			}
+
+			return fmt.Errorf("rule %q: %s", rule.ID, err.Error())
+		}
+
+		if s.ids[rule.ID] || ids[rule.ID] {
+			return fmt.Errorf("duplicate rule %q", rule.ID)
+		}
+
+		ids[rule.ID] = true
+	}
+
+	for _, rule := range file.Rules {
+		s.ids[rule.ID] = true
+		s.Rules = append(s.Rules, rule)
+	}
+
+	return nil
+}
+
+// Len returns the number of rules
+func (s *RuleSet) Len() int {
+	return len(s.Rules)
+}
+
+// SeverityLevel returns the rule's severity, which is medium if it wasn't set
+func (r *Rule) SeverityLevel() findings.Severity {
+	return r.severity
+}
+
+// compile checks the rule and compiles its regular expressions
+func (r *Rule) compile() error {
+	if r.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+
+	if r.Title == "" {
+		return fmt.Errorf("missing title")
+	}
+
+	r.severity = findings.SeverityMedium
+
+	if r.Severity != "" {
+		severity, err := findings.ParseSeverity(r.Severity)
+
+		if err != nil {
+			return err
+		}
+
+		r.severity = severity
+	}
+
+	return r.Match.compile()
+}
+
+func (c *Condition) compile() error {
+	kinds := 0
+
+	if c.All != nil {
+		kinds++
+	}
+
+	if c.Any != nil {
+		kinds++
+	}
+
+	if c.Not != nil {
+		kinds++
+	}
+
+	if c.Field != "" {
+		kinds++
+	}
+
+	if kinds != 1 {
+		return fmt.Errorf("each condition needs exactly one of all, any, not or field")
+	}
+
+	for i := range c.All {
+		if err := c.All[i].compile(); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Any {
+		if err := c.Any[i].compile(); err != nil {
+			return err
+		}
+	}
+
+	if c.Not != nil {
+		return c.Not.compile()
+	}
+
+	if c.Field == "" {
+		return nil
+	}
+
+	if _, ok := Fields[c.Field]; !ok && (!strings.HasPrefix(c.Field, headerPrefix) || c.Field == headerPrefix) {
+		return fmt.Errorf("unknown field %q", c.Field)
+	}
+
+	tests := 0
+
+	for _, values := range []Values{c.Equals, c.Contains, c.StartsWith, c.EndsWith, c.Matches} {
+		if values != nil {
+			tests++
+		}
+	}
+
+	if c.Exists != nil {
+		tests++
+	}
+
+	if tests != 1 {
+		return fmt.Errorf("field %q needs exactly one of equals, contains, starts_with, ends_with, matches or exists", c.Field)
+	}
+
+	for _, pattern := range c.Matches {
+		compiled, err := regexp.Compile("(?i)" + pattern)
+
+		if err != nil {
+			return fmt.Errorf("invalid regular expression for field %q: %s", c.Field, err.Error())
+		}
+
+		c.patterns = append(c.patterns, compiled)
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+// Package extract writes attachments into an AES encrypted zip file with the usual
+// "infected" password, so samples can be handed on without anyone opening one by
+// mistake, along with a manifest of their hashes
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"file-inspector/files"
+	"file-inspector/files/hashing"
+)
+
+const (
+	// DefaultPassword is the password malware samples are conventionally shared with
+	DefaultPassword = "infected"
+
+	// ManifestName is the name of the manifest in the archive. It isn't encrypted, so
+	// what's in the archive can be checked without extracting anything
+	ManifestName = "manifest.csv"
+
+	// names in the archive are cut to this length
+	maxNameLength = 200
+)
+
+// names Windows won't create files with, whatever the extension
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// Sample is an attachment to put in the archive
+type Sample struct {
+	// Path is where it was found, e.g. "1/2" for the second attachment of the first
+	Path string
+	Name string
+	Data []byte
+}
+
+// Entry is a file in the archive, as listed in the manifest
+type Entry struct {
+	// Name is the name in the archive, which is the original name made safe
+	Name         string
+	OriginalName string
+	Path         string
+	Size         int
+	MD5          string
+	SHA1         string
+	SHA256       string
+}
+
+// Samples returns the attachments at the paths, or every attachment, however deeply
+// nested, if there are none. The result must be from an analysis that kept the
+// attachments' content, see files.WithAttachmentData
+func Samples(result *files.ProcessResult, paths []string) ([]Sample, error) {
+	if len(paths) == 0 {
+		samples := appendSamples(nil, "", result.Children)
+
+		if len(samples) == 0 {
+			return nil, errors.New("no attachments found")
+		}
+
+		return samples, nil
+	}
+
+	var samples []Sample
+
+	for _, path := range paths {
+		attachment := result.Attachment(path)
+
+		if attachment == nil {
+			return nil, fmt.Errorf("no attachment %q", path)
+		} else if attachment.Data() == nil {
+			return nil, fmt.Errorf("no content for attachment %q (%s)", path, attachment.Name)
+		}
+
+		samples = append(samples, Sample{Path: strings.Trim(path, "/"), Name: attachment.Name, Data: attachment.Data()})
+	}
+
+	return samples, nil
+}
+
+func appendSamples(samples []Sample, parent string, children []*files.ProcessResult) []Sample {
+	for i, child := range children {
+		path := strconv.Itoa(i + 1)
+
+		if parent != "" {
+			path = parent + "/" + path
+		}
+
+		if child.Data() != nil {
+			samples = append(samples, Sample{Path: path, Name: child.Name, Data: child.Data()})
+		}
+
+		samples = appendSamples(samples, path, child.Children)
+	}
+
+	return samples
+}
+
+// Create writes the archive to a new file, refusing to overwrite one that's already there
+func Create(path string, samples []Sample, password string) ([]Entry, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%q already exists, not overwriting it", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries, err := Write(f, samples, password)
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	// don't leave half an archive behind
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Write writes the archive, with each sample encrypted with the password
+func Write(w io.Writer, samples []Sample, password string) ([]Entry, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no attachments to extract")
+	}
+
+	if password == "" {
+		return nil, errors.New("the archive needs a password")
+	}
+
+	zw := zip.NewWriter(w)
+	modified := time.Now()
+	used := map[string]bool{strings.ToLower(ManifestName): true}
+	var entries []Entry
+
+	for _, sample := range samples {
+		hashes, err := hashing.GetReaderHashes(bytes.NewReader(sample.Data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := Entry{
+			Name:         uniqueName(safeName(sample.Name), used),
+			OriginalName: sample.Name,
+			Path:         sample.Path,
+			Size:         len(sample.Data),
+			MD5:          hashes.MD5,
+			SHA1:         hashes.SHA1,
+			SHA256:       hashes.SHA256,
+		}
+
+		if err := writeEncrypted(zw, entry.Name, sample.Data, password, modified); err != nil {
+			return nil, fmt.Errorf("error adding %q: %s", sample.Name, err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := writeManifest(zw, entries, modified); err != nil {
+		return nil, fmt.Errorf("error adding the manifest: %s", err.Error())
+	}
+
+	return entries, zw.Close()
+}
+
+func writeManifest(zw *zip.Writer, entries []Entry, modified time.Time) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: modified})
+
+	if err != nil {
+		return err
+	}
+
+	manifest := csv.NewWriter(w)
+	manifest.Write([]string{"name", "original_name", "attachment", "size", "md5", "sha1", "sha256"})
+
+	for _, entry := range entries {
+		manifest.Write([]string{
+			entry.Name,
+			spreadsheetSafe(escapeName(entry.OriginalName)),
+			entry.Path,
+			strconv.Itoa(entry.Size),
+			entry.MD5,
+			entry.SHA1,
+			entry.SHA256,
+		})
+	}
+
+	manifest.Flush()
+	return manifest.Error()
+}
+
+// spreadsheetSafe stops an attachment's name being run as a formula if the manifest is
+// opened in a spreadsheet
+func spreadsheetSafe(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+
+	return text
+}
+
+// escapeName shows any control or formatting characters in the name as escapes, so it
+// reads as it really is
+func escapeName(name string) string {
+	var builder strings.Builder
+
+	for _, r := range strings.ToValidUTF8(name, string(unicode.ReplacementChar)) {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			builder.WriteString(strings.Trim(strconv.QuoteRuneToASCII(r), "'"))
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+// safeName makes the attachment's name safe to extract anywhere: no folders, no
+// characters Windows won't allow, no control or formatting characters, like the
+// right-to-left override used to make "invoice\u202efdp.exe" look like a PDF
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f || unicode.Is(unicode.Cf, r) || r == unicode.ReplacementChar:
+			return '_'
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		default:
+			return r
+		}
+	}, strings.ToValidUTF8(name, "_"))
+
+	name = strings.Trim(name, " .")
+
+	if name == "" {
+		return "attachment"
+	}
+
+	// nothing that looks like a formula or a command line option
+	if strings.ContainsRune("=+-@", rune(name[0])) {
+		name = "_" + name[1:]
+	}
+
+	stem, _, _ := strings.Cut(name, ".")
+
+	if reservedNames[strings.ToLower(stem)] {
+		name = "_" + name
+	}
+
+	if len(name) > maxNameLength {
+		extension := filepath.Ext(name)
+
+		if len(extension) > maxNameLength/2 {
+			extension = ""
+		}
+
+		name = strings.ToValidUTF8(name[:maxNameLength-len(extension)], "") + extension
+	}
+
+	return name
+}
+
+// uniqueName adds a number before the extension if the name's been used, e.g.
+// "invoice-1.pdf", ignoring case as not every file system does
+func uniqueName(name string, used map[string]bool) string {
+	unique := name
+	extension := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+
+	for i := 1; used[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s-%d%s", stem, i, extension)
+	}
+
+	used[strings.ToLower(unique)] = true
+
+	return unique
+}
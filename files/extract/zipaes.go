@@ -0,0 +1,209 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"time"
+)
+
+// WinZip AES encryption (AE-2), which 7-Zip, WinZip, WinRAR and libarchive can all open.
+// The entry's data is the salt, a password check value, the encrypted compressed data
+// and an authentication code, with the real compression method in an extra field.
+// See https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipMethodAES      = 99
+	zipFlagEncrypted  = 0x1
+	zipFlagUTF8       = 0x800
+	zipVersionAES     = 51
+	aesExtraID        = 0x9901
+	aesVendorVersion  = 2 // AE-2, the CRC isn't stored as the authentication code covers it
+	aesStrength256    = 3
+	aesKeyLength      = 32
+	aesSaltLength     = 16
+	aesVerifierLength = 2
+	aesAuthCodeLength = 10
+	pbkdf2Iterations  = 1000
+)
+
+// pbkdf2SHA1 derives a key from the password with PBKDF2 and HMAC-SHA1, as in RFC 8018
+func pbkdf2SHA1(password, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var key []byte
+
+	for block := uint32(1); len(key) < length; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:length]
+}
+
+// winzipCTR is AES in counter mode, with the counter little-endian and starting at 1
+// rather than the big-endian counter of cipher.NewCTR
+type winzipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newWinzipCTR(block cipher.Block) *winzipCTR {
+	return &winzipCTR{block: block, used: aes.BlockSize}
+}
+
+func (c *winzipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// encryptAES compresses and encrypts the data, returning the entry's data
+func encryptAES(data []byte, password string) ([]byte, error) {
+	var compressed bytes.Buffer
+	compressor, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := compressor.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, aesSaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keys := pbkdf2SHA1([]byte(password), salt, pbkdf2Iterations, 2*aesKeyLength+aesVerifierLength)
+	encryptionKey := keys[:aesKeyLength]
+	authKey := keys[aesKeyLength : 2*aesKeyLength]
+	verifier := keys[2*aesKeyLength:]
+
+	block, err := aes.NewCipher(encryptionKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, compressed.Len())
+	newWinzipCTR(block).XORKeyStream(encrypted, compressed.Bytes())
+
+	mac := hmac.New(sha1.New, authKey)
+	mac.Write(encrypted)
+
+	out := make([]byte, 0, len(salt)+len(verifier)+len(encrypted)+aesAuthCodeLength)
+	out = append(out, salt...)
+	out = append(out, verifier...)
+	out = append(out, encrypted...)
+	out = append(out, mac.Sum(nil)[:aesAuthCodeLength]...)
+
+	return out, nil
+}
+
+// aesExtra is the extra field saying the entry is AES-256 encrypted and deflated
+func aesExtra() []byte {
+	extra := binary.LittleEndian.AppendUint16(nil, aesExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, aesVendorVersion)
+	extra = append(extra, 'A', 'E', aesStrength256)
+	extra = binary.LittleEndian.AppendUint16(extra, zip.Deflate)
+
+	return extra
+}
+
+// writeEncrypted adds the data to the archive, encrypted with the password
+func writeEncrypted(zw *zip.Writer, name string, data []byte, password string, modified time.Time) error {
+	encrypted, err := encryptAES(data, password)
+
+	if err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             zipMethodAES,
+		Flags:              zipFlagEncrypted,
+		ReaderVersion:      zipVersionAES,
+		CreatorVersion:     zipVersionAES,
+		Extra:              aesExtra(),
+		CompressedSize64:   uint64(len(encrypted)),
+		UncompressedSize64: uint64(len(data)),
+		Modified:           modified,
+	}
+
+	header.ModifiedDate, header.ModifiedTime = msDosDateTime(modified)
+
+	if !isASCII(name) {
+		header.Flags |= zipFlagUTF8
+	}
+
+	// raw, as archive/zip can't encrypt and would compress it again
+	w, err := zw.CreateRaw(header)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(encrypted)
+	return err
+}
+
+// msDosDateTime is the time in the format zip headers use, which CreateRaw doesn't fill in
+func msDosDateTime(t time.Time) (uint16, uint16) {
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+
+	return date, clock
+}
+
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,291 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const testPassword = "infected"
+
+// aesEntryExtra returns the vendor version and real compression method from a WinZip AES
+// entry's extra field
+func aesEntryExtra(extra []byte) (uint16, uint16, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+
+		if size > len(extra) {
+			break
+		}
+
+		if id == aesExtraID && size == 7 && string(extra[2:4]) == "AE" {
+			if extra[4] != aesStrength256 {
+				return 0, 0, fmt.Errorf("AES strength %d, want %d", extra[4], aesStrength256)
+			}
+
+			return binary.LittleEndian.Uint16(extra), binary.LittleEndian.Uint16(extra[5:]), nil
+		}
+
+		extra = extra[size:]
+	}
+
+	return 0, 0, errors.New("no AES extra field")
+}
+
+// decryptEntry reads a WinZip AES-256 entry the way the specification describes, to check
+// the key derivation and cipher against archives written by other tools
+func decryptEntry(f *zip.File, password string) ([]byte, error) {
+	if f.Method != zipMethodAES {
+		return nil, fmt.Errorf("method %d, want %d", f.Method, zipMethodAES)
+	}
+
+	version, method, err := aesEntryExtra(f.Extra)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := f.OpenRaw()
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < aesSaltLength+aesVerifierLength+aesAuthCodeLength {
+		return nil, errors.New("entry too short")
+	}
+
+	salt := raw[:aesSaltLength]
+	verifier := raw[aesSaltLength : aesSaltLength+aesVerifierLength]
+	encrypted := raw[aesSaltLength+aesVerifierLength : len(raw)-aesAuthCodeLength]
+	authCode := raw[len(raw)-aesAuthCodeLength:]
+
+	keys := pbkdf2SHA1([]byte(password), salt, pbkdf2Iterations, 2*aesKeyLength+aesVerifierLength)
+
+	if !bytes.Equal(keys[2*aesKeyLength:], verifier) {
+		return nil, errors.New("wrong password")
+	}
+
+	mac := hmac.New(sha1.New, keys[aesKeyLength:2*aesKeyLength])
+	mac.Write(encrypted)
+
+	if !hmac.Equal(mac.Sum(nil)[:aesAuthCodeLength], authCode) {
+		return nil, errors.New("authentication code doesn't match")
+	}
+
+	block, err := aes.NewCipher(keys[:aesKeyLength])
+
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]byte, len(encrypted))
+	newWinzipCTR(block).XORKeyStream(decrypted, encrypted)
+
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		if decrypted, err = io.ReadAll(flate.NewReader(bytes.NewReader(decrypted))); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected compression method %d", method)
+	}
+
+	// AE-1 keeps the CRC, AE-2 leaves it out
+	if version == 1 && crc32.ChecksumIEEE(decrypted) != f.CRC32 {
+		return nil, errors.New("CRC doesn't match")
+	}
+
+	return decrypted, nil
+}
+
+// the test vectors from RFC 6070
+func TestPBKDF2SHA1(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		length         int
+		want           string
+	}{
+		{"password", "salt", 1, 20, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, 20, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, 20, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+	}
+
+	for _, test := range tests {
+		got := hex.EncodeToString(pbkdf2SHA1([]byte(test.password), []byte(test.salt), test.iterations, test.length))
+
+		if got != test.want {
+			t.Errorf("pbkdf2SHA1(%q, %q, %d) = %s, want %s", test.password, test.salt, test.iterations, got, test.want)
+		}
+	}
+}
+
+// the archives were written by bsdtar 3.7.7 (libarchive) with the password "infected",
+// one AE-2 and stored, one AE-1 and deflated
+func TestDecryptKnownArchives(t *testing.T) {
+	tests := []struct {
+		file string
+		name string
+		want string
+	}{
+		{"ae2.zip", "t.txt", "tiny\n"},
+		{"ae1-deflate.zip", "fox.txt", "the quick brown fox jumps over the lazy dog\n"},
+	}
+
+	for _, test := range tests {
+		archive, err := zip.OpenReader(filepath.Join("testdata", test.file))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer archive.Close()
+
+		if len(archive.File) != 1 || archive.File[0].Name != test.name {
+			t.Fatalf("%s has unexpected entries", test.file)
+		}
+
+		got, err := decryptEntry(archive.File[0], testPassword)
+
+		if err != nil {
+			t.Errorf("decrypting %s: %s", test.file, err.Error())
+			continue
+		}
+
+		if string(got) != test.want {
+			t.Errorf("decrypting %s = %q, want %q", test.file, got, test.want)
+		}
+
+		if _, err := decryptEntry(archive.File[0], "wrong"); err == nil {
+			t.Errorf("decrypting %s with the wrong password succeeded", test.file)
+		}
+	}
+}
+
+func testSamples() []Sample {
+	return []Sample{
+		{Path: "1", Name: "invoice.pdf", Data: []byte("%PDF-1.4 not really a PDF")},
+		{Path: "1/1", Name: "empty.txt", Data: nil},
+		{Path: "2", Name: "big.bin", Data: bytes.Repeat([]byte("0123456789abcdef"), 10000)},
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	samples := testSamples()
+	entries, err := Write(&buf, samples, testPassword)
+
+	if err != nil {
+		t.Fatalf("Write() error = %s", err.Error())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	if err != nil {
+		t.Fatalf("reading the archive: %s", err.Error())
+	}
+
+	// the samples then the manifest
+	if len(archive.File) != len(samples)+1 {
+		t.Fatalf("archive has %d entries, want %d", len(archive.File), len(samples)+1)
+	}
+
+	for i, sample := range samples {
+		f := archive.File[i]
+
+		if f.Name != entries[i].Name {
+			t.Errorf("entry %d is %q, want %q", i, f.Name, entries[i].Name)
+		}
+
+		if version, _, err := aesEntryExtra(f.Extra); err != nil || version != aesVendorVersion {
+			t.Errorf("%s isn't AE-2: version %d, error %v", f.Name, version, err)
+		}
+
+		got, err := decryptEntry(f, testPassword)
+
+		if err != nil {
+			t.Errorf("decrypting %s: %s", f.Name, err.Error())
+			continue
+		}
+
+		if !bytes.Equal(got, sample.Data) {
+			t.Errorf("%s decrypted to %d bytes, want %d", f.Name, len(got), len(sample.Data))
+		}
+	}
+}
+
+// bsdtar and 7z are independent readers, so they're used to open the archive when they're
+// installed
+func TestWriteExternalReaders(t *testing.T) {
+	readers := map[string]func(archive, dir string) *exec.Cmd{
+		"bsdtar": func(archive, dir string) *exec.Cmd {
+			return exec.Command("bsdtar", "-x", "-f", archive, "-C", dir, "--passphrase", testPassword)
+		},
+		"7z": func(archive, dir string) *exec.Cmd {
+			return exec.Command("7z", "x", "-p"+testPassword, "-o"+dir, archive)
+		},
+	}
+
+	for name, command := range readers {
+		t.Run(name, func(t *testing.T) {
+			if _, err := exec.LookPath(name); err != nil {
+				t.Skipf("%s isn't installed", name)
+			}
+
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "samples.zip")
+			samples := testSamples()
+			entries, err := Create(archive, samples, testPassword)
+
+			if err != nil {
+				t.Fatalf("Create() error = %s", err.Error())
+			}
+
+			out := filepath.Join(dir, "out")
+
+			if err := os.Mkdir(out, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			if output, err := command(archive, out).CombinedOutput(); err != nil {
+				t.Fatalf("%s failed: %s\n%s", name, err.Error(), output)
+			}
+
+			for i, sample := range samples {
+				got, err := os.ReadFile(filepath.Join(out, entries[i].Name))
+
+				if err != nil {
+					t.Errorf("reading what %s extracted: %s", name, err.Error())
+					continue
+				}
+
+				if !bytes.Equal(got, sample.Data) {
+					t.Errorf("%s extracted %d bytes for %s, want %d", name, len(got), entries[i].Name, len(sample.Data))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+// Package findings provides a structured model for the things we find when analysing a file
+package findings
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is how much a finding matters, from informational up to critical
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+func (s Severity) String() string {
+	if s < SeverityInfo || s > SeverityCritical {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+
+	return severityNames[s]
+}
+
+// ParseSeverity converts a severity name, e.g. "high", back into a Severity
+func ParseSeverity(name string) (Severity, error) {
+	for i, severityName := range severityNames {
+		if strings.EqualFold(name, severityName) {
+			return Severity(i), nil
+		}
+	}
+
+	return SeverityInfo, fmt.Errorf("unknown severity %q", name)
+}
+
+// MarshalText writes the severity as its name, so exported findings are readable
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText reads the severity from its name
+func (s *Severity) UnmarshalText(text []byte) error {
+	severity, err := ParseSeverity(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	*s = severity
+	return nil
+}
+
+// Category groups related findings together
+type Category string
+
+const (
+	CategoryFileType       Category = "file-type"
+	CategoryAuthentication Category = "authentication"
+	CategoryBody           Category = "body"
+	CategoryURL            Category = "url"
+	CategoryAttachment     Category = "attachment"
+	CategoryActiveContent  Category = "active-content"
+	CategoryEncryption     Category = "encryption"
+	CategoryMetadata       Category = "metadata"
+	CategoryParsing        Category = "parsing"
+	CategorySignature      Category = "signature"
+	CategoryReputation     Category = "reputation"
+)
+
+var categoryTitles = map[Category]string{
+	CategoryFileType:       "File type",
+	CategoryAuthentication: "Authentication results",
+	CategoryBody:           "Body details",
+	CategoryURL:            "Links",
+	CategoryAttachment:     "Attachments",
+	CategoryActiveContent:  "Active content",
+	CategoryEncryption:     "Encryption",
+	CategoryMetadata:       "Metadata",
+	CategoryParsing:        "Parsing problems",
+	CategorySignature:      "Signature matches",
+	CategoryReputation:     "Hash lists",
+}
+
+// Title returns a human readable heading for the category
+func (c Category) Title() string {
+	if title, ok := categoryTitles[c]; ok {
+		return title
+	}
+
+	return string(c)
+}
+
+// a file built to be slow to parse can have thousands of objects with the same content
+const maxObjectsShown = 20
+
+// Location says where in the file a finding came from. All fields are optional
+type Location struct {
+	// Field is a header or property name, e.g. "Authentication-Results"
+	Field string `json:"field,omitempty"`
+
+	// Attachment is the 1-based index of an email attachment
+	Attachment int `json:"attachment,omitempty"`
+
+	// Objects are PDF object numbers
+	Objects []int `json:"objects,omitempty"`
+}
+
+// IsZero returns true if no location has been set
+func (l Location) IsZero() bool {
+	return l.Field == "" && l.Attachment == 0 && len(l.Objects) == 0
+}
+
+func (l Location) String() string {
+	var parts []string
+
+	if l.Field != "" {
+		parts = append(parts, fmt.Sprintf("field %q", l.Field))
+	}
+
+	if l.Attachment > 0 {
+		parts = append(parts, fmt.Sprintf("attachment %d", l.Attachment))
+	}
+
+	if len(l.Objects) > 0 {
+		shown := l.Objects[:min(len(l.Objects), maxObjectsShown)]
+		objects := make([]string, len(shown))
+
+		for i, n := range shown {
+			objects[i] = fmt.Sprint(n)
+		}
+
+		label := "object"
+
+		if len(l.Objects) > 1 {
+			label = "objects"
+		}
+
+		text := fmt.Sprintf("%s %s", label, strings.Join(objects, ", "))
+
+		if len(l.Objects) > len(shown) {
+			text += fmt.Sprintf(" and %d more", len(l.Objects)-len(shown))
+		}
+
+		parts = append(parts, text)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Finding is a single thing found while analysing a file
+type Finding struct {
+	// ID identifies the kind of finding, e.g. "auth.dkim.fail". It's the same for every
+	// file, so can be used to filter, count and weight findings
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Severity    Severity `json:"severity"`
+	Category    Category `json:"category"`
+	Evidence    string   `json:"evidence,omitempty"`
+	Location    Location `json:"location,omitempty"`
+	Remediation string   `json:"remediation,omitempty"`
+}
+
+// String returns a one line summary of the finding
+func (f Finding) String() string {
+	return fmt.Sprintf("[%s] %s", f.Severity, f.Title)
+}
+
+// Filter returns the findings the keep function returns true for
+func Filter(list []Finding, keep func(Finding) bool) []Finding {
+	var kept []Finding
+
+	for _, f := range list {
+		if keep(f) {
+			kept = append(kept, f)
+		}
+	}
+
+	return kept
+}
+
+// AtLeast returns the findings with a severity of at least the minimum
+func AtLeast(list []Finding, minimum Severity) []Finding {
+	return Filter(list, func(f Finding) bool {
+		return f.Severity >= minimum
+	})
+}
+
+// CountBySeverity returns the number of findings of each severity
+func CountBySeverity(list []Finding) map[Severity]int {
+	counts := make(map[Severity]int)
+
+	for _, f := range list {
+		counts[f.Severity]++
+	}
+
+	return counts
+}
+
+// MaxSeverity returns the highest severity in the list, or info if the list is empty
+func MaxSeverity(list []Finding) Severity {
+	max := SeverityInfo
+
+	for _, f := range list {
+		if f.Severity > max {
+			max = f.Severity
+		}
+	}
+
+	return max
+}
+
+// IsDangerous returns true if any of the findings are high severity or worse
+func IsDangerous(list []Finding) bool {
+	return MaxSeverity(list) >= SeverityHigh
+}
+
+// Overrides changes the severity of findings, keyed by finding ID or an ID prefix
+// ending in ".*", e.g. "pdf.active.*"
+type Overrides map[string]Severity
+
+// ParseOverrides reads overrides from severity names, e.g. "url.uncommon-domain" = "high"
+func ParseOverrides(names map[string]string) (Overrides, error) {
+	overrides := make(Overrides, len(names))
+
+	for id, name := range names {
+		severity, err := ParseSeverity(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("severity for %q: %s", id, err.Error())
+		}
+
+		overrides[id] = severity
+	}
+
+	return overrides, nil
+}
+
+// SeverityFor returns the finding's severity once overridden. An exact ID match wins,
+// then the longest matching prefix, otherwise it's unchanged
+func (o Overrides) SeverityFor(f Finding) Severity {
+	if severity, ok := o[f.ID]; ok {
+		return severity
+	}
+
+	bestPrefix := ""
+	severity := f.Severity
+
+	for id, overridden := range o {
+		prefix, ok := strings.CutSuffix(id, "*")
+
+		if ok && strings.HasPrefix(f.ID, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+			severity = overridden
+		}
+	}
+
+	return severity
+}
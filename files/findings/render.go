@@ -0,0 +1,76 @@
+package findings
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// icons shown against each finding in the text view
+var severityIcons = map[Severity]string{
+	SeverityInfo:     "ℹ️",
+	SeverityLow:      "🔎",
+	SeverityMedium:   "⚠️",
+	SeverityHigh:     "☠️",
+	SeverityCritical: "☠️",
+}
+
+// Icon returns the icon shown against findings of this severity
+func (s Severity) Icon() string {
+	return severityIcons[s]
+}
+
+// Group is the findings for a single category
+type Group struct {
+	Category Category
+	Findings []Finding
+}
+
+// GroupByCategory groups the findings by category, in the order each category was first seen
+func GroupByCategory(list []Finding) []Group {
+	var groups []Group
+	index := make(map[Category]int)
+
+	for _, f := range list {
+		i, seen := index[f.Category]
+
+		if !seen {
+			i = len(groups)
+			index[f.Category] = i
+			groups = append(groups, Group{Category: f.Category})
+		}
+
+		groups[i].Findings = append(groups[i].Findings, f)
+	}
+
+	return groups
+}
+
+// Render builds the text view of the findings, grouped by category in the order
+// each category was first seen
+func Render(list []Finding) string {
+	var buffer bytes.Buffer
+
+	for _, group := range GroupByCategory(list) {
+		buffer.WriteString(fmt.Sprintf("%s:\n", group.Category.Title()))
+
+		for _, f := range group.Findings {
+			buffer.WriteString(fmt.Sprintf("\t%s %s\n", f.Severity.Icon(), f.Title))
+
+			if f.Evidence != "" {
+				buffer.WriteString(fmt.Sprintf("\t\t%s\n", f.Evidence))
+			}
+
+			if !f.Location.IsZero() {
+				buffer.WriteString(fmt.Sprintf("\t\tLocation: %s\n", f.Location))
+			}
+
+			if f.Remediation != "" {
+				buffer.WriteString(fmt.Sprintf("\t\tRemediation: %s\n", f.Remediation))
+			}
+		}
+
+		buffer.WriteString("\n")
+	}
+
+	return buffer.String()
+}
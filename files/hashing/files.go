@@ -2,8 +2,12 @@
 package hashing
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"os"
@@ -42,3 +46,99 @@ func GetFileSHA256Hash(fileString string) []byte {
 
 	return h.Sum(nil)
 }
+
+// GetReaderSHA256HashString sha256 hashes everything in the reader and returns the hash as a hex string
+func GetReaderSHA256HashString(r io.Reader) (string, error) {
+	h := sha256.New()
+
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// GetBytesSHA256HashString sha256 hashes the data and returns the hash as a hex string
+func GetBytesSHA256HashString(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// Hashes are the hex MD5, SHA-1, SHA-256 and SHA-512 hashes and the ssdeep and TLSH
+// digests of the same data. TLSH is empty for data too short or uniform to hash
+type Hashes struct {
+	MD5    string
+	SHA1   string
+	SHA256 string
+	SHA512 string
+	SSDeep string
+	TLSH   string
+}
+
+// Hasher hashes everything written to it with every algorithm at once, so it can be
+// used with io.MultiWriter or io.TeeReader to hash data while it's read for something else
+type Hasher struct {
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+	sha512 hash.Hash
+	ssdeep *SSDeep
+	tlsh   *TLSH
+
+	writer io.Writer
+}
+
+// NewHasher returns a new Hasher
+func NewHasher() *Hasher {
+	h := Hasher{
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+		sha512: sha512.New(),
+		ssdeep: NewSSDeep(),
+		tlsh:   NewTLSH(),
+	}
+
+	h.writer = io.MultiWriter(h.md5, h.sha1, h.sha256, h.sha512, h.ssdeep, h.tlsh)
+
+	return &h
+}
+
+// Write adds the data to every hash. It never returns an error
+func (h *Hasher) Write(data []byte) (int, error) {
+	return h.writer.Write(data)
+}
+
+// Sum returns the hashes of everything written so far
+func (h *Hasher) Sum() *Hashes {
+	return &Hashes{
+		MD5:    fmt.Sprintf("%x", h.md5.Sum(nil)),
+		SHA1:   fmt.Sprintf("%x", h.sha1.Sum(nil)),
+		SHA256: fmt.Sprintf("%x", h.sha256.Sum(nil)),
+		SHA512: fmt.Sprintf("%x", h.sha512.Sum(nil)),
+		SSDeep: h.ssdeep.Sum(),
+		TLSH:   h.tlsh.Sum(),
+	}
+}
+
+// GetReaderHashes hashes everything in the reader with every algorithm in one pass
+func GetReaderHashes(r io.Reader) (*Hashes, error) {
+	hasher := NewHasher()
+
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, err
+	}
+
+	return hasher.Sum(), nil
+}
+
+// GetFileHashes hashes the file with every algorithm in one pass
+func GetFileHashes(fileString string) (*Hashes, error) {
+	f, err := os.Open(fileString)
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return GetReaderHashes(f)
+}
@@ -0,0 +1,67 @@
+package hashing
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// SSDeepThreshold is the lowest ssdeep score, out of 100, counted as similar
+	SSDeepThreshold = 70
+
+	// TLSHThreshold is the highest TLSH distance counted as similar
+	TLSHThreshold = 50
+)
+
+// Similarity is how alike two files are by their fuzzy hashes. Either can be missing,
+// e.g. TLSH needs at least 50 bytes
+type Similarity struct {
+	// SSDeep is the ssdeep score, higher is more similar
+	SSDeep    int
+	HasSSDeep bool
+
+	// TLSH is the TLSH distance, lower is more similar
+	TLSH    int
+	HasTLSH bool
+}
+
+// CompareFuzzy compares the ssdeep and TLSH digests of the hashes
+func CompareFuzzy(a, b *Hashes) Similarity {
+	var similarity Similarity
+
+	if a.SSDeep != "" && b.SSDeep != "" {
+		if score, err := CompareSSDeep(a.SSDeep, b.SSDeep); err == nil {
+			similarity.SSDeep = score
+			similarity.HasSSDeep = true
+		}
+	}
+
+	if a.TLSH != "" && b.TLSH != "" {
+		if distance, err := TLSHDistance(a.TLSH, b.TLSH); err == nil {
+			similarity.TLSH = distance
+			similarity.HasTLSH = true
+		}
+	}
+
+	return similarity
+}
+
+// Similar is true if either digest is within its threshold
+func (s Similarity) Similar() bool {
+	return (s.HasSSDeep && s.SSDeep >= SSDeepThreshold) || (s.HasTLSH && s.TLSH <= TLSHThreshold)
+}
+
+// String describes the similarity, e.g. "ssdeep score 97, TLSH distance 12"
+func (s Similarity) String() string {
+	var parts []string
+
+	if s.HasSSDeep {
+		parts = append(parts, fmt.Sprintf("ssdeep score %d", s.SSDeep))
+	}
+
+	if s.HasTLSH {
+		parts = append(parts, fmt.Sprintf("TLSH distance %d", s.TLSH))
+	}
+
+	return strings.Join(parts, ", ")
+}
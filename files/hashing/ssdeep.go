@@ -0,0 +1,349 @@
+package hashing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ssdeep (context triggered piecewise hashing) splits the data into pieces where a rolling
+// hash of the last few bytes hits a trigger value, and hashes each piece to one base64
+// character. Changing a byte only changes the piece it's in, so files that differ by a
+// few bytes get digests that differ by a few characters. This follows ssdeep 2.14
+const (
+	ssdeepRollingWindow = 7
+	ssdeepMinBlockSize  = 3
+	ssdeepDigestLength  = 64
+	ssdeepNumBlockSizes = 31
+	ssdeepHashPrime     = 0x01000193
+	ssdeepHashInit      = 0x28021967
+	ssdeepBase64        = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+type ssdeepRollingHash struct {
+	window     [ssdeepRollingWindow]byte
+	h1, h2, h3 uint32
+	n          uint32
+}
+
+func (r *ssdeepRollingHash) add(c byte) {
+	r.h2 -= r.h1
+	r.h2 += ssdeepRollingWindow * uint32(c)
+
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n%ssdeepRollingWindow])
+
+	r.window[r.n%ssdeepRollingWindow] = c
+	r.n++
+
+	r.h3 <<= 5
+	r.h3 ^= uint32(c)
+}
+
+func (r *ssdeepRollingHash) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+// ssdeepBlockHash is the digest for one block size. halfHash and halfDigest are for the
+// digest cut to half the length, used when it's the second of the pair
+type ssdeepBlockHash struct {
+	hash       uint32
+	halfHash   uint32
+	digest     [ssdeepDigestLength]byte
+	halfDigest byte
+	length     int
+}
+
+// SSDeep computes an ssdeep digest of everything written to it. Every block size is
+// worked out at once, so the data only has to be read once
+type SSDeep struct {
+	roll   ssdeepRollingHash
+	blocks [ssdeepNumBlockSizes]ssdeepBlockHash
+	size   uint64
+}
+
+// NewSSDeep returns a new ssdeep hash
+func NewSSDeep() *SSDeep {
+	s := SSDeep{}
+
+	for i := range s.blocks {
+		s.blocks[i].hash = ssdeepHashInit
+		s.blocks[i].halfHash = ssdeepHashInit
+	}
+
+	return &s
+}
+
+func ssdeepBlockSize(index int) uint64 {
+	return ssdeepMinBlockSize << index
+}
+
+func ssdeepSumHash(c byte, h uint32) uint32 {
+	return (h * ssdeepHashPrime) ^ uint32(c)
+}
+
+// Write adds the data to the hash. It never returns an error
+func (s *SSDeep) Write(data []byte) (int, error) {
+	for _, c := range data {
+		s.roll.add(c)
+		h := uint64(s.roll.sum())
+
+		for i := range s.blocks {
+			s.blocks[i].hash = ssdeepSumHash(c, s.blocks[i].hash)
+			s.blocks[i].halfHash = ssdeepSumHash(c, s.blocks[i].halfHash)
+		}
+
+		// a trigger for a block size is also a trigger for every smaller one
+		for i := range s.blocks {
+			block := &s.blocks[i]
+			size := ssdeepBlockSize(i)
+
+			if h%size != size-1 {
+				break
+			}
+
+			block.digest[block.length] = ssdeepBase64[block.hash%64]
+			block.halfDigest = ssdeepBase64[block.halfHash%64]
+
+			// once the digest is full its last character covers the rest of the data
+			if block.length < ssdeepDigestLength-1 {
+				block.length++
+				block.digest[block.length] = 0
+				block.hash = ssdeepHashInit
+
+				if block.length < ssdeepDigestLength/2 {
+					block.halfHash = ssdeepHashInit
+					block.halfDigest = 0
+				}
+			}
+		}
+	}
+
+	s.size += uint64(len(data))
+
+	return len(data), nil
+}
+
+// Sum returns the digest, e.g. "96:KQhaGCVZGhr83h3bc0ok3892m12wzgnH5w2pw+sxNEI58:FIVkH4x73h39LH+2w+sxaD"
+func (s *SSDeep) Sum() string {
+	// the smallest block size that keeps the digest under the full length, then back
+	// down until the digest is at least half full
+	index := 0
+
+	for ssdeepBlockSize(index)*ssdeepDigestLength < s.size && index < ssdeepNumBlockSizes-1 {
+		index++
+	}
+
+	for index > 0 && s.blocks[index].length < ssdeepDigestLength/2 {
+		index--
+	}
+
+	h := s.roll.sum()
+	block := &s.blocks[index]
+
+	var builder strings.Builder
+	builder.WriteString(strconv.FormatUint(ssdeepBlockSize(index), 10))
+	builder.WriteByte(':')
+	builder.Write(block.digest[:block.length])
+
+	if h != 0 {
+		builder.WriteByte(ssdeepBase64[block.hash%64])
+	} else if block.digest[block.length] != 0 {
+		builder.WriteByte(block.digest[block.length])
+	}
+
+	builder.WriteByte(':')
+
+	if index < ssdeepNumBlockSizes-1 {
+		next := &s.blocks[index+1]
+		length := min(next.length, ssdeepDigestLength/2-1)
+		builder.Write(next.digest[:length])
+
+		if h != 0 {
+			builder.WriteByte(ssdeepBase64[next.halfHash%64])
+		} else if next.halfDigest != 0 {
+			builder.WriteByte(next.halfDigest)
+		}
+	} else if h != 0 {
+		builder.WriteByte(ssdeepBase64[block.hash%64])
+	}
+
+	return builder.String()
+}
+
+// ssdeepDigest is a parsed digest
+type ssdeepDigest struct {
+	blockSize uint64
+	first     string
+	second    string
+}
+
+func parseSSDeep(digest string) (*ssdeepDigest, error) {
+	parts := strings.SplitN(strings.TrimSpace(digest), ":", 3)
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid ssdeep digest %q", digest)
+	}
+
+	blockSize, err := strconv.ParseUint(parts[0], 10, 64)
+
+	// block sizes are always the minimum doubled some number of times
+	if err != nil || blockSize%ssdeepMinBlockSize != 0 || !isPowerOfTwo(blockSize/ssdeepMinBlockSize) {
+		return nil, fmt.Errorf("invalid ssdeep block size in %q", digest)
+	}
+
+	// ssdeep's own output can have the file name after a comma
+	second, _, _ := strings.Cut(parts[2], ",")
+
+	if len(parts[1]) > ssdeepDigestLength || len(second) > ssdeepDigestLength || !isBase64(parts[1]+second) {
+		return nil, fmt.Errorf("invalid ssdeep digest %q", digest)
+	}
+
+	return &ssdeepDigest{
+		blockSize: blockSize,
+		first:     eliminateSequences(parts[1]),
+		second:    eliminateSequences(second),
+	}, nil
+}
+
+func isPowerOfTwo(n uint64) bool {
+	return n != 0 && n&(n-1) == 0
+}
+
+func isBase64(text string) bool {
+	for _, c := range text {
+		if !strings.ContainsRune(ssdeepBase64, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsSSDeep returns true if the text looks like an ssdeep digest
+func IsSSDeep(text string) bool {
+	_, err := parseSSDeep(text)
+	return err == nil
+}
+
+// eliminateSequences cuts runs of the same character to three, as long runs say more
+// about the format than the content
+func eliminateSequences(text string) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if i >= 3 && text[i] == text[i-1] && text[i] == text[i-2] && text[i] == text[i-3] {
+			continue
+		}
+
+		builder.WriteByte(text[i])
+	}
+
+	return builder.String()
+}
+
+// CompareSSDeep scores how similar two ssdeep digests are, from 0 for nothing in common
+// to 100 for the same. Digests can only be compared if their block sizes are the same
+// or one is double the other, otherwise the score is 0
+func CompareSSDeep(a, b string) (int, error) {
+	first, err := parseSSDeep(a)
+
+	if err != nil {
+		return 0, err
+	}
+
+	second, err := parseSSDeep(b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case first.blockSize == second.blockSize:
+		if first.first == second.first && first.second == second.second {
+			return 100, nil
+		}
+
+		return max(
+			scoreSSDeepStrings(first.first, second.first, first.blockSize),
+			scoreSSDeepStrings(first.second, second.second, first.blockSize*2),
+		), nil
+	case first.blockSize*2 == second.blockSize:
+		return scoreSSDeepStrings(second.first, first.second, second.blockSize), nil
+	case second.blockSize*2 == first.blockSize:
+		return scoreSSDeepStrings(first.first, second.second, first.blockSize), nil
+	default:
+		return 0, nil
+	}
+}
+
+func scoreSSDeepStrings(a, b string, blockSize uint64) int {
+	if !hasCommonSubstring(a, b, ssdeepRollingWindow) {
+		return 0
+	}
+
+	score := uint64(editDistance(a, b))
+	score = (score * ssdeepDigestLength) / uint64(len(a)+len(b))
+	score = (100 * score) / ssdeepDigestLength
+
+	if score >= 100 {
+		return 0
+	}
+
+	score = 100 - score
+
+	// small block sizes are from small files, where a high score is easy to get by chance
+	if blockSize < (99+ssdeepRollingWindow)/ssdeepRollingWindow*ssdeepMinBlockSize {
+		score = min(score, blockSize/ssdeepMinBlockSize*uint64(min(len(a), len(b))))
+	}
+
+	return int(score)
+}
+
+func hasCommonSubstring(a, b string, length int) bool {
+	if len(a) < length || len(b) < length {
+		return false
+	}
+
+	seen := make(map[string]bool, len(a))
+
+	for i := 0; i+length <= len(a); i++ {
+		seen[a[i:i+length]] = true
+	}
+
+	for i := 0; i+length <= len(b); i++ {
+		if seen[b[i:i+length]] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// editDistance is the Levenshtein distance with a substitution costing 2, as ssdeep uses
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 2
+
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
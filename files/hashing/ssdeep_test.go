@@ -0,0 +1,85 @@
+package hashing
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func ssdeepSum(data []byte) string {
+	s := NewSSDeep()
+	s.Write(data)
+
+	return s.Sum()
+}
+
+// the random blobs and their digests are from github.com/glaslos/ssdeep's tests, which
+// recorded them from the ssdeep tool. The blobs are read one after the other from a
+// generator seeded with 1
+func TestSSDeep(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{4097, "96:yNDH/iNQaSXRLmOSxu1aQP4iWgC8JbkiA5Ix:yNLaNQhSxEgVYkiA5Ix"},
+		{45056, "768:mlHmRZnCRFRwSuK/UiwY37TMbsDEsb1Jqi6dcXoWpKXIUxpQDOAvWpPK:mqhCJwjmJD31DzbDwd+oGo9AvOi"},
+		{86016, "1536:Jdr3F6yZG0agLg/b6G6REjI+WUhWDKRSpzKjSUT4plmjvX6ex7RwdsHIGV:PrVbZG0BuuGzc+WcdRilmbPx7RwGV"},
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, test := range tests {
+		blob := make([]byte, test.size)
+		r.Read(blob)
+
+		if got := ssdeepSum(blob); got != test.want {
+			t.Errorf("ssdeep of %d random bytes = %q, want %q", test.size, got, test.want)
+		}
+	}
+}
+
+func TestSSDeepEmpty(t *testing.T) {
+	if got := ssdeepSum(nil); got != "3::" {
+		t.Errorf("ssdeep of nothing = %q, want %q", got, "3::")
+	}
+}
+
+// the digests and scores are from github.com/glaslos/ssdeep's tests, which match ssdeep's
+func TestCompareSSDeep(t *testing.T) {
+	const (
+		h1 = "192:MUPMinqP6+wNQ7Q40L/iB3n2rIBrP0GZKF4jsef+0FVQLSwbLbj41iH8nFVYv980:x0CllivQiFmt"
+		h2 = "192:JkjRcePWsNVQza3ntZStn5VfsoXMhRD9+xJMinqF6+wNQ7Q40L/i737rPVt:JkjlQyIrx+kll2"
+		h3 = "196608:pDSC8olnoL1v/uawvbQD7XlZUFYzYyMb615NktYHF7dREN/JNnQrmhnUPI+/n2Yr:5DHoJXv7XOq7Mb2TwYHXREN/3QrmktPd"
+		h4 = "196608:7DSC8olnoL1v/uawvbQD7XlZUFYzYyMb615NktYHF7dREN/JNnQrmhnUPI+/n2Y7:3DHoJXv7XOq7Mb2TwYHXREN/3QrmktPt"
+		h5 = "24:YDVLfsT1ds/1H9Wpgq7n4XMijV6h4Z3QCw4qat:YD51H9CiMuV6uACwVat"
+		h6 = "24:YDVLfyvDj+C+opg8DV0Mdle6hPZ3QCw4qat:YDMvDj+C+kBOM+6HACwVat"
+	)
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{h1, h1, 100},
+		{h1, h2, 35},
+		{h3, h4, 97},
+		{h5, h6, 54},
+	}
+
+	for _, test := range tests {
+		got, err := CompareSSDeep(test.a, test.b)
+
+		if err != nil {
+			t.Errorf("CompareSSDeep(%q, %q) error = %s", test.a, test.b, err.Error())
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("CompareSSDeep(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestCompareSSDeepInvalid(t *testing.T) {
+	if _, err := CompareSSDeep("not a digest", "3::"); err == nil {
+		t.Error("CompareSSDeep() of an invalid digest succeeded, want an error")
+	}
+}
@@ -0,0 +1,277 @@
+package hashing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// TLSH (Trend Micro Locality Sensitive Hash) counts trigrams from a sliding window into
+// buckets and encodes how each bucket compares to the quartiles. Similar files get a
+// small distance between their digests. This is the default 128 bucket, 1 byte checksum
+// version, with digests like "T1" followed by 70 hex characters
+const (
+	tlshWindowSize    = 5
+	tlshBuckets       = 128
+	tlshCodeSize      = tlshBuckets / 4
+	tlshMinDataLength = 50
+	tlshDigestLength  = 3 + tlshCodeSize
+	tlshVersionPrefix = "T1"
+)
+
+// Pearson hashing table from the TLSH reference implementation
+var tlshTable = [256]byte{
+	1, 87, 49, 12, 176, 178, 102, 166, 121, 193, 6, 84, 249, 230, 44, 163,
+	14, 197, 213, 181, 161, 85, 218, 80, 64, 239, 24, 226, 236, 142, 38, 200,
+	110, 177, 104, 103, 141, 253, 255, 50, 77, 101, 81, 18, 45, 96, 31, 222,
+	25, 107, 190, 70, 86, 237, 240, 34, 72, 242, 20, 214, 244, 227, 149, 235,
+	97, 234, 57, 22, 60, 250, 82, 175, 208, 5, 127, 199, 111, 62, 135, 248,
+	174, 169, 211, 58, 66, 154, 106, 195, 245, 171, 17, 187, 182, 179, 0, 243,
+	132, 56, 148, 75, 128, 133, 158, 100, 130, 126, 91, 13, 153, 246, 216, 219,
+	119, 68, 223, 78, 83, 88, 201, 99, 122, 11, 92, 32, 136, 114, 52, 10,
+	138, 30, 48, 183, 156, 35, 61, 26, 143, 74, 251, 94, 129, 162, 63, 152,
+	170, 7, 115, 167, 241, 206, 3, 150, 55, 59, 151, 220, 90, 53, 23, 131,
+	125, 173, 15, 238, 79, 95, 89, 16, 105, 137, 225, 224, 217, 160, 37, 123,
+	118, 73, 2, 157, 46, 116, 9, 145, 134, 228, 207, 212, 202, 215, 69, 229,
+	27, 188, 67, 124, 168, 252, 42, 4, 29, 108, 21, 247, 19, 205, 39, 203,
+	233, 40, 186, 147, 198, 192, 155, 33, 164, 191, 98, 204, 165, 180, 117, 76,
+	140, 36, 210, 172, 41, 54, 159, 8, 185, 232, 113, 196, 231, 47, 146, 120,
+	51, 65, 28, 144, 254, 221, 93, 189, 194, 139, 112, 43, 71, 109, 184, 209,
+}
+
+func tlshMapping(salt, i, j, k byte) byte {
+	h := tlshTable[salt]
+	h = tlshTable[h^i]
+	h = tlshTable[h^j]
+	return tlshTable[h^k]
+}
+
+// TLSH computes a TLSH digest of everything written to it
+type TLSH struct {
+	buckets  [256]uint32
+	window   [tlshWindowSize]byte
+	checksum byte
+	size     uint64
+}
+
+// NewTLSH returns a new TLSH hash
+func NewTLSH() *TLSH {
+	return &TLSH{}
+}
+
+// Write adds the data to the hash. It never returns an error
+func (t *TLSH) Write(data []byte) (int, error) {
+	for _, c := range data {
+		j := int(t.size % tlshWindowSize)
+		t.window[j] = c
+
+		if t.size >= tlshWindowSize-1 {
+			c1 := t.window[(j+4)%tlshWindowSize]
+			c2 := t.window[(j+3)%tlshWindowSize]
+			c3 := t.window[(j+2)%tlshWindowSize]
+			c4 := t.window[(j+1)%tlshWindowSize]
+
+			t.checksum = tlshMapping(0, c, c1, t.checksum)
+
+			t.buckets[tlshMapping(2, c, c1, c2)]++
+			t.buckets[tlshMapping(3, c, c1, c3)]++
+			t.buckets[tlshMapping(5, c, c2, c3)]++
+			t.buckets[tlshMapping(7, c, c2, c4)]++
+			t.buckets[tlshMapping(11, c, c1, c4)]++
+			t.buckets[tlshMapping(13, c, c3, c4)]++
+		}
+
+		t.size++
+	}
+
+	return len(data), nil
+}
+
+// Sum returns the digest, or "" if there isn't enough data or it's too uniform, e.g. a
+// file of less than 50 bytes or all zeros
+func (t *TLSH) Sum() string {
+	if t.size < tlshMinDataLength {
+		return ""
+	}
+
+	sorted := make([]uint32, tlshBuckets)
+	copy(sorted, t.buckets[:tlshBuckets])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	q1 := sorted[tlshBuckets/4-1]
+	q2 := sorted[tlshBuckets/2-1]
+	q3 := sorted[tlshBuckets*3/4-1]
+
+	nonZero := 0
+
+	for _, count := range t.buckets[:tlshBuckets] {
+		if count > 0 {
+			nonZero++
+		}
+	}
+
+	if nonZero <= tlshBuckets/2 {
+		return ""
+	}
+
+	var digest [tlshDigestLength]byte
+	digest[0] = swapNibbles(t.checksum)
+	digest[1] = swapNibbles(tlshLength(t.size))
+
+	q1Ratio := byte(uint32(float32(q1*100)/float32(q3)) % 16)
+	q2Ratio := byte(uint32(float32(q2*100)/float32(q3)) % 16)
+	digest[2] = q1Ratio<<4 | q2Ratio
+
+	// the code is written out last bucket first
+	for i := 0; i < tlshCodeSize; i++ {
+		var code byte
+
+		for j := 0; j < 4; j++ {
+			count := t.buckets[4*i+j]
+
+			switch {
+			case q3 < count:
+				code += 3 << (j * 2)
+			case q2 < count:
+				code += 2 << (j * 2)
+			case q1 < count:
+				code += 1 << (j * 2)
+			}
+		}
+
+		digest[tlshDigestLength-1-i] = code
+	}
+
+	return tlshVersionPrefix + strings.ToUpper(hex.EncodeToString(digest[:]))
+}
+
+// tlshLength encodes the data length on a log scale
+func tlshLength(size uint64) byte {
+	length := math.Log(float64(size))
+	var value int
+
+	switch {
+	case size <= 656:
+		value = int(math.Floor(length / 0.4054651))
+	case size <= 3199:
+		value = int(math.Floor(length/0.26236426 - 8.72777))
+	default:
+		value = int(math.Floor(length/0.095310180 - 62.5472))
+	}
+
+	return byte(value & 0xff)
+}
+
+func swapNibbles(b byte) byte {
+	return b<<4 | b>>4
+}
+
+// tlshDigest is a parsed digest
+type tlshDigest struct {
+	checksum byte
+	length   byte
+	q1Ratio  byte
+	q2Ratio  byte
+	code     []byte
+}
+
+func parseTLSH(digest string) (*tlshDigest, error) {
+	text := strings.TrimSpace(digest)
+
+	if len(text) == 2*tlshDigestLength+len(tlshVersionPrefix) && strings.EqualFold(text[:2], tlshVersionPrefix) {
+		text = text[2:]
+	}
+
+	data, err := hex.DecodeString(text)
+
+	if err != nil || len(data) != tlshDigestLength {
+		return nil, fmt.Errorf("invalid TLSH digest %q", digest)
+	}
+
+	return &tlshDigest{
+		checksum: swapNibbles(data[0]),
+		length:   swapNibbles(data[1]),
+		q1Ratio:  data[2] >> 4,
+		q2Ratio:  data[2] & 0x0f,
+		code:     data[3:],
+	}, nil
+}
+
+// IsTLSH returns true if the text looks like a TLSH digest
+func IsTLSH(text string) bool {
+	_, err := parseTLSH(text)
+	return err == nil
+}
+
+// TLSHDistance returns the distance between two TLSH digests. 0 is the same and anything
+// under about 50 is very similar, there's no upper limit
+func TLSHDistance(a, b string) (int, error) {
+	first, err := parseTLSH(a)
+
+	if err != nil {
+		return 0, err
+	}
+
+	second, err := parseTLSH(b)
+
+	if err != nil {
+		return 0, err
+	}
+
+	distance := 0
+
+	switch lengthDiff := modDiff(int(first.length), int(second.length), 256); {
+	case lengthDiff <= 1:
+		distance += lengthDiff
+	default:
+		distance += lengthDiff * 12
+	}
+
+	for _, ratios := range [][2]byte{{first.q1Ratio, second.q1Ratio}, {first.q2Ratio, second.q2Ratio}} {
+		diff := modDiff(int(ratios[0]), int(ratios[1]), 16)
+
+		if diff <= 1 {
+			distance += diff
+		} else {
+			distance += (diff - 1) * 12
+		}
+	}
+
+	if first.checksum != second.checksum {
+		distance++
+	}
+
+	// each bucket is 2 bits, a difference of 3 quartiles counts double
+	for i := range first.code {
+		x, y := first.code[i], second.code[i]
+
+		for j := 0; j < 4; j++ {
+			diff := int(x>>(j*2)&3) - int(y>>(j*2)&3)
+
+			if diff < 0 {
+				diff = -diff
+			}
+
+			if diff == 3 {
+				diff = 6
+			}
+
+			distance += diff
+		}
+	}
+
+	return distance, nil
+}
+
+// modDiff is the distance between x and y going either way round a circle of size r
+func modDiff(x, y, r int) int {
+	diff := x - y
+
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return min(diff, r-diff)
+}
@@ -0,0 +1,101 @@
+package hashing
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tlshSum(data []byte) string {
+	t := NewTLSH()
+	t.Write(data)
+
+	return t.Sum()
+}
+
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// the files and their digests are from github.com/glaslos/tlsh's tests, which match the
+// TLSH reference library's
+func TestTLSH(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"tlsh_1", "T18ED02202FC30802303A002B03B33300FC30A82F83008C2FA000A0080B8BA0E02CCA0C3"},
+		{"tlsh_2", "T1B2319634F5C033244EB792AA3168A366E737553DA305A28440CE842D7B57A2CC63B6EC"},
+		{"tlsh_3", "T1EA31834386C503B62A920319BA4F92D3BF6FC2B863384515A4EA5638450BC1E9376AE9"},
+		{"tlsh_4", "T15111421E72610B73189A13A055B8A8D9B22BB25B7AAF2A84146DF245232A06CD5FB854"},
+		{"tlsh_5", "T1E1D1B7337E4E03044FE22379D7C9C95ED66CE42426C39759CCEA9A2AF516838E723364"},
+		{"tlsh_6", "T12FE1A7723E8603145BF222F9979ACC7EF74CE4242BD3A7D49899F919F146814C3233A8"},
+	}
+
+	for _, test := range tests {
+		if got := tlshSum(readTestFile(t, test.file)); got != test.want {
+			t.Errorf("TLSH of %s = %q, want %q", test.file, got, test.want)
+		}
+	}
+}
+
+// the reference library doesn't give a digest for these either
+func TestTLSHNoDigest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	short := make([]byte, tlshMinDataLength-1)
+	r.Read(short)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"shorter than the minimum", short},
+		{"one byte repeated", bytes.Repeat([]byte{'a'}, 4096)},
+		{"a few bytes repeated", bytes.Repeat([]byte("abcd"), 1024)},
+	}
+
+	for _, test := range tests {
+		if got := tlshSum(test.data); got != "" {
+			t.Errorf("TLSH of %s = %q, want none", test.name, got)
+		}
+	}
+}
+
+// the distances are from github.com/glaslos/tlsh's tests, which match the TLSH reference
+// library's
+func TestTLSHDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"tlsh_1", "tlsh_1", 0},
+		{"tlsh_1", "tlsh_2", 418},
+		{"tlsh_3", "tlsh_1", 374},
+	}
+
+	for _, test := range tests {
+		a := tlshSum(readTestFile(t, test.a))
+		b := tlshSum(readTestFile(t, test.b))
+		got, err := TLSHDistance(a, b)
+
+		if err != nil {
+			t.Errorf("TLSHDistance(%s, %s) error = %s", test.a, test.b, err.Error())
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("TLSHDistance(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package files
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"file-inspector/files/findings"
+	"file-inspector/files/hashing"
+	"file-inspector/files/hashsets"
+)
+
+var (
+	hashListsMu sync.RWMutex
+	hashLists   *hashsets.Lists
+)
+
+// DefaultHashListDir returns where allow or deny lists are loaded from if no other path
+// is given, e.g. "~/.config/file-inspector/hashsets/deny" on Linux
+func DefaultHashListDir(kind hashsets.Kind) (string, error) {
+	return configPath("hashsets/" + string(kind))
+}
+
+// SetHashLists sets the lists every file and attachment is checked against. Nil turns them off
+func SetHashLists(l *hashsets.Lists) {
+	hashListsMu.Lock()
+	defer hashListsMu.Unlock()
+
+	hashLists = l
+}
+
+// LoadHashLists loads a hash list, or every list in a directory, replacing any lists of
+// the same kind, and returns how many lists were loaded. Files with errors are skipped, so
+// lists can be loaded even when the error isn't nil
+func LoadHashLists(path string, kind hashsets.Kind) (int, error) {
+	hashListsMu.Lock()
+	defer hashListsMu.Unlock()
+
+	loaded := hashsets.NewLists()
+
+	if hashLists != nil {
+		loaded = hashLists.Without(kind)
+	}
+
+	count, err := loaded.LoadPath(path, kind)
+
+	if count > 0 {
+		hashLists = loaded
+	}
+
+	log.Printf("Loaded %d %s lists from %q\n", count, kind, path)
+
+	return count, err
+}
+
+func getHashLists() *hashsets.Lists {
+	hashListsMu.RLock()
+	defer hashListsMu.RUnlock()
+
+	return hashLists
+}
+
+// checkHashLists adds a finding for each allow or deny list the file, or a file similar
+// to it, is on
+func checkHashLists(hashes *hashing.Hashes, result *ProcessResult) {
+	lists := getHashLists()
+
+	if hashes == nil || lists == nil || lists.Len() == 0 {
+		return
+	}
+
+	for _, hit := range lists.Lookup(hashes) {
+		result.AddFinding(hashListFinding(hit))
+	}
+}
+
+func hashListFinding(hit hashsets.Hit) findings.Finding {
+	evidence := fmt.Sprintf("%s %s is listed in %q", hit.Algorithm, hit.Hash, hit.List)
+
+	if hit.Label != "" {
+		evidence += fmt.Sprintf(" as %q", hit.Label)
+	}
+
+	if hit.Similarity != nil {
+		return similarHashListFinding(hit, evidence)
+	}
+
+	if hit.Kind == hashsets.Allow {
+		return findings.Finding{
+			ID:       "hash.allow",
+			Title:    fmt.Sprintf("File is on the %q allowlist", hit.List),
+			Severity: findings.SeverityInfo,
+			Category: findings.CategoryReputation,
+			Evidence: evidence,
+		}
+	}
+
+	return findings.Finding{
+		ID:          "hash.deny",
+		Title:       fmt.Sprintf("File is on the %q blocklist", hit.List),
+		Severity:    findings.SeverityCritical,
+		Category:    findings.CategoryReputation,
+		Evidence:    evidence,
+		Remediation: "The file is known to be malicious. Don't open it, and check where else it's been sent.",
+	}
+}
+
+// similarHashListFinding is for a file that's similar to one on a list, going by its
+// fuzzy hashes, e.g. a lure document repacked with a different invoice number
+func similarHashListFinding(hit hashsets.Hit, evidence string) findings.Finding {
+	evidence = fmt.Sprintf("%s, %s", evidence, hit.Similarity)
+
+	if hit.Kind == hashsets.Allow {
+		return findings.Finding{
+			ID:       "hash.allow.similar",
+			Title:    fmt.Sprintf("File is similar to one on the %q allowlist", hit.List),
+			Severity: findings.SeverityInfo,
+			Category: findings.CategoryReputation,
+			Evidence: evidence,
+		}
+	}
+
+	return findings.Finding{
+		ID:          "hash.deny.similar",
+		Title:       fmt.Sprintf("File is similar to one on the %q blocklist", hit.List),
+		Severity:    findings.SeverityHigh,
+		Category:    findings.CategoryReputation,
+		Evidence:    evidence,
+		Remediation: "The file is probably a variant of a known malicious file. Treat it as malicious until it's been checked.",
+	}
+}
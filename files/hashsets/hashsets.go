@@ -0,0 +1,459 @@
+// Package hashsets checks file hashes against local lists of known bad (deny) and known
+// good (allow) files. Lists can be plain text with a hash per line, e.g. the output of
+// sha256sum, CSV with or without a header, or NSRL RDS style files like NSRLFile.txt.
+// MD5, SHA-1 and SHA-256 hashes are matched exactly, and ssdeep and TLSH digests, e.g.
+// from ssdeep's own output, match files that are similar
+package hashsets
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"file-inspector/files/hashing"
+)
+
+// Kind is whether a list is of known good or known bad files
+type Kind string
+
+const (
+	Allow Kind = "allow"
+	Deny  Kind = "deny"
+)
+
+// labels from the lists, e.g. file names, are cut to this length
+const maxLabelLength = 100
+
+// List is one hash list
+type List struct {
+	Name string
+	Kind Kind
+
+	md5    map[[16]byte]string
+	sha1   map[[20]byte]string
+	sha256 map[[32]byte]string
+	fuzzy  []fuzzyHash
+}
+
+// fuzzyHash is an ssdeep or TLSH digest from a list
+type fuzzyHash struct {
+	hashes hashing.Hashes
+	label  string
+}
+
+// Hit is a hash found in a list
+type Hit struct {
+	List      string
+	Kind      Kind
+	Algorithm string
+	Hash      string
+
+	// Label is what the list says about the file, e.g. its name, if anything
+	Label string
+
+	// Similarity is set if the hit is a similar file, rather than the same one
+	Similarity *hashing.Similarity
+}
+
+// Lists is a set of allow and deny lists
+type Lists struct {
+	lists []*List
+}
+
+func newList(name string, kind Kind) *List {
+	return &List{
+		Name:   name,
+		Kind:   kind,
+		md5:    make(map[[16]byte]string),
+		sha1:   make(map[[20]byte]string),
+		sha256: make(map[[32]byte]string),
+	}
+}
+
+// Len returns the number of hashes in the list
+func (l *List) Len() int {
+	return len(l.md5) + len(l.sha1) + len(l.sha256) + len(l.fuzzy)
+}
+
+// add adds a hash, returning false if it isn't an MD5, SHA-1, SHA-256, ssdeep or TLSH
+func (l *List) add(value, label string) bool {
+	value = strings.TrimSpace(value)
+
+	if len(label) > maxLabelLength {
+		label = label[:maxLabelLength]
+	}
+
+	if hashing.IsSSDeep(value) {
+		l.fuzzy = append(l.fuzzy, fuzzyHash{hashes: hashing.Hashes{SSDeep: value}, label: label})
+		return true
+	}
+
+	if hashing.IsTLSH(value) {
+		l.fuzzy = append(l.fuzzy, fuzzyHash{hashes: hashing.Hashes{TLSH: value}, label: label})
+		return true
+	}
+
+	decoded, err := hex.DecodeString(value)
+
+	if err != nil {
+		return false
+	}
+
+	switch len(decoded) {
+	case 16:
+		l.md5[[16]byte(decoded)] = label
+	case 20:
+		l.sha1[[20]byte(decoded)] = label
+	case 32:
+		l.sha256[[32]byte(decoded)] = label
+	default:
+		return false
+	}
+
+	return true
+}
+
+// lookup returns a hit if any of the hashes are in the list, trying the strongest hash first
+func (l *List) lookup(hashes *hashing.Hashes) (Hit, bool) {
+	hit := Hit{List: l.Name, Kind: l.Kind}
+
+	if b, err := hex.DecodeString(hashes.SHA256); err == nil && len(b) == 32 {
+		if label, ok := l.sha256[[32]byte(b)]; ok {
+			hit.Algorithm, hit.Hash, hit.Label = "SHA256", hashes.SHA256, label
+			return hit, true
+		}
+	}
+
+	if b, err := hex.DecodeString(hashes.SHA1); err == nil && len(b) == 20 {
+		if label, ok := l.sha1[[20]byte(b)]; ok {
+			hit.Algorithm, hit.Hash, hit.Label = "SHA1", hashes.SHA1, label
+			return hit, true
+		}
+	}
+
+	if b, err := hex.DecodeString(hashes.MD5); err == nil && len(b) == 16 {
+		if label, ok := l.md5[[16]byte(b)]; ok {
+			hit.Algorithm, hit.Hash, hit.Label = "MD5", hashes.MD5, label
+			return hit, true
+		}
+	}
+
+	return hit, false
+}
+
+// similar returns a hit for the most similar file in the list, if any are similar enough
+func (l *List) similar(hashes *hashing.Hashes) (Hit, bool) {
+	var best *fuzzyHash
+	var bestSimilarity hashing.Similarity
+
+	for i := range l.fuzzy {
+		entry := &l.fuzzy[i]
+		similarity := hashing.CompareFuzzy(hashes, &entry.hashes)
+
+		if !similarity.Similar() {
+			continue
+		}
+
+		// the entries only have one digest each, so compare like with like
+		if best == nil ||
+			(similarity.HasSSDeep && bestSimilarity.HasSSDeep && similarity.SSDeep > bestSimilarity.SSDeep) ||
+			(similarity.HasTLSH && bestSimilarity.HasTLSH && similarity.TLSH < bestSimilarity.TLSH) {
+			best = entry
+			bestSimilarity = similarity
+		}
+	}
+
+	if best == nil {
+		return Hit{}, false
+	}
+
+	hit := Hit{List: l.Name, Kind: l.Kind, Label: best.label, Similarity: &bestSimilarity}
+
+	if best.hashes.SSDeep != "" {
+		hit.Algorithm, hit.Hash = "ssdeep", best.hashes.SSDeep
+	} else {
+		hit.Algorithm, hit.Hash = "TLSH", best.hashes.TLSH
+	}
+
+	return hit, true
+}
+
+// Load reads a hash list. The list is named after the file, without its extension
+func Load(path string, kind Kind) (*List, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	list, err := Read(f, name, kind)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath.Base(path), err.Error())
+	}
+
+	return list, nil
+}
+
+// Read reads a hash list, working out if it's plain text or CSV from the first line
+func Read(r io.Reader, name string, kind Kind) (*List, error) {
+	reader := bufio.NewReader(r)
+	list := newList(name, kind)
+
+	first, err := reader.Peek(4096)
+
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	firstLine, _, _ := strings.Cut(string(first), "\n")
+
+	if strings.Contains(firstLine, ",") {
+		err = readCSV(reader, list)
+	} else {
+		err = readText(reader, list)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if list.Len() == 0 {
+		return nil, fmt.Errorf("no MD5, SHA-1 or SHA-256 hashes found")
+	}
+
+	return list, nil
+}
+
+// readText reads a hash per line, with anything after it as the label, e.g. "<hash>  invoice.pdf".
+// Blank lines and lines starting with # are skipped
+func readText(r io.Reader, list *List) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		value, label, _ := strings.Cut(line, " ")
+
+		// sha256sum marks binary files with a "*" before the name
+		list.add(value, strings.TrimPrefix(strings.TrimSpace(label), "*"))
+	}
+
+	return scanner.Err()
+}
+
+// the column headings we recognise in CSV files, without "-", "_" or spaces
+var (
+	hashColumns  = map[string]bool{"md5": true, "sha1": true, "sha256": true, "hash": true, "ssdeep": true, "tlsh": true}
+	labelColumns = map[string]bool{"filename": true, "name": true, "description": true, "comment": true, "label": true}
+)
+
+// readCSV reads a CSV file. If the first row has headings like "SHA-1" or "md5", as NSRL
+// RDS files do, only those columns are read, otherwise any field that's a hash is used
+func readCSV(r io.Reader, list *List) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.Comment = '#'
+
+	var hashIndexes []int
+	labelIndex := -1
+	first := true
+
+	for {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if first {
+			first = false
+
+			// ssdeep's header has a heading describing the format, e.g. "1.1--blocksize:hash:hash",
+			// that isn't a column in the rows
+			skipped := 0
+
+			for i, heading := range record {
+				heading = strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(heading)))
+				i -= skipped
+
+				if strings.Contains(heading, "blocksize:") {
+					skipped++
+				} else if hashColumns[heading] {
+					hashIndexes = append(hashIndexes, i)
+				} else if labelColumns[heading] && labelIndex < 0 {
+					labelIndex = i
+				}
+			}
+
+			if len(hashIndexes) > 0 {
+				continue
+			}
+		}
+
+		label := ""
+
+		if labelIndex >= 0 && labelIndex < len(record) {
+			label = strings.TrimSpace(record[labelIndex])
+		}
+
+		if len(hashIndexes) > 0 {
+			for _, i := range hashIndexes {
+				if i < len(record) {
+					list.add(record[i], label)
+				}
+			}
+
+			continue
+		}
+
+		// no headings, so the label is the first field that isn't a hash
+		var values []string
+
+		for _, field := range record {
+			if isHash(field) {
+				values = append(values, field)
+			} else if label == "" {
+				label = strings.TrimSpace(field)
+			}
+		}
+
+		for _, value := range values {
+			list.add(value, label)
+		}
+	}
+}
+
+func isHash(value string) bool {
+	value = strings.TrimSpace(value)
+
+	if hashing.IsSSDeep(value) || hashing.IsTLSH(value) {
+		return true
+	}
+
+	switch len(value) {
+	case 32, 40, 64:
+		_, err := hex.DecodeString(value)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// NewLists returns an empty set of lists
+func NewLists() *Lists {
+	return &Lists{}
+}
+
+// Add adds a list
+func (l *Lists) Add(list *List) {
+	l.lists = append(l.lists, list)
+}
+
+// LoadPath loads a list, or every list in a directory, skipping hidden files. Files with
+// errors are skipped, returning how many lists were loaded and the errors
+func (l *Lists) LoadPath(path string, kind Kind) (int, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !info.IsDir() {
+		list, err := Load(path, kind)
+
+		if err != nil {
+			return 0, err
+		}
+
+		l.Add(list)
+		return 1, nil
+	}
+
+	count := 0
+	var errs []error
+
+	err = filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		list, err := Load(filePath, kind)
+
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+
+		l.Add(list)
+		count++
+
+		return nil
+	})
+
+	if err != nil {
+		return count, err
+	}
+
+	return count, errors.Join(errs...)
+}
+
+// Len returns the number of lists
+func (l *Lists) Len() int {
+	return len(l.lists)
+}
+
+// Without returns a copy of the lists without those of the kind, e.g. to reload them
+func (l *Lists) Without(kind Kind) *Lists {
+	kept := NewLists()
+
+	for _, list := range l.lists {
+		if list.Kind != kind {
+			kept.Add(list)
+		}
+	}
+
+	return kept
+}
+
+// Lookup returns a hit for each list with any of the hashes in it, or failing that a
+// file similar to it, deny lists first
+func (l *Lists) Lookup(hashes *hashing.Hashes) []Hit {
+	var hits []Hit
+
+	for _, kind := range []Kind{Deny, Allow} {
+		for _, list := range l.lists {
+			if list.Kind != kind {
+				continue
+			}
+
+			if hit, ok := list.lookup(hashes); ok {
+				hits = append(hits, hit)
+			} else if hit, ok := list.similar(hashes); ok {
+				hits = append(hits, hit)
+			}
+		}
+	}
+
+	return hits
+}
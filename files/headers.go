@@ -0,0 +1,38 @@
+package files
+
+// EmailHeaders are an email's key headers, taken from the metadata of either an .eml or
+// an .msg file
+type EmailHeaders struct {
+	From       string
+	ReturnPath string
+	To         string
+	Subject    string
+	Date       string
+	MessageID  string
+}
+
+// EmailHeaders returns the email's key headers from its metadata. From is empty if it
+// isn't an email
+func (r *ProcessResult) EmailHeaders() EmailHeaders {
+	return EmailHeaders{
+		From:       r.metadataValue(emlFrom, msgSenderSMTP, msgSenderEmail),
+		ReturnPath: r.metadataValue(emlReturnPath),
+		To:         r.metadataValue(emlTo, msgReceivedSMTP, msgReceivedEmail),
+		Subject:    r.metadataValue(subject),
+		Date:       r.metadataValue(emlDate),
+		MessageID:  r.metadataValue(emlMessageID, msgMessageID),
+	}
+}
+
+// metadataValue returns the value of the first of the fields the result has
+func (r *ProcessResult) metadataValue(fields ...string) string {
+	for _, field := range fields {
+		for _, row := range r.Metadata {
+			if len(row) == 2 && row[0] == field {
+				return row[1]
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,646 @@
+// Package history remembers the files that have been analysed, keyed by SHA-256, so a
+// file seen before can be recognised and the earlier results looked up
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/findings"
+	"file-inspector/files/hashing"
+	"file-inspector/files/ioc"
+)
+
+const (
+	// the most analyses kept for each file, the oldest are dropped first
+	maxAnalysesPerFile = 20
+
+	// the most files remembered, the ones seen longest ago are dropped first
+	maxRecords = 5000
+
+	// the most similar files returned by Similar
+	maxSimilar = 10
+
+	// how long after a file's added the history is saved, so files added close together,
+	// e.g. by a server busy with uploads, are saved at once
+	saveDelay = 2 * time.Second
+
+	// how many changes the journal holds before they're folded into the history file,
+	// which rewrites every record
+	maxJournalEntries = 500
+
+	// the journal's file name is the history's with this added
+	journalSuffix = ".journal"
+
+	dateFormat = "2 Jan 2006 15:04"
+)
+
+// Store is the history, saved as JSON. Changes are appended to a journal next to it
+// shortly after they're made, and folded into the file once there are enough of them, so
+// adding a file doesn't rewrite every record. Flush saves them straight away, e.g. before
+// quitting
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	records map[string]*Record
+
+	// pending is the save that's been scheduled, if there is one
+	pending *time.Timer
+
+	// unsaved are the changes since the last save, and journaled is how many changes are
+	// in the journal
+	unsaved   []journalEntry
+	journaled int
+	replaying bool
+}
+
+// journalEntry is a line in the journal, an analysis of a file or new notes for it
+type journalEntry struct {
+	SHA256   string    `json:"sha256"`
+	Analysis *Analysis `json:"analysis,omitempty"`
+	Notes    *string   `json:"notes,omitempty"`
+}
+
+// Record is everything we know about a file
+type Record struct {
+	SHA256    string    `json:"sha256"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Count     int       `json:"count"`
+	Notes     string    `json:"notes,omitempty"`
+
+	// Analyses are the most recent analyses, oldest first
+	Analyses []Analysis `json:"analyses"`
+}
+
+// Analysis is the result of analysing the file once
+type Analysis struct {
+	Time     time.Time          `json:"time"`
+	Name     string             `json:"name"`
+	Path     string             `json:"path,omitempty"`
+	FileType string             `json:"fileType,omitempty"`
+	Size     int64              `json:"size,omitempty"`
+	Verdict  string             `json:"verdict,omitempty"`
+	Score    int                `json:"score"`
+	Summary  string             `json:"summary"`
+	SSDeep   string             `json:"ssdeep,omitempty"`
+	TLSH     string             `json:"tlsh,omitempty"`
+	Findings []findings.Finding `json:"findings,omitempty"`
+	IOCs     []ioc.IOC          `json:"iocs,omitempty"`
+	Metadata [][]string         `json:"metadata,omitempty"`
+}
+
+// DefaultPath returns where the history is kept, e.g. "~/.config/file-inspector/history.json" on Linux
+func DefaultPath() (string, error) {
+	return defaultPath("history.json")
+}
+
+// DefaultServerPath returns where the serve command keeps its history. It's separate from
+// the user interface's, as a store only reads its file when it's opened, so two processes
+// sharing one would overwrite each other's records
+func DefaultServerPath() (string, error) {
+	return defaultPath("server-history.json")
+}
+
+func defaultPath(name string) (string, error) {
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "file-inspector", name), nil
+}
+
+// Open loads the history from the file and its journal, which are created when the first
+// file is added
+func Open(path string) (*Store, error) {
+	store := Store{path: path, records: make(map[string]*Record)}
+	data, err := os.ReadFile(path)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading history: %s", err.Error())
+	}
+
+	if err == nil {
+		var records []*Record
+
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("error reading history %q: %s", path, err.Error())
+		}
+
+		for _, record := range records {
+			store.records[record.SHA256] = record
+		}
+	}
+
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded history of %d files\n", len(store.records))
+
+	return &store, nil
+}
+
+// replay applies the changes in the journal. A line cut short, e.g. by a crash part way
+// through saving, ends it
+func (s *Store) replay() error {
+	data, err := os.ReadFile(s.path + journalSuffix)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading history journal: %s", err.Error())
+	}
+
+	s.replaying = true
+	defer func() { s.replaying = false }()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		var entry journalEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Error reading history journal, ignoring the rest of it: %s\n", err.Error())
+			break
+		}
+
+		s.journaled++
+
+		if entry.Analysis != nil {
+			s.add(entry.SHA256, *entry.Analysis)
+		} else if record, ok := s.records[entry.SHA256]; ok && entry.Notes != nil {
+			record.Notes = *entry.Notes
+		}
+	}
+
+	return nil
+}
+
+// NewAnalysis builds the history entry for an analysis. The properties can be nil
+func NewAnalysis(properties *files.FileProperties, result *files.ProcessResult) Analysis {
+	analysis := Analysis{
+		Time:     time.Now().UTC(),
+		Name:     result.Name,
+		Path:     result.FilePath,
+		FileType: result.MimeType,
+		Summary:  result.Summary(),
+		SSDeep:   result.SSDeep,
+		TLSH:     result.TLSH,
+		Findings: result.Findings,
+		IOCs:     result.IOCs,
+		Metadata: result.Metadata,
+	}
+
+	if properties != nil {
+		analysis.FileType = properties.FileType
+		analysis.Size = properties.SizeBytes
+	}
+
+	if result.Assessment != nil {
+		analysis.Verdict = string(result.Assessment.Verdict)
+		analysis.Score = result.Assessment.Score
+	}
+
+	return analysis
+}
+
+// Lookup returns a copy of the file's record, if it's been seen before
+func (s *Store) Lookup(sha256 string) (Record, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[strings.ToLower(sha256)]
+
+	if !ok {
+		return Record{}, false
+	}
+
+	return *record, true
+}
+
+// Entry is an analysis of the file with the hash, for adding several at once
+type Entry struct {
+	SHA256   string
+	Analysis Analysis
+}
+
+// Add records an analysis of the file, returning its record from before this analysis
+// and whether it had been seen before. It's saved shortly after
+func (s *Store) Add(sha256 string, analysis Analysis) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, seen, err := s.add(sha256, analysis)
+
+	if err != nil {
+		return previous, seen, err
+	}
+
+	s.scheduleSave()
+
+	return previous, seen, nil
+}
+
+// AddAll records the analyses, e.g. for a folder scan. They're saved shortly after
+func (s *Store) AddAll(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// save the ones added before an error
+	defer s.scheduleSave()
+
+	for _, entry := range entries {
+		if _, _, err := s.add(entry.SHA256, entry.Analysis); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush saves any files added since the history was last saved
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == nil {
+		return nil
+	}
+
+	return s.save()
+}
+
+// scheduleSave saves the history after saveDelay, unless a save's already scheduled.
+// The caller holds the lock
+func (s *Store) scheduleSave() {
+	if s.pending != nil {
+		return
+	}
+
+	s.pending = time.AfterFunc(saveDelay, func() {
+		if err := s.Flush(); err != nil {
+			log.Printf("Error saving history: %s\n", err.Error())
+		}
+	})
+}
+
+// add records the analysis without saving. The caller holds the lock
+func (s *Store) add(sha256 string, analysis Analysis) (Record, bool, error) {
+	sha256 = strings.ToLower(sha256)
+
+	if sha256 == "" {
+		return Record{}, false, fmt.Errorf("can't add a file to the history without its hash")
+	}
+
+	var previous Record
+	record, seen := s.records[sha256]
+
+	// it's in the history file already if it was folded in before the journal was removed
+	if seen && s.replaying && record.has(analysis) {
+		return *record, true, nil
+	}
+
+	if seen {
+		previous = *record
+	} else {
+		record = &Record{SHA256: sha256, FirstSeen: analysis.Time}
+		s.records[sha256] = record
+	}
+
+	record.Count++
+	record.LastSeen = analysis.Time
+	record.Analyses = append(record.Analyses, analysis)
+
+	if len(record.Analyses) > maxAnalysesPerFile {
+		record.Analyses = record.Analyses[len(record.Analyses)-maxAnalysesPerFile:]
+	}
+
+	s.prune()
+
+	if !s.replaying {
+		s.unsaved = append(s.unsaved, journalEntry{SHA256: sha256, Analysis: &analysis})
+	}
+
+	return previous, seen, nil
+}
+
+// has returns true if the record has an analysis from the same time
+func (r *Record) has(analysis Analysis) bool {
+	for _, existing := range r.Analyses {
+		if existing.Time.Equal(analysis.Time) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetNotes replaces the analyst's notes on a file
+func (s *Store) SetNotes(sha256, notes string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[strings.ToLower(sha256)]
+
+	if !ok {
+		return fmt.Errorf("%s isn't in the history", sha256)
+	}
+
+	record.Notes = notes
+	s.unsaved = append(s.unsaved, journalEntry{SHA256: record.SHA256, Notes: &notes})
+
+	return s.save()
+}
+
+// Search returns the records with the query in their hash, names, notes, verdicts,
+// findings or indicators, most recently seen first. An empty query returns everything
+func (s *Store) Search(query string) []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var found []Record
+
+	for _, record := range s.records {
+		if query == "" || record.matches(query) {
+			found = append(found, *record)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].LastSeen.After(found[j].LastSeen)
+	})
+
+	return found
+}
+
+// Match is a file in the history that's similar to another one
+type Match struct {
+	Record     Record
+	Similarity hashing.Similarity
+}
+
+// Similar returns the files with ssdeep or TLSH digests similar to the file's, most
+// similar first, leaving out the file itself
+func (s *Store) Similar(sha256, ssdeep, tlsh string) []Match {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if ssdeep == "" && tlsh == "" {
+		return nil
+	}
+
+	hashes := hashing.Hashes{SSDeep: ssdeep, TLSH: tlsh}
+	var matches []Match
+
+	for _, record := range s.records {
+		if record.SHA256 == strings.ToLower(sha256) {
+			continue
+		}
+
+		latest := record.Latest()
+		similarity := hashing.CompareFuzzy(&hashes, &hashing.Hashes{SSDeep: latest.SSDeep, TLSH: latest.TLSH})
+
+		if similarity.Similar() {
+			matches = append(matches, Match{Record: *record, Similarity: similarity})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i].Similarity, matches[j].Similarity
+
+		if a.SSDeep != b.SSDeep {
+			return a.SSDeep > b.SSDeep
+		}
+
+		return a.TLSH < b.TLSH
+	})
+
+	return matches[:min(len(matches), maxSimilar)]
+}
+
+// Len returns the number of files in the history
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.records)
+}
+
+// Latest returns the most recent analysis
+func (r Record) Latest() Analysis {
+	if len(r.Analyses) == 0 {
+		return Analysis{}
+	}
+
+	return r.Analyses[len(r.Analyses)-1]
+}
+
+// Names returns the different names the file has had, most recent first
+func (r Record) Names() []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for i := len(r.Analyses) - 1; i >= 0; i-- {
+		if name := r.Analyses[i].Name; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// SeenBefore describes the record, e.g. "Seen 3 times before, first seen 2 Jan 2026 10:04"
+func (r Record) SeenBefore() string {
+	times := "once"
+
+	if r.Count > 1 {
+		times = fmt.Sprintf("%d times", r.Count)
+	}
+
+	return fmt.Sprintf("Seen %s before, first seen %s", times, r.FirstSeen.Local().Format(dateFormat))
+}
+
+// FormatTime formats a time from the history for display
+func FormatTime(t time.Time) string {
+	return t.Local().Format(dateFormat)
+}
+
+func (r *Record) matches(query string) bool {
+	if strings.Contains(r.SHA256, query) || strings.Contains(strings.ToLower(r.Notes), query) {
+		return true
+	}
+
+	for _, analysis := range r.Analyses {
+		if strings.Contains(strings.ToLower(analysis.Name), query) ||
+			strings.Contains(strings.ToLower(analysis.Path), query) ||
+			strings.Contains(strings.ToLower(analysis.Verdict), query) {
+			return true
+		}
+
+		for _, f := range analysis.Findings {
+			if strings.Contains(strings.ToLower(f.Title), query) || strings.Contains(f.ID, query) {
+				return true
+			}
+		}
+
+		for _, found := range analysis.IOCs {
+			if strings.Contains(strings.ToLower(found.Value), query) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// prune drops the files seen longest ago once there are too many
+func (s *Store) prune() {
+	if len(s.records) <= maxRecords {
+		return
+	}
+
+	records := make([]*Record, 0, len(s.records))
+
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastSeen.Before(records[j].LastSeen)
+	})
+
+	for _, record := range records[:len(records)-maxRecords] {
+		delete(s.records, record.SHA256)
+	}
+}
+
+// save appends the changes since the last save to the journal, or folds them all into
+// the history file once the journal's full. Any scheduled save is cancelled. The caller
+// holds the lock
+func (s *Store) save() error {
+	if s.pending != nil {
+		s.pending.Stop()
+		s.pending = nil
+	}
+
+	if len(s.unsaved) == 0 {
+		return nil
+	}
+
+	if s.journaled+len(s.unsaved) > maxJournalEntries {
+		return s.compact()
+	}
+
+	var data []byte
+
+	for _, entry := range s.unsaved {
+		line, err := json.Marshal(entry)
+
+		if err != nil {
+			return fmt.Errorf("error encoding history: %s", err.Error())
+		}
+
+		data = append(append(data, line...), '\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	f, err := os.OpenFile(s.path+journalSuffix, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	_, err = f.Write(data)
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	s.journaled += len(s.unsaved)
+	s.unsaved = nil
+
+	return nil
+}
+
+// compact writes the whole history to a temporary file then renames it, so a crash part
+// way through can't lose it, then removes the journal. The caller holds the lock
+func (s *Store) compact() error {
+	records := make([]*Record, 0, len(s.records))
+
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SHA256 < records[j].SHA256
+	})
+
+	data, err := json.Marshal(records)
+
+	if err != nil {
+		return fmt.Errorf("error encoding history: %s", err.Error())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	// a unique name, so two stores saving to the same folder can't write over each other's
+	temp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+
+	if err != nil {
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	_, err = temp.Write(data)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), s.path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	// if this fails the journal's replayed over records that already have its analyses,
+	// which are skipped
+	if err := os.Remove(s.path + journalSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error saving history: %s", err.Error())
+	}
+
+	s.journaled = 0
+	s.unsaved = nil
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package ioc
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+	"mvdan.cc/xurls/v2"
+)
+
+// text bigger than this is only searched up to the limit, so one huge part can't stall the analysis
+const maxTextSize = 8 * 1024 * 1024
+
+var (
+	urlPattern         = xurls.Strict()
+	emailPattern       = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@(?:[A-Za-z0-9](?:[A-Za-z0-9\-]{0,61}[A-Za-z0-9])?\.)+[A-Za-z]{2,24}`)
+	domainPattern      = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9\-]{0,61}[a-z0-9])?\.)+[a-z]{2,24}\b`)
+	ipv4Pattern        = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Pattern        = regexp.MustCompile(`(?i)\b[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}\b`)
+	md5Pattern         = regexp.MustCompile(`\b[A-Fa-f0-9]{32}\b`)
+	sha1Pattern        = regexp.MustCompile(`\b[A-Fa-f0-9]{40}\b`)
+	sha256Pattern      = regexp.MustCompile(`\b[A-Fa-f0-9]{64}\b`)
+	windowsPathPattern = regexp.MustCompile(`(?:\b[A-Za-z]:|\\\\[A-Za-z0-9._\-$]+)\\(?:[^\\/:*?"<>|\s]+\\)*[^\\/:*?"<>|\s]*`)
+	unixPathPattern    = regexp.MustCompile(`(?:^|[\s"'(=])(/(?:tmp|etc|var|usr|bin|sbin|home|root|dev|opt|Users|Library|private)/[^\s"'<>|;)]+)`)
+	registryPattern    = regexp.MustCompile(`(?i)\b(?:HKEY_LOCAL_MACHINE|HKEY_CURRENT_USER|HKEY_CLASSES_ROOT|HKEY_USERS|HKEY_CURRENT_CONFIG|HKLM|HKCU|HKCR|HKU|HKCC)\\[^\s"'<>|]+`)
+	bitcoinPattern     = regexp.MustCompile(`\b(?:[13][a-km-zA-HJ-NP-Z1-9]{25,34}|bc1[ac-hj-np-z02-9]{11,71})\b`)
+	ethereumPattern    = regexp.MustCompile(`\b0x[a-fA-F0-9]{40}\b`)
+	moneroPattern      = regexp.MustCompile(`\b4[0-9AB][1-9A-HJ-NP-Za-km-z]{93}\b`)
+
+	// top level domains that are also file extensions, so a name like "invoice.zip" in
+	// the text is only a domain if it's in a URL or email address, or starts with "www."
+	fileExtensionTLDs = map[string]bool{
+		"ai": true, "app": true, "md": true, "mobi": true, "mov": true, "one": true,
+		"pl": true, "ps": true, "py": true, "rs": true, "sh": true, "zip": true,
+	}
+
+	// analysts and mail filters "defang" indicators so they can't be clicked
+	refanger = strings.NewReplacer(
+		"hxxp", "http",
+		"hXXp", "http",
+		"[.]", ".",
+		"(.)", ".",
+		"{.}", ".",
+		"[dot]", ".",
+		"[:]", ":",
+		"[://]", "://",
+		"[@]", "@",
+		"[at]", "@",
+	)
+)
+
+// Extract finds all the indicators in the text and adds them to the set against the source
+func (s *Set) Extract(text, source string) {
+	if len(text) > maxTextSize {
+		text = text[:maxTextSize]
+	}
+
+	text = refanger.Replace(text)
+
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		// xurls also finds emails and other schemes, which are handled separately
+		lower := strings.ToLower(match)
+
+		if strings.HasPrefix(lower, "mailto:") || strings.HasPrefix(lower, "tel:") {
+			continue
+		}
+
+		if parsed, err := url.Parse(match); err == nil && parsed.Host != "" {
+			s.Add(TypeURL, match, source)
+			s.addHost(parsed.Hostname(), source)
+		}
+	}
+
+	for _, match := range emailPattern.FindAllString(text, -1) {
+		s.Add(TypeEmail, match, source)
+		s.addHost(match[strings.LastIndex(match, "@")+1:], source)
+	}
+
+	for _, loc := range domainPattern.FindAllStringIndex(text, -1) {
+		// names in paths are files, e.g. "/tmp/payload.sh", and URL hosts were added above
+		if loc[0] > 0 && (text[loc[0]-1] == '/' || text[loc[0]-1] == '\\') {
+			continue
+		}
+
+		if match := text[loc[0]:loc[1]]; isDomain(match) && !looksLikeFileName(match) {
+			s.Add(TypeDomain, match, source)
+		}
+	}
+
+	for _, match := range ipv4Pattern.FindAllString(text, -1) {
+		if ip := net.ParseIP(match); ip != nil && ip.To4() != nil {
+			s.Add(TypeIPv4, match, source)
+		}
+	}
+
+	for _, match := range ipv6Pattern.FindAllString(text, -1) {
+		if ip := net.ParseIP(match); ip != nil && ip.To4() == nil {
+			s.Add(TypeIPv6, ip.String(), source)
+		}
+	}
+
+	for _, match := range sha256Pattern.FindAllString(text, -1) {
+		s.Add(TypeSHA256, match, source)
+	}
+
+	for _, match := range sha1Pattern.FindAllString(text, -1) {
+		s.Add(TypeSHA1, match, source)
+	}
+
+	for _, match := range md5Pattern.FindAllString(text, -1) {
+		s.Add(TypeMD5, match, source)
+	}
+
+	for _, match := range windowsPathPattern.FindAllString(text, -1) {
+		s.Add(TypeFilePath, match, source)
+	}
+
+	for _, match := range unixPathPattern.FindAllStringSubmatch(text, -1) {
+		s.Add(TypeFilePath, match[1], source)
+	}
+
+	for _, match := range registryPattern.FindAllString(text, -1) {
+		s.Add(TypeRegistryKey, match, source)
+	}
+
+	for _, match := range bitcoinPattern.FindAllString(text, -1) {
+		if isBitcoinAddress(match) {
+			s.Add(TypeBitcoin, match, source)
+		}
+	}
+
+	for _, match := range ethereumPattern.FindAllString(text, -1) {
+		s.Add(TypeEthereum, match, source)
+	}
+
+	for _, match := range moneroPattern.FindAllString(text, -1) {
+		s.Add(TypeMonero, match, source)
+	}
+}
+
+// addHost adds the host of a URL or email address as a domain or IP address
+func (s *Set) addHost(host, source string) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			s.Add(TypeIPv4, ip.String(), source)
+		} else {
+			s.Add(TypeIPv6, ip.String(), source)
+		}
+	} else if isDomain(host) {
+		s.Add(TypeDomain, host, source)
+	}
+}
+
+// looksLikeFileName returns true if the name found on its own in the text is more likely a
+// file than a domain, e.g. "invoice.zip" or "setup.sh" but not "www.example.zip"
+func looksLikeFileName(name string) bool {
+	name = strings.ToLower(name)
+	tld := name[strings.LastIndex(name, ".")+1:]
+
+	return fileExtensionTLDs[tld] && !strings.HasPrefix(name, "www.")
+}
+
+// isDomain returns true if the name ends in a real top level domain, so file names like
+// "invoice.pdf" aren't mistaken for domains
+func isDomain(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	suffix, icann := publicsuffix.PublicSuffix(name)
+
+	return icann && suffix != name
+}
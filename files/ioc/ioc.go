@@ -0,0 +1,166 @@
+// Package ioc pulls indicators of compromise out of the content of a file, e.g. URLs,
+// IP addresses and hashes, so they can be pivoted on after triage
+package ioc
+
+import (
+	"sort"
+	"strings"
+)
+
+// Type is the kind of indicator
+type Type string
+
+const (
+	TypeURL         Type = "url"
+	TypeDomain      Type = "domain"
+	TypeIPv4        Type = "ipv4"
+	TypeIPv6        Type = "ipv6"
+	TypeEmail       Type = "email"
+	TypeMD5         Type = "md5"
+	TypeSHA1        Type = "sha1"
+	TypeSHA256      Type = "sha256"
+	TypeFileName    Type = "file-name"
+	TypeFilePath    Type = "file-path"
+	TypeRegistryKey Type = "registry-key"
+	TypeBitcoin     Type = "bitcoin"
+	TypeEthereum    Type = "ethereum"
+	TypeMonero      Type = "monero"
+)
+
+// the order types are listed in, most useful for pivoting first
+var typeOrder = []Type{
+	TypeURL, TypeDomain, TypeIPv4, TypeIPv6, TypeEmail,
+	TypeSHA256, TypeSHA1, TypeMD5,
+	TypeFileName, TypeFilePath, TypeRegistryKey,
+	TypeBitcoin, TypeEthereum, TypeMonero,
+}
+
+var typeTitles = map[Type]string{
+	TypeURL:         "URL",
+	TypeDomain:      "Domain",
+	TypeIPv4:        "IPv4 address",
+	TypeIPv6:        "IPv6 address",
+	TypeEmail:       "Email address",
+	TypeMD5:         "MD5 hash",
+	TypeSHA1:        "SHA1 hash",
+	TypeSHA256:      "SHA256 hash",
+	TypeFileName:    "File name",
+	TypeFilePath:    "File path",
+	TypeRegistryKey: "Registry key",
+	TypeBitcoin:     "Bitcoin address",
+	TypeEthereum:    "Ethereum address",
+	TypeMonero:      "Monero address",
+}
+
+// Title returns a human readable name for the type
+func (t Type) Title() string {
+	if title, ok := typeTitles[t]; ok {
+		return title
+	}
+
+	return string(t)
+}
+
+func (t Type) rank() int {
+	for i, ordered := range typeOrder {
+		if ordered == t {
+			return i
+		}
+	}
+
+	return len(typeOrder)
+}
+
+// IOC is a single indicator and everywhere in the file it was found
+type IOC struct {
+	Type  Type   `json:"type"`
+	Value string `json:"value"`
+
+	// Sources are where the indicator was found, e.g. "body" or "attachment 1 (invoice.pdf): object 4"
+	Sources []string `json:"sources"`
+}
+
+// Set is a deduplicated collection of indicators. The zero value is ready to use
+type Set struct {
+	iocs  []*IOC
+	index map[string]*IOC
+}
+
+// Add adds an indicator found at the source, merging it with any it duplicates
+func (s *Set) Add(t Type, value, source string) {
+	value = normalise(t, value)
+
+	if value == "" {
+		return
+	}
+
+	if s.index == nil {
+		s.index = make(map[string]*IOC)
+	}
+
+	key := string(t) + "|" + value
+	existing, seen := s.index[key]
+
+	if !seen {
+		existing = &IOC{Type: t, Value: value}
+		s.index[key] = existing
+		s.iocs = append(s.iocs, existing)
+	}
+
+	for _, known := range existing.Sources {
+		if known == source {
+			return
+		}
+	}
+
+	existing.Sources = append(existing.Sources, source)
+}
+
+// AddAll adds the indicators, putting the prefix in front of their sources, e.g. "attachment 1"
+func (s *Set) AddAll(list []IOC, prefix string) {
+	for _, i := range list {
+		for _, source := range i.Sources {
+			if prefix != "" {
+				source = prefix + ": " + source
+			}
+
+			s.Add(i.Type, i.Value, source)
+		}
+	}
+}
+
+// Len returns the number of distinct indicators
+func (s *Set) Len() int {
+	return len(s.iocs)
+}
+
+// List returns the indicators, sorted by type then value
+func (s *Set) List() []IOC {
+	list := make([]IOC, len(s.iocs))
+
+	for i, found := range s.iocs {
+		list[i] = *found
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return list[i].Type.rank() < list[j].Type.rank()
+		}
+
+		return list[i].Value < list[j].Value
+	})
+
+	return list
+}
+
+// normalise makes values that mean the same thing compare equal
+func normalise(t Type, value string) string {
+	value = strings.TrimSpace(value)
+
+	switch t {
+	case TypeDomain, TypeEmail, TypeMD5, TypeSHA1, TypeSHA256, TypeEthereum:
+		return strings.ToLower(strings.TrimSuffix(value, "."))
+	default:
+		return value
+	}
+}
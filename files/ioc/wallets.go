@@ -0,0 +1,106 @@
+package ioc
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// bech32 is used by native segwit addresses, e.g. "bc1q..."
+const bech32Alphabet = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// isBitcoinAddress checks the address's checksum, as plenty of random strings look like addresses
+func isBitcoinAddress(address string) bool {
+	if strings.HasPrefix(address, "bc1") {
+		return isBech32(address)
+	}
+
+	return isBase58Check(address)
+}
+
+// legacy addresses are base58 encoded with the first 4 bytes of a double SHA256 on the end
+func isBase58Check(address string) bool {
+	decoded := big.NewInt(0)
+	base := big.NewInt(58)
+
+	for _, c := range address {
+		index := strings.IndexRune(base58Alphabet, c)
+
+		if index < 0 {
+			return false
+		}
+
+		decoded.Mul(decoded, base)
+		decoded.Add(decoded, big.NewInt(int64(index)))
+	}
+
+	// leading 1s are leading zero bytes
+	leadingZeros := len(address) - len(strings.TrimLeft(address, "1"))
+	data := append(make([]byte, leadingZeros), decoded.Bytes()...)
+
+	// version byte, 20 byte hash and 4 byte checksum
+	if len(data) != 25 {
+		return false
+	}
+
+	first := sha256.Sum256(data[:21])
+	second := sha256.Sum256(first[:])
+
+	return string(second[:4]) == string(data[21:])
+}
+
+// checks the BIP 173 checksum, allowing either the bech32 or bech32m constant
+func isBech32(address string) bool {
+	separator := strings.LastIndex(address, "1")
+
+	if separator < 1 || separator+7 > len(address) {
+		return false
+	}
+
+	hrp := address[:separator]
+	var values []int
+
+	for _, c := range hrp {
+		values = append(values, int(c)>>5)
+	}
+
+	values = append(values, 0)
+
+	for _, c := range hrp {
+		values = append(values, int(c)&31)
+	}
+
+	for _, c := range address[separator+1:] {
+		index := strings.IndexRune(bech32Alphabet, c)
+
+		if index < 0 {
+			return false
+		}
+
+		values = append(values, index)
+	}
+
+	checksum := bech32Polymod(values)
+
+	return checksum == 1 || checksum == 0x2bc830a3
+}
+
+func bech32Polymod(values []int) int {
+	generator := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	checksum := 1
+
+	for _, value := range values {
+		top := checksum >> 25
+		checksum = (checksum&0x1ffffff)<<5 ^ value
+
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator[i]
+			}
+		}
+	}
+
+	return checksum
+}
@@ -0,0 +1,44 @@
+package files
+
+import (
+	"context"
+
+	"file-inspector/files/findings"
+	"file-inspector/utils/limits"
+)
+
+// withBudget returns the context with the budget for the input. Attachments share the
+// decompressed bytes and objects of the file they're in
+func withBudget(ctx context.Context, input *Input) (context.Context, *limits.Budget) {
+	budget := limits.NewBudget()
+
+	if input.Depth > 0 {
+		budget = limits.FromContext(ctx).Child()
+	}
+
+	return limits.WithBudget(ctx, budget), budget
+}
+
+// addLimitFindings adds a finding for each limit the file reached, marking the results as
+// incomplete if anything was skipped because of them
+func (r *ProcessResult) addLimitFindings(budget *limits.Budget) {
+	for _, exceeded := range budget.Exceeded() {
+		// deeply nested attachments are common in forwarded emails, so they're only noted
+		severity := findings.SeverityLow
+
+		if exceeded.Limit != limits.Depth {
+			severity = findings.SeverityMedium
+			r.LimitExceeded = true
+			r.Completed = false
+		}
+
+		r.AddFinding(findings.Finding{
+			ID:          "limit." + exceeded.Limit,
+			Title:       "Resource limit exceeded, so the results are incomplete",
+			Severity:    severity,
+			Category:    findings.CategoryParsing,
+			Evidence:    exceeded.Error(),
+			Remediation: "Files built to use up memory, e.g. zip bombs, are used to get past scanners. Treat the file with caution.",
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package misp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	misp_url_env     = "MISP_URL"
+	misp_api_key_env = "MISP_API_KEY"
+
+	uploadTimeout = 30 * time.Second
+
+	// the most of an error response we'll show
+	maxErrorBody = 1024
+)
+
+// Client uploads events to a MISP server
+type Client struct {
+	// URL is the base URL of the server, e.g. "https://misp.example.com"
+	URL    string
+	APIKey string
+
+	HTTPClient *http.Client
+}
+
+// NewClientFromEnv returns a client for the server and API key in the MISP_URL and
+// MISP_API_KEY environment variables
+func NewClientFromEnv() (*Client, error) {
+	return NewClient("", "")
+}
+
+// NewClient returns a client for the server and API key, e.g. from the settings. The
+// MISP_URL and MISP_API_KEY environment variables are used instead if they're set
+func NewClient(serverURL, apiKey string) (*Client, error) {
+	if envURL := os.Getenv(misp_url_env); envURL != "" {
+		serverURL = envURL
+	}
+
+	if serverURL == "" {
+		return nil, fmt.Errorf("failed. We need the MISP server's URL set in the settings or as the environment variable %q", misp_url_env)
+	}
+
+	if envKey, err := getMISPAPIKeyFromEnv(); err == nil {
+		apiKey = envKey
+	} else if apiKey == "" {
+		return nil, err
+	}
+
+	return &Client{
+		URL:        strings.TrimSuffix(serverURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: uploadTimeout},
+	}, nil
+}
+
+// Upload adds the event to the server, returning the ID the server gave it
+func (c *Client) Upload(ctx context.Context, event *Event) (string, error) {
+	var body bytes.Buffer
+
+	if err := event.Write(&body); err != nil {
+		return "", fmt.Errorf("error encoding MISP event: %s", err.Error())
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+"/events/add", &body)
+
+	if err != nil {
+		return "", fmt.Errorf("error building MISP request: %s", err.Error())
+	}
+
+	request.Header.Set("Authorization", c.APIKey)
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+
+	log.Printf("Uploading event to MISP at %s\n", c.URL)
+	response, err := c.HTTPClient.Do(request)
+
+	if err != nil {
+		return "", fmt.Errorf("error uploading to MISP: %s", err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+		return "", fmt.Errorf("MISP rejected the event with status %q: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+
+	var added struct {
+		Event struct {
+			ID string `json:"id"`
+		} `json:"Event"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&added); err != nil {
+		return "", fmt.Errorf("error reading MISP response: %s", err.Error())
+	}
+
+	log.Printf("MISP added event %s\n", added.Event.ID)
+
+	return added.Event.ID, nil
+}
+
+func getMISPAPIKeyFromEnv() (string, error) {
+	key := os.Getenv(misp_api_key_env)
+
+	if key == "" {
+		return "", fmt.Errorf("failed. We need a MISP API key set as the environment variable %q", misp_api_key_env)
+	}
+
+	log.Println("Got MISP API key from env")
+
+	return key, nil
+}
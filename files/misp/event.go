@@ -0,0 +1,361 @@
+// Package misp exports analysis results as MISP events, and uploads them to a MISP server
+package misp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/ioc"
+	"file-inspector/files/verdict"
+	"file-inspector/utils/urls"
+)
+
+// attribute categories, see https://www.misp-project.org/datamodels/
+const (
+	categoryPayloadDelivery = "Payload delivery"
+	categoryNetworkActivity = "Network activity"
+	categoryOther           = "Other"
+)
+
+// threat levels and analysis states for the event
+const (
+	threatLevelHigh      = "1"
+	threatLevelMedium    = "2"
+	threatLevelLow       = "3"
+	threatLevelUndefined = "4"
+
+	analysisOngoing   = "1"
+	analysisCompleted = "2"
+
+	// only shared with the uploader's organisation, it's up to them to share it further
+	distributionOrganisation = "0"
+)
+
+// the MISP object templates the objects follow
+var (
+	fileTemplate  = template{name: "file", metaCategory: "file", uuid: "688c46fb-5edb-40a3-8273-1af7923e2215", version: "24"}
+	emailTemplate = template{name: "email", metaCategory: "network", uuid: "a0c666e0-fc65-4be8-b48f-3423d788b552", version: "18"}
+	urlTemplate   = template{name: "url", metaCategory: "network", uuid: "60efb77b-40b5-4c46-871b-ed1ed999fce5", version: "9"}
+)
+
+type template struct {
+	name         string
+	metaCategory string
+	uuid         string
+	version      string
+}
+
+// Document is the JSON MISP expects when adding an event
+type Document struct {
+	Event *Event `json:"Event"`
+}
+
+// Event is a MISP event, holding an object for each file, email and URL
+type Event struct {
+	Info          string   `json:"info"`
+	Date          string   `json:"date"`
+	ThreatLevelID string   `json:"threat_level_id"`
+	Analysis      string   `json:"analysis"`
+	Distribution  string   `json:"distribution"`
+	Objects       []Object `json:"Object"`
+
+	// the worst verdict of the files added, for the threat level
+	worst   verdict.Verdict
+	names   []string
+	partial bool
+	urls    map[string]bool
+	checker urls.URLChecker
+}
+
+// Object is a group of attributes describing one thing, e.g. a file
+type Object struct {
+	Name            string      `json:"name"`
+	MetaCategory    string      `json:"meta-category"`
+	TemplateUUID    string      `json:"template_uuid"`
+	TemplateVersion string      `json:"template_version"`
+	Comment         string      `json:"comment,omitempty"`
+	Attributes      []Attribute `json:"Attribute"`
+}
+
+// Attribute is a single value. ToIDS is set if it should be used for detection
+type Attribute struct {
+	Type           string `json:"type"`
+	Category       string `json:"category"`
+	ObjectRelation string `json:"object_relation"`
+	Value          string `json:"value"`
+	ToIDS          bool   `json:"to_ids"`
+	Comment        string `json:"comment,omitempty"`
+}
+
+// NewEvent returns an empty event, dated today
+func NewEvent() *Event {
+	// the URLs we'd expect to see, which shouldn't be used for detection
+	checker, _ := urls.GetCommonURLChecker()
+
+	return &Event{
+		Date:          time.Now().UTC().Format("2006-01-02"),
+		ThreatLevelID: threatLevelUndefined,
+		Analysis:      analysisCompleted,
+		Distribution:  distributionOrganisation,
+		Objects:       []Object{},
+		urls:          make(map[string]bool),
+		checker:       checker,
+	}
+}
+
+// Add adds objects for the file, its attachments, the email if it is one, and the URLs
+// found. Hashes and senders are only flagged for detection if the file scored Suspicious
+// or Malicious. The properties can be nil, e.g. if the file wasn't on disk
+func (e *Event) Add(properties *files.FileProperties, result *files.ProcessResult) {
+	hash := result.SHA256
+	var size int64
+	mimeType := result.MimeType
+
+	if properties != nil {
+		hash = properties.Hash
+		size = properties.SizeBytes
+		mimeType = properties.FileType
+	}
+
+	e.addResult(result, hash, size, mimeType, "")
+
+	for _, found := range result.IOCs {
+		if found.Type == ioc.TypeURL && !e.urls[found.Value] {
+			e.urls[found.Value] = true
+			e.addURL(found.Value, strings.Join(found.Sources, ", "))
+		}
+	}
+
+	e.names = append(e.names, result.Name)
+
+	if !result.Completed {
+		e.partial = true
+	}
+
+	e.updateSummary(result)
+}
+
+func (e *Event) addResult(result *files.ProcessResult, hash string, size int64, mimeType, comment string) {
+	toIDS := isDetected(result)
+	file := newObject(fileTemplate, comment)
+
+	file.add("filename", categoryPayloadDelivery, "filename", result.Name, false)
+
+	if hash != "" {
+		file.add("sha256", categoryPayloadDelivery, "sha256", hash, toIDS)
+	}
+
+	for _, attribute := range []struct {
+		name, value string
+		toIDS       bool
+	}{
+		{"md5", result.MD5, toIDS},
+		{"sha1", result.SHA1, toIDS},
+		{"sha512", result.SHA512, toIDS},
+
+		// fuzzy hashes match similar files too, so they're never used for detection
+		{"ssdeep", result.SSDeep, false},
+		{"tlsh", result.TLSH, false},
+	} {
+		if attribute.value != "" {
+			file.add(attribute.name, categoryPayloadDelivery, attribute.name, attribute.value, attribute.toIDS)
+		}
+	}
+
+	if mimeType != "" {
+		file.add("mime-type", categoryPayloadDelivery, "mimetype", mimeType, false)
+	}
+
+	if size > 0 {
+		file.add("size-in-bytes", categoryOther, "size-in-bytes", strconv.FormatInt(size, 10), false)
+	}
+
+	if result.Assessment != nil {
+		verdictComment := "Verdict: " + result.Summary()
+
+		if comment != "" {
+			verdictComment = comment + ". " + verdictComment
+		}
+
+		file.Comment = verdictComment
+	}
+
+	e.Objects = append(e.Objects, file)
+
+	if email := newEmailObject(result, toIDS); email != nil {
+		e.Objects = append(e.Objects, *email)
+	}
+
+	for i, child := range result.Children {
+		childComment := fmt.Sprintf("Attachment %d of %s", i+1, result.Name)
+		e.addResult(child, child.SHA256, 0, child.MimeType, childComment)
+	}
+}
+
+// newEmailObject builds the email from the analyzer's metadata, returning nil if it isn't an email
+func newEmailObject(result *files.ProcessResult, toIDS bool) *Object {
+	headers := result.EmailHeaders()
+
+	if headers.From == "" {
+		return nil
+	}
+
+	email := newObject(emailTemplate, fmt.Sprintf("Email %s", result.Name))
+
+	for _, address := range parseAddresses(headers.From) {
+		email.add("email-src", categoryPayloadDelivery, "from", address, toIDS)
+	}
+
+	for _, address := range parseAddresses(headers.ReturnPath) {
+		email.add("email-src", categoryPayloadDelivery, "return-path", address, toIDS)
+	}
+
+	// the recipients are the victims, not indicators
+	for _, address := range parseAddresses(headers.To) {
+		email.add("email-dst", categoryPayloadDelivery, "to", address, false)
+	}
+
+	if headers.Subject != "" {
+		email.add("email-subject", categoryPayloadDelivery, "subject", headers.Subject, false)
+	}
+
+	if headers.MessageID != "" {
+		email.add("email-message-id", categoryPayloadDelivery, "message-id", headers.MessageID, false)
+	}
+
+	for _, child := range result.Children {
+		email.add("email-attachment", categoryPayloadDelivery, "attachment", child.Name, false)
+	}
+
+	return &email
+}
+
+// addURL adds a URL object. URLs on common domains aren't used for detection
+func (e *Event) addURL(value, comment string) {
+	object := newObject(urlTemplate, "Found in "+comment)
+	toIDS := true
+
+	if e.checker != nil {
+		if common, err := e.checker.Check(value); err == nil && common {
+			toIDS = false
+		}
+	}
+
+	object.add("url", categoryNetworkActivity, "url", value, toIDS)
+
+	if parsed, err := url.Parse(value); err == nil && parsed.Hostname() != "" {
+		object.add("domain", categoryNetworkActivity, "domain", parsed.Hostname(), false)
+	}
+
+	e.Objects = append(e.Objects, object)
+}
+
+// updateSummary sets the title and threat level from the files added so far
+func (e *Event) updateSummary(result *files.ProcessResult) {
+	if result.Assessment != nil && verdictRank(result.Assessment.Verdict) > verdictRank(e.worst) {
+		e.worst = result.Assessment.Verdict
+	}
+
+	switch e.worst {
+	case verdict.Malicious:
+		e.ThreatLevelID = threatLevelHigh
+	case verdict.Suspicious:
+		e.ThreatLevelID = threatLevelMedium
+	case verdict.Clean:
+		e.ThreatLevelID = threatLevelLow
+	}
+
+	if e.partial {
+		e.Analysis = analysisOngoing
+	}
+
+	if len(e.names) == 1 {
+		e.Info = fmt.Sprintf("File-Inspector analysis of %s: %s", e.names[0], result.Summary())
+	} else {
+		e.Info = fmt.Sprintf("File-Inspector analysis of %d files", len(e.names))
+
+		if e.worst != "" {
+			e.Info += fmt.Sprintf(", worst verdict %s", e.worst)
+		}
+	}
+}
+
+// Write writes the event as the JSON MISP imports
+func (e *Event) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(Document{Event: e})
+}
+
+func newObject(t template, comment string) Object {
+	return Object{
+		Name:            t.name,
+		MetaCategory:    t.metaCategory,
+		TemplateUUID:    t.uuid,
+		TemplateVersion: t.version,
+		Comment:         comment,
+		Attributes:      []Attribute{},
+	}
+}
+
+func (o *Object) add(attributeType, category, relation, value string, toIDS bool) {
+	o.Attributes = append(o.Attributes, Attribute{
+		Type:           attributeType,
+		Category:       category,
+		ObjectRelation: relation,
+		Value:          value,
+		ToIDS:          toIDS,
+	})
+}
+
+// isDetected is true if the file scored high enough that its indicators should be used for detection
+func isDetected(result *files.ProcessResult) bool {
+	return result.Assessment != nil && result.Assessment.Verdict != verdict.Clean
+}
+
+func verdictRank(v verdict.Verdict) int {
+	switch v {
+	case verdict.Clean:
+		return 1
+	case verdict.Suspicious:
+		return 2
+	case verdict.Malicious:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// parseAddresses returns the bare addresses from a header, e.g. "Bob <bob@example.com>"
+func parseAddresses(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	addresses, err := mail.ParseAddressList(list)
+
+	// .msg files often just have the bare address
+	if err != nil {
+		if strings.Contains(list, "@") {
+			return []string{strings.Trim(strings.TrimSpace(list), "<>")}
+		}
+
+		return nil
+	}
+
+	var values []string
+
+	for _, address := range addresses {
+		values = append(values, strings.ToLower(address.Address))
+	}
+
+	return values
+}
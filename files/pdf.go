@@ -2,19 +2,43 @@ package files
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"strings"
 
+	"file-inspector/files/findings"
 	"file-inspector/files/pdf"
 )
 
-func processPDFFile(result *ProcessResult) {
-	var analysis bytes.Buffer
+func init() {
+	RegisterAnalyzer(pdfAnalyzer{})
+}
+
+// pdfAnalyzer handles PDF documents
+type pdfAnalyzer struct{}
+
+func (pdfAnalyzer) Name() string         { return "pdf" }
+func (pdfAnalyzer) MimeTypes() []string  { return []string{pdfMimeType} }
+func (pdfAnalyzer) Extensions() []string { return []string{".pdf"} }
+
+// readers accept the header anywhere in the first 1024 bytes, so attackers put junk before it
+func (pdfAnalyzer) MatchContent(header []byte) bool {
+	return bytes.Contains(header[:min(len(header), 1024)], []byte("%PDF-"))
+}
+
+func (pdfAnalyzer) Analyze(ctx context.Context, input *Input) (*ProcessResult, error) {
+	log.Println("Parsing PDF file")
+	res := ProcessResult{FilePath: input.FilePath}
+	processPDFFile(ctx, input, &res)
+	return &res, res.Error
+}
+
+func processPDFFile(ctx context.Context, input *Input, result *ProcessResult) {
 	var metadata [][]string
 
 	// check encryption
-	encrypted, err := pdf.IsEncrypted(result.FilePath)
+	encrypted, err := pdf.IsEncryptedReader(input.Reader, input.Size)
 
 	if err != nil {
 		result.Parsed = false
@@ -24,14 +48,20 @@ func processPDFFile(result *ProcessResult) {
 	}
 
 	if encrypted {
-		result.Analysis = fmt.Sprintf("File %q is encrypted and password protected, so cannot be inspected.\n", result.FilePath)
+		result.AddFinding(findings.Finding{
+			ID:          "pdf.encrypted",
+			Title:       "File is encrypted and password protected, so cannot be inspected",
+			Severity:    findings.SeverityHigh,
+			Category:    findings.CategoryEncryption,
+			Evidence:    fmt.Sprintf("File %q is encrypted", input.Name),
+			Remediation: "Encryption is often used to hide malicious content from scanners. Only open it in a sandbox.",
+		})
 		result.Completed = false
-		result.Dangerous = true
 		return
 	}
 
 	// get metadata
-	md, err := pdf.GetMetadata(result.FilePath)
+	md, err := pdf.GetMetadataReader(input.Reader, input.Size)
 
 	// don't care if errors because there's no metadata
 	if err != nil && !strings.Contains(err.Error(), "Failed to get any metadata") {
@@ -43,29 +73,79 @@ func processPDFFile(result *ProcessResult) {
 	if len(md) > 0 {
 		for field, value := range md {
 			metadata = append(metadata, []string{strings.TrimSpace(field), value})
+			result.ExtractIOCs(value, "metadata "+strings.TrimSpace(field))
 		}
 	}
 
-	// check for active content
-	activeResult, err := pdf.CheckForActiveContent(result.FilePath)
+	result.Metadata = metadata
 
-	if err != nil {
+	// check for active content, which can take a long time if there are lots of objects
+	activeResult, err := pdf.CheckForActiveContentReader(ctx, input.Reader, input.Size)
+
+	if activeResult == nil {
 		result.Completed = false
 		result.Error = err
 		return
 	}
 
-	analysis.WriteString("Active content in file:\n\n")
+	if len(activeResult.Found) == 0 && err == nil {
+		result.AddFinding(findings.Finding{
+			ID:       "pdf.active.none",
+			Title:    "No active content found",
+			Severity: findings.SeverityInfo,
+			Category: findings.CategoryActiveContent,
+		})
+	}
 
-	if len(activeResult) == 0 {
-		analysis.WriteString("\t✅ None found")
-	} else {
-		analysis.WriteString(activeResult)
-		result.Dangerous = true
+	for _, active := range activeResult.Found {
+		result.AddFinding(findings.Finding{
+			ID:          "pdf.active." + strings.ToLower(strings.TrimPrefix(active.Keyword, "/")),
+			Title:       fmt.Sprintf("Active content %q found", active.Keyword),
+			Severity:    findings.SeverityHigh,
+			Category:    findings.CategoryActiveContent,
+			Evidence:    fmt.Sprintf("Found %d references to %q in the file's objects. %s.", len(active.Objects), active.Keyword, active.Description),
+			Location:    findings.Location{Objects: active.Objects},
+			Remediation: "Don't open the file in a PDF reader with scripting enabled.",
+		})
+	}
+
+	for _, object := range activeResult.Objects {
+		result.ExtractIOCs(object.Text, fmt.Sprintf("object %d", object.Number))
+	}
+
+	// the streams are usually compressed, so scan them decoded
+	if hasRules() {
+		err := pdf.ReadStreams(ctx, input.Reader, input.Size, func(object int, data []byte) {
+			scanWithRules(ctx, data, findings.Location{Objects: []int{object}}, result)
+		})
+
+		if err != nil {
+			log.Printf("Error reading PDF streams for YARA scanning: %s\n", err.Error())
+		}
+	}
+
+	for _, problem := range activeResult.Problems {
+		result.AddFinding(findings.Finding{
+			ID:       "pdf.object-error",
+			Title:    "Failed to check an object for active content",
+			Severity: findings.SeverityLow,
+			Category: findings.CategoryParsing,
+			Evidence: problem,
+		})
+	}
+
+	// the objects can all be checked and the time still run out scanning the streams
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	// stopped part way through, so keep what we found
+	if err != nil {
+		result.Completed = false
+		result.Error = err
+		return
 	}
 
 	log.Println("PDF processing done")
-	result.Analysis = analysis.String()
-	result.Metadata = metadata
 	result.Completed = true
 }
@@ -3,16 +3,32 @@ package pdf
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
-	"os"
+	"io"
 	"strings"
+	"sync"
 
 	"seehuhn.de/go/pdf"
+
+	"file-inspector/utils/limits"
 )
 
 func IsEncrypted(filePath string) (bool, error) {
-	_, err := getReader(filePath)
+	fd, size, err := openFile(filePath)
+
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	return IsEncryptedReader(fd, size)
+}
+
+// IsEncryptedReader is IsEncrypted for a PDF that's already open or in memory
+func IsEncryptedReader(r io.ReaderAt, size int64) (bool, error) {
+	_, err := getReader(r, size)
 
 	if err != nil {
 		if strings.Contains(err.Error(), "authentication failed for document") {
@@ -25,40 +41,122 @@ func IsEncrypted(filePath string) (bool, error) {
 	return false, nil
 }
 
-func CheckForActiveContent(filePath string) (string, error) {
+// ActiveContent is a type of active content found in the file's objects
+type ActiveContent struct {
+	Keyword     string
+	Description string
+	Objects     []int
+}
 
-	fd, err := os.Open(filePath)
+// ObjectText is the text of an object, without any stream data
+type ObjectText struct {
+	Number int
+	Text   string
+}
 
-	if err != nil {
-		return "❓ Failed to complete checks", err
+// ActiveContentResult holds the active content found, plus any objects we couldn't check
+type ActiveContentResult struct {
+	Found    []ActiveContent
+	Problems []string
+
+	// Objects are the objects holding string literals, which is where URLs, scripts and
+	// file names are found. Only the first maxObjectText bytes are kept
+	Objects []ObjectText
+}
+
+// the most object text kept, so huge files don't use huge amounts of memory
+const maxObjectText = 4 * 1024 * 1024
+
+// Keyword is a name in an object's dictionary that marks active content
+type Keyword struct {
+	// Keyword is the name, including the slash, e.g. "/JavaScript"
+	Keyword     string `toml:"keyword"`
+	Description string `toml:"description"`
+}
+
+var (
+	keywordsMu sync.RWMutex
+	keywords   = DefaultKeywords()
+)
+
+// DefaultKeywords returns the built in keywords checked for
+func DefaultKeywords() []Keyword {
+	return []Keyword{
+		{"/JavaScript", "Javascript content is an embedded script that can run when the document is opened"}, // "<<\n/EmbeddedFiles 243 0 R\n/JavaScript 251 0 R\n>>"
+		{"/AcroForm", "Active content use to build an editable form"},                                        // "<<\n/AcroForm 249 0 R\n/Metadata 245 0 R\n/Names 250 0 R\n/Outlines 176 0 R\n/
+		{"/JS", "Javascript aka 'JS' content is an embedded script that can run when the document is opened"},
+		{"/OpenAction", "An active action that is designed to run when the PDF is opened"},
+		{"/Launch", "An active action that is designed to run when the PDF is opened"},
+		{"/AA", "An active action 'AA' that is designed to run when the PDF is opened"},
 	}
+}
+
+// SetKeywords changes the keywords CheckForActiveContent looks for
+func SetKeywords(list []Keyword) {
+	keywordsMu.Lock()
+	defer keywordsMu.Unlock()
 
-	reader, err := getReader(filePath)
+	keywords = list
+}
+
+func getKeywords() []Keyword {
+	keywordsMu.RLock()
+	defer keywordsMu.RUnlock()
+
+	return keywords
+}
+
+// CheckForActiveContent looks through every object in the file for active content. If the
+// context is done part way through, what's been found so far is returned with the context's error
+func CheckForActiveContent(ctx context.Context, filePath string) (*ActiveContentResult, error) {
+	fd, size, err := openFile(filePath)
 
 	if err != nil {
-		return "❓Failed to complete checks", err
+		return nil, err
 	}
+	defer fd.Close()
 
-	info, err := pdf.SequentialScan(fd)
+	return CheckForActiveContentReader(ctx, fd, size)
+}
+
+// CheckForActiveContentReader is CheckForActiveContent for a PDF that's already open or in
+// memory. Objects past the limit in the context's budget aren't checked
+func CheckForActiveContentReader(ctx context.Context, r io.ReaderAt, size int64) (*ActiveContentResult, error) {
+	reader, err := getReader(r, size)
 
 	if err != nil {
-		return "❓Failed to complete checks", err
+		return nil, err
 	}
 
-	keywords := [][]string{
-		{"/JavaScript","Javascript content is an embedded script that can run when the document is opened"}, // "<<\n/EmbeddedFiles 243 0 R\n/JavaScript 251 0 R\n>>"
-		{"/AcroForm","Active content use to build an editable form"},   // "<<\n/AcroForm 249 0 R\n/Metadata 245 0 R\n/Names 250 0 R\n/Outlines 176 0 R\n/
-		{"/JS","Javascript aka 'JS' content is an embedded script that can run when the document is opened"},
-		{"/OpenAction","An active action that is designed to run when the PDF is opened"},
-		{"/Launch","An active action that is designed to run when the PDF is opened"},
-		{"/AA","An active action 'AA' that is designed to run when the PDF is opened"},
+	info, err := pdf.SequentialScan(io.NewSectionReader(r, 0, size))
+
+	if err != nil {
+		return nil, err
 	}
 
-	var result bytes.Buffer
-	counts := make([]int, len(keywords))
+	keywords := getKeywords()
+
+	var result ActiveContentResult
+	objects := make([][]int, len(keywords))
+
+	// set if we're stopped before checking every object
+	var stopErr error
+	textSize := 0
+	budget := limits.FromContext(ctx)
 
+sections:
 	for _, section := range info.Sections {
 		for _, fileObject := range section.Objects {
+			if err := ctx.Err(); err != nil {
+				stopErr = err
+				break sections
+			}
+
+			// the budget records why we stopped
+			if budget.AddObject(fmt.Sprintf("object %d", fileObject.Reference.Number())) != nil {
+				break sections
+			}
+
 			n := fileObject.Reference.Number()
 
 			if fileObject.Broken {
@@ -70,7 +168,8 @@ func CheckForActiveContent(filePath string) (string, error) {
 			object, err := reader.Get(fileObject.Reference, true)
 
 			if err != nil {
-				result.WriteString(fmt.Sprintf("❓Failed to get obj: %s", err.Error()))
+				result.Problems = append(result.Problems, fmt.Sprintf("Failed to get object %d: %s", n, err.Error()))
+				continue
 			}
 
 			var buf bytes.Buffer
@@ -78,33 +177,46 @@ func CheckForActiveContent(filePath string) (string, error) {
 			err = object.PDF(writer)
 
 			if err != nil {
-				result.WriteString(fmt.Sprintf("❓Failed to write obj: %s", err.Error()))
+				result.Problems = append(result.Problems, fmt.Sprintf("Failed to write object %d: %s", n, err.Error()))
 			}
 
 			// need to flush the writer to get the bytes in to the buffer
 			writer.Flush()
 			objectBody := buf.String()
 
+			// stream data is usually compressed, so only the dictionary is useful
+			text := objectBody
+
+			if index := strings.Index(text, "\nstream"); index >= 0 {
+				text = text[:index]
+			}
+
+			if strings.Contains(text, "(") && textSize+len(text) <= maxObjectText {
+				result.Objects = append(result.Objects, ObjectText{Number: int(n), Text: text})
+				textSize += len(text)
+			}
+
 			// the body header sits between << and >>, if present. E.g.:
 			// "<<\n/B 1709\n/Filter /FlateDecode\n/I 1733\n/L 1693\n/Length 1173\n/O 1297\n/S 613\n/V 1313\n>>\nstream\nx
-			if strings.Contains(objectBody, "<<") && strings.Contains(objectBody, ">>") {
+			// The >> has to come after the <<, as strings like "(a >> b << c)" are allowed
+			if start := strings.Index(objectBody, "<<"); start >= 0 && strings.Contains(objectBody[start:], ">>") {
 
-				header := objectBody[strings.Index(objectBody, "<<"):strings.Index(objectBody, ">>")]
+				header := objectBody[start : start+strings.Index(objectBody[start:], ">>")]
 
 				// replace ASCII hex equivalents
 				if strings.Contains(header, "#") {
 					header, err = replaceEscaped(header)
 
 					if err != nil {
-						result.WriteString(fmt.Sprintf("Error decoding body for object %d: %s\n", n, err.Error()))
+						result.Problems = append(result.Problems, fmt.Sprintf("Error decoding body for object %d: %s", n, err.Error()))
 					}
 				}
 
 				// Check all the known keywords
 				for i, keyword := range keywords {
-					if strings.Contains(header, keyword[0]) {
+					if strings.Contains(header, keyword.Keyword) {
 						//log.Printf("Found %q in object %d\n", keyword, n)
-						counts[i]++
+						objects[i] = append(objects[i], int(n))
 					}
 				}
 			}
@@ -112,13 +224,17 @@ func CheckForActiveContent(filePath string) (string, error) {
 		}
 	}
 
-	for i, c := range counts {
-		if c > 0 {
-			result.WriteString(fmt.Sprintf("☠️ Found %d references to the active content %q in the file's objects. %s.\n\n", c, keywords[i][0], keywords[i][1]))
+	for i, found := range objects {
+		if len(found) > 0 {
+			result.Found = append(result.Found, ActiveContent{
+				Keyword:     keywords[i].Keyword,
+				Description: keywords[i].Description,
+				Objects:     found,
+			})
 		}
 	}
 
-	return result.String(), nil
+	return &result, stopErr
 }
 
 // Decode ASCII hex obfuscation, e.g.
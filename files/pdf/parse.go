@@ -2,14 +2,26 @@ package pdf
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"seehuhn.de/go/pdf"
 )
 
 func GetMetadata(filePath string) (map[string]string, error) {
+	fd, size, err := openFile(filePath)
 
-	reader, err := getReader(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return GetMetadataReader(fd, size)
+}
+
+// GetMetadataReader is GetMetadata for a PDF that's already open or in memory
+func GetMetadataReader(r io.ReaderAt, size int64) (map[string]string, error) {
+	reader, err := getReader(r, size)
 
 	if err != nil {
 		return nil, err
@@ -63,18 +75,31 @@ func GetMetadata(filePath string) (map[string]string, error) {
 	return fields, nil
 }
 
-func getReader(filePath string) (*pdf.Reader, error) {
+// openFile opens the file and returns its size, for the reader based functions
+func openFile(filePath string) (*os.File, int64, error) {
 	fd, err := os.Open(filePath)
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	info, err := fd.Stat()
+
+	if err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+
+	return fd, info.Size(), nil
+}
+
+func getReader(data io.ReaderAt, size int64) (*pdf.Reader, error) {
 	opt := &pdf.ReaderOptions{
 		ErrorHandling: pdf.ErrorHandlingReport,
 	}
 
-	r, err := pdf.NewReader(fd, opt)
+	// each reader gets its own section so they don't share a read position
+	r, err := pdf.NewReader(io.NewSectionReader(data, 0, size), opt)
 
 	if err != nil {
 		return nil, err
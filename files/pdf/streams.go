@@ -0,0 +1,79 @@
+package pdf
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"seehuhn.de/go/pdf"
+
+	"file-inspector/utils/limits"
+)
+
+// streams bigger than this once decoded are cut off, so a compression bomb can't use up all
+// the memory. Everything decoded also counts towards the file's budget, see limits.Budget
+const maxStreamSize = 16 * 1024 * 1024
+
+// ReadStreams decodes every stream in the file and passes it to fn with its object number.
+// Streams that can't be decoded are skipped, as are those past the limits in the context's
+// budget. If the context is done part way through, the context's error is returned
+func ReadStreams(ctx context.Context, r io.ReaderAt, size int64, fn func(object int, data []byte)) error {
+	reader, err := getReader(r, size)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := pdf.SequentialScan(io.NewSectionReader(r, 0, size))
+
+	if err != nil {
+		return err
+	}
+
+	budget := limits.FromContext(ctx)
+
+	for _, section := range info.Sections {
+		for _, fileObject := range section.Objects {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// reading the object again counts too, so this pass can't go past what's left
+			// of the budget after the active content check and any attachments
+			if budget.AddObject(fmt.Sprintf("object %d", fileObject.Reference.Number())) != nil {
+				return nil
+			}
+
+			if fileObject.Broken {
+				continue
+			}
+
+			object, err := reader.Get(fileObject.Reference, true)
+
+			if err != nil {
+				continue
+			}
+
+			stream, ok := object.(*pdf.Stream)
+
+			if !ok {
+				continue
+			}
+
+			decoded, err := pdf.DecodeStream(reader, stream, 0)
+
+			if err != nil {
+				continue
+			}
+
+			// keep what decoded before any error, as broken streams are common in malicious files
+			data, _ := budget.ReadAll(decoded, maxStreamSize, fmt.Sprintf("object %d", fileObject.Reference.Number()))
+
+			if len(data) > 0 {
+				fn(int(fileObject.Reference.Number()), data)
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,35 +1,177 @@
 package files
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"path"
+	"sync"
+
+	"github.com/dustin/go-humanize"
 
 	"file-inspector/files/details"
+	"file-inspector/files/findings"
+	"file-inspector/files/hashing"
+	"file-inspector/files/ioc"
+	"file-inspector/files/verdict"
 )
 
 const (
-	emlMimeType  = "text/plain; charset=utf-8"
-	msgMimeType  = "application/vnd.ms-outlook"
-	pdfMimeType  = "application/pdf"
-	docxMimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	emlMimeType    = "text/plain; charset=utf-8"
+	emlAltMimeType = "message/rfc822"
+	msgMimeType    = "application/vnd.ms-outlook"
+	pdfMimeType    = "application/pdf"
+	docxMimeType   = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
 )
 
 type FileProperties struct {
 	FileName string
-	Hash     string
 	FileType string
 	Size     string
+
+	// Hash is the SHA-256 hash, the one used to identify the file everywhere else
+	Hash   string
+	MD5    string
+	SHA1   string
+	SHA512 string
+
+	// SSDeep and TLSH are fuzzy hashes, for finding similar files. TLSH is empty for
+	// files too small or uniform to hash
+	SSDeep string
+	TLSH   string
+
+	// SizeBytes is the size as a number, where Size is for display, e.g. "1.2 kB"
+	SizeBytes int64
 }
 
 type ProcessResult struct {
 	FilePath  string
+	Name      string
+	MimeType  string
+	MD5       string
+	SHA1      string
+	SHA256    string
+	SHA512    string
+	SSDeep    string
+	TLSH      string
 	Error     error
 	Parsed    bool
 	Completed bool
 	Dangerous bool
 	Metadata  [][]string
+	Findings  []findings.Finding
 	Analysis  string
+
+	// TimedOut is true if the analysis ran out of time, so the result is partial
+	TimedOut bool
+
+	// LimitExceeded is true if the file reached a resource limit, e.g. it's a zip bomb, so
+	// the result is partial. See limits.Limits
+	LimitExceeded bool
+
+	// Assessment is the weighted score and verdict for the findings
+	Assessment *verdict.Assessment
+
+	// Children are the results for any attachments, in the order they appear
+	Children []*ProcessResult
+
+	// IOCs are the indicators found in the file and its attachments, set by Summarise
+	IOCs []ioc.IOC
+
+	iocs ioc.Set
+
+	// data is the content of an attachment, only kept if asked for with WithAttachmentData
+	data []byte
+}
+
+var (
+	// used to score findings, can be replaced with SetVerdictConfig
+	verdictMu     sync.RWMutex
+	verdictConfig = verdict.DefaultConfig()
+)
+
+var (
+	severityMu        sync.RWMutex
+	severityOverrides findings.Overrides
+)
+
+// SetSeverityOverrides changes the severity of findings from now on, e.g. to make
+// links to uncommon domains high for a team that never gets them. Nil removes them
+func SetSeverityOverrides(overrides findings.Overrides) {
+	severityMu.Lock()
+	defer severityMu.Unlock()
+
+	severityOverrides = overrides
+}
+
+func getSeverityOverrides() findings.Overrides {
+	severityMu.RLock()
+	defer severityMu.RUnlock()
+
+	return severityOverrides
+}
+
+// SetVerdictConfig changes the weights and thresholds used to score results
+func SetVerdictConfig(config *verdict.Config) {
+	verdictMu.Lock()
+	defer verdictMu.Unlock()
+
+	verdictConfig = config
+}
+
+func getVerdictConfig() *verdict.Config {
+	verdictMu.RLock()
+	defer verdictMu.RUnlock()
+
+	return verdictConfig
+}
+
+// AddFinding adds a finding to the result, with its severity changed if it's been
+// overridden, see SetSeverityOverrides
+func (r *ProcessResult) AddFinding(f findings.Finding) {
+	f.Severity = getSeverityOverrides().SeverityFor(f)
+	r.Findings = append(r.Findings, f)
+}
+
+// ExtractIOCs finds the indicators of compromise in the text, e.g. "body" or "object 4"
+func (r *ProcessResult) ExtractIOCs(text, source string) {
+	r.iocs.Extract(text, source)
+}
+
+// AddIOC adds an indicator that's already known, e.g. an attachment's name
+func (r *ProcessResult) AddIOC(t ioc.Type, value, source string) {
+	r.iocs.Add(t, value, source)
+}
+
+// Summarise scores the findings, renders the analysis text from them and flags the
+// result as dangerous if the verdict is Malicious or any attachment is dangerous
+func (r *ProcessResult) Summarise() {
+	r.Assessment = getVerdictConfig().Assess(r.Findings)
+	r.IOCs = r.iocs.List()
+
+	for _, child := range r.Children {
+		if child.Dangerous {
+			r.Dangerous = true
+		}
+	}
+
+	if len(r.Findings) == 0 {
+		return
+	}
+
+	r.Analysis = r.Assessment.Render() + findings.Render(r.Findings)
+
+	if len(r.Children) > 0 {
+		r.Analysis += renderAttachmentTree(r.Children)
+	}
+
+	if r.Assessment.Verdict == verdict.Malicious {
+		r.Dangerous = true
+	}
 }
 
 func GetFileProperties(filePath string) (*FileProperties, error) {
@@ -46,62 +188,237 @@ func GetFileProperties(filePath string) (*FileProperties, error) {
 	} else {
 		props.FileType = details.Mimetype
 		props.Hash = details.SHA256
+		props.MD5 = details.MD5
+		props.SHA1 = details.SHA1
+		props.SHA512 = details.SHA512
+		props.SSDeep = details.SSDeep
+		props.TLSH = details.TLSH
 		props.Size = details.SizeString
+		props.SizeBytes = details.Size
 	}
 
 	return &props, nil
 }
 
-func CheckMime(extension, mime string) (bool, string) {
-	switch extension {
-	case ".msg":
-		if mime != msgMimeType {
-			return false, fmt.Sprintf("☠️ We expect %q for files with .msg extensions, but found %q.", msgMimeType, mime)
-		}
-	case ".eml":
-		if mime != emlMimeType {
-			return false, fmt.Sprintf("☠️ We expect %q for files with .eml extensions, but found %q.", emlMimeType, mime)
-		}
-	case ".pdf":
-		if mime != pdfMimeType {
-			return false, fmt.Sprintf("☠️ We expect %q for files with .pdf extensions, but found %q.", pdfMimeType, mime)
-		}
-	case ".docx":
-		if mime != docxMimeType {
-			return false, fmt.Sprintf("☠️ We expect %q for files with .docx extensions, but found %q.", docxMimeType, mime)
-		}
+// GetResultProperties returns the file's properties using the type and hashes found when
+// it was processed, so it isn't read and hashed a second time
+func GetResultProperties(filePath string, result *ProcessResult) (*FileProperties, error) {
+	// nothing was hashed, e.g. the context was done first
+	if result.SHA256 == "" {
+		return GetFileProperties(filePath)
+	}
+
+	info, err := os.Stat(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	props := FileProperties{
+		FileName:  filePath,
+		FileType:  result.MimeType,
+		Hash:      result.SHA256,
+		MD5:       result.MD5,
+		SHA1:      result.SHA1,
+		SHA512:    result.SHA512,
+		SSDeep:    result.SSDeep,
+		TLSH:      result.TLSH,
+		Size:      humanize.Bytes(uint64(info.Size())),
+		SizeBytes: info.Size(),
 	}
 
-	return true, ""
+	return &props, nil
 }
 
+// ProcessFile works out what the file really is from its content, flags it if the
+// extension says otherwise, then processes it with the analyzer for that type
 func ProcessFile(filePath string) *ProcessResult {
+	return ProcessFileContext(context.Background(), filePath)
+}
+
+// ProcessFileContext is ProcessFile, stopping early with a partial result if the context is done
+func ProcessFileContext(ctx context.Context, filePath string) *ProcessResult {
+	return processFile(ctx, filePath, nil)
+}
+
+// ProcessDetectedFile is ProcessFileContext for a file whose type is already known from
+// DetectType, e.g. when choosing which files in a folder to process, so it isn't sniffed again
+func ProcessDetectedFile(ctx context.Context, filePath string, detected *Detection) *ProcessResult {
+	return processFile(ctx, filePath, detected)
+}
+
+// ProcessReader is ProcessFileContext for content that's already open or in memory. The
+// name is used in place of the file name, e.g. for checking the extension
+func ProcessReader(ctx context.Context, name string, r io.ReaderAt, size int64) *ProcessResult {
+	return processReader(ctx, name, r, size, 0)
+}
+
+// ProcessBytes is ProcessReader for content in memory, so it never needs to be written to disk
+func ProcessBytes(ctx context.Context, name string, data []byte) *ProcessResult {
+	return processBytes(ctx, name, data, 0)
+}
+
+// processFile processes the file, detecting its type unless it's given
+func processFile(ctx context.Context, filePath string, detected *Detection) *ProcessResult {
 	log.Printf("Processing file %q\n", filePath)
-	fileExt := path.Ext(filePath)
-
-	res := ProcessResult{
-		FilePath: filePath,
-		Error:    nil,
-	}
-
-	switch fileExt {
-	case ".msg":
-		log.Println("Parsing email file")
-		processMsgFile(&res)
-	case ".eml":
-		log.Println("Parsing email file")
-		processEmlFile(&res)
-	case ".pdf":
-		log.Println("Parsing PDF file")
-		processPDFFile(&res)
-	case ".docx":
-		log.Println("Parsing document file")
-		processDocxFile(&res)
-	default:
+
+	f, err := os.Open(filePath)
+
+	if err != nil {
+		return &ProcessResult{FilePath: filePath, Name: path.Base(filePath), Error: err}
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return &ProcessResult{FilePath: filePath, Name: path.Base(filePath), Error: err}
+	}
+
+	input := Input{
+		FilePath:  filePath,
+		Name:      path.Base(filePath),
+		Extension: path.Ext(filePath),
+		Reader:    f,
+		Size:      info.Size(),
+		detected:  detected,
+	}
+
+	return processInput(ctx, &input)
+}
+
+func processReader(ctx context.Context, name string, r io.ReaderAt, size int64, depth int) *ProcessResult {
+	log.Printf("Processing %q from memory\n", name)
+
+	input := Input{
+		Name:      name,
+		Extension: path.Ext(name),
+		Reader:    r,
+		Size:      size,
+		Depth:     depth,
+	}
+
+	return processInput(ctx, &input)
+}
+
+func processBytes(ctx context.Context, name string, data []byte, depth int) *ProcessResult {
+	return processReader(ctx, name, bytes.NewReader(data), int64(len(data)), depth)
+}
+
+// processInput works out what the input really is and runs it through the analyzer for that type
+func processInput(ctx context.Context, input *Input) *ProcessResult {
+	res := &ProcessResult{
+		FilePath: input.FilePath,
+		Name:     input.Name,
+	}
+
+	if err := ctx.Err(); err != nil {
+		res.Error = err
+		return res
+	}
+
+	ctx, budget := withBudget(ctx, input)
+
+	detected := input.detected
+
+	if detected == nil {
+		var err error
+		detected, err = DetectReaderType(input.Reader)
+
+		if err != nil {
+			res.Error = fmt.Errorf("error reading the start of %q: %s", input.Name, err.Error())
+			return res
+		}
+	}
+
+	res.MimeType = detected.MimeType
+	input.MimeType = detected.MimeType
+
+	// flag it if the extension doesn't match the content, but carry on with the real type
+	if finding := checkExtension(input.Extension, detected); finding != nil {
+		res.AddFinding(*finding)
+	}
+
+	hashes := hashInput(input)
+	res.setHashes(hashes)
+
+	// scan everything, including types we can't analyse like executables
+	checkHashLists(hashes, res)
+	scanInputWithRules(ctx, input, res)
+
+	if detected.Analyzer == nil {
+		res.Error = fmt.Errorf("%w: %q", ErrUnsupportedFileType, detected.MimeType)
+		res.addLimitFindings(budget)
+		res.Summarise()
+		return res
+	}
+
+	if !AnalyzerEnabled(detected.Analyzer.Name()) {
+		res.Error = fmt.Errorf("%w: the %s analyzer is turned off", ErrUnsupportedFileType, detected.Analyzer.Name())
+		res.addLimitFindings(budget)
+		res.Summarise()
+		return res
+	}
+
+	// hashed and scanned, but too big to parse
+	if err := budget.CheckInputSize(input.Size, input.Name); err != nil {
+		res.Error = err
+		res.addLimitFindings(budget)
+		res.Summarise()
+		return res
+	}
+
+	log.Printf("Parsing file with the %s analyzer\n", detected.Analyzer.Name())
+	analyzed, err := runAnalyzer(ctx, detected.Analyzer, input)
+
+	if analyzed != nil {
+		analyzed.Findings = append(res.Findings, analyzed.Findings...)
+		res = analyzed
+	}
+
+	res.FilePath = input.FilePath
+	res.Name = input.Name
+	res.MimeType = detected.MimeType
+	res.setHashes(hashes)
+
+	if err != nil {
 		res.Completed = false
-		res.Error = fmt.Errorf("unknown file extension %q", fileExt)
-		return &res
+		res.Error = err
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			res.markTimedOut()
+		} else if errors.Is(err, ErrAnalyzerPanic) {
+			res.markCrashed()
+		}
+	}
+
+	res.addLimitFindings(budget)
+	res.Summarise()
+
+	return res
+}
+
+// hashInput hashes the whole input, returning nil if it can't be read
+func hashInput(input *Input) *hashing.Hashes {
+	hashes, err := hashing.GetReaderHashes(io.NewSectionReader(input.Reader, 0, input.Size))
+
+	if err != nil {
+		log.Printf("Error hashing %q: %s\n", input.Name, err.Error())
+		return nil
+	}
+
+	return hashes
+}
+
+func (r *ProcessResult) setHashes(hashes *hashing.Hashes) {
+	if hashes == nil {
+		return
 	}
 
-	return &res
+	r.MD5 = hashes.MD5
+	r.SHA1 = hashes.SHA1
+	r.SHA256 = hashes.SHA256
+	r.SHA512 = hashes.SHA512
+	r.SSDeep = hashes.SSDeep
+	r.TLSH = hashes.TLSH
 }
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"html/template"
+	"io"
+	"strings"
+
+	"file-inspector/files/findings"
+	"file-inspector/files/verdict"
+)
+
+// everything is inline so the report is a single file that can be attached to a ticket
+const htmlTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="Content-Security-Policy" content="default-src 'none'; style-src 'unsafe-inline'">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h1 { font-size: 1.5em; border-bottom: 2px solid #ccc; padding-bottom: 0.3em; }
+h2, h3, h4 { margin-top: 1.5em; }
+table { border-collapse: collapse; margin: 0.5em 0 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; word-break: break-all; }
+th { background: #f4f4f4; }
+code { font-family: Consolas, Menlo, monospace; }
+.verdict { display: inline-block; padding: 0.2em 0.6em; border-radius: 0.3em; color: #fff; font-weight: bold; }
+.verdict-Clean { background: #2e7d32; }
+.verdict-Suspicious { background: #ef6c00; }
+.verdict-Malicious { background: #c62828; }
+.verdict- { background: #757575; }
+.warning { border-left: 4px solid #c62828; background: #fdecea; padding: 0.5em 1em; }
+.finding { margin: 0.5em 0 0.8em; }
+.severity { font-weight: bold; text-transform: uppercase; font-size: 0.8em; }
+.severity-info { color: #1565c0; }
+.severity-low { color: #558b2f; }
+.severity-medium { color: #ef6c00; }
+.severity-high, .severity-critical { color: #c62828; }
+.detail { margin-left: 1.5em; color: #444; word-break: break-all; }
+.attachment { border-left: 3px solid #ccc; padding-left: 1em; margin-left: 0.5em; }
+footer { margin-top: 3em; font-size: 0.8em; color: #777; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>File Name</th><td>{{.File.Name}}</td></tr>
+{{- if .File.Path}}
+<tr><th>Path</th><td>{{.File.Path}}</td></tr>
+{{- end}}
+<tr><th>File Type</th><td>{{.File.MimeType}}</td></tr>
+<tr><th>File Size</th><td>{{.File.Size}}</td></tr>
+<tr><th>SHA256 Hash</th><td><code>{{.File.SHA256}}</code></td></tr>
+{{- if .File.MD5}}
+<tr><th>MD5 Hash</th><td><code>{{.File.MD5}}</code></td></tr>
+<tr><th>SHA1 Hash</th><td><code>{{.File.SHA1}}</code></td></tr>
+<tr><th>SHA512 Hash</th><td><code>{{.File.SHA512}}</code></td></tr>
+{{- end}}
+{{- if .File.SSDeep}}
+<tr><th>SSDeep</th><td><code>{{.File.SSDeep}}</code></td></tr>
+{{- end}}
+{{- if .File.TLSH}}
+<tr><th>TLSH</th><td><code>{{.File.TLSH}}</code></td></tr>
+{{- end}}
+</table>
+{{template "result" .Result}}
+{{- if .IOCs}}
+<h2>Indicators of Compromise</h2>
+<table>
+<tr><th>Type</th><th>Value</th><th>Found In</th></tr>
+{{- range .IOCs}}
+<tr><td>{{.Type.Title}}</td><td><code>{{.Value}}</code></td><td>{{join .Sources ", "}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<footer>Generated by {{.Generator}} at {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}</footer>
+</body>
+</html>
+
+{{define "result"}}
+<h2><span class="verdict verdict-{{.Verdict}}">{{.Summary}}</span></h2>
+{{- if .TimedOut}}
+<p class="warning">The analysis timed out, so these results are incomplete.</p>
+{{- else if .LimitExceeded}}
+<p class="warning">The file reached a resource limit, so these results are incomplete.</p>
+{{- else if .Error}}
+<p class="warning">Error: {{.Error}}</p>
+{{- end}}
+{{- if .Signals}}
+<table>
+<tr><th>Weight</th><th>Signal</th></tr>
+{{- range .Signals}}
+<tr><td>{{printf "%+d" .Weight}}</td><td>{{.Title}}{{if gt .Count 1}} (x{{.Count}}){{end}}</td></tr>
+{{- end}}
+<tr><th>Score</th><th>{{.Score}}/{{maxScore}}</th></tr>
+</table>
+{{- end}}
+{{- if .Metadata}}
+<h3>Metadata</h3>
+<table>
+<tr><th>Field</th><th>Value</th></tr>
+{{- range .Metadata}}
+<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Findings}}
+<h3>Findings</h3>
+{{- range groupFindings .Findings}}
+<h4>{{.Category.Title}}</h4>
+{{- range .Findings}}
+<div class="finding">
+<span class="severity severity-{{.Severity}}">{{.Severity}}</span> {{.Title}}
+{{- if .Evidence}}<div class="detail">{{.Evidence}}</div>{{end}}
+{{- if not .Location.IsZero}}<div class="detail">Location: {{.Location.String}}</div>{{end}}
+{{- if .Remediation}}<div class="detail">Remediation: {{.Remediation}}</div>{{end}}
+</div>
+{{- end}}
+{{- end}}
+{{- end}}
+{{- range $i, $attachment := .Attachments}}
+<div class="attachment">
+<h3>Attachment {{inc $i}}: {{$attachment.Name}}</h3>
+{{- if $attachment.SHA256}}
+<p>Type: {{$attachment.MimeType}}, SHA256: <code>{{$attachment.SHA256}}</code></p>
+{{- end}}
+{{template "result" $attachment}}
+</div>
+{{- end}}
+{{end}}
+`
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"groupFindings": findings.GroupByCategory,
+	"maxScore":      func() int { return verdict.MaxScore },
+	"inc":           func(i int) int { return i + 1 },
+	"join":          strings.Join,
+}).Parse(htmlTemplate))
+
+// WriteHTML writes the report as a self-contained HTML page. Everything from the file is
+// escaped, and the page's security policy stops anything being loaded or run
+func (d *Document) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, d)
+}
@@ -0,0 +1,175 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"file-inspector/files/findings"
+	"file-inspector/files/verdict"
+)
+
+// WriteMarkdown writes the report as Markdown, for pasting into tickets
+func (d *Document) WriteMarkdown(w io.Writer) error {
+	var builder strings.Builder
+
+	builder.WriteString(fmt.Sprintf("# %s\n\n", escapeMarkdown(d.Title())))
+	builder.WriteString(fmt.Sprintf("Generated by %s at %s\n\n", d.Generator, d.GeneratedAt.Format("2006-01-02 15:04:05 MST")))
+
+	builder.WriteString("## File\n\n")
+	builder.WriteString("| Property | Value |\n|---|---|\n")
+	writeMarkdownRow(&builder, "File Name", d.File.Name)
+
+	if d.File.Path != "" {
+		writeMarkdownRow(&builder, "Path", d.File.Path)
+	}
+
+	writeMarkdownRow(&builder, "File Type", d.File.MimeType)
+	writeMarkdownRow(&builder, "File Size", d.File.Size)
+	writeMarkdownRow(&builder, "SHA256 Hash", "`"+d.File.SHA256+"`")
+
+	if d.File.MD5 != "" {
+		writeMarkdownRow(&builder, "MD5 Hash", "`"+d.File.MD5+"`")
+		writeMarkdownRow(&builder, "SHA1 Hash", "`"+d.File.SHA1+"`")
+		writeMarkdownRow(&builder, "SHA512 Hash", "`"+d.File.SHA512+"`")
+	}
+
+	if d.File.SSDeep != "" {
+		writeMarkdownRow(&builder, "SSDeep", "`"+d.File.SSDeep+"`")
+	}
+
+	if d.File.TLSH != "" {
+		writeMarkdownRow(&builder, "TLSH", "`"+d.File.TLSH+"`")
+	}
+
+	builder.WriteString("\n")
+
+	writeMarkdownResult(&builder, d.Result, 2)
+
+	if len(d.IOCs) > 0 {
+		builder.WriteString("## Indicators of Compromise\n\n")
+		builder.WriteString("| Type | Value | Found In |\n|---|---|---|\n")
+
+		for _, found := range d.IOCs {
+			value := "`" + strings.ReplaceAll(found.Value, "`", "'") + "`"
+			builder.WriteString(fmt.Sprintf("| %s | %s | %s |\n", escapeMarkdownCell(found.Type.Title()),
+				escapeMarkdownCell(value), escapeMarkdownCell(strings.Join(found.Sources, ", "))))
+		}
+
+		builder.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// writeMarkdownResult writes the result, with its attachments as sub sections
+func writeMarkdownResult(builder *strings.Builder, result *Result, level int) {
+	heading := strings.Repeat("#", min(level, 6))
+
+	builder.WriteString(fmt.Sprintf("%s Verdict: %s\n\n", heading, escapeMarkdown(result.Summary)))
+
+	if result.TimedOut {
+		builder.WriteString("> **The analysis timed out, so these results are incomplete.**\n\n")
+	} else if result.LimitExceeded {
+		builder.WriteString("> **The file reached a resource limit, so these results are incomplete.**\n\n")
+	} else if result.Error != "" {
+		builder.WriteString(fmt.Sprintf("> **Error:** %s\n\n", escapeMarkdown(result.Error)))
+	}
+
+	if len(result.Signals) > 0 {
+		builder.WriteString("| Weight | Signal |\n|---|---|\n")
+
+		for _, signal := range result.Signals {
+			title := signal.Title
+
+			if signal.Count > 1 {
+				title = fmt.Sprintf("%s (x%d)", title, signal.Count)
+			}
+
+			writeMarkdownRow(builder, fmt.Sprintf("%+d", signal.Weight), title)
+		}
+
+		builder.WriteString(fmt.Sprintf("\nScore: **%d/%d**\n\n", result.Score, verdict.MaxScore))
+	}
+
+	if len(result.Metadata) > 0 {
+		builder.WriteString(fmt.Sprintf("%s# Metadata\n\n", heading))
+		builder.WriteString("| Field | Value |\n|---|---|\n")
+
+		for _, field := range result.Metadata {
+			writeMarkdownRow(builder, field.Name, field.Value)
+		}
+
+		builder.WriteString("\n")
+	}
+
+	if len(result.Findings) > 0 {
+		builder.WriteString(fmt.Sprintf("%s# Findings\n\n", heading))
+
+		for _, group := range findings.GroupByCategory(result.Findings) {
+			builder.WriteString(fmt.Sprintf("**%s**\n\n", group.Category.Title()))
+
+			for _, f := range group.Findings {
+				builder.WriteString(fmt.Sprintf("- %s **[%s]** %s\n", f.Severity.Icon(), f.Severity, escapeMarkdown(f.Title)))
+
+				if f.Evidence != "" {
+					builder.WriteString(fmt.Sprintf("  - Evidence: %s\n", escapeMarkdown(f.Evidence)))
+				}
+
+				if !f.Location.IsZero() {
+					builder.WriteString(fmt.Sprintf("  - Location: %s\n", escapeMarkdown(f.Location.String())))
+				}
+
+				if f.Remediation != "" {
+					builder.WriteString(fmt.Sprintf("  - Remediation: %s\n", escapeMarkdown(f.Remediation)))
+				}
+			}
+
+			builder.WriteString("\n")
+		}
+	}
+
+	for i, attachment := range result.Attachments {
+		builder.WriteString(fmt.Sprintf("%s# Attachment %d: %s\n\n", heading, i+1, escapeMarkdown(attachment.Name)))
+
+		if attachment.SHA256 != "" {
+			builder.WriteString(fmt.Sprintf("Type: %s, SHA256: `%s`\n\n", escapeMarkdown(attachment.MimeType), attachment.SHA256))
+		}
+
+		writeMarkdownResult(builder, attachment, level+2)
+	}
+}
+
+func writeMarkdownRow(builder *strings.Builder, name, value string) {
+	builder.WriteString(fmt.Sprintf("| %s | %s |\n", escapeMarkdownCell(name), escapeMarkdownCell(value)))
+}
+
+// evidence is untrusted, so stop it being rendered as formatting or HTML
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", `&lt;`,
+	">", `&gt;`,
+	"#", `\#`,
+	"|", `\|`,
+	"\r", "",
+	"\n", " ",
+)
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// escapes text for a table cell, leaving inline code alone
+func escapeMarkdownCell(text string) string {
+	if strings.HasPrefix(text, "`") && strings.HasSuffix(text, "`") && len(text) > 1 && !strings.Contains(text[1:len(text)-1], "`") {
+		return strings.ReplaceAll(text, "|", `\|`)
+	}
+
+	return escapeMarkdown(text)
+}
@@ -0,0 +1,277 @@
+// Package report exports analysis results as HTML, Markdown or JSON documents, e.g. to
+// attach as evidence to an incident ticket
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/findings"
+	"file-inspector/files/ioc"
+	"file-inspector/files/misp"
+	"file-inspector/files/stix"
+	"file-inspector/files/verdict"
+)
+
+const (
+	// SchemaVersion is the version of the JSON document. It only changes if fields are
+	// removed or change meaning, new fields can be added without a new version
+	SchemaVersion = 1
+
+	generator = "File-Inspector"
+)
+
+// Format is a type of report
+type Format string
+
+const (
+	FormatHTML     Format = "html"
+	FormatMarkdown Format = "markdown"
+	FormatJSON     Format = "json"
+
+	// FormatSTIX is a STIX 2.1 bundle, for threat intelligence platforms
+	FormatSTIX Format = "stix"
+
+	// FormatMISP is a MISP event, for importing into MISP
+	FormatMISP Format = "misp"
+)
+
+var formatExtensions = map[Format]string{
+	FormatHTML:     ".html",
+	FormatMarkdown: ".md",
+	FormatJSON:     ".json",
+	FormatSTIX:     ".stix.json",
+	FormatMISP:     ".misp.json",
+}
+
+// Extension returns the file extension for the format, including the dot
+func (f Format) Extension() string {
+	return formatExtensions[f]
+}
+
+// FormatForFileName returns the format for a file name, e.g. "report.stix.json"
+func FormatForFileName(name string) (Format, error) {
+	for _, format := range []Format{FormatSTIX, FormatMISP} {
+		if strings.HasSuffix(strings.ToLower(name), format.Extension()) {
+			return format, nil
+		}
+	}
+
+	return FormatForExtension(path.Ext(name))
+}
+
+// FormatForExtension returns the format for a file extension, e.g. ".md"
+func FormatForExtension(extension string) (Format, error) {
+	extension = strings.ToLower(extension)
+
+	if extension == ".htm" {
+		return FormatHTML, nil
+	}
+
+	for format, ext := range formatExtensions {
+		if ext == extension && format != FormatSTIX && format != FormatMISP {
+			return format, nil
+		}
+	}
+
+	return "", fmt.Errorf("no report format for extension %q, use .html, .md, .json, .stix.json or .misp.json", extension)
+}
+
+// Document is a report on a single file
+type Document struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Generator     string    `json:"generator"`
+	GeneratedAt   time.Time `json:"generatedAt"`
+	File          File      `json:"file"`
+	Result        *Result   `json:"result"`
+
+	// IOCs are the indicators found in the file, including those in its attachments
+	IOCs []ioc.IOC `json:"iocs"`
+
+	// kept for the formats that are built straight from the analysis
+	properties *files.FileProperties
+	result     *files.ProcessResult
+}
+
+// File holds the properties of the file that was analysed
+type File struct {
+	Name     string `json:"name"`
+	Path     string `json:"path,omitempty"`
+	MimeType string `json:"mimeType"`
+	Size     string `json:"size"`
+	MD5      string `json:"md5,omitempty"`
+	SHA1     string `json:"sha1,omitempty"`
+	SHA256   string `json:"sha256"`
+	SHA512   string `json:"sha512,omitempty"`
+	SSDeep   string `json:"ssdeep,omitempty"`
+	TLSH     string `json:"tlsh,omitempty"`
+}
+
+// Field is a single metadata field
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Result is the outcome of analysing a file or one of its attachments
+type Result struct {
+	Name          string             `json:"name"`
+	MimeType      string             `json:"mimeType,omitempty"`
+	MD5           string             `json:"md5,omitempty"`
+	SHA1          string             `json:"sha1,omitempty"`
+	SHA256        string             `json:"sha256,omitempty"`
+	SHA512        string             `json:"sha512,omitempty"`
+	SSDeep        string             `json:"ssdeep,omitempty"`
+	TLSH          string             `json:"tlsh,omitempty"`
+	Parsed        bool               `json:"parsed"`
+	Completed     bool               `json:"completed"`
+	TimedOut      bool               `json:"timedOut"`
+	LimitExceeded bool               `json:"limitExceeded"`
+	Dangerous     bool               `json:"dangerous"`
+	Score         int                `json:"score"`
+	Verdict       string             `json:"verdict,omitempty"`
+	Summary       string             `json:"summary"`
+	Error         string             `json:"error,omitempty"`
+	Signals       []verdict.Signal   `json:"signals"`
+	Metadata      []Field            `json:"metadata"`
+	Findings      []findings.Finding `json:"findings"`
+
+	// Attachments are the results for each attachment, nested as they are in the file
+	Attachments []*Result `json:"attachments"`
+}
+
+// New builds a report from the file's properties and analysis
+func New(properties *files.FileProperties, result *files.ProcessResult) *Document {
+	doc := Document{
+		SchemaVersion: SchemaVersion,
+		Generator:     generator,
+		GeneratedAt:   time.Now().UTC().Truncate(time.Second),
+		Result:        newResult(result),
+		IOCs:          append([]ioc.IOC{}, result.IOCs...),
+		properties:    properties,
+		result:        result,
+	}
+
+	doc.File = File{
+		Name:     result.Name,
+		Path:     result.FilePath,
+		MimeType: result.MimeType,
+		MD5:      result.MD5,
+		SHA1:     result.SHA1,
+		SHA256:   result.SHA256,
+		SHA512:   result.SHA512,
+		SSDeep:   result.SSDeep,
+		TLSH:     result.TLSH,
+	}
+
+	if properties != nil {
+		doc.File.MimeType = properties.FileType
+		doc.File.Size = properties.Size
+		doc.File.MD5 = properties.MD5
+		doc.File.SHA1 = properties.SHA1
+		doc.File.SHA256 = properties.Hash
+		doc.File.SHA512 = properties.SHA512
+		doc.File.SSDeep = properties.SSDeep
+		doc.File.TLSH = properties.TLSH
+	}
+
+	return &doc
+}
+
+func newResult(result *files.ProcessResult) *Result {
+	r := Result{
+		Name:          result.Name,
+		MimeType:      result.MimeType,
+		MD5:           result.MD5,
+		SHA1:          result.SHA1,
+		SHA256:        result.SHA256,
+		SHA512:        result.SHA512,
+		SSDeep:        result.SSDeep,
+		TLSH:          result.TLSH,
+		Parsed:        result.Parsed,
+		Completed:     result.Completed,
+		TimedOut:      result.TimedOut,
+		LimitExceeded: result.LimitExceeded,
+		Dangerous:     result.Dangerous,
+		Summary:       result.Summary(),
+
+		// empty rather than null, so the schema is the same for every file
+		Signals:     []verdict.Signal{},
+		Metadata:    []Field{},
+		Findings:    []findings.Finding{},
+		Attachments: []*Result{},
+	}
+
+	if result.Assessment != nil {
+		r.Score = result.Assessment.Score
+		r.Verdict = string(result.Assessment.Verdict)
+		r.Signals = append(r.Signals, result.Assessment.Signals...)
+	}
+
+	if result.Error != nil {
+		r.Error = result.Error.Error()
+	}
+
+	for _, row := range result.Metadata {
+		if len(row) == 2 {
+			r.Metadata = append(r.Metadata, Field{Name: row[0], Value: row[1]})
+		}
+	}
+
+	r.Findings = append(r.Findings, result.Findings...)
+
+	for _, child := range result.Children {
+		r.Attachments = append(r.Attachments, newResult(child))
+	}
+
+	return &r
+}
+
+// Write writes the report in the format
+func (d *Document) Write(w io.Writer, format Format) error {
+	switch format {
+	case FormatHTML:
+		return d.WriteHTML(w)
+	case FormatMarkdown:
+		return d.WriteMarkdown(w)
+	case FormatJSON:
+		return d.WriteJSON(w)
+	case FormatSTIX:
+		return d.WriteSTIX(w)
+	case FormatMISP:
+		return d.WriteMISP(w)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// WriteJSON writes the report as an indented JSON document
+func (d *Document) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+// WriteSTIX writes the analysis as a STIX 2.1 bundle
+func (d *Document) WriteSTIX(w io.Writer) error {
+	bundle := stix.NewBundle()
+	bundle.Add(d.properties, d.result)
+	return bundle.Write(w)
+}
+
+// WriteMISP writes the analysis as a MISP event
+func (d *Document) WriteMISP(w io.Writer) error {
+	event := misp.NewEvent()
+	event.Add(d.properties, d.result)
+	return event.Write(w)
+}
+
+// Title returns the heading for the report
+func (d *Document) Title() string {
+	return fmt.Sprintf("File Analysis Report: %s", d.File.Name)
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"file-inspector/files/findings"
+	"file-inspector/files/yara"
+	"file-inspector/utils/limits"
+)
+
+const (
+	// only this much of a file is scanned with the rules
+	maxRuleScanSize = 64 * 1024 * 1024
+
+	// how many string matches are shown in a finding's evidence
+	maxMatchesShown = 5
+)
+
+var (
+	rulesMu sync.RWMutex
+	rules   *yara.Rules
+)
+
+// DefaultRulesDir returns where rules are loaded from if no other directory is given,
+// e.g. "~/.config/file-inspector/rules" on Linux
+func DefaultRulesDir() (string, error) {
+	return configPath("rules")
+}
+
+// configPath returns the path of the name in our directory in the user's config
+func configPath(name string) (string, error) {
+	configDir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "file-inspector", name), nil
+}
+
+// SetRules sets the YARA rules files are scanned with. Nil turns scanning off
+func SetRules(r *yara.Rules) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	rules = r
+}
+
+// LoadRules loads the .yar and .yara files in the directory and scans files with them,
+// returning how many rules were loaded. Files with errors are skipped, so rules can be
+// loaded even when the error isn't nil
+func LoadRules(dir string) (int, error) {
+	loaded, err := yara.LoadDir(dir)
+
+	if loaded.Len() > 0 {
+		SetRules(loaded)
+	}
+
+	log.Printf("Loaded %d YARA rules from %q\n", loaded.Len(), dir)
+
+	return loaded.Len(), err
+}
+
+func getRules() *yara.Rules {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	return rules
+}
+
+// hasRules is true if there are rules to scan with, so callers can skip extracting data for them
+func hasRules() bool {
+	return getRules() != nil
+}
+
+// scanInputWithRules scans the start of the input with the rules, adding a finding for each match
+func scanInputWithRules(ctx context.Context, input *Input, result *ProcessResult) {
+	if !hasRules() {
+		return
+	}
+
+	// too big to analyse is too big to scan, the budget notes it for the limit findings
+	budget := limits.FromContext(ctx)
+
+	if err := budget.CheckInputSize(input.Size, input.Name); err != nil {
+		log.Printf("Not scanning %q with the YARA rules: %s\n", input.Name, err.Error())
+		return
+	}
+
+	data, err := budget.ReadAll(io.NewSectionReader(input.Reader, 0, input.Size), maxRuleScanSize, input.Name)
+
+	if err != nil {
+		log.Printf("Error reading %q for YARA scanning: %s\n", input.Name, err.Error())
+		return
+	}
+
+	scanWithRules(ctx, data, findings.Location{}, result)
+}
+
+// scanWithRules scans the data with the rules, adding a finding for each match. The
+// location says where the data came from, e.g. a PDF stream
+func scanWithRules(ctx context.Context, data []byte, location findings.Location, result *ProcessResult) {
+	loaded := getRules()
+
+	if loaded == nil {
+		return
+	}
+
+	timeout := getAnalyzerTimeout("yara")
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	matches, err := loaded.Scan(ctx, data[:min(len(data), maxRuleScanSize)])
+
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("YARA scan timed out after %s", timeout)
+		}
+
+		result.AddFinding(findings.Finding{
+			ID:       "yara.error",
+			Title:    "Failed to scan with the YARA rules",
+			Severity: findings.SeverityLow,
+			Category: findings.CategoryParsing,
+			Evidence: err.Error(),
+			Location: location,
+		})
+		return
+	}
+
+	for _, match := range matches {
+		result.AddFinding(ruleFinding(match, location))
+	}
+}
+
+// ruleFinding builds the finding for a rule match. The severity comes from the rule's
+// "severity" meta value, and is high if it isn't set
+func ruleFinding(match yara.Match, location findings.Location) findings.Finding {
+	severity := findings.SeverityHigh
+
+	if name := match.MetaValue("severity"); name != "" {
+		if parsed, err := findings.ParseSeverity(name); err == nil {
+			severity = parsed
+		}
+	}
+
+	var evidence []string
+
+	if description := match.MetaValue("description"); description != "" {
+		evidence = append(evidence, description)
+	}
+
+	for i, found := range match.Strings {
+		if i == maxMatchesShown {
+			evidence = append(evidence, fmt.Sprintf("and %d more matches", len(match.Strings)-i))
+			break
+		}
+
+		evidence = append(evidence, fmt.Sprintf("%s at 0x%x: %q", found.ID, found.Offset, found.Data))
+	}
+
+	if len(match.Tags) > 0 {
+		evidence = append(evidence, "Tags: "+strings.Join(match.Tags, ", "))
+	}
+
+	return findings.Finding{
+		ID:          "yara." + match.Rule,
+		Title:       fmt.Sprintf("YARA rule %q matched", match.Rule),
+		Severity:    severity,
+		Category:    findings.CategorySignature,
+		Evidence:    strings.Join(evidence, ". "),
+		Location:    location,
+		Remediation: "The file matches a rule written to detect known threats. Check what the rule looks for before trusting the file.",
+	}
+}
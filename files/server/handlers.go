@@ -0,0 +1,428 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/history"
+	"file-inspector/files/report"
+)
+
+const (
+	// the multipart form field the file is uploaded in
+	uploadField = "file"
+
+	// room for the multipart headers and any other fields around the file
+	multipartOverhead = 64 << 10
+
+	// how many seconds clients are told to wait when the queue is full
+	retryAfterSeconds = "5"
+
+	// how many history records are returned if the client doesn't say
+	defaultHistoryLimit = 100
+)
+
+// jobResponse is the state of a job, without the result
+type jobResponse struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Status    Status     `json:"status"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ResultURL string     `json:"resultUrl,omitempty"`
+}
+
+// attachmentResponse describes an attachment that can be downloaded from the URL
+type attachmentResponse struct {
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Size     int64  `json:"size"`
+	Verdict  string `json:"verdict,omitempty"`
+
+	// URL is where it can be downloaded, if it's still kept, see Config.MaxAttachmentStorage
+	URL string `json:"url,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/files", s.handleUpload)
+	mux.HandleFunc("GET /api/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /api/jobs/{id}/result", s.handleResult)
+	mux.HandleFunc("GET /api/jobs/{id}/attachments", s.handleListAttachments)
+	mux.HandleFunc("GET /api/jobs/{id}/attachments/{path...}", s.handleAttachment)
+	mux.HandleFunc("GET /api/history", s.handleHistory)
+
+	return mux
+}
+
+// ServeHTTP serves the API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleUpload saves the file from the multipart form and queues it, responding with the job
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize+multipartOverhead)
+
+	reader, err := r.MultipartReader()
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expected a multipart form with the file in the %q field", uploadField))
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("no file in the %q field", uploadField))
+			return
+		} else if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		if part.FormName() != uploadField {
+			part.Close()
+			continue
+		}
+
+		name, dir, err := s.saveUpload(part)
+		part.Close()
+
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+
+		j, err := s.submit(name, dir)
+
+		if err != nil {
+			os.RemoveAll(dir)
+
+			if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrClosed) {
+				w.Header().Set("Retry-After", retryAfterSeconds)
+				writeError(w, http.StatusServiceUnavailable, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, err.Error())
+			}
+
+			return
+		}
+
+		w.Header().Set("Location", jobURL(j.id))
+		writeJSON(w, http.StatusAccepted, newJobResponse(*j))
+
+		return
+	}
+}
+
+// saveUpload writes the file to a new temporary directory under its own name, so the
+// extension can be checked against the content as it would be for a file on disk
+func (s *Server) saveUpload(part *multipart.Part) (string, string, error) {
+	name := uploadName(part.FileName())
+	dir, err := os.MkdirTemp("", "file-inspector-")
+
+	if err != nil {
+		return "", "", err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	written, err := io.Copy(f, io.LimitReader(part, s.config.MaxUploadSize+1))
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && written > s.config.MaxUploadSize {
+		err = &http.MaxBytesError{Limit: s.config.MaxUploadSize}
+	}
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	return name, dir, nil
+}
+
+// uploadName returns the base name of the uploaded file, cut to a sensible length but
+// keeping its extension
+func uploadName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "upload"
+	}
+
+	if len(name) > maxNameLength {
+		extension := filepath.Ext(name)
+
+		if len(extension) > maxNameLength/2 {
+			extension = ""
+		}
+
+		name = strings.ToValidUTF8(name[:maxNameLength-len(extension)], "") + extension
+	}
+
+	return name
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	var maxBytesError *http.MaxBytesError
+
+	if errors.As(err, &maxBytesError) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the file is larger than the limit of %d bytes", maxBytesError.Limit))
+		return
+	}
+
+	log.Printf("Error receiving upload: %s\n", err.Error())
+	writeError(w, http.StatusBadRequest, fmt.Sprintf("error receiving the file: %s", err.Error()))
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.listJobs()
+	responses := make([]jobResponse, 0, len(jobs))
+
+	for _, j := range jobs {
+		responses = append(responses, newJobResponse(j))
+	}
+
+	writeJSON(w, http.StatusOK, responses)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.getJob(r.PathValue("id"))
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such job")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newJobResponse(j))
+}
+
+// handleResult responds with the same JSON report that's exported from the user interface
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.getFinishedJob(w, r)
+
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report.New(j.properties, j.result))
+}
+
+func (s *Server) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.getFinishedJob(w, r)
+
+	if !ok {
+		return
+	}
+
+	attachments := appendAttachments([]attachmentResponse{}, &j, "", j.result.Children)
+	writeJSON(w, http.StatusOK, attachments)
+}
+
+// appendAttachments lists the attachments and theirs in turn, with paths like "1/2" for
+// the second attachment of the first
+func appendAttachments(list []attachmentResponse, j *job, parent string, children []*files.ProcessResult) []attachmentResponse {
+	for i, child := range children {
+		path := strconv.Itoa(i + 1)
+
+		if parent != "" {
+			path = parent + "/" + path
+		}
+
+		attachment := attachmentResponse{
+			Path:     path,
+			Name:     child.Name,
+			MimeType: child.MimeType,
+			SHA256:   child.SHA256,
+			Size:     j.attachmentSizes[path],
+		}
+
+		if j.attachmentDir != "" {
+			attachment.URL = jobURL(j.id) + "/attachments/" + path
+		}
+
+		if child.Assessment != nil {
+			attachment.Verdict = string(child.Assessment.Verdict)
+		}
+
+		list = append(list, attachment)
+		list = appendAttachments(list, j, path, child.Children)
+	}
+
+	return list
+}
+
+// handleAttachment responds with the content of an attachment, always as a download so
+// a browser never renders it
+func (s *Server) handleAttachment(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.getFinishedJob(w, r)
+
+	if !ok {
+		return
+	}
+
+	path := r.PathValue("path")
+	child := j.result.Attachment(path)
+
+	if child == nil || j.attachmentDir == "" {
+		writeError(w, http.StatusNotFound, "no such attachment")
+		return
+	}
+
+	// the path's been checked to be numbers, so it can't point outside the directory
+	f, err := os.Open(filepath.Join(j.attachmentDir, attachmentFileName(path)))
+
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no such attachment")
+		return
+	}
+	defer f.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": child.Name})
+
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", j.finished, f)
+}
+
+// handleHistory lists the files analysed before, most recently seen first, optionally
+// only those matching the query in "q"
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.config.History == nil {
+		writeError(w, http.StatusNotFound, "history is turned off")
+		return
+	}
+
+	limit := defaultHistoryLimit
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q", value))
+			return
+		}
+
+		limit = parsed
+	}
+
+	records := s.config.History.Search(r.URL.Query().Get("q"))
+
+	if len(records) > limit {
+		records = records[:limit]
+	}
+
+	if records == nil {
+		records = []history.Record{}
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
+
+// getFinishedJob returns the job if it's done, otherwise responding with why not
+func (s *Server) getFinishedJob(w http.ResponseWriter, r *http.Request) (job, bool) {
+	j, ok := s.getJob(r.PathValue("id"))
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such job")
+		return j, false
+	}
+
+	switch j.status {
+	case StatusDone:
+		return j, true
+	case StatusFailed:
+		writeError(w, http.StatusUnprocessableEntity, j.err.Error())
+	default:
+		writeError(w, http.StatusConflict, fmt.Sprintf("the job is %s", j.status))
+	}
+
+	return j, false
+}
+
+func newJobResponse(j job) jobResponse {
+	response := jobResponse{
+		ID:        j.id,
+		Name:      j.name,
+		Status:    j.status,
+		Submitted: j.submitted,
+		Started:   optionalTime(j.started),
+		Finished:  optionalTime(j.finished),
+	}
+
+	if j.err != nil {
+		response.Error = j.err.Error()
+	}
+
+	if j.status == StatusDone {
+		response.ResultURL = jobURL(j.id) + "/result"
+	}
+
+	return response
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func jobURL(id string) string {
+	return "/api/jobs/" + id
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Error writing response: %s\n", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -0,0 +1,459 @@
+// Package server runs files through the same pipeline as the user interface for
+// clients over HTTP. Files are uploaded, queued for a fixed number of workers and the
+// results polled for, along with any attachments found in them
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/history"
+)
+
+const (
+	// DefaultWorkers is how many files are analysed at once if not specified
+	DefaultWorkers = 2
+
+	// DefaultQueueSize is how many files can be waiting to be analysed if not specified
+	DefaultQueueSize = 16
+
+	// DefaultMaxUploadSize is the largest file that can be uploaded if not specified
+	DefaultMaxUploadSize = 50 << 20
+
+	// DefaultMaxJobs is how many finished jobs are kept if not specified, the oldest are dropped first
+	DefaultMaxJobs = 200
+
+	// DefaultMaxAttachmentStorage is how much space the attachments of finished jobs can
+	// take up on disk if not specified, the oldest jobs' attachments are deleted first
+	DefaultMaxAttachmentStorage = 1 << 30
+
+	// names of uploaded files are cut to this length
+	maxNameLength = 200
+)
+
+var (
+	// ErrQueueFull is returned when there's no room for another file, so try again later
+	ErrQueueFull = errors.New("the queue is full")
+
+	// ErrClosed is returned when the server is shutting down
+	ErrClosed = errors.New("the server is shutting down")
+)
+
+// Config is how the server is set up. Zero values are replaced with the defaults
+type Config struct {
+	Workers       int
+	QueueSize     int
+	MaxUploadSize int64
+	MaxJobs       int
+
+	// MaxAttachmentStorage is the most bytes of attachments kept for download across every job
+	MaxAttachmentStorage int64
+
+	// History records every analysis and is listed by the API, if not nil
+	History *history.Store
+}
+
+// Status is how far a job has got
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// job is a file waiting to be, or that has been, analysed
+type job struct {
+	id        string
+	name      string
+	status    Status
+	submitted time.Time
+	started   time.Time
+	finished  time.Time
+	err       error
+
+	// dir holds the uploaded file until it's been analysed
+	dir string
+
+	properties *files.FileProperties
+	result     *files.ProcessResult
+
+	// attachmentDir holds the attachments found, named by their paths, e.g. "1-2" for the
+	// second attachment of the first, until they're deleted to make room for newer ones.
+	// The sizes are kept after that, for listing them
+	attachmentDir   string
+	attachmentSizes map[string]int64
+	attachmentBytes int64
+}
+
+// Server analyses uploaded files with a bounded queue, serving the API through ServeHTTP
+type Server struct {
+	config Config
+	queue  chan *job
+	mux    *http.ServeMux
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	jobs     map[string]*job
+	finished []string
+
+	// storedBytes is the size of every job's attachments on disk
+	storedBytes int64
+}
+
+// New returns a server with its workers started. Close stops them
+func New(config Config) *Server {
+	if config.Workers < 1 {
+		config.Workers = DefaultWorkers
+	}
+
+	if config.QueueSize < 1 {
+		config.QueueSize = DefaultQueueSize
+	}
+
+	if config.MaxUploadSize < 1 {
+		config.MaxUploadSize = DefaultMaxUploadSize
+	}
+
+	if config.MaxJobs < 1 {
+		config.MaxJobs = DefaultMaxJobs
+	}
+
+	if config.MaxAttachmentStorage < 1 {
+		config.MaxAttachmentStorage = DefaultMaxAttachmentStorage
+	}
+
+	s := Server{
+		config: config,
+		queue:  make(chan *job, config.QueueSize),
+		jobs:   make(map[string]*job),
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.mux = s.routes()
+
+	for w := 1; w <= config.Workers; w++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+
+	return &s
+}
+
+// Close stops taking files, stops any analyses that are running and waits for the
+// workers to finish. Files still in the queue are dropped
+func (s *Server) Close() {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+
+	// the attachments are only for the API, which has stopped
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.attachmentDir != "" {
+			os.RemoveAll(j.attachmentDir)
+		}
+	}
+}
+
+// submit queues the uploaded file in the directory, returning ErrQueueFull if there's no room
+func (s *Server) submit(name, dir string) (*job, error) {
+	id, err := newJobID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job{
+		id:        id,
+		name:      name,
+		status:    StatusQueued,
+		submitted: time.Now().UTC(),
+		dir:       dir,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	select {
+	case s.queue <- j:
+	default:
+		return nil, ErrQueueFull
+	}
+
+	s.jobs[id] = j
+	log.Printf("Queued %q as job %s\n", name, id)
+
+	return j, nil
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+func (s *Server) worker() {
+	defer s.wg.Done()
+
+	for j := range s.queue {
+		s.run(j)
+	}
+}
+
+// run analyses the job's file exactly as the user interface would, then deletes it
+func (s *Server) run(j *job) {
+	defer os.RemoveAll(j.dir)
+
+	// a hostile file that crashes the analysis fails its job, not the server
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v\n%s", j.id, r, debug.Stack())
+			s.finish(j, nil, nil, fmt.Errorf("the analysis crashed: %v", r))
+		}
+	}()
+
+	if err := s.ctx.Err(); err != nil {
+		s.finish(j, nil, nil, ErrClosed)
+		return
+	}
+
+	s.mu.Lock()
+	j.status = StatusRunning
+	j.started = time.Now().UTC()
+	s.mu.Unlock()
+
+	filePath := filepath.Join(j.dir, j.name)
+
+	// keep the attachments so they can be downloaded, the file itself is deleted
+	result := files.ProcessFileContext(files.WithAttachmentData(s.ctx), filePath)
+	properties, err := files.GetResultProperties(filePath, result)
+
+	if err != nil {
+		s.finish(j, nil, nil, err)
+		return
+	}
+
+	// they're written to disk rather than kept in memory until the job's dropped
+	if err := storeAttachments(j, result); err != nil {
+		log.Printf("Error storing the attachments of job %s: %s\n", j.id, err.Error())
+	}
+
+	// the temporary path means nothing to the client
+	properties.FileName = j.name
+	result.FilePath = ""
+
+	s.recordHistory(properties, result)
+	s.finish(j, properties, result, nil)
+}
+
+// storeAttachments writes the content of the result's attachments to a directory for the
+// job, dropping it from the result. Attachments are still listed if they can't be written
+func storeAttachments(j *job, result *files.ProcessResult) error {
+	j.attachmentSizes = make(map[string]int64)
+
+	if len(result.Children) == 0 {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "file-inspector-attachments-")
+
+	if err != nil {
+		return err
+	}
+
+	j.attachmentDir = dir
+
+	return storeChildren(j, "", result.Children)
+}
+
+func storeChildren(j *job, parent string, children []*files.ProcessResult) error {
+	for i, child := range children {
+		path := strconv.Itoa(i + 1)
+
+		if parent != "" {
+			path = parent + "/" + path
+		}
+
+		if data := child.Data(); data != nil {
+			child.ClearData()
+
+			if err := os.WriteFile(filepath.Join(j.attachmentDir, attachmentFileName(path)), data, 0600); err != nil {
+				return err
+			}
+
+			j.attachmentSizes[path] = int64(len(data))
+			j.attachmentBytes += int64(len(data))
+		}
+
+		if err := storeChildren(j, path, child.Children); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attachmentFileName is the name an attachment's stored under, e.g. "1-2" for "1/2"
+func attachmentFileName(path string) string {
+	return strings.ReplaceAll(strings.Trim(path, "/"), "/", "-")
+}
+
+// finish stores the outcome, dropping the oldest finished jobs if there are too many and
+// the oldest attachments if they take up too much space
+func (s *Server) finish(j *job, properties *files.FileProperties, result *files.ProcessResult, err error) {
+	var expired []string
+
+	// delete them once we've let go of the lock
+	defer func() {
+		for _, dir := range expired {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j.properties = properties
+	j.result = result
+	j.err = err
+	j.finished = time.Now().UTC()
+	j.status = StatusDone
+
+	if err != nil {
+		j.status = StatusFailed
+		log.Printf("Job %s failed: %s\n", j.id, err.Error())
+	}
+
+	s.finished = append(s.finished, j.id)
+	s.storedBytes += j.attachmentBytes
+
+	for len(s.finished) > s.config.MaxJobs {
+		if dir := s.dropAttachments(s.jobs[s.finished[0]]); dir != "" {
+			expired = append(expired, dir)
+		}
+
+		delete(s.jobs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+
+	for _, id := range s.finished {
+		if s.storedBytes <= s.config.MaxAttachmentStorage {
+			break
+		}
+
+		if dir := s.dropAttachments(s.jobs[id]); dir != "" {
+			log.Printf("Deleted the attachments of job %s to make room\n", id)
+			expired = append(expired, dir)
+		}
+	}
+}
+
+// dropAttachments forgets the job's stored attachments, returning the directory to delete.
+// The caller holds the lock
+func (s *Server) dropAttachments(j *job) string {
+	dir := j.attachmentDir
+	s.storedBytes -= j.attachmentBytes
+
+	j.attachmentDir = ""
+	j.attachmentBytes = 0
+
+	return dir
+}
+
+// getJob returns a copy of the job, so it can be read without holding the lock
+func (s *Server) getJob(id string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return job{}, false
+	}
+
+	return *j, true
+}
+
+// listJobs returns a copy of every job, most recently submitted first
+func (s *Server) listJobs() []job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]job, 0, len(s.jobs))
+
+	for _, j := range s.jobs {
+		list = append(list, *j)
+	}
+
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].submitted.After(list[k].submitted)
+	})
+
+	return list
+}
+
+// recordHistory adds the file and its attachments to the history, if there is one
+func (s *Server) recordHistory(properties *files.FileProperties, result *files.ProcessResult) {
+	if s.config.History == nil {
+		return
+	}
+
+	entries := []history.Entry{{SHA256: properties.Hash, Analysis: history.NewAnalysis(properties, result)}}
+	entries = appendAttachmentEntries(entries, result.Children)
+
+	if err := s.config.History.AddAll(entries); err != nil {
+		log.Printf("Error saving history: %s\n", err.Error())
+	}
+}
+
+func appendAttachmentEntries(entries []history.Entry, children []*files.ProcessResult) []history.Entry {
+	for _, child := range children {
+		if child.SHA256 != "" {
+			entries = append(entries, history.Entry{SHA256: child.SHA256, Analysis: history.NewAnalysis(nil, child)})
+		}
+
+		entries = appendAttachmentEntries(entries, child.Children)
+	}
+
+	return entries
+}
@@ -0,0 +1,319 @@
+package stix
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"file-inspector/files"
+	"file-inspector/files/findings"
+	"file-inspector/files/ioc"
+	"file-inspector/files/verdict"
+)
+
+// how many findings are listed in an indicator's description
+const indicatorFindings = 5
+
+// Add converts the analysis of a file into STIX objects and adds them to the bundle: the
+// file, its attachments, the email if it is one, the URLs and domains found, plus a
+// malware analysis with the verdict. Files that scored Suspicious or Malicious also get
+// an indicator. The properties can be nil, e.g. if the file wasn't on disk
+func (b *Bundle) Add(properties *files.FileProperties, result *files.ProcessResult) {
+	file := File{
+		Type:        "file",
+		SpecVersion: SpecVersion,
+		Name:        result.Name,
+		MimeType:    result.MimeType,
+	}
+
+	hash := result.SHA256
+
+	if properties != nil {
+		hash = properties.Hash
+		file.Size = properties.SizeBytes
+		file.MimeType = properties.FileType
+	}
+
+	refs := b.addResult(result, file, hash)
+
+	for _, found := range result.IOCs {
+		switch found.Type {
+		case ioc.TypeURL:
+			refs = append(refs, b.addObservable("url", found.Value))
+		case ioc.TypeDomain:
+			refs = append(refs, b.addObservable("domain-name", found.Value))
+		}
+	}
+
+	analysis := MalwareAnalysis{
+		Type:            "malware-analysis",
+		SpecVersion:     SpecVersion,
+		ID:              domainObjectID("malware-analysis"),
+		Created:         b.created,
+		Modified:        b.created,
+		Product:         Product,
+		AnalysisEnded:   b.created,
+		ResultName:      result.Summary(),
+		Result:          getAnalysisResult(result),
+		SampleRef:       refs[0],
+		AnalysisSCORefs: unique(refs),
+	}
+
+	b.add(analysis.ID, analysis)
+}
+
+// addResult adds the file, any email and the attachments, returning the IDs of the objects
+// added with the file's first
+func (b *Bundle) addResult(result *files.ProcessResult, file File, hash string) []string {
+	if hash != "" {
+		file.Hashes = map[string]string{"SHA-256": hash}
+
+		// names from the STIX hashing algorithm vocabulary
+		for name, value := range map[string]string{
+			"MD5":     result.MD5,
+			"SHA-1":   result.SHA1,
+			"SHA-512": result.SHA512,
+			"SSDEEP":  result.SSDeep,
+			"TLSH":    result.TLSH,
+		} {
+			if value != "" {
+				file.Hashes[name] = value
+			}
+		}
+	}
+
+	file.ID = getFileID(file)
+	b.add(file.ID, file)
+	refs := []string{file.ID}
+
+	b.addIndicator(result, file.Name, hash)
+
+	var parts []MIMEPart
+
+	for _, child := range result.Children {
+		childFile := File{
+			Type:        "file",
+			SpecVersion: SpecVersion,
+			Name:        child.Name,
+			MimeType:    child.MimeType,
+		}
+
+		childRefs := b.addResult(child, childFile, child.SHA256)
+		refs = append(refs, childRefs...)
+
+		parts = append(parts, MIMEPart{
+			ContentType:        child.MimeType,
+			ContentDisposition: fmt.Sprintf("attachment; filename=%q", child.Name),
+			BodyRawRef:         childRefs[0],
+		})
+	}
+
+	if email, emailRefs := b.getEmailMessage(result, parts); email != nil {
+		b.add(email.ID, *email)
+		refs = append(refs, email.ID)
+		refs = append(refs, emailRefs...)
+	}
+
+	return refs
+}
+
+// getEmailMessage builds the email from the analyzer's metadata, returning nil if it
+// isn't an email. The IDs of the addresses are returned too
+func (b *Bundle) getEmailMessage(result *files.ProcessResult, parts []MIMEPart) (*EmailMessage, []string) {
+	headers := result.EmailHeaders()
+
+	if headers.From == "" {
+		return nil, nil
+	}
+
+	email := EmailMessage{
+		Type:          "email-message",
+		SpecVersion:   SpecVersion,
+		IsMultipart:   len(parts) > 0,
+		Subject:       headers.Subject,
+		MessageID:     headers.MessageID,
+		BodyMultipart: parts,
+	}
+
+	var refs []string
+
+	if from := b.addEmailAddresses(headers.From); len(from) > 0 {
+		email.FromRef = from[0]
+		refs = append(refs, from[0])
+	}
+
+	if headers.To != "" {
+		email.ToRefs = b.addEmailAddresses(headers.To)
+		refs = append(refs, email.ToRefs...)
+	}
+
+	if date, err := mail.ParseDate(headers.Date); err == nil {
+		email.Date = date.UTC().Format(timestampFormat)
+	}
+
+	idProperties := make(map[string]interface{})
+
+	if email.FromRef != "" {
+		idProperties["from_ref"] = email.FromRef
+	}
+
+	if email.Subject != "" {
+		idProperties["subject"] = email.Subject
+	}
+
+	email.ID = observableID("email-message", idProperties)
+
+	return &email, refs
+}
+
+// addEmailAddresses adds each address in the list, e.g. "Bob <bob@example.com>, alice@example.com"
+func (b *Bundle) addEmailAddresses(list string) []string {
+	addresses, err := mail.ParseAddressList(list)
+
+	// .msg files often just have the bare address
+	if err != nil {
+		if !strings.Contains(list, "@") {
+			return nil
+		}
+
+		addresses = []*mail.Address{{Address: strings.TrimSpace(list)}}
+	}
+
+	var refs []string
+
+	for _, address := range addresses {
+		value := strings.ToLower(address.Address)
+		observable := EmailAddress{
+			Type:        "email-addr",
+			SpecVersion: SpecVersion,
+			ID:          observableID("email-addr", map[string]interface{}{"value": value}),
+			Value:       value,
+			DisplayName: address.Name,
+		}
+
+		b.add(observable.ID, observable)
+		refs = append(refs, observable.ID)
+	}
+
+	return refs
+}
+
+// addObservable adds a single value observable, e.g. a URL, and returns its ID
+func (b *Bundle) addObservable(objectType, value string) string {
+	observable := Observable{
+		Type:        objectType,
+		SpecVersion: SpecVersion,
+		ID:          observableID(objectType, map[string]interface{}{"value": value}),
+		Value:       value,
+	}
+
+	b.add(observable.ID, observable)
+
+	return observable.ID
+}
+
+// addIndicator adds a hash pattern for files that scored Suspicious or Malicious
+func (b *Bundle) addIndicator(result *files.ProcessResult, name, hash string) {
+	if hash == "" || result.Assessment == nil || result.Assessment.Verdict == verdict.Clean {
+		return
+	}
+
+	indicatorType := "anomalous-activity"
+
+	if result.Assessment.Verdict == verdict.Malicious {
+		indicatorType = "malicious-activity"
+	}
+
+	indicator := Indicator{
+		Type:           "indicator",
+		SpecVersion:    SpecVersion,
+		ID:             domainObjectID("indicator"),
+		Created:        b.created,
+		Modified:       b.created,
+		Name:           fmt.Sprintf("%s file %s", result.Assessment.Verdict, name),
+		Description:    getIndicatorDescription(result),
+		IndicatorTypes: []string{indicatorType},
+		Pattern:        fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", hash),
+		PatternType:    "stix",
+		ValidFrom:      b.created,
+	}
+
+	b.add(indicator.ID, indicator)
+}
+
+// e.g. "Malicious (85/100). Active content "/JS" found; ..."
+func getIndicatorDescription(result *files.ProcessResult) string {
+	var titles []string
+
+	for _, f := range result.Findings {
+		if f.Severity == findings.SeverityInfo {
+			continue
+		}
+
+		titles = append(titles, f.Title)
+
+		if len(titles) == indicatorFindings {
+			break
+		}
+	}
+
+	if len(titles) == 0 {
+		return result.Summary()
+	}
+
+	return fmt.Sprintf("%s. %s", result.Summary(), strings.Join(titles, "; "))
+}
+
+// getAnalysisResult maps the verdict on to the STIX malware result vocabulary
+func getAnalysisResult(result *files.ProcessResult) string {
+	if result.Assessment == nil || (result.Error != nil && !result.TimedOut) {
+		return "unknown"
+	}
+
+	switch result.Assessment.Verdict {
+	case verdict.Malicious:
+		return "malicious"
+	case verdict.Suspicious:
+		return "suspicious"
+	case verdict.Clean:
+		// we couldn't look at everything, so we can't say it's benign
+		if result.TimedOut {
+			return "unknown"
+		}
+
+		return "benign"
+	default:
+		return "unknown"
+	}
+}
+
+// a file's ID comes from its hash and name
+func getFileID(file File) string {
+	properties := make(map[string]interface{})
+
+	// only the SHA-256, so the ID stays the same whichever other hashes are included
+	if hash, ok := file.Hashes["SHA-256"]; ok {
+		properties["hashes"] = map[string]string{"SHA-256": hash}
+	}
+
+	if file.Name != "" {
+		properties["name"] = file.Name
+	}
+
+	return observableID("file", properties)
+}
+
+// unique removes repeated IDs, e.g. when the same file is attached twice
+func unique(ids []string) []string {
+	seen := make(map[string]bool)
+	var list []string
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			list = append(list, id)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,190 @@
+// Package stix exports analysis results as STIX 2.1 bundles, so the files, emails and
+// URLs we've seen can be loaded straight into a threat intelligence platform
+package stix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// SpecVersion is the version of STIX the bundles follow
+	SpecVersion = "2.1"
+
+	// Product is the name the analysis is credited to
+	Product = "file-inspector"
+
+	// STIX needs at least millisecond precision
+	timestampFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// the namespace the spec defines for deterministic observable IDs, so the same URL or
+// file gets the same ID no matter who exports it
+var scoNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// Bundle is a collection of STIX objects. Objects with the same ID are only added once
+type Bundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+
+	created string
+	seen    map[string]bool
+}
+
+// File is a file observable
+type File struct {
+	Type        string            `json:"type"`
+	SpecVersion string            `json:"spec_version"`
+	ID          string            `json:"id"`
+	Hashes      map[string]string `json:"hashes,omitempty"`
+	Size        int64             `json:"size,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	MimeType    string            `json:"mime_type,omitempty"`
+}
+
+// EmailAddress is an email address observable
+type EmailAddress struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// EmailMessage is an email observable
+type EmailMessage struct {
+	Type          string     `json:"type"`
+	SpecVersion   string     `json:"spec_version"`
+	ID            string     `json:"id"`
+	IsMultipart   bool       `json:"is_multipart"`
+	Date          string     `json:"date,omitempty"`
+	FromRef       string     `json:"from_ref,omitempty"`
+	ToRefs        []string   `json:"to_refs,omitempty"`
+	Subject       string     `json:"subject,omitempty"`
+	MessageID     string     `json:"message_id,omitempty"`
+	BodyMultipart []MIMEPart `json:"body_multipart,omitempty"`
+}
+
+// MIMEPart is an attachment of an email
+type MIMEPart struct {
+	ContentType        string `json:"content_type,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+	BodyRawRef         string `json:"body_raw_ref,omitempty"`
+}
+
+// Observable is a single value observable, e.g. a URL or domain name
+type Observable struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+// MalwareAnalysis records our analysis of a file and the verdict
+type MalwareAnalysis struct {
+	Type            string   `json:"type"`
+	SpecVersion     string   `json:"spec_version"`
+	ID              string   `json:"id"`
+	Created         string   `json:"created"`
+	Modified        string   `json:"modified"`
+	Product         string   `json:"product"`
+	AnalysisEnded   string   `json:"analysis_ended,omitempty"`
+	ResultName      string   `json:"result_name,omitempty"`
+	Result          string   `json:"result"`
+	SampleRef       string   `json:"sample_ref,omitempty"`
+	AnalysisSCORefs []string `json:"analysis_sco_refs,omitempty"`
+}
+
+// Indicator is a pattern for detecting a file we think is bad
+type Indicator struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description,omitempty"`
+	IndicatorTypes []string `json:"indicator_types"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	ValidFrom      string   `json:"valid_from"`
+}
+
+// NewBundle returns an empty bundle, with the current time used for the objects added to it
+func NewBundle() *Bundle {
+	return &Bundle{
+		Type:    "bundle",
+		ID:      "bundle--" + newRandomUUID(),
+		Objects: []interface{}{},
+		created: time.Now().UTC().Format(timestampFormat),
+		seen:    make(map[string]bool),
+	}
+}
+
+// add adds the object unless one with the same ID is already in the bundle
+func (b *Bundle) add(id string, object interface{}) {
+	if b.seen[id] {
+		return
+	}
+
+	b.seen[id] = true
+	b.Objects = append(b.Objects, object)
+}
+
+// Write writes the bundle as JSON
+func (b *Bundle) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(b)
+}
+
+// observableID returns the deterministic ID for an observable, from the UUIDv5 of its
+// ID contributing properties in canonical JSON
+func observableID(objectType string, properties map[string]interface{}) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+
+	// maps are encoded with sorted keys and no spaces, which is canonical enough for our values
+	if err := encoder.Encode(properties); err != nil {
+		return objectType + "--" + newRandomUUID()
+	}
+
+	hash := sha1.New()
+	hash.Write(scoNamespace[:])
+	hash.Write(bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
+
+	return objectType + "--" + formatUUID(hash.Sum(nil), 5)
+}
+
+// domain objects are unique to the analysis, so they get random IDs
+func domainObjectID(objectType string) string {
+	return objectType + "--" + newRandomUUID()
+}
+
+func newRandomUUID() string {
+	data := make([]byte, 16)
+
+	// crypto/rand doesn't fail on the platforms we support
+	_, _ = rand.Read(data)
+
+	return formatUUID(data, 4)
+}
+
+// formatUUID sets the version and variant bits on the first 16 bytes and formats them
+func formatUUID(data []byte, version byte) string {
+	var uuid [16]byte
+	copy(uuid[:], data)
+
+	uuid[6] = (uuid[6] & 0x0f) | version<<4
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
@@ -0,0 +1,197 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"file-inspector/files/findings"
+)
+
+const (
+	// DefaultAnalyzerTimeout is how long an analyzer gets, including any attachments, unless set otherwise
+	DefaultAnalyzerTimeout = 30 * time.Second
+
+	// once the context is done this is how long we wait for an analyzer to hand back a
+	// partial result, before giving up on it. Some of the parsers can't be interrupted
+	analyzerGracePeriod = 2 * time.Second
+
+	// the most analyzers left running after their time was up. Past this new files fail
+	// straight away, rather than each leaving another one behind in serve or watch
+	maxAbandonedAnalyzers = 4
+)
+
+var (
+	defaultAnalyzerTimeout = DefaultAnalyzerTimeout
+	analyzerTimeouts       = make(map[string]time.Duration)
+
+	// how many abandoned analyzers are still running
+	abandonedAnalyzers atomic.Int32
+)
+
+// SetDefaultAnalyzerTimeout changes the timeout for analyzers without one of their own
+func SetDefaultAnalyzerTimeout(timeout time.Duration) {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	defaultAnalyzerTimeout = timeout
+}
+
+// SetAnalyzerTimeout sets the timeout for the named analyzer, e.g. "pdf"
+func SetAnalyzerTimeout(name string, timeout time.Duration) {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	analyzerTimeouts[name] = timeout
+}
+
+// SetAnalyzerTimeouts replaces the timeouts for individual analyzers, keyed by name
+func SetAnalyzerTimeouts(timeouts map[string]time.Duration) {
+	analyzersMu.Lock()
+	defer analyzersMu.Unlock()
+
+	analyzerTimeouts = make(map[string]time.Duration, len(timeouts))
+
+	for name, timeout := range timeouts {
+		analyzerTimeouts[name] = timeout
+	}
+}
+
+func getAnalyzerTimeout(name string) time.Duration {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	if timeout, ok := analyzerTimeouts[name]; ok {
+		return timeout
+	}
+
+	return defaultAnalyzerTimeout
+}
+
+// ErrAnalyzerPanic is wrapped by the error for a file that crashed its analyzer
+var ErrAnalyzerPanic = errors.New("analyzer panicked")
+
+// ErrAnalyzersStuck is wrapped by the error for a file that wasn't analysed because too
+// many analyzers are still stuck on earlier files
+var ErrAnalyzersStuck = errors.New("analyzers stuck on earlier files")
+
+type analyzerOutput struct {
+	result *ProcessResult
+	err    error
+}
+
+// runAnalyzer runs the analyzer with its timeout. If it doesn't stop when the time is
+// up we leave it running and return without a result, so a pathological file can't
+// hold up everything else. Only a few can be left running at once
+func runAnalyzer(ctx context.Context, analyzer Analyzer, input *Input) (*ProcessResult, error) {
+	if stuck := abandonedAnalyzers.Load(); stuck >= maxAbandonedAnalyzers {
+		return nil, fmt.Errorf("%w: %d haven't stopped, so the %s analyzer wasn't run", ErrAnalyzersStuck, stuck, analyzer.Name())
+	}
+
+	timeout := getAnalyzerTimeout(analyzer.Name())
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// buffered so an abandoned analyzer can still finish
+	done := make(chan analyzerOutput, 1)
+
+	// whether it's finished or been abandoned, whichever comes first
+	var state struct {
+		sync.Mutex
+		finished  bool
+		abandoned bool
+	}
+
+	go func() {
+		defer func() {
+			state.Lock()
+			defer state.Unlock()
+
+			state.finished = true
+
+			if state.abandoned {
+				abandonedAnalyzers.Add(-1)
+				log.Printf("The abandoned %s analyzer has stopped\n", analyzer.Name())
+			}
+		}()
+
+		// a parser that panics on a hostile file fails that file, not everything else
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("The %s analyzer panicked: %v\n%s", analyzer.Name(), r, debug.Stack())
+				done <- analyzerOutput{err: fmt.Errorf("%w: the %s analyzer crashed: %v", ErrAnalyzerPanic, analyzer.Name(), r)}
+			}
+		}()
+
+		result, err := analyzer.Analyze(ctx, input)
+		done <- analyzerOutput{result, err}
+	}()
+
+	var output analyzerOutput
+
+	select {
+	case output = <-done:
+	case <-ctx.Done():
+		// give it a chance to return what it has
+		select {
+		case output = <-done:
+		case <-time.After(analyzerGracePeriod):
+			log.Printf("The %s analyzer didn't stop, abandoning it\n", analyzer.Name())
+			output.err = ctx.Err()
+
+			state.Lock()
+
+			if !state.finished {
+				state.abandoned = true
+				abandonedAnalyzers.Add(1)
+			}
+
+			state.Unlock()
+		}
+	}
+
+	if errors.Is(output.err, context.DeadlineExceeded) {
+		output.err = fmt.Errorf("%s analysis timed out after %s: %w", analyzer.Name(), timeout, output.err)
+	} else if errors.Is(output.err, context.Canceled) {
+		output.err = fmt.Errorf("%s analysis cancelled: %w", analyzer.Name(), output.err)
+	}
+
+	return output.result, output.err
+}
+
+// markTimedOut flags the result as partial because the analysis ran out of time
+func (r *ProcessResult) markTimedOut() {
+	r.TimedOut = true
+	r.Completed = false
+
+	r.AddFinding(findings.Finding{
+		ID:          "analysis.timeout",
+		Title:       "Analysis timed out, so the results are incomplete",
+		Severity:    findings.SeverityMedium,
+		Category:    findings.CategoryParsing,
+		Evidence:    r.Error.Error(),
+		Remediation: "Files built to be slow to parse are used to get past scanners. Treat the file with caution.",
+	})
+}
+
+// markCrashed flags the result as partial because the file crashed its analyzer
+func (r *ProcessResult) markCrashed() {
+	r.AddFinding(findings.Finding{
+		ID:          "analysis.crash",
+		Title:       "The file crashed the analysis, so the results are incomplete",
+		Severity:    findings.SeverityMedium,
+		Category:    findings.CategoryParsing,
+		Evidence:    r.Error.Error(),
+		Remediation: "Files built to crash parsers are used to get past scanners. Treat the file with caution.",
+	})
+}
+
+// IsCancelled returns true if the analysis was stopped before it finished, rather than timing out
+func (r *ProcessResult) IsCancelled() bool {
+	return errors.Is(r.Error, context.Canceled)
+}
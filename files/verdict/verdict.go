@@ -0,0 +1,279 @@
+// Package verdict weights the findings from an analysis into a risk score and verdict
+package verdict
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"file-inspector/files/findings"
+)
+
+// Verdict is the overall call on a file
+type Verdict string
+
+const (
+	Clean      Verdict = "Clean"
+	Suspicious Verdict = "Suspicious"
+	Malicious  Verdict = "Malicious"
+)
+
+const (
+	// MaxScore is the highest score a file can get
+	MaxScore = 100
+
+	// wildcard suffix for weights covering a family of findings, e.g. "pdf.active.*"
+	wildcard = "*"
+)
+
+// Config holds the weights and thresholds used to score findings
+type Config struct {
+	// Weights maps a finding ID, or an ID prefix ending in ".*", to its weight
+	Weights map[string]int `toml:"weights"`
+
+	// SeverityWeights are used for findings with no weight of their own, keyed by severity name
+	SeverityWeights map[string]int `toml:"severity_weights"`
+
+	// SuspiciousThreshold is the lowest score that's Suspicious
+	SuspiciousThreshold int `toml:"suspicious_threshold"`
+
+	// MaliciousThreshold is the lowest score that's Malicious
+	MaliciousThreshold int `toml:"malicious_threshold"`
+}
+
+// Signal is a finding that contributed to the score
+type Signal struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Weight int    `json:"weight"`
+	Count  int    `json:"count"`
+}
+
+// Assessment is the score and verdict for a set of findings
+type Assessment struct {
+	Score   int      `json:"score"`
+	Verdict Verdict  `json:"verdict"`
+	Signals []Signal `json:"signals,omitempty"`
+}
+
+// DefaultConfig returns the built in weights and thresholds. Authentication failures are
+// weighted low enough that a newsletter failing a single check stays Clean
+func DefaultConfig() *Config {
+	return &Config{
+		Weights: map[string]int{
+			"file.mime-mismatch":           50,
+			"pdf.encrypted":                40,
+			"pdf.active.javascript":        40,
+			"pdf.active.js":                40,
+			"pdf.active.launch":            60,
+			"pdf.active.openaction":        20,
+			"pdf.active.aa":                20,
+			"pdf.active.acroform":          10,
+			"auth.dkim.fail":               10,
+			"auth.spf.fail":                15,
+			"auth.dmarc.fail":              20,
+			"url.uncommon-domain":          10,
+			"url.safelink.uncommon-domain": 10,
+			"hash.deny":                    100,
+			"hash.deny.similar":            50,
+			"hash.allow":                   -100,
+		},
+		SeverityWeights: map[string]int{
+			findings.SeverityInfo.String():     0,
+			findings.SeverityLow.String():      5,
+			findings.SeverityMedium.String():   10,
+			findings.SeverityHigh.String():     25,
+			findings.SeverityCritical.String(): 50,
+		},
+		SuspiciousThreshold: 30,
+		MaliciousThreshold:  70,
+	}
+}
+
+// LoadConfig reads weights and thresholds from a TOML file. Anything not set in
+// the file keeps its default value, e.g.
+//
+//	malicious_threshold = 80
+//
+//	[weights]
+//	"auth.dkim.fail" = 5
+//	"pdf.active.*" = 30
+func LoadConfig(filePath string) (*Config, error) {
+	config := DefaultConfig()
+
+	var loaded Config
+
+	if _, err := toml.DecodeFile(filePath, &loaded); err != nil {
+		return nil, fmt.Errorf("error reading verdict config %q: %s", filePath, err.Error())
+	}
+
+	config.Merge(&loaded)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Merge overrides this config with anything set in the other one. A wildcard weight
+// replaces any of our weights it covers, so "auth.*" applies to every auth finding
+func (c *Config) Merge(other *Config) {
+	for id, weight := range other.Weights {
+		if strings.HasSuffix(id, wildcard) {
+			prefix := strings.TrimSuffix(id, wildcard)
+
+			for existing := range c.Weights {
+				if _, overridden := other.Weights[existing]; !overridden && strings.HasPrefix(existing, prefix) {
+					delete(c.Weights, existing)
+				}
+			}
+		}
+
+		c.Weights[id] = weight
+	}
+
+	for severity, weight := range other.SeverityWeights {
+		c.SeverityWeights[strings.ToLower(severity)] = weight
+	}
+
+	if other.SuspiciousThreshold > 0 {
+		c.SuspiciousThreshold = other.SuspiciousThreshold
+	}
+
+	if other.MaliciousThreshold > 0 {
+		c.MaliciousThreshold = other.MaliciousThreshold
+	}
+}
+
+// Validate checks the thresholds make sense
+func (c *Config) Validate() error {
+	if c.SuspiciousThreshold <= 0 || c.SuspiciousThreshold > MaxScore {
+		return fmt.Errorf("suspicious threshold must be between 1 and %d, not %d", MaxScore, c.SuspiciousThreshold)
+	}
+
+	if c.MaliciousThreshold < c.SuspiciousThreshold || c.MaliciousThreshold > MaxScore {
+		return fmt.Errorf("malicious threshold must be between the suspicious threshold (%d) and %d, not %d", c.SuspiciousThreshold, MaxScore, c.MaliciousThreshold)
+	}
+
+	for severity := range c.SeverityWeights {
+		if _, err := findings.ParseSeverity(severity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WeightFor returns the weight of a finding. An exact ID match wins, then the longest
+// matching wildcard, then the weight for its severity
+func (c *Config) WeightFor(f findings.Finding) int {
+	if weight, ok := c.Weights[f.ID]; ok {
+		return weight
+	}
+
+	bestPrefix := ""
+	bestWeight := 0
+
+	for id, weight := range c.Weights {
+		if !strings.HasSuffix(id, wildcard) {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(id, wildcard)
+
+		if strings.HasPrefix(f.ID, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+			bestWeight = weight
+		}
+	}
+
+	if bestPrefix != "" {
+		return bestWeight
+	}
+
+	return c.SeverityWeights[f.Severity.String()]
+}
+
+// Assess scores the findings. Each kind of finding only counts once, so ten links to
+// uncommon domains weigh the same as one. Negative weights, e.g. for a file on an
+// allowlist, lower the score, which never drops below zero
+func (c *Config) Assess(list []findings.Finding) *Assessment {
+	signals := make(map[string]*Signal)
+	var order []string
+
+	for _, f := range list {
+		weight := c.WeightFor(f)
+
+		if weight == 0 {
+			continue
+		}
+
+		if signal, seen := signals[f.ID]; seen {
+			signal.Count++
+			continue
+		}
+
+		signals[f.ID] = &Signal{
+			ID:     f.ID,
+			Title:  f.Title,
+			Weight: weight,
+			Count:  1,
+		}
+		order = append(order, f.ID)
+	}
+
+	var assessment Assessment
+
+	for _, id := range order {
+		assessment.Signals = append(assessment.Signals, *signals[id])
+		assessment.Score += signals[id].Weight
+	}
+
+	// biggest contributors first
+	sort.SliceStable(assessment.Signals, func(i, j int) bool {
+		return assessment.Signals[i].Weight > assessment.Signals[j].Weight
+	})
+
+	assessment.Score = max(min(assessment.Score, MaxScore), 0)
+	assessment.Verdict = c.verdictFor(assessment.Score)
+
+	return &assessment
+}
+
+func (c *Config) verdictFor(score int) Verdict {
+	switch {
+	case score >= c.MaliciousThreshold:
+		return Malicious
+	case score >= c.SuspiciousThreshold:
+		return Suspicious
+	default:
+		return Clean
+	}
+}
+
+// String returns the verdict and score, e.g. "Suspicious (45/100)"
+func (a *Assessment) String() string {
+	return fmt.Sprintf("%s (%d/%d)", a.Verdict, a.Score, MaxScore)
+}
+
+// Render builds the text view of the assessment and the signals behind it
+func (a *Assessment) Render() string {
+	var builder strings.Builder
+
+	builder.WriteString(fmt.Sprintf("Verdict: %s\n", a))
+
+	for _, signal := range a.Signals {
+		if signal.Count > 1 {
+			builder.WriteString(fmt.Sprintf("\t%+d\t%s (x%d)\n", signal.Weight, signal.Title, signal.Count))
+		} else {
+			builder.WriteString(fmt.Sprintf("\t%+d\t%s\n", signal.Weight, signal.Title))
+		}
+	}
+
+	builder.WriteString("\n")
+
+	return builder.String()
+}
@@ -0,0 +1,621 @@
+// Package watch analyses files as they're dropped into folders, e.g. messages users
+// have reported as phishing, writing a report for each and moving it out of the way
+// into a processed or failed folder
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"file-inspector/files"
+	"file-inspector/files/report"
+)
+
+const (
+	// DefaultSettleTime is how long a file has to be left unchanged before it's analysed if not specified
+	DefaultSettleTime = 2 * time.Second
+
+	// ProcessedDir is the folder, in the watched folder, files that were analysed are moved to
+	ProcessedDir = "processed"
+
+	// FailedDir is the folder, in the watched folder, files that couldn't be analysed are moved to
+	FailedDir = "failed"
+
+	// DefaultWorkers is how many files are analysed at once if not specified
+	DefaultWorkers = 2
+
+	// the shortest time between checks on files being written
+	minCheckInterval = 100 * time.Millisecond
+
+	// how long to wait before trying to move a file again, doubling each time it fails
+	minMoveRetry = time.Second
+	maxMoveRetry = 5 * time.Minute
+)
+
+// Config is what to watch and where the reports go
+type Config struct {
+	Dirs []string
+
+	// OutputDir is where reports are written. If empty they're written next to the file,
+	// once it's been moved
+	OutputDir string
+	Format    report.Format
+
+	// SettleTime is how long a file's size and modification time have to stay the same
+	// before it's thought to be written
+	SettleTime time.Duration
+
+	// Workers is how many files are analysed at once
+	Workers int
+
+	// Done is called after each file, it can be nil
+	Done func(Outcome)
+}
+
+// Outcome is what happened to a file
+type Outcome struct {
+	// Path is where the file was found
+	Path string
+
+	// MovedTo is where the file is now, empty if it couldn't be moved
+	MovedTo string
+
+	// ReportPath is where the report was written, empty if it wasn't
+	ReportPath string
+
+	// Result is nil if the file couldn't be analysed at all
+	Result *files.ProcessResult
+
+	// Failed is true if the file couldn't be analysed, e.g. it isn't a supported type
+	Failed bool
+
+	// Err is why it failed, and any errors writing the report or moving the file
+	Err error
+}
+
+// Watcher watches the folders
+type Watcher struct {
+	config  Config
+	pending map[string]*pendingFile
+
+	// files are handed to the workers and come back done, the pending files are only
+	// touched by Run
+	tasks chan task
+	done  chan task
+}
+
+// pendingFile is a file that's still being written, or hasn't been left long enough to
+// tell, or is with a worker, or has been analysed but couldn't be moved yet
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	changed time.Time
+
+	// busy is true while a worker has it
+	busy bool
+
+	// analysed is set if it couldn't be moved, so only the move is tried again at retryAt
+	analysed *task
+	retryAt  time.Time
+	backoff  time.Duration
+}
+
+// task is a file for a worker to analyse, or just move if it's been analysed already
+type task struct {
+	path       string
+	analysed   bool
+	outcome    Outcome
+	properties *files.FileProperties
+
+	// finished is the outcome once it's been moved, or not
+	finished Outcome
+
+	// stopped is true if the context was done part way through
+	stopped bool
+}
+
+// New checks the folders exist and creates the folders files and reports are moved to
+func New(config Config) (*Watcher, error) {
+	if len(config.Dirs) == 0 {
+		return nil, errors.New("no folders to watch")
+	}
+
+	if config.SettleTime <= 0 {
+		config.SettleTime = DefaultSettleTime
+	}
+
+	if config.Workers < 1 {
+		config.Workers = DefaultWorkers
+	}
+
+	if config.Format == "" {
+		config.Format = report.FormatJSON
+	}
+
+	if config.Format.Extension() == "" {
+		return nil, fmt.Errorf("unknown report format %q", config.Format)
+	}
+
+	for i, dir := range config.Dirs {
+		absolute, err := filepath.Abs(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(absolute)
+
+		if err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("%q isn't a folder", dir)
+		}
+
+		for _, sub := range []string{ProcessedDir, FailedDir} {
+			if err := os.MkdirAll(filepath.Join(absolute, sub), 0755); err != nil {
+				return nil, err
+			}
+		}
+
+		config.Dirs[i] = absolute
+	}
+
+	if config.OutputDir != "" {
+		absolute, err := filepath.Abs(config.OutputDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// the reports would be picked up as new files
+		for _, dir := range config.Dirs {
+			if absolute == dir {
+				return nil, fmt.Errorf("reports can't be written to %q as it's being watched", config.OutputDir)
+			}
+		}
+
+		if err := os.MkdirAll(absolute, 0755); err != nil {
+			return nil, err
+		}
+
+		config.OutputDir = absolute
+	}
+
+	return &Watcher{config: config, pending: make(map[string]*pendingFile)}, nil
+}
+
+// worker analyses and moves the files it's given until there are no more
+func (w *Watcher) worker(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for t := range w.tasks {
+		if !t.analysed {
+			t.outcome, t.properties, t.stopped = w.analyseFile(ctx, t.path)
+			t.analysed = true
+		}
+
+		if !t.stopped {
+			t.finished = w.finish(t.outcome, t.properties)
+		}
+
+		w.done <- t
+	}
+}
+
+// Run watches the folders until the context is done, analysing the files already in
+// them first. A file being analysed when the context is done is left where it is, to be
+// analysed next time
+func (w *Watcher) Run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// watch before looking at what's there, so nothing dropped in between is missed
+	for _, dir := range w.config.Dirs {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("error watching %q: %s", dir, err.Error())
+		}
+
+		log.Printf("Watching %q\n", dir)
+	}
+
+	// the workers stop with the context, leaving the file they're on where it is
+	w.tasks = make(chan task)
+	w.done = make(chan task, w.config.Workers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < w.config.Workers; i++ {
+		wg.Add(1)
+		go w.worker(ctx, &wg)
+	}
+
+	// wait for the workers, dealing with what they finish in the meantime
+	defer func() {
+		close(w.tasks)
+
+		go func() {
+			wg.Wait()
+			close(w.done)
+		}()
+
+		for t := range w.done {
+			w.handleDone(t)
+		}
+	}()
+
+	w.addExisting()
+
+	ticker := time.NewTicker(max(w.config.SettleTime/4, minCheckInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case t := <-w.done:
+			w.handleDone(t)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			w.handleEvent(event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			// some events were lost, so look for anything we've missed
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				log.Println("Too many changes to keep up with, checking the folders again")
+				w.addExisting()
+				continue
+			}
+
+			log.Printf("Error watching: %s\n", err.Error())
+		case <-ticker.C:
+			w.processSettled(ctx)
+		}
+	}
+}
+
+// addExisting adds every file already in the folders
+func (w *Watcher) addExisting() {
+	for _, dir := range w.config.Dirs {
+		entries, err := os.ReadDir(dir)
+
+		if err != nil {
+			log.Printf("Error reading %q: %s\n", dir, err.Error())
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				w.touch(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	switch {
+	case event.Has(fsnotify.Create) || event.Has(fsnotify.Write):
+		w.touch(event.Name)
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		// the worker moving it renames it, and says when it's done
+		if file, ok := w.pending[event.Name]; !ok || !file.busy {
+			delete(w.pending, event.Name)
+		}
+	}
+}
+
+// touch notes the file has changed, so it's only analysed once it's been left alone
+func (w *Watcher) touch(path string) {
+	if isIgnored(filepath.Base(path)) {
+		return
+	}
+
+	if file, ok := w.pending[path]; ok {
+		file.changed = time.Now()
+
+		// changed since it was analysed, so it's analysed again
+		if file.analysed != nil {
+			file.analysed = nil
+			file.size = -1
+		}
+
+		return
+	}
+
+	w.pending[path] = &pendingFile{size: -1, changed: time.Now()}
+}
+
+// isIgnored returns true for hidden files and the temporary files programs write
+// before renaming them to the real name
+func isIgnored(name string) bool {
+	lower := strings.ToLower(name)
+
+	if strings.HasPrefix(lower, ".") || strings.HasPrefix(lower, "~") {
+		return true
+	}
+
+	for _, suffix := range []string{".tmp", ".part", ".partial", ".crdownload", "~"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processSettled hands the workers the files whose size and modification time haven't
+// changed for the settle time, as they've probably finished being written, and those due
+// another try at moving them. Files wait for the next check if the workers are all busy
+func (w *Watcher) processSettled(ctx context.Context) {
+	for path, file := range w.pending {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if file.busy {
+			continue
+		}
+
+		if file.analysed != nil {
+			if time.Now().Before(file.retryAt) {
+				continue
+			}
+
+			if !w.assign(path, file, *file.analysed) {
+				return
+			}
+
+			continue
+		}
+
+		info, err := os.Stat(path)
+
+		// gone, or a folder, e.g. processed being created
+		if err != nil || !info.Mode().IsRegular() {
+			delete(w.pending, path)
+			continue
+		}
+
+		if info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+			file.size = info.Size()
+			file.modTime = info.ModTime()
+			file.changed = time.Now()
+			continue
+		}
+
+		if time.Since(file.changed) < w.config.SettleTime {
+			continue
+		}
+
+		if !w.assign(path, file, task{path: path}) {
+			return
+		}
+	}
+}
+
+// assign hands the file to a worker, returning false if they're all busy
+func (w *Watcher) assign(path string, file *pendingFile, t task) bool {
+	select {
+	case w.tasks <- t:
+		file.busy = true
+		return true
+	default:
+		return false
+	}
+}
+
+// handleDone deals with a file a worker has finished with. One that couldn't be moved
+// stays pending, so moving it is tried again later, backing off each time
+func (w *Watcher) handleDone(t task) {
+	if t.stopped {
+		delete(w.pending, t.path)
+		return
+	}
+
+	if t.finished.MovedTo == "" {
+		if _, err := os.Lstat(t.path); err == nil {
+			file, ok := w.pending[t.path]
+
+			if !ok {
+				file = &pendingFile{size: -1, changed: time.Now()}
+				w.pending[t.path] = file
+			}
+
+			file.busy = false
+			file.analysed = &t
+			file.backoff = min(max(2*file.backoff, minMoveRetry), maxMoveRetry)
+			file.retryAt = time.Now().Add(file.backoff)
+
+			log.Printf("%s, trying again in %s\n", t.finished.Err.Error(), file.backoff)
+			return
+		}
+	}
+
+	delete(w.pending, t.path)
+
+	if w.config.Done != nil {
+		w.config.Done(t.finished)
+	}
+}
+
+// analyseFile analyses the file, returning true if it was stopped part way through and
+// should be left where it is
+func (w *Watcher) analyseFile(ctx context.Context, path string) (Outcome, *files.FileProperties, bool) {
+	log.Printf("Analysing dropped file %q\n", path)
+
+	outcome := Outcome{Path: path}
+	properties, result, err := analyse(ctx, path)
+	outcome.Result = result
+
+	if ctx.Err() != nil {
+		return outcome, nil, true
+	}
+
+	if err == nil {
+		err = result.Error
+	}
+
+	if err != nil {
+		outcome.Failed = true
+		outcome.Err = err
+	}
+
+	return outcome, properties, false
+}
+
+// finish moves the analysed file and writes its report. If it can't be moved the report
+// isn't written yet, as it goes next to the moved file
+func (w *Watcher) finish(outcome Outcome, properties *files.FileProperties) Outcome {
+	var errs []error
+	dest := ProcessedDir
+
+	if outcome.Failed {
+		errs = append(errs, outcome.Err)
+		dest = FailedDir
+	}
+
+	movedTo, err := moveFile(outcome.Path, filepath.Join(filepath.Dir(outcome.Path), dest))
+
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error moving %q: %s", outcome.Path, err.Error()))
+		outcome.Err = errors.Join(errs...)
+
+		return outcome
+	}
+
+	outcome.MovedTo = movedTo
+
+	if outcome.Result != nil {
+		reportPath, err := w.writeReport(movedTo, properties, outcome.Result)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error writing report for %q: %s", outcome.Path, err.Error()))
+		}
+
+		outcome.ReportPath = reportPath
+	}
+
+	outcome.Err = errors.Join(errs...)
+
+	return outcome
+}
+
+// analyse processes the file and gets its properties from the result. A file that crashes the analysis
+// is an error, so it's moved to the failed folder rather than crashing the watcher every
+// time it's restarted
+func analyse(ctx context.Context, path string) (properties *files.FileProperties, result *files.ProcessResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Analysing %q panicked: %v\n%s", path, r, debug.Stack())
+			properties, result = nil, nil
+			err = fmt.Errorf("the analysis crashed: %v", r)
+		}
+	}()
+
+	result = files.ProcessFileContext(ctx, path)
+	properties, err = files.GetResultProperties(path, result)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return properties, result, nil
+}
+
+// writeReport writes the report to the output folder, or next to the moved file if there
+// isn't one, named like those exported from the user interface, e.g. "phish-report.json"
+func (w *Watcher) writeReport(movedTo string, properties *files.FileProperties, result *files.ProcessResult) (string, error) {
+	dir := w.config.OutputDir
+	name := result.Name
+
+	if movedTo != "" {
+		name = filepath.Base(movedTo)
+
+		if dir == "" {
+			dir = filepath.Dir(movedTo)
+		}
+	}
+
+	// it'd be picked up as a new file if written in the watched folder
+	if dir == "" {
+		return "", errors.New("the file is still in the watched folder")
+	}
+
+	reportName := fmt.Sprintf("%s-report%s", strings.TrimSuffix(name, filepath.Ext(name)), w.config.Format.Extension())
+	f, err := createUnique(dir, reportName)
+
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := report.New(properties, result).Write(f, w.config.Format); err != nil {
+		return f.Name(), err
+	}
+
+	return f.Name(), nil
+}
+
+// moveFile moves the file into the folder, adding a number to its name if there's
+// already a file with the same name, and returns where it is now
+func moveFile(path, dir string) (string, error) {
+	name := filepath.Base(path)
+
+	for i := 1; ; i++ {
+		dest := filepath.Join(dir, name)
+
+		if _, err := os.Lstat(dest); errors.Is(err, os.ErrNotExist) {
+			if err := os.Rename(path, dest); err != nil {
+				return "", err
+			}
+
+			return dest, nil
+		} else if err != nil {
+			return "", err
+		}
+
+		name = numberedName(filepath.Base(path), i)
+	}
+}
+
+// createUnique creates the file in the folder, adding a number to its name if there's
+// already a file with the same name
+func createUnique(dir, name string) (*os.File, error) {
+	unique := name
+
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, unique), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+		if !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+
+		unique = numberedName(name, i)
+	}
+}
+
+// numberedName adds the number before the extension, e.g. "phish-1.eml"
+func numberedName(name string, number int) string {
+	extension := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+
+	return fmt.Sprintf("%s-%d%s", stem, number, extension)
+}
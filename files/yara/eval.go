@@ -0,0 +1,453 @@
+package yara
+
+import (
+	"encoding/binary"
+)
+
+// value is the result of an expression. Booleans are 0 or 1, and reading past the end
+// of the file gives an undefined value, which is false in a condition
+type value struct {
+	n       int64
+	defined bool
+}
+
+func defined(n int64) value {
+	return value{n: n, defined: true}
+}
+
+func boolean(b bool) value {
+	if b {
+		return defined(1)
+	}
+
+	return defined(0)
+}
+
+var undefined = value{}
+
+func (v value) isTrue() bool {
+	return v.defined && v.n != 0
+}
+
+// evalContext is what conditions are evaluated against
+type evalContext struct {
+	data    []byte
+	matches map[*String][][2]int
+	rules   map[*Rule]bool
+
+	// the string in a "for ... of" loop, for "$", "#", "@" and "!"
+	current []*String
+
+	// the variables in "for ... in" loops
+	vars map[string]int64
+}
+
+func (c *evalContext) resolve(s *String) *String {
+	if s == nil && len(c.current) > 0 {
+		return c.current[len(c.current)-1]
+	}
+
+	return s
+}
+
+type expr interface {
+	eval(c *evalContext) value
+}
+
+type constant struct {
+	v value
+}
+
+func (e constant) eval(*evalContext) value {
+	return e.v
+}
+
+type filesizeNode struct{}
+
+func (filesizeNode) eval(c *evalContext) value {
+	return defined(int64(len(c.data)))
+}
+
+// stringRange is the optional "in (low..high)" on string matches and counts
+type stringRange struct {
+	low, high expr
+}
+
+// matchesIn returns the matches starting in the range, or all of them if there's no range
+func (r *stringRange) matchesIn(c *evalContext, matches [][2]int) ([][2]int, bool) {
+	if r == nil {
+		return matches, true
+	}
+
+	low := r.low.eval(c)
+	high := r.high.eval(c)
+
+	if !low.defined || !high.defined {
+		return nil, false
+	}
+
+	var in [][2]int
+
+	for _, m := range matches {
+		if int64(m[0]) >= low.n && int64(m[0]) <= high.n {
+			in = append(in, m)
+		}
+	}
+
+	return in, true
+}
+
+// $a, $a at 100, or $a in (0..100)
+type stringMatchNode struct {
+	s       *String
+	at      expr
+	inRange *stringRange
+}
+
+func (e stringMatchNode) eval(c *evalContext) value {
+	matches := c.matches[c.resolve(e.s)]
+
+	if e.at != nil {
+		offset := e.at.eval(c)
+
+		if !offset.defined {
+			return undefined
+		}
+
+		for _, m := range matches {
+			if int64(m[0]) == offset.n {
+				return defined(1)
+			}
+		}
+
+		return defined(0)
+	}
+
+	in, ok := e.inRange.matchesIn(c, matches)
+
+	if !ok {
+		return undefined
+	}
+
+	return boolean(len(in) > 0)
+}
+
+// #a or #a in (0..100)
+type stringCountNode struct {
+	s       *String
+	inRange *stringRange
+}
+
+func (e stringCountNode) eval(c *evalContext) value {
+	in, ok := e.inRange.matchesIn(c, c.matches[c.resolve(e.s)])
+
+	if !ok {
+		return undefined
+	}
+
+	return defined(int64(len(in)))
+}
+
+// @a[i] or !a[i], the offset or length of the i'th match, counting from 1
+type stringOffsetNode struct {
+	s      *String
+	index  expr
+	length bool
+}
+
+func (e stringOffsetNode) eval(c *evalContext) value {
+	matches := c.matches[c.resolve(e.s)]
+	index := e.index.eval(c)
+
+	if !index.defined || index.n < 1 || index.n > int64(len(matches)) {
+		return undefined
+	}
+
+	m := matches[index.n-1]
+
+	if e.length {
+		return defined(int64(m[1] - m[0]))
+	}
+
+	return defined(int64(m[0]))
+}
+
+// uint8(0), int16be(4), etc.
+type readIntNode struct {
+	size      int
+	signed    bool
+	bigEndian bool
+	offset    expr
+}
+
+func (e readIntNode) eval(c *evalContext) value {
+	offset := e.offset.eval(c)
+
+	if !offset.defined || offset.n < 0 || offset.n+int64(e.size) > int64(len(c.data)) {
+		return undefined
+	}
+
+	data := c.data[offset.n : offset.n+int64(e.size)]
+	var order binary.ByteOrder = binary.LittleEndian
+
+	if e.bigEndian {
+		order = binary.BigEndian
+	}
+
+	switch e.size {
+	case 1:
+		if e.signed {
+			return defined(int64(int8(data[0])))
+		}
+
+		return defined(int64(data[0]))
+	case 2:
+		if e.signed {
+			return defined(int64(int16(order.Uint16(data))))
+		}
+
+		return defined(int64(order.Uint16(data)))
+	default:
+		if e.signed {
+			return defined(int64(int32(order.Uint32(data))))
+		}
+
+		return defined(int64(order.Uint32(data)))
+	}
+}
+
+type unaryNode struct {
+	op string
+	x  expr
+}
+
+func (e unaryNode) eval(c *evalContext) value {
+	x := e.x.eval(c)
+
+	if !x.defined {
+		return undefined
+	}
+
+	switch e.op {
+	case "not":
+		return boolean(x.n == 0)
+	case "-":
+		return defined(-x.n)
+	default:
+		return defined(^x.n)
+	}
+}
+
+type binaryNode struct {
+	op   string
+	l, r expr
+}
+
+func (e binaryNode) eval(c *evalContext) value {
+	// and/or treat undefined as false, and don't evaluate more than they need to
+	switch e.op {
+	case "and":
+		return boolean(e.l.eval(c).isTrue() && e.r.eval(c).isTrue())
+	case "or":
+		return boolean(e.l.eval(c).isTrue() || e.r.eval(c).isTrue())
+	}
+
+	l := e.l.eval(c)
+	r := e.r.eval(c)
+
+	if !l.defined || !r.defined {
+		return undefined
+	}
+
+	switch e.op {
+	case "==":
+		return boolean(l.n == r.n)
+	case "!=":
+		return boolean(l.n != r.n)
+	case "<":
+		return boolean(l.n < r.n)
+	case "<=":
+		return boolean(l.n <= r.n)
+	case ">":
+		return boolean(l.n > r.n)
+	case ">=":
+		return boolean(l.n >= r.n)
+	case "+":
+		return defined(l.n + r.n)
+	case "-":
+		return defined(l.n - r.n)
+	case "*":
+		return defined(l.n * r.n)
+	case "\\":
+		if r.n == 0 {
+			return undefined
+		}
+
+		return defined(l.n / r.n)
+	case "%":
+		if r.n == 0 {
+			return undefined
+		}
+
+		return defined(l.n % r.n)
+	case "&":
+		return defined(l.n & r.n)
+	case "|":
+		return defined(l.n | r.n)
+	case "^":
+		return defined(l.n ^ r.n)
+	case "<<":
+		return defined(l.n << uint64(r.n&63))
+	default:
+		return defined(l.n >> uint64(r.n&63))
+	}
+}
+
+type quantifierKind int
+
+const (
+	quantifyAll quantifierKind = iota
+	quantifyAny
+	quantifyNone
+	quantifyCount
+)
+
+// quantifier is the "all", "any", "none" or number in "of" and "for" expressions
+type quantifier struct {
+	kind  quantifierKind
+	count expr
+}
+
+func (q quantifier) satisfied(c *evalContext, matched, total int) bool {
+	switch q.kind {
+	case quantifyAll:
+		return matched == total
+	case quantifyAny:
+		return matched > 0
+	case quantifyNone:
+		return matched == 0
+	default:
+		count := q.count.eval(c)
+		return count.defined && int64(matched) >= count.n
+	}
+}
+
+// any of ($a, $b*), 2 of them in (0..100), etc.
+type ofNode struct {
+	quantifier quantifier
+	strings    []*String
+	at         expr
+	inRange    *stringRange
+}
+
+func (e ofNode) eval(c *evalContext) value {
+	matched := 0
+
+	for _, s := range e.strings {
+		if (stringMatchNode{s: s, at: e.at, inRange: e.inRange}).eval(c).isTrue() {
+			matched++
+		}
+	}
+
+	return boolean(e.quantifier.satisfied(c, matched, len(e.strings)))
+}
+
+// for any of ($a, $b) : ( $ at 0 )
+type forOfNode struct {
+	quantifier quantifier
+	strings    []*String
+	body       expr
+}
+
+func (e forOfNode) eval(c *evalContext) value {
+	matched := 0
+
+	for _, s := range e.strings {
+		c.current = append(c.current, s)
+
+		if e.body.eval(c).isTrue() {
+			matched++
+		}
+
+		c.current = c.current[:len(c.current)-1]
+	}
+
+	return boolean(e.quantifier.satisfied(c, matched, len(e.strings)))
+}
+
+// the most iterations a "for ... in" loop can run, e.g. over (1..filesize)
+const maxLoopIterations = 1000000
+
+// for all i in (1..#a) : ( @a[i] < 100 )
+type forInNode struct {
+	quantifier quantifier
+	variable   string
+	inRange    *stringRange
+	values     []expr
+	body       expr
+}
+
+func (e forInNode) eval(c *evalContext) value {
+	var items []int64
+
+	if e.inRange != nil {
+		low := e.inRange.low.eval(c)
+		high := e.inRange.high.eval(c)
+
+		if !low.defined || !high.defined || high.n-low.n >= maxLoopIterations {
+			return undefined
+		}
+
+		for i := low.n; i <= high.n; i++ {
+			items = append(items, i)
+		}
+	} else {
+		for _, v := range e.values {
+			item := v.eval(c)
+
+			if item.defined {
+				items = append(items, item.n)
+			}
+		}
+	}
+
+	previous, shadowed := c.vars[e.variable]
+	matched := 0
+
+	for _, item := range items {
+		c.vars[e.variable] = item
+
+		if e.body.eval(c).isTrue() {
+			matched++
+		}
+	}
+
+	if shadowed {
+		c.vars[e.variable] = previous
+	} else {
+		delete(c.vars, e.variable)
+	}
+
+	return boolean(e.quantifier.satisfied(c, matched, len(items)))
+}
+
+type varNode struct {
+	name string
+}
+
+func (e varNode) eval(c *evalContext) value {
+	if n, ok := c.vars[e.name]; ok {
+		return defined(n)
+	}
+
+	return undefined
+}
+
+// a reference to an earlier rule, which is true if it matched
+type ruleRefNode struct {
+	rule *Rule
+}
+
+func (e ruleRefNode) eval(c *evalContext) value {
+	return boolean(c.rules[e.rule])
+}
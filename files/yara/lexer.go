@@ -0,0 +1,338 @@
+package yara
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenText
+
+	// string references, e.g. "$a", "#a", "@a" and "!a". The value is the name, without the sigil
+	tokenStringID
+	tokenStringCount
+	tokenStringOffset
+	tokenStringLength
+
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	n     int64
+	line  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of file"
+	case tokenText:
+		return strconv.Quote(t.value)
+	case tokenStringID:
+		return "$" + t.value
+	case tokenStringCount:
+		return "#" + t.value
+	case tokenStringOffset:
+		return "@" + t.value
+	case tokenStringLength:
+		return "!" + t.value
+	default:
+		return fmt.Sprintf("%q", t.value)
+	}
+}
+
+// lexer splits rule source into tokens. Hex strings and regular expressions depend on
+// where they are, so the parser asks for them directly
+type lexer struct {
+	source string
+	pos    int
+	line   int
+}
+
+func newLexer(source string) *lexer {
+	return &lexer{source: source, line: 1}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips white space and comments
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.source[l.pos:], "//"):
+			end := strings.IndexByte(l.source[l.pos:], '\n')
+
+			if end < 0 {
+				l.pos = len(l.source)
+			} else {
+				l.pos += end
+			}
+		case strings.HasPrefix(l.source[l.pos:], "/*"):
+			end := strings.Index(l.source[l.pos+2:], "*/")
+
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+
+			l.line += strings.Count(l.source[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// peek returns the next character after any space, or 0 at the end
+func (l *lexer) peek() (byte, error) {
+	if err := l.skipSpace(); err != nil {
+		return 0, err
+	}
+
+	if l.pos >= len(l.source) {
+		return 0, nil
+	}
+
+	return l.source[l.pos], nil
+}
+
+var punctuation = []string{
+	"..", "==", "!=", "<=", ">=", "<<", ">>",
+	"{", "}", "(", ")", "[", "]", ":", "=", ",", "<", ">", "+", "-", "*", "\\", "%", "&", "|", "^", "~",
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.source[l.pos]
+
+	switch {
+	case c == '"':
+		return l.readText()
+	case c == '$' || c == '#' || c == '@' || (c == '!' && !strings.HasPrefix(l.source[l.pos:], "!=")):
+		kinds := map[byte]tokenKind{'$': tokenStringID, '#': tokenStringCount, '@': tokenStringOffset, '!': tokenStringLength}
+		l.pos++
+		name := l.readWord()
+
+		// a "*" on the end of an ID is a wildcard in a set, e.g. "any of ($a*)"
+		if c == '$' && l.pos < len(l.source) && l.source[l.pos] == '*' {
+			name += "*"
+			l.pos++
+		}
+
+		return token{kind: kinds[c], value: name, line: l.line}, nil
+	case isDigit(c):
+		return l.readNumber()
+	case isWordChar(c):
+		word := l.readWord()
+
+		// module fields, e.g. "pe.number_of_sections"
+		for l.pos < len(l.source) && l.source[l.pos] == '.' && l.pos+1 < len(l.source) && isWordChar(l.source[l.pos+1]) {
+			l.pos++
+			word += "." + l.readWord()
+		}
+
+		return token{kind: tokenIdent, value: word, line: l.line}, nil
+	}
+
+	for _, p := range punctuation {
+		if strings.HasPrefix(l.source[l.pos:], p) {
+			l.pos += len(p)
+			return token{kind: tokenPunct, value: p, line: l.line}, nil
+		}
+	}
+
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+func (l *lexer) readWord() string {
+	start := l.pos
+
+	for l.pos < len(l.source) && isWordChar(l.source[l.pos]) {
+		l.pos++
+	}
+
+	return l.source[start:l.pos]
+}
+
+// numbers can be decimal, hex or octal, with an optional KB or MB multiplier
+func (l *lexer) readNumber() (token, error) {
+	word := l.readWord()
+	multiplier := int64(1)
+
+	if strings.HasSuffix(word, "KB") {
+		multiplier = 1024
+		word = strings.TrimSuffix(word, "KB")
+	} else if strings.HasSuffix(word, "MB") {
+		multiplier = 1024 * 1024
+		word = strings.TrimSuffix(word, "MB")
+	}
+
+	var n int64
+	var err error
+
+	switch {
+	case strings.HasPrefix(word, "0x"):
+		n, err = strconv.ParseInt(word[2:], 16, 64)
+	case strings.HasPrefix(word, "0o"):
+		n, err = strconv.ParseInt(word[2:], 8, 64)
+	default:
+		n, err = strconv.ParseInt(word, 10, 64)
+	}
+
+	if err != nil {
+		return token{}, l.errorf("invalid number %q", word)
+	}
+
+	return token{kind: tokenNumber, value: word, n: n * multiplier, line: l.line}, nil
+}
+
+// readText reads a quoted string, decoding the escapes YARA allows
+func (l *lexer) readText() (token, error) {
+	var text strings.Builder
+	l.pos++
+
+	for {
+		if l.pos >= len(l.source) || l.source[l.pos] == '\n' {
+			return token{}, l.errorf("unterminated string")
+		}
+
+		c := l.source[l.pos]
+		l.pos++
+
+		if c == '"' {
+			break
+		}
+
+		if c != '\\' {
+			text.WriteByte(c)
+			continue
+		}
+
+		if l.pos >= len(l.source) {
+			return token{}, l.errorf("unterminated string")
+		}
+
+		escape := l.source[l.pos]
+		l.pos++
+
+		switch escape {
+		case '"', '\\':
+			text.WriteByte(escape)
+		case 'n':
+			text.WriteByte('\n')
+		case 'r':
+			text.WriteByte('\r')
+		case 't':
+			text.WriteByte('\t')
+		case 'x':
+			if l.pos+2 > len(l.source) {
+				return token{}, l.errorf("invalid \\x escape")
+			}
+
+			b, err := strconv.ParseUint(l.source[l.pos:l.pos+2], 16, 8)
+
+			if err != nil {
+				return token{}, l.errorf("invalid \\x escape %q", l.source[l.pos:l.pos+2])
+			}
+
+			text.WriteByte(byte(b))
+			l.pos += 2
+		default:
+			return token{}, l.errorf("unknown escape \\%c", escape)
+		}
+	}
+
+	return token{kind: tokenText, value: text.String(), line: l.line}, nil
+}
+
+// readHex reads the body of a hex string, from "{" to "}"
+func (l *lexer) readHex() (string, error) {
+	start := l.pos + 1
+	end := strings.IndexByte(l.source[start:], '}')
+
+	if end < 0 {
+		return "", l.errorf("unterminated hex string")
+	}
+
+	body := l.source[start : start+end]
+	l.line += strings.Count(body, "\n")
+	l.pos = start + end + 1
+
+	return body, nil
+}
+
+// readRegex reads a regular expression, e.g. "/ab+c/is", returning the pattern and flags
+func (l *lexer) readRegex() (string, string, error) {
+	var pattern strings.Builder
+	l.pos++
+
+	for {
+		if l.pos >= len(l.source) || l.source[l.pos] == '\n' {
+			return "", "", l.errorf("unterminated regular expression")
+		}
+
+		c := l.source[l.pos]
+		l.pos++
+
+		if c == '/' {
+			break
+		}
+
+		// escaped slashes are part of the pattern, other escapes are left for the regexp package
+		if c == '\\' && l.pos < len(l.source) {
+			if l.source[l.pos] == '/' {
+				pattern.WriteByte('/')
+			} else {
+				pattern.WriteByte('\\')
+				pattern.WriteByte(l.source[l.pos])
+			}
+
+			l.pos++
+			continue
+		}
+
+		pattern.WriteByte(c)
+	}
+
+	flags := ""
+
+	for l.pos < len(l.source) && (l.source[l.pos] == 'i' || l.source[l.pos] == 's') {
+		flags += string(l.source[l.pos])
+		l.pos++
+	}
+
+	return pattern.String(), flags, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,1101 @@
+package yara
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// how deep includes can nest, so a file can't include itself forever
+const maxIncludeDepth = 8
+
+// the integer functions conditions can use, e.g. uint16(0) or int32be(4)
+var readFunctions = map[string]readIntNode{
+	"uint8": {size: 1}, "uint16": {size: 2}, "uint32": {size: 4},
+	"int8": {size: 1, signed: true}, "int16": {size: 2, signed: true}, "int32": {size: 4, signed: true},
+	"uint8be": {size: 1, bigEndian: true}, "uint16be": {size: 2, bigEndian: true}, "uint32be": {size: 4, bigEndian: true},
+	"int8be": {size: 1, signed: true, bigEndian: true}, "int16be": {size: 2, signed: true, bigEndian: true},
+	"int32be": {size: 4, signed: true, bigEndian: true},
+}
+
+// binary operators from the loosest binding to the tightest, after "and" and "or"
+var operatorLevels = [][]string{
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "\\", "%"},
+}
+
+type parser struct {
+	lex   *lexer
+	tok   token
+	rules *Rules
+
+	// the file being parsed, for includes, and how deeply it's included
+	path  string
+	depth int
+
+	// the rule being parsed and the variables of any "for ... in" loops around the expression
+	rule *Rule
+	vars []string
+
+	// "for ... of" loops around the expression, where "$" means the current string
+	loops int
+
+	// how often the rule's condition refers to each string, and the offsets of those that
+	// are "$a at <number>"
+	refs      map[*String]int
+	atOffsets map[*String][]int64
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+
+	if err != nil {
+		return err
+	}
+
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) is(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) isKeyword(value string) bool {
+	return p.is(tokenIdent, value)
+}
+
+func (p *parser) isPunct(value string) bool {
+	return p.is(tokenPunct, value)
+}
+
+// expect checks the current token is the punctuation or keyword, and moves past it
+func (p *parser) expect(kind tokenKind, value string) error {
+	if !p.is(kind, value) {
+		return p.errorf("expected %q, found %s", value, p.tok)
+	}
+
+	return p.advance()
+}
+
+func (p *parser) parseFile() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	for p.tok.kind != tokenEOF {
+		var err error
+
+		switch {
+		case p.isKeyword("import"):
+			err = p.parseImport()
+		case p.isKeyword("include"):
+			err = p.parseInclude()
+		default:
+			err = p.parseRule()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// imports are allowed so rules that only use them in some conditions still load, using a
+// module in a condition is an error
+func (p *parser) parseImport() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	if p.tok.kind != tokenText {
+		return p.errorf("expected a module name after import, found %s", p.tok)
+	}
+
+	return p.advance()
+}
+
+func (p *parser) parseInclude() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	if p.tok.kind != tokenText {
+		return p.errorf("expected a file name after include, found %s", p.tok)
+	}
+
+	if p.depth >= maxIncludeDepth {
+		return p.errorf("includes are nested too deeply")
+	}
+
+	path := p.tok.value
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.path), path)
+	}
+
+	source, err := os.ReadFile(path)
+
+	if err != nil {
+		return p.errorf("error reading include: %s", err.Error())
+	}
+
+	included := parser{lex: newLexer(string(source)), rules: p.rules, path: path, depth: p.depth + 1}
+
+	if err := included.parseFile(); err != nil {
+		return fmt.Errorf("%s: %s", filepath.Base(path), err.Error())
+	}
+
+	return p.advance()
+}
+
+func (p *parser) parseRule() error {
+	rule := &Rule{}
+
+	for {
+		if p.isKeyword("private") {
+			rule.Private = true
+		} else if p.isKeyword("global") {
+			rule.Global = true
+		} else {
+			break
+		}
+
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.expect(tokenIdent, "rule"); err != nil {
+		return err
+	}
+
+	if p.tok.kind != tokenIdent {
+		return p.errorf("expected a rule name, found %s", p.tok)
+	}
+
+	rule.Name = p.tok.value
+
+	if p.rules.byName[rule.Name] != nil {
+		return p.errorf("duplicate rule %q", rule.Name)
+	}
+
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	if p.isPunct(":") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		for p.tok.kind == tokenIdent {
+			rule.Tags = append(rule.Tags, p.tok.value)
+
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := p.expect(tokenPunct, "{"); err != nil {
+		return err
+	}
+
+	p.rule = rule
+	p.refs = make(map[*String]int)
+	p.atOffsets = make(map[*String][]int64)
+	defer func() { p.rule = nil }()
+
+	if p.isKeyword("meta") {
+		if err := p.parseMeta(); err != nil {
+			return err
+		}
+	}
+
+	if p.isKeyword("strings") {
+		if err := p.parseStrings(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.expect(tokenIdent, "condition"); err != nil {
+		return err
+	}
+
+	if err := p.expect(tokenPunct, ":"); err != nil {
+		return err
+	}
+
+	condition, err := p.parseExpr()
+
+	if err != nil {
+		return err
+	}
+
+	rule.condition = condition
+
+	if err := p.checkStringRefs(); err != nil {
+		return err
+	}
+
+	if err := p.expect(tokenPunct, "}"); err != nil {
+		return err
+	}
+
+	p.rules.add(rule)
+
+	return nil
+}
+
+// checkStringRefs makes sure the condition uses every string, as YARA does unless they start
+// with an underscore. Strings only ever used at the same fixed offset are only looked for
+// there, which YARA also does, so only that match is reported
+func (p *parser) checkStringRefs() error {
+	for _, s := range p.rule.Strings {
+		if p.refs[s] == 0 && !strings.HasPrefix(s.ID, "_") {
+			return p.errorf("unreferenced string $%s", s.ID)
+		}
+
+		offsets := p.atOffsets[s]
+
+		if len(offsets) == 0 || len(offsets) != p.refs[s] {
+			continue
+		}
+
+		s.fixed = true
+		s.offset = offsets[0]
+
+		for _, offset := range offsets {
+			if offset != s.offset {
+				s.fixed = false
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) parseMeta() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	if err := p.expect(tokenPunct, ":"); err != nil {
+		return err
+	}
+
+	for p.tok.kind == tokenIdent && !p.isKeyword("strings") && !p.isKeyword("condition") {
+		key := p.tok.value
+
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		if err := p.expect(tokenPunct, "="); err != nil {
+			return err
+		}
+
+		negative := false
+
+		if p.isPunct("-") {
+			negative = true
+
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+
+		var meta Meta
+
+		switch {
+		case p.tok.kind == tokenText:
+			meta = Meta{Key: key, Value: p.tok.value}
+		case p.tok.kind == tokenNumber && negative:
+			meta = Meta{Key: key, Value: fmt.Sprint(-p.tok.n)}
+		case p.tok.kind == tokenNumber:
+			meta = Meta{Key: key, Value: fmt.Sprint(p.tok.n)}
+		case p.isKeyword("true") || p.isKeyword("false"):
+			meta = Meta{Key: key, Value: p.tok.value}
+		default:
+			return p.errorf("invalid value for meta %q: %s", key, p.tok)
+		}
+
+		p.rule.Meta = append(p.rule.Meta, meta)
+
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) parseStrings() error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	if !p.isPunct(":") {
+		return p.errorf("expected \":\", found %s", p.tok)
+	}
+
+	if err := p.advance(); err != nil {
+		return err
+	}
+
+	for p.tok.kind == tokenStringID {
+		id := p.tok.value
+
+		if strings.HasSuffix(id, "*") {
+			return p.errorf("invalid string name $%s", id)
+		}
+
+		if id != "" && p.rule.findString(id) != nil {
+			return p.errorf("duplicate string $%s", id)
+		}
+
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		// the lexer is now just past the "=", so hex strings and regular expressions
+		// can be read directly
+		if !p.isPunct("=") {
+			return p.errorf("expected \"=\" after $%s, found %s", id, p.tok)
+		}
+
+		c, err := p.lex.peek()
+
+		if err != nil {
+			return err
+		}
+
+		var build func(modifiers) (matcher, error)
+
+		switch c {
+		case '{':
+			body, err := p.lex.readHex()
+
+			if err != nil {
+				return err
+			}
+
+			build = func(mods modifiers) (matcher, error) {
+				if mods.nocase || mods.wide || mods.ascii || mods.fullword {
+					return nil, fmt.Errorf("hex strings only support the private modifier")
+				}
+
+				return newHexMatcher(body)
+			}
+		case '/':
+			pattern, flags, err := p.lex.readRegex()
+
+			if err != nil {
+				return err
+			}
+
+			build = func(mods modifiers) (matcher, error) {
+				return newRegexMatcher(pattern, flags, mods)
+			}
+		default:
+			if err := p.advance(); err != nil {
+				return err
+			}
+
+			if p.tok.kind != tokenText {
+				return p.errorf("expected a string, hex string or regular expression for $%s, found %s", id, p.tok)
+			}
+
+			text := p.tok.value
+
+			build = func(mods modifiers) (matcher, error) {
+				return newTextMatcher(text, mods)
+			}
+		}
+
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		mods, err := p.parseModifiers()
+
+		if err != nil {
+			return err
+		}
+
+		m, err := build(mods)
+
+		if err != nil {
+			return p.errorf("$%s: %s", id, err.Error())
+		}
+
+		p.rule.Strings = append(p.rule.Strings, &String{ID: id, Private: mods.private, matcher: m})
+	}
+
+	return nil
+}
+
+func (p *parser) parseModifiers() (modifiers, error) {
+	var mods modifiers
+
+	for p.tok.kind == tokenIdent && !p.isKeyword("condition") {
+		switch p.tok.value {
+		case "nocase":
+			mods.nocase = true
+		case "wide":
+			mods.wide = true
+		case "ascii":
+			mods.ascii = true
+		case "fullword":
+			mods.fullword = true
+		case "private":
+			mods.private = true
+		default:
+			return mods, p.errorf("the %s modifier isn't supported", p.tok.value)
+		}
+
+		if err := p.advance(); err != nil {
+			return mods, err
+		}
+	}
+
+	return mods, nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseBoolean("or")
+}
+
+// parseBoolean parses "or", then "and" inside it
+func (p *parser) parseBoolean(op string) (expr, error) {
+	next := func() (expr, error) {
+		if op == "or" {
+			return p.parseBoolean("and")
+		}
+
+		return p.parseNot()
+	}
+
+	left, err := next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword(op) {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		right, err := next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: op, l: left, r: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if !p.isKeyword("not") {
+		return p.parseBinary(0)
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	x, err := p.parseNot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return unaryNode{op: "not", x: x}, nil
+}
+
+// parseBinary parses the operators from the level in operatorLevels down
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(operatorLevels) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokenPunct && contains(operatorLevels[level], p.tok.value) {
+		op := p.tok.value
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		right, err := p.parseBinary(level + 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: op, l: left, r: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.isPunct("-") || p.isPunct("~") {
+		op := p.tok.value
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		x, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return unaryNode{op: op, x: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokenNumber:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.isKeyword("of") {
+			return p.parseOf(quantifier{kind: quantifyCount, count: constant{defined(tok.n)}})
+		}
+
+		return constant{defined(tok.n)}, nil
+	case tokenText:
+		return nil, p.errorf("strings can only be used in meta and strings sections, found %s", tok)
+	case tokenStringID:
+		return p.parseStringMatch()
+	case tokenStringCount:
+		s, err := p.stringRef(tok)
+
+		if err != nil {
+			return nil, err
+		}
+
+		inRange, err := p.parseOptionalRange()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return stringCountNode{s: s, inRange: inRange}, nil
+	case tokenStringOffset, tokenStringLength:
+		s, err := p.stringRef(tok)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// "@a" on its own is the first match
+		var index expr = constant{defined(1)}
+
+		if p.isPunct("[") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+
+			if index, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+
+			if err := p.expect(tokenPunct, "]"); err != nil {
+				return nil, err
+			}
+		}
+
+		return stringOffsetNode{s: s, index: index, length: tok.kind == tokenStringLength}, nil
+	case tokenPunct:
+		if tok.value != "(" {
+			return nil, p.errorf("unexpected %s", tok)
+		}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		x, err := p.parseExpr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokenPunct, ")"); err != nil {
+			return nil, err
+		}
+
+		return x, nil
+	case tokenIdent:
+		return p.parseIdent()
+	}
+
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+func (p *parser) parseIdent() (expr, error) {
+	tok := p.tok
+
+	switch tok.value {
+	case "true", "false":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		return constant{boolean(tok.value == "true")}, nil
+	case "filesize":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		return filesizeNode{}, nil
+	case "all", "any", "none":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		return p.parseOf(quantifier{kind: map[string]quantifierKind{"all": quantifyAll, "any": quantifyAny, "none": quantifyNone}[tok.value]})
+	case "for":
+		return p.parseFor()
+	case "entrypoint":
+		return nil, p.errorf("entrypoint isn't supported")
+	}
+
+	if read, ok := readFunctions[tok.value]; ok {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokenPunct, "("); err != nil {
+			return nil, err
+		}
+
+		offset, err := p.parseExpr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokenPunct, ")"); err != nil {
+			return nil, err
+		}
+
+		read.offset = offset
+		return read, nil
+	}
+
+	if strings.Contains(tok.value, ".") {
+		return nil, p.errorf("%s: modules aren't supported", tok.value)
+	}
+
+	if contains(p.vars, tok.value) {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.isKeyword("of") {
+			return p.parseOf(quantifier{kind: quantifyCount, count: varNode{name: tok.value}})
+		}
+
+		return varNode{name: tok.value}, nil
+	}
+
+	if rule := p.rules.byName[tok.value]; rule != nil {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		return ruleRefNode{rule: rule}, nil
+	}
+
+	return nil, p.errorf("unknown identifier %q", tok.value)
+}
+
+// $a, $a at 100 or $a in (0..100)
+func (p *parser) parseStringMatch() (expr, error) {
+	s, err := p.stringRef(p.tok)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node := stringMatchNode{s: s}
+
+	if p.isKeyword("at") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if node.at, err = p.parseBinary(len(operatorLevels) - 2); err != nil {
+			return nil, err
+		}
+
+		if offset, ok := node.at.(constant); ok && s != nil && offset.v.defined {
+			p.atOffsets[s] = append(p.atOffsets[s], offset.v.n)
+		}
+
+		return node, nil
+	}
+
+	node.inRange, err = p.parseOptionalRange()
+
+	return node, err
+}
+
+// stringRef finds the string a "$a", "#a", "@a" or "!a" refers to, and moves past it.
+// Inside "for ... of" loops the bare sigil means the current string, which is nil here
+func (p *parser) stringRef(tok token) (*String, error) {
+	if tok.value == "" {
+		if p.loops == 0 {
+			return nil, p.errorf("%s can only be used inside a \"for ... of\" loop", tok)
+		}
+
+		return nil, p.advance()
+	}
+
+	s := p.rule.findString(tok.value)
+
+	if s == nil {
+		return nil, p.errorf("undefined string %s", tok)
+	}
+
+	p.refs[s]++
+
+	return s, p.advance()
+}
+
+// parseOptionalRange parses "in (low..high)" if it's next
+func (p *parser) parseOptionalRange() (*stringRange, error) {
+	if !p.isKeyword("in") {
+		return nil, nil
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	return p.parseRange()
+}
+
+// parseRange parses "(low..high)"
+func (p *parser) parseRange() (*stringRange, error) {
+	if err := p.expect(tokenPunct, "("); err != nil {
+		return nil, err
+	}
+
+	low, err := p.parseBinary(1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(tokenPunct, ".."); err != nil {
+		return nil, err
+	}
+
+	high, err := p.parseBinary(1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(tokenPunct, ")"); err != nil {
+		return nil, err
+	}
+
+	return &stringRange{low: low, high: high}, nil
+}
+
+// parseOf parses the rest of "any of them", "2 of ($a*) in (0..100)", etc.
+func (p *parser) parseOf(q quantifier) (expr, error) {
+	if err := p.expect(tokenIdent, "of"); err != nil {
+		return nil, err
+	}
+
+	set, err := p.parseStringSet()
+
+	if err != nil {
+		return nil, err
+	}
+
+	node := ofNode{quantifier: q, strings: set}
+
+	if p.isKeyword("at") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if node.at, err = p.parseBinary(len(operatorLevels) - 2); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+	}
+
+	node.inRange, err = p.parseOptionalRange()
+
+	return node, err
+}
+
+// parseStringSet parses "them" or a list of strings, which can end in a wildcard
+func (p *parser) parseStringSet() ([]*String, error) {
+	if p.isKeyword("them") {
+		if len(p.rule.Strings) == 0 {
+			return nil, p.errorf("\"them\" used in a rule with no strings")
+		}
+
+		for _, s := range p.rule.Strings {
+			p.refs[s]++
+		}
+
+		return p.rule.Strings, p.advance()
+	}
+
+	if err := p.expect(tokenPunct, "("); err != nil {
+		return nil, err
+	}
+
+	var set []*String
+
+	for {
+		if p.tok.kind != tokenStringID {
+			return nil, p.errorf("expected a string in the set, found %s", p.tok)
+		}
+
+		var found []*String
+
+		if prefix, wildcard := strings.CutSuffix(p.tok.value, "*"); wildcard {
+			for _, s := range p.rule.Strings {
+				if s.ID != "" && strings.HasPrefix(s.ID, prefix) {
+					found = append(found, s)
+				}
+			}
+		} else if s := p.rule.findString(p.tok.value); s != nil {
+			found = append(found, s)
+		}
+
+		if len(found) == 0 {
+			return nil, p.errorf("no strings match %s", p.tok)
+		}
+
+		set = append(set, found...)
+
+		for _, s := range found {
+			p.refs[s]++
+		}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.isPunct(")") {
+			return set, p.advance()
+		}
+
+		if err := p.expect(tokenPunct, ","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseFor parses "for <quantifier> of <set> : ( ... )" and "for <quantifier> i in <range> : ( ... )"
+func (p *parser) parseFor() (expr, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var q quantifier
+
+	switch {
+	case p.isKeyword("all"):
+		q.kind = quantifyAll
+	case p.isKeyword("any"):
+		q.kind = quantifyAny
+	case p.isKeyword("none"):
+		q.kind = quantifyNone
+	case p.tok.kind == tokenNumber:
+		// parsed here, as a number followed by "of" is otherwise an "of" expression
+		q = quantifier{kind: quantifyCount, count: constant{defined(p.tok.n)}}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	default:
+		count, err := p.parseBinary(1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		q = quantifier{kind: quantifyCount, count: count}
+	}
+
+	if q.kind != quantifyCount {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isKeyword("of") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		set, err := p.parseStringSet()
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.loops++
+		body, err := p.parseLoopBody()
+		p.loops--
+
+		if err != nil {
+			return nil, err
+		}
+
+		return forOfNode{quantifier: q, strings: set, body: body}, nil
+	}
+
+	if p.tok.kind != tokenIdent {
+		return nil, p.errorf("expected \"of\" or a variable after \"for\", found %s", p.tok)
+	}
+
+	node := forInNode{quantifier: q, variable: p.tok.value}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(tokenIdent, "in"); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseLoopItems(&node); err != nil {
+		return nil, err
+	}
+
+	p.vars = append(p.vars, node.variable)
+	body, err := p.parseLoopBody()
+	p.vars = p.vars[:len(p.vars)-1]
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.body = body
+
+	return node, nil
+}
+
+// parseLoopItems parses the range "(1..#a)" or list "(1, 2, 3)" a "for ... in" loop runs over
+func (p *parser) parseLoopItems(node *forInNode) error {
+	if err := p.expect(tokenPunct, "("); err != nil {
+		return err
+	}
+
+	first, err := p.parseBinary(1)
+
+	if err != nil {
+		return err
+	}
+
+	if p.isPunct("..") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		high, err := p.parseBinary(1)
+
+		if err != nil {
+			return err
+		}
+
+		node.inRange = &stringRange{low: first, high: high}
+
+		return p.expect(tokenPunct, ")")
+	}
+
+	node.values = []expr{first}
+
+	for p.isPunct(",") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+
+		item, err := p.parseBinary(1)
+
+		if err != nil {
+			return err
+		}
+
+		node.values = append(node.values, item)
+	}
+
+	return p.expect(tokenPunct, ")")
+}
+
+func (p *parser) parseLoopBody() (expr, error) {
+	if err := p.expect(tokenPunct, ":"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(tokenPunct, "("); err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseExpr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(tokenPunct, ")"); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,268 @@
+// Package yara is a pure Go scanner for YARA rules. It supports text, hex and regular
+// expression strings, and conditions using string matches, counts, offsets, lengths,
+// filesize, the integer functions and "of"/"for" expressions. Modules aren't supported
+package yara
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the most of each string match we keep, for showing in findings
+const maxMatchData = 64
+
+// Rules is a set of compiled rules
+type Rules struct {
+	rules  []*Rule
+	byName map[string]*Rule
+}
+
+// Rule is one compiled rule
+type Rule struct {
+	Name    string
+	Tags    []string
+	Meta    []Meta
+	Strings []*String
+
+	// private rules can be used by other rules but aren't reported, and global rules
+	// have to match for any other rule to
+	Private bool
+	Global  bool
+
+	condition expr
+}
+
+// Meta is a key and value from a rule's meta section
+type Meta struct {
+	Key   string
+	Value string
+}
+
+// Match is a rule that matched, with where its strings were found
+type Match struct {
+	Rule    string
+	Tags    []string
+	Meta    []Meta
+	Strings []StringMatch
+}
+
+// MetaValue returns the first meta value with the key, or "" if there isn't one
+func (m Match) MetaValue(key string) string {
+	for _, meta := range m.Meta {
+		if meta.Key == key {
+			return meta.Value
+		}
+	}
+
+	return ""
+}
+
+// NewRules returns an empty set of rules
+func NewRules() *Rules {
+	return &Rules{byName: make(map[string]*Rule)}
+}
+
+// Compile compiles rules from source
+func Compile(source string) (*Rules, error) {
+	rules := NewRules()
+
+	if err := rules.AddSource(source, ""); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// AddSource adds the rules in the source. The path is used to find included files, and can
+// be empty. Rule names have to be unique across everything added
+func (r *Rules) AddSource(source, path string) error {
+	// parse into a copy so a file with an error doesn't leave half its rules behind
+	added := &Rules{rules: append([]*Rule{}, r.rules...), byName: make(map[string]*Rule, len(r.byName))}
+
+	for name, rule := range r.byName {
+		added.byName[name] = rule
+	}
+
+	p := parser{lex: newLexer(source), rules: added, path: path}
+
+	if err := p.parseFile(); err != nil {
+		return err
+	}
+
+	*r = *added
+	return nil
+}
+
+// AddFile adds the rules in a file
+func (r *Rules) AddFile(path string) error {
+	source, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := r.AddSource(string(source), path); err != nil {
+		return fmt.Errorf("%s: %s", filepath.Base(path), err.Error())
+	}
+
+	return nil
+}
+
+// LoadDir loads every .yar and .yara file under the directory. Files with errors are
+// skipped, so the rules returned are still usable when the error isn't nil
+func LoadDir(dir string) (*Rules, error) {
+	rules := NewRules()
+	var errs []error
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		extension := strings.ToLower(filepath.Ext(path))
+
+		if entry.IsDir() || (extension != ".yar" && extension != ".yara") {
+			return nil
+		}
+
+		if err := rules.AddFile(path); err != nil {
+			errs = append(errs, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return rules, err
+	}
+
+	return rules, errors.Join(errs...)
+}
+
+// Len returns the number of rules
+func (r *Rules) Len() int {
+	return len(r.rules)
+}
+
+func (r *Rules) add(rule *Rule) {
+	r.rules = append(r.rules, rule)
+	r.byName[rule.Name] = rule
+}
+
+func (r *Rule) findString(id string) *String {
+	for _, s := range r.Strings {
+		if s.ID == id {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// matchesAt returns the match that starts at the offset, if there is one
+func matchesAt(matches [][2]int, offset int64) [][2]int {
+	for _, m := range matches {
+		if int64(m[0]) == offset {
+			return [][2]int{m}
+		}
+	}
+
+	return nil
+}
+
+// scanner is the data being scanned, with a lower case copy made the first time a
+// nocase string needs it
+type scanner struct {
+	data       []byte
+	foldedData []byte
+}
+
+func (s *scanner) folded() []byte {
+	if s.foldedData == nil {
+		s.foldedData = foldASCII(s.data)
+	}
+
+	return s.foldedData
+}
+
+// Scan runs the rules against the data, returning the rules that matched in the order
+// they were defined. The context stops the scan, returning its error
+func (r *Rules) Scan(ctx context.Context, data []byte) ([]Match, error) {
+	s := scanner{data: data}
+	c := evalContext{
+		data:    data,
+		matches: make(map[*String][][2]int),
+		rules:   make(map[*Rule]bool),
+		vars:    make(map[string]int64),
+	}
+
+	for _, rule := range r.rules {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		for _, str := range rule.Strings {
+			c.matches[str] = str.matcher.find(ctx, &s, maxMatchesPerString)
+
+			if str.fixed {
+				c.matches[str] = matchesAt(c.matches[str], str.offset)
+			}
+
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	globalsMatched := true
+
+	for _, rule := range r.rules {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		c.rules[rule] = rule.condition.eval(&c).isTrue()
+
+		if rule.Global && !c.rules[rule] {
+			globalsMatched = false
+		}
+	}
+
+	if !globalsMatched {
+		return nil, nil
+	}
+
+	var matches []Match
+
+	for _, rule := range r.rules {
+		if !c.rules[rule] || rule.Private {
+			continue
+		}
+
+		match := Match{Rule: rule.Name, Tags: rule.Tags, Meta: rule.Meta}
+
+		for _, str := range rule.Strings {
+			if str.Private {
+				continue
+			}
+
+			for _, m := range c.matches[str] {
+				end := min(m[1], m[0]+maxMatchData)
+				match.Strings = append(match.Strings, StringMatch{
+					ID:     "$" + str.ID,
+					Offset: int64(m[0]),
+					Data:   append([]byte{}, data[m[0]:end]...),
+				})
+			}
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,326 @@
+package yara
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describe formats the matches like "yara -s -g" does, without the file name or the
+// matched data, e.g. "rule [tag]" then "0x3:$a" for each string match
+func describe(matches []Match) []string {
+	var lines []string
+
+	for _, match := range matches {
+		lines = append(lines, fmt.Sprintf("%s [%s]", match.Rule, strings.Join(match.Tags, ",")))
+
+		for _, s := range match.Strings {
+			lines = append(lines, fmt.Sprintf("0x%x:%s", s.Offset, s.ID))
+		}
+	}
+
+	return lines
+}
+
+// the wanted matches are what YARA 4.5.2 reports for the same rules and data
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		data  string
+		want  []string
+	}{
+		{
+			name:  "text",
+			rules: `rule t { strings: $a = "evil" condition: $a }`,
+			data:  "an evil file, evil",
+			want:  []string{"t []", "0x3:$a", "0xe:$a"},
+		},
+		{
+			name:  "text no match",
+			rules: `rule t { strings: $a = "evil" condition: $a }`,
+			data:  "an EVIL file",
+			want:  nil,
+		},
+		{
+			name:  "nocase",
+			rules: `rule t { strings: $a = "evil" nocase condition: $a }`,
+			data:  "an EVIL file, eViL",
+			want:  []string{"t []", "0x3:$a", "0xe:$a"},
+		},
+		{
+			name:  "wide",
+			rules: `rule t { strings: $a = "cmd" wide condition: $a }`,
+			data:  "cmd c\x00m\x00d\x00",
+			want:  []string{"t []", "0x4:$a"},
+		},
+		{
+			name:  "wide ascii",
+			rules: `rule t { strings: $a = "cmd" wide ascii condition: $a }`,
+			data:  "cmd c\x00m\x00d\x00",
+			want:  []string{"t []", "0x0:$a", "0x4:$a"},
+		},
+		{
+			name:  "wide nocase",
+			rules: `rule t { strings: $a = "cmd" wide nocase condition: $a }`,
+			data:  "C\x00m\x00D\x00",
+			want:  []string{"t []", "0x0:$a"},
+		},
+		{
+			name:  "fullword",
+			rules: `rule t { strings: $a = "exec" fullword condition: $a }`,
+			data:  "execute exec; _exec (exec)",
+			want:  []string{"t []", "0x8:$a", "0xf:$a", "0x15:$a"},
+		},
+		{
+			name:  "fullword wide",
+			rules: `rule t { strings: $a = "ab" wide fullword condition: $a }`,
+			data:  "a\x00b\x00c\x00 a\x00b\x00 \x00",
+			want:  []string{"t []", "0x7:$a"},
+		},
+		{
+			name:  "hex",
+			rules: `rule t { strings: $a = { 4D 5A 90 00 } condition: $a }`,
+			data:  "xxMZ\x90\x00yy",
+			want:  []string{"t []", "0x2:$a"},
+		},
+		{
+			name:  "hex wildcards",
+			rules: `rule t { strings: $a = { 4D ?? 90 0? } condition: $a }`,
+			data:  "MZ\x90\x0f MQ\x90\x01 MZ\x91\x00",
+			want:  []string{"t []", "0x0:$a", "0x5:$a"},
+		},
+		{
+			name:  "hex jump",
+			rules: `rule t { strings: $a = { 41 [2-4] 42 } condition: $a }`,
+			data:  "A12B A1B A1234B A12345B",
+			want:  []string{"t []", "0x0:$a", "0x9:$a"},
+		},
+		{
+			name:  "hex fixed jump",
+			rules: `rule t { strings: $a = { 41 [3] 42 } condition: $a }`,
+			data:  "A12B A123B",
+			want:  []string{"t []", "0x5:$a"},
+		},
+		{
+			name:  "hex alternation",
+			rules: `rule t { strings: $a = { 41 ( 42 | 43 44 ) 45 } condition: $a }`,
+			data:  "ABE ACDE ACE ADE",
+			want:  []string{"t []", "0x0:$a", "0x4:$a"},
+		},
+		{
+			name:  "hex alternation wildcard",
+			rules: `rule t { strings: $a = { 41 ( 42 | ?? 44 ) } condition: $a }`,
+			data:  "AB AxD AxE",
+			want:  []string{"t []", "0x0:$a", "0x3:$a"},
+		},
+		{
+			name:  "regex",
+			rules: `rule t { strings: $a = /[0-9]{3}-[0-9]{4}/ condition: $a }`,
+			data:  "call 555-1234 or 555-12",
+			want:  []string{"t []", "0x5:$a"},
+		},
+		{
+			name:  "regex nocase",
+			rules: `rule t { strings: $a = /power(shell)?\.exe/i condition: $a }`,
+			data:  "POWERSHELL.EXE power.exe",
+			want:  []string{"t []", "0x0:$a", "0xf:$a"},
+		},
+		{
+			name:  "regex anchored",
+			rules: `rule t { strings: $a = /^MZ/ condition: $a }`,
+			data:  "MZ exec MZ_executable",
+			want:  []string{"t []", "0x0:$a"},
+		},
+		{
+			name:  "regex word boundaries",
+			rules: `rule t { strings: $a = /\bexec\b/ condition: $a }`,
+			data:  "MZ exec MZ_executable",
+			want:  []string{"t []", "0x3:$a"},
+		},
+		{
+			name:  "count",
+			rules: `rule t { strings: $a = "ab" condition: #a == 3 }`,
+			data:  "ab ab ab",
+			want:  []string{"t []", "0x0:$a", "0x3:$a", "0x6:$a"},
+		},
+		{
+			name:  "count too few",
+			rules: `rule t { strings: $a = "ab" condition: #a > 3 }`,
+			data:  "ab ab ab",
+			want:  nil,
+		},
+		{
+			name:  "offset",
+			rules: `rule t { strings: $a = "ab" condition: @a[2] == 3 }`,
+			data:  "ab ab ab",
+			want:  []string{"t []", "0x0:$a", "0x3:$a", "0x6:$a"},
+		},
+		{
+			name:  "offset first",
+			rules: `rule t { strings: $a = "ab" condition: @a[1] == 0 and @a[3] == 6 }`,
+			data:  "ab ab ab",
+			want:  []string{"t []", "0x0:$a", "0x3:$a", "0x6:$a"},
+		},
+		{
+			name:  "at",
+			rules: `rule t { strings: $a = "MZ" condition: $a at 0 }`,
+			data:  "MZ MZ",
+			want:  []string{"t []", "0x0:$a"},
+		},
+		{
+			name:  "at wrong offset",
+			rules: `rule t { strings: $a = "MZ" condition: $a at 1 }`,
+			data:  "MZ MZ",
+			want:  nil,
+		},
+		{
+			name:  "in range",
+			rules: `rule t { strings: $a = "MZ" condition: $a in (1..filesize) }`,
+			data:  "MZ MZ",
+			want:  []string{"t []", "0x0:$a", "0x3:$a"},
+		},
+		{
+			name:  "any of",
+			rules: `rule t { strings: $a = "one" $b = "two" $c = "three" condition: any of them }`,
+			data:  "two",
+			want:  []string{"t []", "0x0:$b"},
+		},
+		{
+			name:  "any of none",
+			rules: `rule t { strings: $a = "one" $b = "two" condition: any of them }`,
+			data:  "four",
+			want:  nil,
+		},
+		{
+			name:  "all of",
+			rules: `rule t { strings: $a = "one" $b = "two" condition: all of them }`,
+			data:  "one two",
+			want:  []string{"t []", "0x0:$a", "0x4:$b"},
+		},
+		{
+			name:  "all of missing",
+			rules: `rule t { strings: $a = "one" $b = "two" condition: all of them }`,
+			data:  "one",
+			want:  nil,
+		},
+		{
+			name:  "2 of them",
+			rules: `rule t { strings: $a = "one" $b = "two" $c = "three" condition: 2 of them }`,
+			data:  "one three",
+			want:  []string{"t []", "0x0:$a", "0x4:$c"},
+		},
+		{
+			name:  "2 of them one",
+			rules: `rule t { strings: $a = "one" $b = "two" $c = "three" condition: 2 of them }`,
+			data:  "three",
+			want:  nil,
+		},
+		{
+			name:  "of wildcard set",
+			rules: `rule t { strings: $x1 = "one" $x2 = "two" $y = "three" condition: 2 of ($x*) and not $y }`,
+			data:  "one two",
+			want:  []string{"t []", "0x0:$x1", "0x4:$x2"},
+		},
+		{
+			name:  "filesize",
+			rules: `rule t { condition: filesize < 10 }`,
+			data:  "short",
+			want:  []string{"t []"},
+		},
+		{
+			name:  "uint16",
+			rules: `rule t { condition: uint16(0) == 0x5A4D }`,
+			data:  "MZ\x90\x00",
+			want:  []string{"t []"},
+		},
+		{
+			name:  "private string",
+			rules: `rule t { strings: $a = "one" private $b = "two" condition: $a and $b }`,
+			data:  "one two",
+			want:  []string{"t []", "0x4:$b"},
+		},
+		{
+			name:  "private rule",
+			rules: `private rule p { strings: $a = "one" condition: $a } rule t { condition: p }`,
+			data:  "one",
+			want:  []string{"t []"},
+		},
+		{
+			name:  "tags",
+			rules: `rule t : tag1 tag2 { strings: $a = "one" condition: $a }`,
+			data:  "one",
+			want:  []string{"t [tag1,tag2]", "0x0:$a"},
+		},
+		{
+			name:  "unreferenced underscore string",
+			rules: `rule t { strings: $a = "_" $_b = "x" condition: $a }`,
+			data:  "MZ exec MZ_executable",
+			want:  []string{"t []", "0xa:$a", "0x4:$_b", "0xc:$_b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := Compile(test.rules)
+
+			if err != nil {
+				t.Fatalf("Compile() error = %s", err.Error())
+			}
+
+			matches, err := rules.Scan(context.Background(), []byte(test.data))
+
+			if err != nil {
+				t.Fatalf("Scan() error = %s", err.Error())
+			}
+
+			if got := describe(matches); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Scan() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// YARA refuses to compile all of these
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"unreferenced string", `rule t { strings: $a = "one" $b = "two" condition: $a }`},
+		{"undefined string", `rule t { condition: $a }`},
+		{"duplicate rule", `rule t { condition: true } rule t { condition: true }`},
+		{"missing condition", `rule t { strings: $a = "one" }`},
+		{"duplicate string", `rule t { strings: $a = "one" $a = "two" condition: $a }`},
+		{"unterminated string", `rule t { strings: $a = "one condition: $a }`},
+		{"bad hex", `rule t { strings: $a = { 4G } condition: $a }`},
+		{"undefined rule", `rule t { condition: other }`},
+		{"unbalanced braces", `rule t { condition: true`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Compile(test.rules); err == nil {
+				t.Errorf("Compile(%q) succeeded, want an error", test.rules)
+			}
+		})
+	}
+}
+
+func TestScanCancelled(t *testing.T) {
+	rules, err := Compile(`rule t { strings: $a = "one" $b = /t[a-z]o/ condition: any of them }`)
+
+	if err != nil {
+		t.Fatalf("Compile() error = %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := rules.Scan(ctx, []byte(strings.Repeat("one two ", 1000))); !errors.Is(err, context.Canceled) {
+		t.Errorf("Scan() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,578 @@
+package yara
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// the most matches recorded for each string, so a common byte sequence can't slow the scan
+// down. Counts stop at this number
+const maxMatchesPerString = 1000
+
+// how often the matchers check if the scan has been stopped
+const cancelCheckInterval = 64 * 1024
+
+// String is a string defined in a rule, e.g. `$a = "cmd.exe" nocase`
+type String struct {
+	ID      string
+	Private bool
+
+	matcher matcher
+
+	// only looked for at the offset, when the condition only ever uses "$a at <offset>"
+	fixed  bool
+	offset int64
+}
+
+// StringMatch is where one of a rule's strings was found
+type StringMatch struct {
+	ID     string
+	Offset int64
+	Data   []byte
+}
+
+type matcher interface {
+	// find returns the start and end of each match, up to the limit
+	find(ctx context.Context, s *scanner, limit int) [][2]int
+}
+
+type modifiers struct {
+	nocase   bool
+	wide     bool
+	ascii    bool
+	fullword bool
+	private  bool
+}
+
+// textMatcher finds a literal string, in ASCII and/or wide (UTF-16LE) form
+type textMatcher struct {
+	variants [][]byte
+	nocase   bool
+	fullword bool
+	wide     bool
+}
+
+func newTextMatcher(text string, mods modifiers) (*textMatcher, error) {
+	if text == "" {
+		return nil, fmt.Errorf("empty string")
+	}
+
+	m := textMatcher{nocase: mods.nocase, fullword: mods.fullword, wide: mods.wide}
+	literal := []byte(text)
+
+	if mods.nocase {
+		literal = foldASCII(literal)
+	}
+
+	if mods.ascii || !mods.wide {
+		m.variants = append(m.variants, literal)
+	}
+
+	if mods.wide {
+		wide := make([]byte, 0, len(literal)*2)
+
+		for _, b := range literal {
+			wide = append(wide, b, 0)
+		}
+
+		m.variants = append(m.variants, wide)
+	}
+
+	return &m, nil
+}
+
+func (m *textMatcher) find(ctx context.Context, s *scanner, limit int) [][2]int {
+	data := s.data
+
+	if m.nocase {
+		data = s.folded()
+	}
+
+	var found [][2]int
+
+	for _, variant := range m.variants {
+		for start := 0; start <= len(data)-len(variant); {
+			if ctx.Err() != nil || len(found) >= limit {
+				return found
+			}
+
+			index := bytes.Index(data[start:], variant)
+
+			if index < 0 {
+				break
+			}
+
+			offset := start + index
+			end := offset + len(variant)
+
+			if !m.fullword || isFullWord(s.data, offset, end, len(variant) > 1 && variant[1] == 0) {
+				found = append(found, [2]int{offset, end})
+			}
+
+			start = offset + 1
+		}
+	}
+
+	return found
+}
+
+// regexMatcher finds a regular expression. Go's regexp package works on UTF-8, so
+// patterns can't match bytes above 0x7F, hex strings should be used for those
+type regexMatcher struct {
+	pattern  *regexp.Regexp
+	fullword bool
+
+	// anchored patterns, e.g. with ^ or \b, depend on what comes before the match, so
+	// can't be searched for again from part way through the data
+	anchored bool
+}
+
+func newRegexMatcher(pattern, flags string, mods modifiers) (*regexMatcher, error) {
+	if mods.wide {
+		return nil, fmt.Errorf("the wide modifier isn't supported for regular expressions")
+	}
+
+	if hasHighByteEscape(pattern) {
+		return nil, fmt.Errorf("bytes above \\x7F aren't supported in regular expressions, use a hex string")
+	}
+
+	goFlags := ""
+
+	if strings.Contains(flags, "i") || mods.nocase {
+		goFlags += "i"
+	}
+
+	if strings.Contains(flags, "s") {
+		goFlags += "s"
+	}
+
+	if goFlags != "" {
+		pattern = "(?" + goFlags + ")" + pattern
+	}
+
+	compiled, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &regexMatcher{pattern: compiled, fullword: mods.fullword, anchored: hasEmptyWidth(parsed)}, nil
+}
+
+func (m *regexMatcher) find(ctx context.Context, s *scanner, limit int) [][2]int {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// searched in one go, which still takes time in proportion to the data
+	if m.anchored {
+		return m.filter(s, m.pattern.FindAllIndex(s.data, limit), limit)
+	}
+
+	var found [][2]int
+
+	for start := 0; start < len(s.data) && len(found) < limit; {
+		r := cancelReader{ctx: ctx, data: s.data[start:]}
+		match := m.pattern.FindReaderIndex(&r)
+
+		if ctx.Err() != nil || match == nil {
+			break
+		}
+
+		match[0] += start
+		match[1] += start
+		found = append(found, m.filter(s, [][]int{match}, 1)...)
+
+		start = max(match[1], match[0]+1)
+	}
+
+	return found
+}
+
+// filter drops matches that aren't full words if they have to be
+func (m *regexMatcher) filter(s *scanner, matches [][]int, limit int) [][2]int {
+	var found [][2]int
+
+	for _, match := range matches {
+		if len(found) >= limit {
+			break
+		}
+
+		if !m.fullword || isFullWord(s.data, match[0], match[1], false) {
+			found = append(found, [2]int{match[0], match[1]})
+		}
+	}
+
+	return found
+}
+
+// hasEmptyWidth returns true if the pattern has an assertion like ^, $ or \b
+func hasEmptyWidth(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	}
+
+	for _, sub := range re.Sub {
+		if hasEmptyWidth(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cancelReader reads the data a rune at a time for the regexp package, ending early once
+// the scan has been stopped
+type cancelReader struct {
+	ctx   context.Context
+	data  []byte
+	pos   int
+	reads int
+}
+
+func (r *cancelReader) ReadRune() (rune, int, error) {
+	r.reads++
+
+	if r.pos >= len(r.data) || (r.reads%cancelCheckInterval == 0 && r.ctx.Err() != nil) {
+		return 0, 0, io.EOF
+	}
+
+	c, size := utf8.DecodeRune(r.data[r.pos:])
+	r.pos += size
+
+	return c, size, nil
+}
+
+// hasHighByteEscape returns true if the pattern has an escape like \xE8
+func hasHighByteEscape(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			if pattern[i+1] == 'x' && i+2 < len(pattern) && pattern[i+2] >= '8' {
+				return true
+			}
+
+			// skip the escaped character, e.g. "\\x41" is a backslash then "x41"
+			i++
+		}
+	}
+
+	return false
+}
+
+// hex tokens are a byte with a mask for wildcards, a jump, or a set of alternatives
+type hexToken struct {
+	value  byte
+	mask   byte
+	negate bool
+
+	jump     bool
+	min, max int
+
+	alternatives [][]hexToken
+}
+
+// unbounded jumps, e.g. "[4-]", are limited to this so a match can't scan the whole file
+// from every candidate
+const maxHexJump = 64 * 1024
+
+type hexMatcher struct {
+	tokens []hexToken
+
+	// a literal run at the start, used to find candidates quickly
+	prefix []byte
+}
+
+func newHexMatcher(body string) (*hexMatcher, error) {
+	tokens, rest, err := parseHexTokens(strings.Join(strings.Fields(body), " "), false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("unexpected %q in hex string", rest)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty hex string")
+	}
+
+	if tokens[0].jump || tokens[len(tokens)-1].jump {
+		return nil, fmt.Errorf("hex strings can't start or end with a jump")
+	}
+
+	m := hexMatcher{tokens: tokens}
+
+	for _, t := range tokens {
+		if t.jump || t.alternatives != nil || t.mask != 0xff || t.negate {
+			break
+		}
+
+		m.prefix = append(m.prefix, t.value)
+	}
+
+	return &m, nil
+}
+
+// parseHexTokens parses tokens until the end or, inside alternatives, a "|" or ")"
+func parseHexTokens(body string, inAlternative bool) ([]hexToken, string, error) {
+	var tokens []hexToken
+
+	for {
+		body = strings.TrimLeft(body, " ")
+
+		if body == "" {
+			return tokens, body, nil
+		}
+
+		switch body[0] {
+		case '|', ')':
+			if !inAlternative {
+				return nil, body, fmt.Errorf("unexpected %q in hex string", body[0])
+			}
+
+			return tokens, body, nil
+		case '(':
+			var alternatives [][]hexToken
+			rest := body[1:]
+
+			for {
+				alternative, remaining, err := parseHexTokens(rest, true)
+
+				if err != nil {
+					return nil, remaining, err
+				}
+
+				if len(alternative) == 0 {
+					return nil, remaining, fmt.Errorf("empty alternative in hex string")
+				}
+
+				alternatives = append(alternatives, alternative)
+
+				if remaining == "" {
+					return nil, remaining, fmt.Errorf("unterminated alternative in hex string")
+				}
+
+				rest = remaining[1:]
+
+				if remaining[0] == ')' {
+					break
+				}
+			}
+
+			tokens = append(tokens, hexToken{alternatives: alternatives})
+			body = rest
+		case '[':
+			end := strings.IndexByte(body, ']')
+
+			if end < 0 {
+				return nil, body, fmt.Errorf("unterminated jump in hex string")
+			}
+
+			jump, err := parseJump(strings.ReplaceAll(body[1:end], " ", ""))
+
+			if err != nil {
+				return nil, body, err
+			}
+
+			tokens = append(tokens, jump)
+			body = body[end+1:]
+		default:
+			negate := false
+
+			if body[0] == '~' {
+				negate = true
+				body = body[1:]
+			}
+
+			if len(body) < 2 {
+				return nil, body, fmt.Errorf("incomplete byte %q in hex string", body)
+			}
+
+			t, err := parseHexByte(body[:2])
+
+			if err != nil {
+				return nil, body, err
+			}
+
+			t.negate = negate
+			tokens = append(tokens, t)
+			body = body[2:]
+		}
+	}
+}
+
+// e.g. "4", "4-6", "4-" or "-"
+func parseJump(text string) (hexToken, error) {
+	t := hexToken{jump: true, max: maxHexJump}
+	low, high, isRange := strings.Cut(text, "-")
+
+	var err error
+
+	if low != "" {
+		if t.min, err = strconv.Atoi(low); err != nil {
+			return t, fmt.Errorf("invalid jump [%s] in hex string", text)
+		}
+	}
+
+	switch {
+	case !isRange:
+		t.max = t.min
+	case high != "":
+		if t.max, err = strconv.Atoi(high); err != nil {
+			return t, fmt.Errorf("invalid jump [%s] in hex string", text)
+		}
+	}
+
+	if t.min < 0 || t.max < t.min {
+		return t, fmt.Errorf("invalid jump [%s] in hex string", text)
+	}
+
+	return t, nil
+}
+
+// e.g. "4D", "4?", "?D" or "??"
+func parseHexByte(text string) (hexToken, error) {
+	t := hexToken{}
+
+	for i, shift := range []uint{4, 0} {
+		c := text[i]
+
+		if c == '?' {
+			continue
+		}
+
+		n, err := strconv.ParseUint(string(c), 16, 8)
+
+		if err != nil {
+			return t, fmt.Errorf("invalid byte %q in hex string", text)
+		}
+
+		t.value |= byte(n) << shift
+		t.mask |= 0xf << shift
+	}
+
+	return t, nil
+}
+
+func (m *hexMatcher) find(ctx context.Context, s *scanner, limit int) [][2]int {
+	var found [][2]int
+	data := s.data
+
+	for start := 0; start < len(data); start++ {
+		if len(found) >= limit || (start%cancelCheckInterval == 0 && ctx.Err() != nil) {
+			break
+		}
+
+		// jump to the next place the literal start appears
+		if len(m.prefix) > 0 {
+			index := bytes.Index(data[start:], m.prefix)
+
+			if index < 0 {
+				break
+			}
+
+			start += index
+		}
+
+		if end, ok := matchHexTokens(m.tokens, data, start); ok {
+			found = append(found, [2]int{start, end})
+		}
+	}
+
+	return found
+}
+
+// matchHexTokens matches the tokens at the position, trying each length of jump in turn
+func matchHexTokens(tokens []hexToken, data []byte, pos int) (int, bool) {
+	if len(tokens) == 0 {
+		return pos, true
+	}
+
+	t := tokens[0]
+
+	switch {
+	case t.jump:
+		for n := t.min; n <= t.max && pos+n <= len(data); n++ {
+			if end, ok := matchHexTokens(tokens[1:], data, pos+n); ok {
+				return end, true
+			}
+		}
+
+		return 0, false
+	case t.alternatives != nil:
+		for _, alternative := range t.alternatives {
+			// the rest has to match after each alternative, as they can be different lengths
+			combined := append(append([]hexToken{}, alternative...), tokens[1:]...)
+
+			if end, ok := matchHexTokens(combined, data, pos); ok {
+				return end, true
+			}
+		}
+
+		return 0, false
+	default:
+		if pos >= len(data) {
+			return 0, false
+		}
+
+		if (data[pos]&t.mask == t.value) == t.negate {
+			return 0, false
+		}
+
+		return matchHexTokens(tokens[1:], data, pos+1)
+	}
+}
+
+// isFullWord checks the match isn't part of a bigger word
+func isFullWord(data []byte, start, end int, wide bool) bool {
+	step := 1
+
+	if wide {
+		step = 2
+	}
+
+	if start-step >= 0 && isAlphanumeric(data[start-step]) {
+		return false
+	}
+
+	if end < len(data) && isAlphanumeric(data[end]) {
+		return false
+	}
+
+	return true
+}
+
+func isAlphanumeric(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// foldASCII lower cases ASCII letters, leaving other bytes alone so offsets don't change
+func foldASCII(data []byte) []byte {
+	folded := make([]byte, len(data))
+
+	for i, b := range data {
+		if b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+
+		folded[i] = b
+	}
+
+	return folded
+}
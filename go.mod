@@ -6,8 +6,10 @@ toolchain go1.22.11
 
 require (
 	fyne.io/fyne/v2 v2.5.3
+	github.com/BurntSushi/toml v1.4.0
 	github.com/dustin/go-humanize v1.0.1
 	github.com/existentiality/urlscan v0.1.1
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/fumiama/go-docx v0.0.0-20241231153056-9f8f327c74a5
 	github.com/gabriel-vasile/mimetype v1.4.8
 	github.com/richardlehane/mscfb v1.0.4
@@ -19,10 +21,8 @@ require (
 
 require (
 	fyne.io/systray v1.11.0 // indirect
-	github.com/BurntSushi/toml v1.4.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fredbi/uri v1.1.0 // indirect
-	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/fumiama/imgsz v0.0.2 // indirect
 	github.com/fyne-io/gl-js v0.0.0-20220119005834-d2da28d9ccfe // indirect
 	github.com/fyne-io/glfw-js v0.0.0-20241126112943-313d8a0fe1d0 // indirect
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"file-inspector/files"
+	"file-inspector/files/batch"
+	"file-inspector/files/findings"
+	"file-inspector/files/history"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	historyWindowWidth  = 1200
+	historyWindowHeight = 800
+
+	historyDetailText = "Select a file to see its history..."
+	firstSeenText     = "First time this file has been seen"
+)
+
+// columns of the history table, the first row is the headings
+var historyColumns = []struct {
+	heading string
+	width   float32
+}{
+	{"Last Seen", 140},
+	{"Name", 250},
+	{"Verdict", 100},
+	{"Seen", 60},
+	{"SHA256", 250},
+	{"Notes", 350},
+}
+
+// openHistory opens the history store, leaving it nil if it can't be opened so the
+// rest of the app still works
+func openHistory() {
+	path, err := history.DefaultPath()
+
+	if err != nil {
+		log.Printf("Error finding history: %s\n", err.Error())
+		return
+	}
+
+	store, err := history.Open(path)
+
+	if err != nil {
+		log.Printf("Error opening history: %s\n", err.Error())
+		return
+	}
+
+	historyStore = store
+}
+
+// flushHistory saves what's been added to the history but not saved yet, e.g. when quitting
+func flushHistory() {
+	if historyStore == nil {
+		return
+	}
+
+	if err := historyStore.Flush(); err != nil {
+		log.Printf("Error saving history: %s\n", err.Error())
+	}
+}
+
+// recordHistory adds the analysis and its attachments to the history, and shows if the
+// file or any of the attachments have been seen before
+func recordHistory(properties *files.FileProperties, result *files.ProcessResult) {
+	if historyStore == nil || properties == nil {
+		return
+	}
+
+	previous, seen, err := historyStore.Add(properties.Hash, history.NewAnalysis(properties, result))
+
+	if err != nil {
+		log.Printf("Error saving history: %s\n", err.Error())
+	}
+
+	text := firstSeenText
+
+	if seen {
+		text = previous.SeenBefore()
+	}
+
+	for _, match := range historyStore.Similar(properties.Hash, properties.SSDeep, properties.TLSH) {
+		text += "\n" + describeSimilar(match)
+	}
+
+	// the same attachment is often sent in lots of different emails
+	for _, attachment := range addAttachmentHistory(result.Children) {
+		text += "\n" + attachment
+	}
+
+	historyBS.Set(text)
+	notesButton.Enable()
+}
+
+// addAttachmentHistory adds the attachments to the history, returning a line for each one seen before
+func addAttachmentHistory(children []*files.ProcessResult) []string {
+	var seenBefore []string
+
+	for _, child := range children {
+		if child.SHA256 != "" {
+			previous, seen, err := historyStore.Add(child.SHA256, history.NewAnalysis(nil, child))
+
+			if err != nil {
+				log.Printf("Error saving history: %s\n", err.Error())
+			}
+
+			if seen {
+				seenBefore = append(seenBefore, fmt.Sprintf("Attachment %s: %s", child.Name, previous.SeenBefore()))
+			}
+
+			for _, match := range historyStore.Similar(child.SHA256, child.SSDeep, child.TLSH) {
+				seenBefore = append(seenBefore, fmt.Sprintf("Attachment %s: %s", child.Name, describeSimilar(match)))
+			}
+		}
+
+		seenBefore = append(seenBefore, addAttachmentHistory(child.Children)...)
+	}
+
+	return seenBefore
+}
+
+// describeSimilar describes a similar file from the history, e.g.
+// "Similar to invoice.pdf (ssdeep score 97, TLSH distance 12), Malicious"
+func describeSimilar(match history.Match) string {
+	text := fmt.Sprintf("Similar to %s (%s)", strings.Join(match.Record.Names(), ", "), match.Similarity)
+
+	if verdict := match.Record.Latest().Verdict; verdict != "" {
+		text += ", " + verdict
+	}
+
+	return text
+}
+
+// recordScanHistory adds every file from a folder scan, and their attachments, to the history
+func recordScanHistory(summary *batch.Summary) {
+	if historyStore == nil {
+		return
+	}
+
+	var entries []history.Entry
+
+	for _, item := range summary.Items {
+		if item.Result != nil {
+			entries = appendHistoryEntries(entries, item.SHA256, item.Result)
+		}
+	}
+
+	if err := historyStore.AddAll(entries); err != nil {
+		log.Printf("Error saving history: %s\n", err.Error())
+	}
+}
+
+func appendHistoryEntries(entries []history.Entry, sha256 string, result *files.ProcessResult) []history.Entry {
+	if sha256 != "" {
+		entries = append(entries, history.Entry{SHA256: sha256, Analysis: history.NewAnalysis(nil, result)})
+	}
+
+	for _, child := range result.Children {
+		entries = appendHistoryEntries(entries, child.SHA256, child)
+	}
+
+	return entries
+}
+
+func onNotesButtonClicked() {
+	if historyStore == nil || reportProperties == nil {
+		return
+	}
+
+	hash := reportProperties.Hash
+	record, _ := historyStore.Lookup(hash)
+
+	entry := widget.NewMultiLineEntry()
+	entry.SetText(record.Notes)
+	entry.SetPlaceHolder("Notes on this file, e.g. the campaign it's part of")
+	entry.SetMinRowsVisible(8)
+
+	d := dialog.NewForm(fmt.Sprintf("Notes on %s", reportProperties.FileName), "Save", "Cancel",
+		[]*widget.FormItem{widget.NewFormItem("Notes", entry)},
+		func(save bool) {
+			if !save {
+				return
+			}
+
+			if err := historyStore.SetNotes(hash, entry.Text); err != nil {
+				launchErrorDialog(err, window)
+			}
+		}, window)
+
+	d.Resize(fyne.NewSize(500, 300))
+	d.Show()
+}
+
+// onHistoryButtonClicked opens a window of the files analysed before, searchable by hash,
+// name, notes, verdict, findings or indicators
+func onHistoryButtonClicked() {
+	if historyStore == nil {
+		launchInfoDialog("History Unavailable", "The history couldn't be opened, see the log for details.", &window)
+		return
+	}
+
+	historyWindow := fyne.CurrentApp().NewWindow("History")
+
+	detailBox := getScrollContainer(historyDetailText, binding.NewString())
+	detailText := detailBox.Content.(*widget.Label)
+
+	records := historyStore.Search("")
+	countLabel := widget.NewLabel("")
+	setCount := func() {
+		countLabel.SetText(fmt.Sprintf("%d of %d files", len(records), historyStore.Len()))
+	}
+	setCount()
+
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(records) + 1, len(historyColumns)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("History")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, o fyne.CanvasObject) {
+			label := o.(*widget.Label)
+
+			if id.Row == 0 {
+				label.TextStyle.Bold = true
+				label.SetText(historyColumns[id.Col].heading)
+				return
+			}
+
+			label.TextStyle.Bold = false
+			label.SetText(getHistoryCellText(records[id.Row-1], id.Col))
+		},
+	)
+
+	for i, column := range historyColumns {
+		table.SetColumnWidth(i, column.width)
+	}
+
+	table.OnSelected = func(id widget.TableCellID) {
+		if id.Row == 0 {
+			return
+		}
+
+		detailText.SetText(renderHistoryRecord(records[id.Row-1]))
+	}
+
+	search := widget.NewEntry()
+	search.SetPlaceHolder("Search by hash, name, notes, verdict, finding or indicator")
+	search.OnChanged = func(query string) {
+		records = historyStore.Search(query)
+		setCount()
+		table.UnselectAll()
+		table.Refresh()
+		detailText.SetText(historyDetailText)
+	}
+
+	split := container.NewVSplit(table, detailBox)
+	split.Offset = 0.5
+
+	content := container.NewBorder(container.NewBorder(nil, nil, nil, countLabel, search), nil, nil, nil, split)
+
+	historyWindow.SetContent(content)
+	historyWindow.Resize(fyne.NewSize(historyWindowWidth, historyWindowHeight))
+	historyWindow.Show()
+}
+
+func getHistoryCellText(record history.Record, column int) string {
+	latest := record.Latest()
+
+	switch historyColumns[column].heading {
+	case "Last Seen":
+		return history.FormatTime(record.LastSeen)
+	case "Name":
+		return strings.Join(record.Names(), ", ")
+	case "Verdict":
+		return latest.Verdict
+	case "Seen":
+		return strconv.Itoa(record.Count)
+	case "SHA256":
+		return record.SHA256
+	default:
+		return strings.Join(strings.Fields(record.Notes), " ")
+	}
+}
+
+// renderHistoryRecord builds the text view of a file's history and its latest analysis
+func renderHistoryRecord(record history.Record) string {
+	var text strings.Builder
+	latest := record.Latest()
+
+	text.WriteString(fmt.Sprintf("SHA256:\t\t%s\n", record.SHA256))
+	text.WriteString(fmt.Sprintf("Names:\t\t%s\n", strings.Join(record.Names(), ", ")))
+	text.WriteString(fmt.Sprintf("File Type:\t%s\n", latest.FileType))
+
+	if latest.SSDeep != "" {
+		text.WriteString(fmt.Sprintf("SSDeep:\t\t%s\n", latest.SSDeep))
+	}
+
+	if latest.TLSH != "" {
+		text.WriteString(fmt.Sprintf("TLSH:\t\t%s\n", latest.TLSH))
+	}
+	text.WriteString(fmt.Sprintf("Seen:\t\t%d times, first %s, last %s\n", record.Count, history.FormatTime(record.FirstSeen), history.FormatTime(record.LastSeen)))
+
+	if record.Notes != "" {
+		text.WriteString(fmt.Sprintf("\nNotes:\n%s\n", record.Notes))
+	}
+
+	if similar := historyStore.Similar(record.SHA256, latest.SSDeep, latest.TLSH); len(similar) > 0 {
+		text.WriteString("\nSimilar files:\n")
+
+		for _, match := range similar {
+			text.WriteString(fmt.Sprintf("\t%s\n", describeSimilar(match)))
+		}
+	}
+
+	text.WriteString("\nAnalyses:\n")
+
+	for i := len(record.Analyses) - 1; i >= 0; i-- {
+		analysis := record.Analyses[i]
+		text.WriteString(fmt.Sprintf("\t%s\t%s: %s\n", history.FormatTime(analysis.Time), analysis.Name, analysis.Summary))
+	}
+
+	text.WriteString(fmt.Sprintf("\nLatest analysis, %s:\n\n", history.FormatTime(latest.Time)))
+	text.WriteString(findings.Render(latest.Findings))
+
+	return text.String()
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
+
+	"file-inspector/files"
+	"file-inspector/files/history"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/data/binding"
@@ -10,26 +17,51 @@ import (
 
 const (
 	appName = "File-Inspector"
-
-	windowWidth  = 700
-	windowHeight = 900
 )
 
 // These all need to be global to allow us to break up some of the functions
 var (
-	window        fyne.Window
-	openButton    *widget.Button
-	iconSeparator *widget.Separator
+	window             fyne.Window
+	openButton         *widget.Button
+	selectFolderButton *widget.Button
+	saveReportButton   *widget.Button
+	compareButton      *widget.Button
+	notesButton        *widget.Button
+	iconSeparator      *widget.Separator
 
 	analysisTextBS binding.String
 	fileNameBS     binding.String
 	fileTypeBS     binding.String
 	fileSizeBS     binding.String
 	fileHashBS     binding.String
+	fileMD5BS      binding.String
+	fileSHA1BS     binding.String
+	fileSHA512BS   binding.String
+	fileSSDeepBS   binding.String
+	fileTLSHBS     binding.String
+	verdictBS      binding.String
+	historyBS      binding.String
 
 	metadataTable     *widget.Table
 	metadataTableData [][]string
 
+	iocTable     *widget.Table
+	iocTableData [][]string
+
+	attachmentTree    *widget.Tree
+	attachmentResults []*files.ProcessResult
+
+	// the attachments ticked to extract, by tree node ID
+	extractButton    *widget.Button
+	extractSelection = map[string]bool{}
+
+	// the file currently shown, for the Save Report button
+	reportProperties *files.FileProperties
+	reportResult     *files.ProcessResult
+
+	// the files analysed before, nil if it couldn't be opened
+	historyStore *history.Store
+
 	errorLabel     *widget.Label
 	errorIcon      *widget.Icon
 	errorSeparator *widget.Separator
@@ -38,12 +70,31 @@ var (
 	processedLabel     *widget.Label
 	processedSeparator *widget.Separator
 
+	suspiciousIcon      *widget.Icon
+	suspiciousLabel     *widget.Label
+	suspiciousSeparator *widget.Separator
+
 	dangerIcon      *widget.Icon
 	dangerLabel     *widget.Label
 	dangerSeparator *widget.Separator
 )
 
 func main() {
+	// run headless if we've been given a command
+	if isCommandLine(os.Args) {
+		os.Exit(runCommandLine(os.Args))
+	}
+
+	// a broken settings file shouldn't stop the app, it runs with the defaults instead
+	settingsErr := loadSettings()
+
+	if settingsErr != nil {
+		log.Printf("Error loading settings: %s\n", settingsErr.Error())
+	}
+
+	loadDefaultRules()
+	openHistory()
+
 	// create an app and window instance
 	myApp := app.New()
 	myApp.Settings().SetTheme(&WindowTheme{Theme: theme.DefaultTheme()})
@@ -56,9 +107,43 @@ func main() {
 	//window.SetOnDropped(onFileDroppedin)
 
 	// set default size
-	window.Resize(fyne.NewSize(windowWidth, windowHeight))
+	window.Resize(fyne.NewSize(settings.UI.WindowWidth, settings.UI.WindowHeight))
 
 	// run
 	window.SetContent(content)
+
+	if settingsErr != nil {
+		launchErrorDialog(fmt.Errorf("%s\n\nThe default settings are being used", settingsErr.Error()), window)
+	}
+
 	window.ShowAndRun()
+	flushHistory()
+}
+
+// loadDefaultRules loads the YARA rules, email rules and hash lists in the directories in
+// the settings, or those in the user's config, if there are any
+func loadDefaultRules() {
+	for _, rules := range []struct {
+		kind ruleKind
+		dir  string
+	}{
+		{ruleKinds.yara, settings.Rules.YARA},
+		{ruleKinds.email, settings.Rules.Email},
+		{ruleKinds.allow, settings.Rules.AllowHashes},
+		{ruleKinds.deny, settings.Rules.DenyHashes},
+	} {
+		kind, dir := rules.kind, rules.dir
+
+		if dir == "" {
+			dir = kind.existingDefaultDir()
+		}
+
+		if dir == "" {
+			continue
+		}
+
+		if _, err := kind.load(dir); err != nil {
+			log.Printf("Error loading %s: %s\n", kind.name, err.Error())
+		}
+	}
 }
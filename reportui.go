@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"file-inspector/files/report"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+)
+
+func onSaveReportButtonClicked() {
+	log.Println("Save report was clicked!")
+
+	if reportResult == nil {
+		return
+	}
+
+	// the format comes from the extension the user picks, e.g. ".stix.json" for a STIX bundle or ".misp.json" for a MISP event
+	d := dialog.NewFileSave(onReportFileChosen, window)
+	d.SetFilter(storage.NewExtensionFileFilter([]string{
+		report.FormatHTML.Extension(),
+		report.FormatMarkdown.Extension(),
+		report.FormatJSON.Extension(),
+	}))
+	d.SetFileName(getReportFileName(reportResult.Name))
+	d.Show()
+}
+
+func onReportFileChosen(f fyne.URIWriteCloser, err error) {
+	if err != nil {
+		log.Printf("Error from save dialog: %s\n", err.Error())
+		return
+	}
+	if f == nil {
+		log.Println("Nil result from save dialog")
+		return
+	}
+	log.Printf("saving report to: %v", f.URI())
+
+	format, err := report.FormatForFileName(f.URI().Name())
+
+	if err != nil {
+		// the dialog's already created the file, so don't leave it behind empty
+		f.Close()
+
+		if err := storage.Delete(f.URI()); err != nil {
+			log.Printf("Error removing %v: %s\n", f.URI(), err.Error())
+		}
+
+		launchErrorDialog(err, window)
+		return
+	}
+	defer f.Close()
+
+	doc := report.New(reportProperties, reportResult)
+
+	if err := doc.Write(f, format); err != nil {
+		launchErrorDialog(fmt.Errorf("error saving report: %s", err.Error()), window)
+		return
+	}
+
+	launchInfoDialog("Report Saved", fmt.Sprintf("Report saved to %s", f.URI().Path()), &window)
+}
+
+// e.g. "invoice.pdf" gives "invoice-report.html"
+func getReportFileName(name string) string {
+	return fmt.Sprintf("%s-report%s", strings.TrimSuffix(name, path.Ext(name)), report.FormatHTML.Extension())
+}
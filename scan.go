@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"file-inspector/files"
+	"file-inspector/files/batch"
+)
+
+const (
+	// how many findings to show per file in the summary table
+	scanTopFindings = 3
+)
+
+// scanOptions holds the flags for the scan command
+type scanOptions struct {
+	analyseOptions
+	workers    int
+	sortColumn string
+	descending bool
+}
+
+// scanReport is the machine readable result for a folder
+type scanReport struct {
+	Root    string         `json:"root"`
+	Summary string         `json:"summary"`
+	Skipped int            `json:"skipped"`
+	Folders map[string]int `json:"folders,omitempty"`
+	Files   []*fileReport  `json:"files"`
+}
+
+func newScanFlagSet(programName string, output io.Writer, opts *scanOptions) *flag.FlagSet {
+	flags := newAnalyseFlagSet(programName, output, &opts.analyseOptions)
+
+	flags.IntVar(&opts.workers, "workers", batch.DefaultWorkers, "scan, serve and watch: number of files to process at once")
+	flags.StringVar(&opts.sortColumn, "sort", string(batch.SortPath), "scan: sort by path, type, hash or score")
+	flags.BoolVar(&opts.descending, "desc", false, "scan: sort in descending order")
+
+	return flags
+}
+
+func runScanCommand(programName string, args []string) int {
+	var opts scanOptions
+	flags := newScanFlagSet(programName, os.Stderr, &opts)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if !isOutputFormat(opts.format) {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", opts.format)
+		return exitUsage
+	}
+
+	if !isSortColumn(opts.sortColumn) {
+		fmt.Fprintf(os.Stderr, "Unknown sort column %q\n", opts.sortColumn)
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Provide a single folder to scan")
+		flags.Usage()
+		return exitUsage
+	}
+
+	if err := applyAnalyseOptions(&opts.analyseOptions); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	// stop on Ctrl-C, reporting on the files done so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	summary, err := batch.Scan(ctx, flags.Arg(0), opts.workers, nil)
+
+	if summary == nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	summary.Sort(batch.SortColumn(opts.sortColumn), opts.descending)
+
+	report := buildScanReport(summary)
+	exitCode := exitCompleted
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Scan stopped early: %s\n", err.Error())
+		exitCode = exitError
+	}
+
+	for _, fileReport := range report.Files {
+		exitCode = worstExitCode(exitCode, statusExitCode(fileReport.Status))
+	}
+
+	switch opts.format {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	case outputTable:
+		err = writeScanTable(os.Stdout, summary)
+	case outputSTIX:
+		err = writeSTIXReports(os.Stdout, report.Files)
+	case outputMISP:
+		err = buildMISPEvent(report.Files).Write(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stdout, "%s\n\n", report.Summary)
+		err = writeTextReports(os.Stdout, report.Files)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %s\n", err.Error())
+		return exitError
+	}
+
+	if opts.mispUpload {
+		exitCode = worstExitCode(exitCode, uploadToMISP(report.Files))
+	}
+
+	return exitCode
+}
+
+func isSortColumn(column string) bool {
+	switch batch.SortColumn(column) {
+	case batch.SortPath, batch.SortType, batch.SortHash, batch.SortScore:
+		return true
+	default:
+		return false
+	}
+}
+
+// buildScanReport converts the scan results into the same reports the analyse command produces
+func buildScanReport(summary *batch.Summary) *scanReport {
+	report := &scanReport{
+		Root:    summary.Root,
+		Summary: summary.String(),
+		Skipped: summary.Skipped,
+		Folders: summary.Folders,
+	}
+
+	for _, item := range summary.Items {
+		fileReport := &fileReport{
+			Path:     item.Path,
+			FileName: filepath.Base(item.Path),
+			FileType: item.MimeType,
+			Size:     item.Size,
+			SHA256:   item.SHA256,
+		}
+
+		fileReport.properties = &files.FileProperties{
+			FileName: item.Path,
+			Hash:     item.SHA256,
+			FileType: item.MimeType,
+			Size:     item.Size,
+		}
+
+		addResultToReport(fileReport, item.Result)
+		report.Files = append(report.Files, fileReport)
+	}
+
+	return report
+}
+
+func writeScanTable(w io.Writer, summary *batch.Summary) error {
+	tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "PATH\tVERDICT\tSCORE\tTYPE\tSHA256\tTOP FINDINGS")
+
+	for _, item := range summary.Items {
+		var top []string
+
+		for _, f := range item.TopFindings(scanTopFindings) {
+			top = append(top, f.String())
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", item.Path, item.Verdict(), max(item.Score(), 0), item.MimeType, item.SHA256, strings.Join(top, "; "))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%s\n", summary.String())
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"file-inspector/files/history"
+	"file-inspector/files/server"
+)
+
+const (
+	// only reachable from this machine unless asked otherwise
+	defaultServeAddress = "127.0.0.1:8080"
+
+	// how long requests in progress get to finish when stopping
+	serveShutdownTimeout = 10 * time.Second
+
+	// how long clients get to send the request headers
+	serveReadHeaderTimeout = 10 * time.Second
+)
+
+// serveOptions holds the flags for the serve command
+type serveOptions struct {
+	scanOptions
+	address     string
+	queueSize   int
+	maxSizeMB   int64
+	historyPath string
+	noHistory   bool
+}
+
+func newServeFlagSet(programName string, output io.Writer, opts *serveOptions) *flag.FlagSet {
+	flags := newScanFlagSet(programName, output, &opts.scanOptions)
+
+	flags.StringVar(&opts.address, "addr", defaultServeAddress, "serve: address to listen on")
+	flags.IntVar(&opts.queueSize, "queue", server.DefaultQueueSize, "serve: number of files that can be waiting to be analysed")
+	flags.Int64Var(&opts.maxSizeMB, "max-size", settings.Limits.MaxUploadMB, "serve: largest file that can be uploaded, in MB")
+	flags.StringVar(&opts.historyPath, "history", "", "serve: history file to record analyses in, instead of server-history.json in the user's config")
+	flags.BoolVar(&opts.noHistory, "no-history", false, "serve: don't record analyses in the history")
+
+	return flags
+}
+
+func runServeCommand(programName string, args []string) int {
+	var opts serveOptions
+	flags := newServeFlagSet(programName, os.Stderr, &opts)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "The serve command doesn't take any files, upload them to the API instead")
+		flags.Usage()
+		return exitUsage
+	}
+
+	if opts.queueSize < 1 || opts.maxSizeMB < 1 {
+		fmt.Fprintln(os.Stderr, "The queue and maximum upload size must be more than zero")
+		return exitUsage
+	}
+
+	if err := applyAnalyseOptions(&opts.analyseOptions); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	store, err := openServeHistory(&opts)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	if store != nil {
+		// deferred first, so it's saved once the server's finished its jobs
+		defer func() {
+			if err := store.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving history: %s\n", err.Error())
+			}
+		}()
+	}
+
+	srv := server.New(server.Config{
+		Workers:       opts.workers,
+		QueueSize:     opts.queueSize,
+		MaxUploadSize: opts.maxSizeMB << 20,
+		History:       store,
+	})
+	defer srv.Close()
+
+	// listen first, so we only say we're serving once we are
+	listener, err := net.Listen("tcp", opts.address)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving the API: %s\n", err.Error())
+		return exitError
+	}
+
+	httpServer := &http.Server{
+		Handler:           srv,
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+	}
+
+	// stop on Ctrl-C, letting requests in progress finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- httpServer.Serve(listener)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving the API on http://%s, press Ctrl-C to stop\n", listener.Addr())
+
+	select {
+	case err := <-errs:
+		fmt.Fprintf(os.Stderr, "Error serving the API: %s\n", err.Error())
+		return exitError
+	case <-ctx.Done():
+	}
+
+	fmt.Fprintln(os.Stderr, "Stopping")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error stopping the API: %s\n", err.Error())
+		return exitError
+	}
+
+	return exitCompleted
+}
+
+// openServeHistory opens the history the server records in, its own rather than the user
+// interface's unless another is given, or returns nil if it's turned off
+func openServeHistory(opts *serveOptions) (*history.Store, error) {
+	if opts.noHistory {
+		return nil, nil
+	}
+
+	path := opts.historyPath
+
+	if path == "" {
+		defaultPath, err := history.DefaultServerPath()
+
+		if err != nil {
+			return nil, fmt.Errorf("error finding history: %s", err.Error())
+		}
+
+		path = defaultPath
+	}
+
+	return history.Open(path)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"file-inspector/files/config"
+)
+
+// the settings from the config file, or the defaults if there isn't one
+var settings = config.Default()
+
+// loadSettings reads the settings file in the user's config, if there is one, and sets
+// up the analysis with it. Command line flags are applied on top
+func loadSettings() error {
+	path, err := config.DefaultPath()
+
+	if err != nil {
+		return err
+	}
+
+	loaded, err := config.Load(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := loaded.Apply(); err != nil {
+		return err
+	}
+
+	settings = loaded
+
+	return nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-inspector/files"
+	"file-inspector/files/config"
+	"file-inspector/files/pdf"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	settingsWindowWidth  = 700
+	settingsWindowHeight = 600
+)
+
+// onSettingsButtonClicked opens a window to edit the settings file, which are applied as
+// soon as they're saved
+func onSettingsButtonClicked() {
+	log.Println("Settings was clicked!")
+
+	settingsWindow := fyne.CurrentApp().NewWindow("Settings")
+
+	// analyzers
+	analyzerChecks := map[string]*widget.Check{}
+	analyzerBox := container.NewVBox(widget.NewLabel("Analyzers that run:"))
+
+	for _, analyzer := range files.Analyzers() {
+		check := widget.NewCheck(analyzer.Name(), nil)
+		check.SetChecked(!slices.Contains(settings.Analyzers.Disabled, analyzer.Name()))
+		analyzerChecks[analyzer.Name()] = check
+		analyzerBox.Add(check)
+	}
+
+	timeoutsEntry := newSettingsLinesEntry("e.g. pdf = 1m", formatSettingLines(settings.Analyzers.Timeouts))
+	analyzerBox.Add(widget.NewLabel("Timeouts for analyzers that need longer, or less, one per line:"))
+	analyzerBox.Add(timeoutsEntry)
+
+	// limits
+	timeoutEntry := widget.NewEntry()
+	timeoutEntry.SetText(settings.Limits.Timeout.String())
+	depthEntry := widget.NewEntry()
+	depthEntry.SetText(strconv.Itoa(settings.Limits.MaxAttachmentDepth))
+	uploadEntry := widget.NewEntry()
+	uploadEntry.SetText(strconv.FormatInt(settings.Limits.MaxUploadMB, 10))
+	inputEntry := widget.NewEntry()
+	inputEntry.SetText(strconv.FormatInt(settings.Limits.MaxInputMB, 10))
+	decompressedEntry := widget.NewEntry()
+	decompressedEntry.SetText(strconv.FormatInt(settings.Limits.MaxDecompressedMB, 10))
+	attachmentsEntry := widget.NewEntry()
+	attachmentsEntry.SetText(strconv.Itoa(settings.Limits.MaxAttachments))
+	objectsEntry := widget.NewEntry()
+	objectsEntry.SetText(strconv.Itoa(settings.Limits.MaxObjects))
+	widthEntry := widget.NewEntry()
+	widthEntry.SetText(strconv.Itoa(int(settings.UI.WindowWidth)))
+	heightEntry := widget.NewEntry()
+	heightEntry.SetText(strconv.Itoa(int(settings.UI.WindowHeight)))
+
+	limitsForm := widget.NewForm(
+		widget.NewFormItem("Timeout per file", timeoutEntry),
+		widget.NewFormItem("Attachment depth", depthEntry),
+		widget.NewFormItem("Max attachments", attachmentsEntry),
+		widget.NewFormItem("Max file size (MB)", inputEntry),
+		widget.NewFormItem("Max decompressed (MB)", decompressedEntry),
+		widget.NewFormItem("Max objects", objectsEntry),
+		widget.NewFormItem("Max upload (MB)", uploadEntry),
+		widget.NewFormItem("Window width", widthEntry),
+		widget.NewFormItem("Window height", heightEntry),
+	)
+
+	// severities
+	severitiesEntry := newSettingsLinesEntry("e.g. url.uncommon-domain = high, or pdf.* = low", formatSettingLines(settings.Severities))
+
+	// domains
+	commonEntry := newSettingsLinesEntry("e.g. example.com", strings.Join(settings.Domains.Common, "\n"))
+	uncommonEntry := newSettingsLinesEntry("e.g. file-sharing.example", strings.Join(settings.Domains.Uncommon, "\n"))
+	builtinCheck := widget.NewCheck("Count the 100,000 most popular domains as common", nil)
+	builtinCheck.SetChecked(settings.Domains.UseBuiltinList)
+
+	domainsBox := container.NewVBox(
+		widget.NewLabel("Common domains, and their subdomains, one per line:"),
+		commonEntry,
+		widget.NewLabel("Uncommon domains, never trusted, one per line:"),
+		uncommonEntry,
+		builtinCheck,
+	)
+
+	// PDF keywords
+	keywordsEntry := newSettingsLinesEntry("e.g. /JavaScript = Javascript that can run when the document is opened", formatKeywordLines(settings.PDF.Keywords))
+
+	// rule directories
+	yaraEntry := newSettingsPathEntry(settings.Rules.YARA)
+	emailEntry := newSettingsPathEntry(settings.Rules.Email)
+	allowEntry := newSettingsPathEntry(settings.Rules.AllowHashes)
+	denyEntry := newSettingsPathEntry(settings.Rules.DenyHashes)
+
+	rulesForm := widget.NewForm(
+		widget.NewFormItem("YARA rules", yaraEntry),
+		widget.NewFormItem("Email rules", emailEntry),
+		widget.NewFormItem("Allow hashes", allowEntry),
+		widget.NewFormItem("Deny hashes", denyEntry),
+	)
+
+	// integrations
+	mispURLEntry := widget.NewEntry()
+	mispURLEntry.SetText(settings.Integrations.MISP.URL)
+	mispURLEntry.SetPlaceHolder("e.g. https://misp.example.com")
+	mispKeyEntry := newSettingsKeyEntry(settings.Integrations.MISP.APIKey)
+	googleKeyEntry := newSettingsKeyEntry(settings.Integrations.GoogleSafeBrowsing.APIKey)
+	urlscanKeyEntry := newSettingsKeyEntry(settings.Integrations.URLScan.APIKey)
+
+	integrationsForm := widget.NewForm(
+		widget.NewFormItem("MISP URL", mispURLEntry),
+		widget.NewFormItem("MISP API key", mispKeyEntry),
+		widget.NewFormItem("Google Safe Browsing key", googleKeyEntry),
+		widget.NewFormItem("URLScan key", urlscanKeyEntry),
+	)
+
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Analyzers", container.NewVScroll(analyzerBox)),
+		container.NewTabItem("Limits", limitsForm),
+		container.NewTabItem("Severities", container.NewBorder(widget.NewLabel("Severities for findings, by ID or prefix, one per line:"), nil, nil, nil, severitiesEntry)),
+		container.NewTabItem("Domains", container.NewVScroll(domainsBox)),
+		container.NewTabItem("PDF", container.NewBorder(widget.NewLabel("Keywords that mark active content, one per line:"), nil, nil, nil, keywordsEntry)),
+		container.NewTabItem("Rules", container.NewVBox(widget.NewLabel("Leave empty to use the directories in the user's config"), rulesForm)),
+		container.NewTabItem("Integrations", container.NewVBox(widget.NewLabel("Environment variables, e.g. MISP_URL, take precedence"), integrationsForm)),
+	)
+
+	saveButton := widget.NewButton("Save", func() {
+		updated := *settings
+		var err error
+
+		updated.Analyzers.Disabled = []string{}
+
+		for _, analyzer := range files.Analyzers() {
+			if !analyzerChecks[analyzer.Name()].Checked {
+				updated.Analyzers.Disabled = append(updated.Analyzers.Disabled, analyzer.Name())
+			}
+		}
+
+		if updated.Analyzers.Timeouts, err = parseTimeoutLines(timeoutsEntry.Text); err != nil {
+			launchErrorDialog(err, settingsWindow)
+			return
+		}
+
+		if err := parseLimits(&updated.Limits, limitEntries{
+			timeout:      timeoutEntry.Text,
+			depth:        depthEntry.Text,
+			upload:       uploadEntry.Text,
+			input:        inputEntry.Text,
+			decompressed: decompressedEntry.Text,
+			attachments:  attachmentsEntry.Text,
+			objects:      objectsEntry.Text,
+		}); err != nil {
+			launchErrorDialog(err, settingsWindow)
+			return
+		}
+
+		if updated.UI, err = parseWindowSize(widthEntry.Text, heightEntry.Text); err != nil {
+			launchErrorDialog(err, settingsWindow)
+			return
+		}
+
+		if updated.Severities, err = parseSettingLines(severitiesEntry.Text); err != nil {
+			launchErrorDialog(err, settingsWindow)
+			return
+		}
+
+		updated.Domains = config.Domains{
+			Common:         parseListLines(commonEntry.Text),
+			Uncommon:       parseListLines(uncommonEntry.Text),
+			UseBuiltinList: builtinCheck.Checked,
+		}
+
+		if updated.PDF.Keywords, err = parseKeywordLines(keywordsEntry.Text); err != nil {
+			launchErrorDialog(err, settingsWindow)
+			return
+		}
+
+		updated.Rules = config.Rules{
+			YARA:        strings.TrimSpace(yaraEntry.Text),
+			Email:       strings.TrimSpace(emailEntry.Text),
+			AllowHashes: strings.TrimSpace(allowEntry.Text),
+			DenyHashes:  strings.TrimSpace(denyEntry.Text),
+		}
+
+		updated.Integrations.MISP = config.MISP{URL: strings.TrimSpace(mispURLEntry.Text), APIKey: strings.TrimSpace(mispKeyEntry.Text)}
+		updated.Integrations.GoogleSafeBrowsing.APIKey = strings.TrimSpace(googleKeyEntry.Text)
+		updated.Integrations.URLScan.APIKey = strings.TrimSpace(urlscanKeyEntry.Text)
+
+		if err := saveSettings(&updated); err != nil {
+			launchErrorDialog(err, settingsWindow)
+			return
+		}
+
+		window.Resize(fyne.NewSize(settings.UI.WindowWidth, settings.UI.WindowHeight))
+		settingsWindow.Close()
+	})
+	saveButton.Importance = widget.HighImportance
+
+	buttons := container.NewHBox(
+		widget.NewButton("Cancel", settingsWindow.Close),
+		widget.NewButton("Defaults", func() {
+			settingsWindow.Close()
+			showDefaultSettings()
+		}),
+		saveButton,
+	)
+
+	settingsWindow.SetContent(container.NewBorder(nil, container.NewBorder(nil, nil, nil, buttons), nil, nil, tabs))
+	settingsWindow.Resize(fyne.NewSize(settingsWindowWidth, settingsWindowHeight))
+	settingsWindow.Show()
+}
+
+// showDefaultSettings reopens the settings window with the built in settings, which are
+// only kept if they're saved
+func showDefaultSettings() {
+	saved := settings
+	settings = config.Default()
+	settings.Verdict = saved.Verdict
+
+	defer func() {
+		settings = saved
+	}()
+
+	onSettingsButtonClicked()
+}
+
+// saveSettings writes the settings to the file in the user's config, then applies them
+// and reloads the rules from their directories
+func saveSettings(updated *config.Config) error {
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	path, err := config.DefaultPath()
+
+	if err != nil {
+		return err
+	}
+
+	if err := updated.Save(path); err != nil {
+		return err
+	}
+
+	if err := updated.Apply(); err != nil {
+		return err
+	}
+
+	settings = updated
+	loadDefaultRules()
+
+	return nil
+}
+
+func newSettingsLinesEntry(placeHolder, text string) *widget.Entry {
+	entry := widget.NewMultiLineEntry()
+	entry.SetPlaceHolder(placeHolder)
+	entry.SetText(text)
+	entry.SetMinRowsVisible(6)
+	return entry
+}
+
+func newSettingsPathEntry(path string) *widget.Entry {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("the default directory")
+	entry.SetText(path)
+	return entry
+}
+
+func newSettingsKeyEntry(key string) *widget.Entry {
+	entry := widget.NewPasswordEntry()
+	entry.SetText(key)
+	return entry
+}
+
+// formatSettingLines writes the values as "key = value" lines, sorted by key
+func formatSettingLines[V any](values map[string]V) string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("%s = %v", key, values[key])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// parseSettingLines reads "key = value" lines, skipping blank lines
+func parseSettingLines(text string) (map[string]string, error) {
+	values := map[string]string{}
+
+	for _, line := range parseListLines(text) {
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("%q should be a name and a value, e.g. \"pdf = 1m\"", line)
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func parseTimeoutLines(text string) (map[string]time.Duration, error) {
+	values, err := parseSettingLines(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts := make(map[string]time.Duration, len(values))
+
+	for name, value := range values {
+		timeout, err := time.ParseDuration(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("the timeout for %s should be a duration, e.g. 30s, not %q", name, value)
+		}
+
+		timeouts[name] = timeout
+	}
+
+	return timeouts, nil
+}
+
+func formatKeywordLines(keywords []pdf.Keyword) string {
+	lines := make([]string, len(keywords))
+
+	for i, keyword := range keywords {
+		lines[i] = fmt.Sprintf("%s = %s", keyword.Keyword, keyword.Description)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// parseKeywordLines reads "/Keyword = description" lines, keeping their order
+func parseKeywordLines(text string) ([]pdf.Keyword, error) {
+	var keywords []pdf.Keyword
+
+	for _, line := range parseListLines(text) {
+		keyword, description, _ := strings.Cut(line, "=")
+		keyword, description = strings.TrimSpace(keyword), strings.TrimSpace(description)
+
+		if description == "" {
+			return nil, fmt.Errorf("%q should be a keyword and what it means, e.g. \"/JavaScript = embedded script\"", line)
+		}
+
+		keywords = append(keywords, pdf.Keyword{Keyword: keyword, Description: description})
+	}
+
+	return keywords, nil
+}
+
+// parseListLines returns the non-blank lines, trimmed
+func parseListLines(text string) []string {
+	list := []string{}
+
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			list = append(list, line)
+		}
+	}
+
+	return list
+}
+
+// limitEntries is the text of the limits' entries
+type limitEntries struct {
+	timeout      string
+	depth        string
+	upload       string
+	input        string
+	decompressed string
+	attachments  string
+	objects      string
+}
+
+// parseLimits sets the limits that can be edited, leaving the rest as they are
+func parseLimits(limits *config.Limits, entries limitEntries) error {
+	var err error
+
+	if limits.Timeout, err = time.ParseDuration(strings.TrimSpace(entries.timeout)); err != nil {
+		return fmt.Errorf("the timeout should be a duration, e.g. 30s, not %q", entries.timeout)
+	}
+
+	for _, number := range []struct {
+		name  string
+		text  string
+		value *int64
+	}{
+		{"max upload size", entries.upload, &limits.MaxUploadMB},
+		{"max file size", entries.input, &limits.MaxInputMB},
+		{"max decompressed size", entries.decompressed, &limits.MaxDecompressedMB},
+	} {
+		if *number.value, err = strconv.ParseInt(strings.TrimSpace(number.text), 10, 64); err != nil {
+			return fmt.Errorf("the %s should be a number of MB, not %q", number.name, number.text)
+		}
+	}
+
+	for _, number := range []struct {
+		name  string
+		text  string
+		value *int
+	}{
+		{"attachment depth", entries.depth, &limits.MaxAttachmentDepth},
+		{"max attachments", entries.attachments, &limits.MaxAttachments},
+		{"max objects", entries.objects, &limits.MaxObjects},
+	} {
+		if *number.value, err = strconv.Atoi(strings.TrimSpace(number.text)); err != nil {
+			return fmt.Errorf("the %s should be a number, not %q", number.name, number.text)
+		}
+	}
+
+	return nil
+}
+
+func parseWindowSize(widthText, heightText string) (config.UI, error) {
+	width, err := strconv.Atoi(strings.TrimSpace(widthText))
+
+	if err != nil {
+		return config.UI{}, fmt.Errorf("the window width should be a number, not %q", widthText)
+	}
+
+	height, err := strconv.Atoi(strings.TrimSpace(heightText))
+
+	if err != nil {
+		return config.UI{}, fmt.Errorf("the window height should be a number, not %q", heightText)
+	}
+
+	return config.UI{WindowWidth: float32(width), WindowHeight: float32(height)}, nil
+}
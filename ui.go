@@ -1,6 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"file-inspector/files"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
@@ -16,13 +22,26 @@ const (
 	filePropertiesText = "File Properties"
 	fileNameText       = "File Name:\t\t"
 	hashLabelText      = "SHA256 Hash:\t"
+	md5LabelText       = "MD5 Hash:\t\t"
+	sha1LabelText      = "SHA1 Hash:\t\t"
+	sha512LabelText    = "SHA512 Hash:\t"
+	ssdeepLabelText    = "SSDeep:\t\t"
+	tlshLabelText      = "TLSH:\t\t\t"
 	fileTypeText       = "File Type:\t\t"
 	fileSizeText       = "File Size:\t\t"
+	verdictText        = "Verdict:\t\t"
+	historyText        = "History:\t\t"
 	fileAnalysisText   = "File Analysis"
 
 	metadataTableNumColumns       = 2
 	metadataTableFieldColumnID    = 0
 	metadataTableFieldColumnWidth = 200
+
+	iocTableNumColumns       = 3
+	iocTableTypeColumnID     = 0
+	iocTableTypeColumnWidth  = 140
+	iocTableValueColumnID    = 1
+	iocTableValueColumnWidth = 400
 )
 
 func buildUI() *fyne.Container {
@@ -45,6 +64,28 @@ func buildUI() *fyne.Container {
 	hashAndLabel := getBoundStringAndLabelContainer(hashLabelText, fileHashBS)
 	props.Add(hashAndLabel)
 
+	// other hashes, as a lot of threat intel still uses MD5 and SHA1
+	fileMD5BS = binding.NewString()
+	md5AndLabel := getBoundStringAndLabelContainer(md5LabelText, fileMD5BS)
+	props.Add(md5AndLabel)
+
+	fileSHA1BS = binding.NewString()
+	sha1AndLabel := getBoundStringAndLabelContainer(sha1LabelText, fileSHA1BS)
+	props.Add(sha1AndLabel)
+
+	fileSHA512BS = binding.NewString()
+	sha512AndLabel := getTruncatedStringAndLabelContainer(sha512LabelText, fileSHA512BS)
+	props.Add(sha512AndLabel)
+
+	// fuzzy hashes, for finding similar files
+	fileSSDeepBS = binding.NewString()
+	ssdeepAndLabel := getTruncatedStringAndLabelContainer(ssdeepLabelText, fileSSDeepBS)
+	props.Add(ssdeepAndLabel)
+
+	fileTLSHBS = binding.NewString()
+	tlshAndLabel := getTruncatedStringAndLabelContainer(tlshLabelText, fileTLSHBS)
+	props.Add(tlshAndLabel)
+
 	// file mime type
 	fileTypeBS = binding.NewString()
 	typeAndLabel := getBoundStringAndLabelContainer(fileTypeText, fileTypeBS)
@@ -55,6 +96,16 @@ func buildUI() *fyne.Container {
 	sizeAndLabel := getBoundStringAndLabelContainer(fileSizeText, fileSizeBS)
 	props.Add(sizeAndLabel)
 
+	// verdict and score
+	verdictBS = binding.NewString()
+	verdictAndLabel := getBoundStringAndLabelContainer(verdictText, verdictBS)
+	props.Add(verdictAndLabel)
+
+	// whether we've seen the file before
+	historyBS = binding.NewString()
+	historyAndLabel := getBoundStringAndLabelContainer(historyText, historyBS)
+	props.Add(historyAndLabel)
+
 	// add file analysis section
 	props.Add(widget.NewSeparator())
 	props.Add(widget.NewLabelWithStyle(fileAnalysisText, fyne.TextAlignCenter, headingStyle))
@@ -82,6 +133,16 @@ func buildUI() *fyne.Container {
 	metadataTable.SetColumnWidth(metadataTableFieldColumnID, metadataTableFieldColumnWidth)
 	metadataBox := container.NewScroll(metadataTable)
 
+	// table of indicators of compromise, a row per indicator
+	iocTableData = getIOCTableData(nil)
+	iocTable = getIOCTable()
+	iocBox := container.NewScroll(iocTable)
+
+	// tree of attachment results, with the ticked ones extracted by the button below it
+	attachmentTree = getAttachmentTree()
+	extractButton = widget.NewButtonWithIcon("Extract Attachments", theme.DownloadIcon(), onExtractButtonClicked)
+	extractButton.Disable()
+
 	// add text for the middle tabs
 
 	analysisTextBS = binding.NewString()
@@ -103,7 +164,13 @@ func buildUI() *fyne.Container {
 	icons.Add(errorLabel)
 	icons.Add(errorSeparator)
 
-	// Danger icon - only show where we find something suspicious
+	// Suspicious icon - where the score is high enough to be worth a closer look
+	suspiciousIcon, suspiciousLabel, suspiciousSeparator = getIconAndLabel("Suspicious", true, warningIconType)
+	icons.Add(suspiciousIcon)
+	icons.Add(suspiciousLabel)
+	icons.Add(suspiciousSeparator)
+
+	// Danger icon - only show where the verdict is malicious
 	dangerIcon, dangerLabel, dangerSeparator = getIconAndLabel("Danger", true, warningIconType)
 	icons.Add(dangerIcon)
 	icons.Add(dangerLabel)
@@ -115,8 +182,25 @@ func buildUI() *fyne.Container {
 	buttons := container.NewHBox()
 	openButton = widget.NewButtonWithIcon("Select File", theme.FileIcon(), onOpenButtonClicked)
 
+	selectFolderButton = widget.NewButtonWithIcon("Select Folder", theme.FolderOpenIcon(), onSelectFolderButtonClicked)
+
 	buttons.Add(openButton)
+	saveReportButton = widget.NewButtonWithIcon("Save Report", theme.DocumentSaveIcon(), onSaveReportButtonClicked)
+	saveReportButton.Disable()
+
+	compareButton = widget.NewButtonWithIcon("Compare", theme.ContentCopyIcon(), onCompareButtonClicked)
+	compareButton.Disable()
+
+	notesButton = widget.NewButtonWithIcon("Notes", theme.DocumentCreateIcon(), onNotesButtonClicked)
+	notesButton.Disable()
+
+	buttons.Add(selectFolderButton)
+	buttons.Add(saveReportButton)
+	buttons.Add(compareButton)
+	buttons.Add(notesButton)
+	buttons.Add(widget.NewButtonWithIcon("History", theme.HistoryIcon(), onHistoryButtonClicked))
 	buttons.Add(widget.NewButtonWithIcon("Reset", theme.MediaReplayIcon(), onResetButtonClicked))
+	buttons.Add(widget.NewButtonWithIcon("Settings", theme.SettingsIcon(), onSettingsButtonClicked))
 
 	buttonsAndIcons := container.NewVBox()
 	iconSeparator.Hide()
@@ -129,6 +213,8 @@ func buildUI() *fyne.Container {
 	centreBox := container.NewAppTabs(
 		container.NewTabItem("Content", analysisBox),
 		container.NewTabItem("Metadata", metadataBox),
+		container.NewTabItem("IOCs", iocBox),
+		container.NewTabItem("Attachments", container.NewBorder(nil, extractButton, nil, nil, attachmentTree)),
 	)
 
 	// set layout to borders
@@ -137,6 +223,113 @@ func buildUI() *fyne.Container {
 	return content
 }
 
+// Table of indicators of compromise. Selecting a row copies the value, to paste into other tools
+func getIOCTable() *widget.Table {
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(iocTableData), iocTableNumColumns
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("IOC")
+		},
+		func(i widget.TableCellID, o fyne.CanvasObject) {
+			if o.(*widget.Label) != nil {
+				o.(*widget.Label).SetText(iocTableData[i.Row][i.Col])
+			}
+		},
+	)
+
+	table.OnSelected = func(id widget.TableCellID) {
+		table.UnselectAll()
+
+		// the first row is the headings
+		if id.Row == 0 {
+			return
+		}
+
+		window.Clipboard().SetContent(iocTableData[id.Row][iocTableValueColumnID])
+	}
+
+	table.SetColumnWidth(iocTableTypeColumnID, iocTableTypeColumnWidth)
+	table.SetColumnWidth(iocTableValueColumnID, iocTableValueColumnWidth)
+
+	return table
+}
+
+// getIOCTableData returns the headings plus a row for each of the result's indicators
+func getIOCTableData(result *files.ProcessResult) [][]string {
+	data := [][]string{
+		{"Type", "Value", "Found In"},
+	}
+
+	if result == nil {
+		return data
+	}
+
+	for _, found := range result.IOCs {
+		data = append(data, []string{found.Type.Title(), found.Value, strings.Join(found.Sources, ", ")})
+	}
+
+	return data
+}
+
+// Tree of attachment results. Node IDs are the path of indexes to the result, e.g. "0/1"
+// is the second attachment of the first attachment. Each has a box to tick it for extraction
+func getAttachmentTree() *widget.Tree {
+	return widget.NewTree(
+		func(id widget.TreeNodeID) []widget.TreeNodeID {
+			children := attachmentResults
+
+			if id != "" {
+				children = getAttachmentResult(id).Children
+			}
+
+			ids := make([]widget.TreeNodeID, len(children))
+
+			for i := range children {
+				if id == "" {
+					ids[i] = strconv.Itoa(i)
+				} else {
+					ids[i] = id + "/" + strconv.Itoa(i)
+				}
+			}
+
+			return ids
+		},
+		func(id widget.TreeNodeID) bool {
+			return id == "" || len(getAttachmentResult(id).Children) > 0
+		},
+		func(branch bool) fyne.CanvasObject {
+			return widget.NewCheck("Attachment", nil)
+		},
+		func(id widget.TreeNodeID, branch bool, o fyne.CanvasObject) {
+			result := getAttachmentResult(id)
+			check := o.(*widget.Check)
+
+			// the node is reused, so don't let setting it change the selection
+			check.OnChanged = nil
+			check.SetChecked(extractSelection[id])
+			check.SetText(fmt.Sprintf("%s: %s", result.Name, result.Summary()))
+			check.OnChanged = func(checked bool) {
+				extractSelection[id] = checked
+			}
+		},
+	)
+}
+
+func getAttachmentResult(id widget.TreeNodeID) *files.ProcessResult {
+	var result *files.ProcessResult
+	children := attachmentResults
+
+	for _, part := range strings.Split(id, "/") {
+		index, _ := strconv.Atoi(part)
+		result = children[index]
+		children = result.Children
+	}
+
+	return result
+}
+
 func hideIconAndLabel(icon *widget.Icon, label *widget.Label, sep *widget.Separator) {
 	icon.Hide()
 	label.Hide()
@@ -178,6 +371,19 @@ func getBoundStringAndLabelContainer(labelText string, boundString binding.Strin
 	return typeAndLabel
 }
 
+// getTruncatedStringAndLabelContainer is for values too long for the window, like the
+// SHA512 hash, which are cut short with an ellipsis rather than widening it
+func getTruncatedStringAndLabelContainer(labelText string, boundString binding.String) *fyne.Container {
+	titleStyle := fyne.TextStyle{
+		Bold: true,
+	}
+
+	value := widget.NewLabelWithData(boundString)
+	value.Truncation = fyne.TextTruncateEllipsis
+
+	return container.NewBorder(nil, nil, widget.NewLabelWithStyle(labelText, fyne.TextAlignLeading, titleStyle), nil, value)
+}
+
 func getScrollContainer(labelText string, boundString binding.String) *container.Scroll {
 	boundString.Set(labelText)
 	textBox := widget.NewLabelWithData(boundString)
@@ -0,0 +1,338 @@
+// Package limits caps how much work the parsers do on a file, so a hostile one, e.g. a
+// 40 KB zip bomb or a .msg file claiming a 4 GB stream, is reported rather than using up
+// all the memory. Each file analysed gets a Budget, handed to the parsers in its context
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	DefaultMaxInputSize        = 256 << 20
+	DefaultMaxDecompressedSize = 512 << 20
+	DefaultMaxAttachments      = 100
+	DefaultMaxDepth            = 3
+	DefaultMaxObjects          = 100_000
+)
+
+// the names of the limits, used in errors and finding IDs
+const (
+	InputSize        = "input-size"
+	DecompressedSize = "decompressed-size"
+	Attachments      = "attachments"
+	Depth            = "depth"
+	Objects          = "objects"
+)
+
+// ErrExceeded is wrapped by every ExceededError, for errors.Is
+var ErrExceeded = errors.New("resource limit exceeded")
+
+// Limits are the most a file can use
+type Limits struct {
+	// MaxInputSize is the largest file, or attachment, analysed
+	MaxInputSize int64
+
+	// MaxDecompressedSize is the most that's read out of a file and its attachments once
+	// decompressed or decoded, e.g. zip entries, PDF streams and base64 attachments
+	MaxDecompressedSize int64
+
+	// MaxAttachments is the most attachments a file can have analysed
+	MaxAttachments int
+
+	// MaxDepth is how deeply nested attachments are analysed, e.g. a PDF attached to an
+	// email attached to an email is at depth 2
+	MaxDepth int
+
+	// MaxObjects is the most entries, e.g. zip entries or PDF objects, read from a file and its attachments
+	MaxObjects int
+}
+
+// Default returns the built in limits
+func Default() Limits {
+	return Limits{
+		MaxInputSize:        DefaultMaxInputSize,
+		MaxDecompressedSize: DefaultMaxDecompressedSize,
+		MaxAttachments:      DefaultMaxAttachments,
+		MaxDepth:            DefaultMaxDepth,
+		MaxObjects:          DefaultMaxObjects,
+	}
+}
+
+// Validate checks the limits make sense
+func (l Limits) Validate() error {
+	if l.MaxInputSize < 1 || l.MaxDecompressedSize < 1 {
+		return fmt.Errorf("the max input and decompressed sizes must be more than zero")
+	}
+
+	if l.MaxAttachments < 0 || l.MaxDepth < 0 {
+		return fmt.Errorf("the max attachments and depth can't be negative")
+	}
+
+	if l.MaxObjects < 1 {
+		return fmt.Errorf("the max objects must be more than zero, not %d", l.MaxObjects)
+	}
+
+	return nil
+}
+
+var (
+	limitsMu sync.RWMutex
+	current  = Default()
+)
+
+// Set changes the limits for files analysed from now on
+func Set(limits Limits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+
+	current = limits
+}
+
+// Get returns the limits files are analysed with
+func Get() Limits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+
+	return current
+}
+
+// ExceededError says which limit was reached and where
+type ExceededError struct {
+	// Limit is the name of the limit, e.g. "decompressed-size"
+	Limit string
+
+	// Max is the limit's value
+	Max int64
+
+	// Where is what was being read when it was reached, e.g. "word/document.xml"
+	Where string
+}
+
+func (e *ExceededError) Error() string {
+	text := fmt.Sprintf("%s: %s over %s", ErrExceeded.Error(), e.Limit, e.describeMax())
+
+	if e.Where != "" {
+		text += " reading " + e.Where
+	}
+
+	return text
+}
+
+func (e *ExceededError) Unwrap() error {
+	return ErrExceeded
+}
+
+// e.g. "64 MB" or "100"
+func (e *ExceededError) describeMax() string {
+	if e.Limit == InputSize || e.Limit == DecompressedSize {
+		if e.Max >= 1<<20 {
+			return fmt.Sprintf("%d MB", e.Max>>20)
+		}
+
+		return fmt.Sprintf("%d bytes", e.Max)
+	}
+
+	return fmt.Sprintf("%d", e.Max)
+}
+
+// usage is shared by a file and its attachments, so nesting a bomb doesn't multiply the limits
+type usage struct {
+	mu           sync.Mutex
+	decompressed int64
+	objects      int
+}
+
+// Budget is what's left of the limits for a file. It's safe to use from more than one
+// goroutine, as an analyzer that's run out of time can still be running
+type Budget struct {
+	limits Limits
+	used   *usage
+
+	mu       sync.Mutex
+	exceeded []*ExceededError
+}
+
+// NewBudget returns a budget for a file with the current limits
+func NewBudget() *Budget {
+	return &Budget{limits: Get(), used: &usage{}}
+}
+
+// Child returns a budget for an attachment, which has its own record of the limits it
+// reaches but shares the decompressed bytes and objects with the file it's in
+func (b *Budget) Child() *Budget {
+	return &Budget{limits: b.limits, used: b.used}
+}
+
+// Limits returns the limits the budget was made with
+func (b *Budget) Limits() Limits {
+	return b.limits
+}
+
+// Exceeded returns the limits reached by this file, not its attachments, once each
+func (b *Budget) Exceeded() []*ExceededError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*ExceededError(nil), b.exceeded...)
+}
+
+// exceed records the limit was reached and returns the error for it
+func (b *Budget) exceed(limit string, max int64, where string) error {
+	err := &ExceededError{Limit: limit, Max: max, Where: where}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, previous := range b.exceeded {
+		if previous.Limit == limit {
+			return err
+		}
+	}
+
+	b.exceeded = append(b.exceeded, err)
+
+	return err
+}
+
+// CheckInputSize returns an error if the file's too big to analyse
+func (b *Budget) CheckInputSize(size int64, where string) error {
+	if size > b.limits.MaxInputSize {
+		return b.exceed(InputSize, b.limits.MaxInputSize, where)
+	}
+
+	return nil
+}
+
+// CheckAttachments returns an error if there are more attachments than can be analysed
+func (b *Budget) CheckAttachments(count int, where string) error {
+	if count > b.limits.MaxAttachments {
+		return b.exceed(Attachments, int64(b.limits.MaxAttachments), where)
+	}
+
+	return nil
+}
+
+// CheckDepth returns an error if attachments at the depth are nested too deeply to analyse
+func (b *Budget) CheckDepth(depth int, where string) error {
+	if depth > b.limits.MaxDepth {
+		return b.exceed(Depth, int64(b.limits.MaxDepth), where)
+	}
+
+	return nil
+}
+
+// AddObject counts an object read from the file, returning an error once there are too many
+func (b *Budget) AddObject(where string) error {
+	b.used.mu.Lock()
+	b.used.objects++
+	over := b.used.objects > b.limits.MaxObjects
+	b.used.mu.Unlock()
+
+	if over {
+		return b.exceed(Objects, int64(b.limits.MaxObjects), where)
+	}
+
+	return nil
+}
+
+// Allocate takes n bytes from the decompressed budget before they're read, e.g. for a
+// buffer the size a header claims. Nothing's taken if there isn't enough left
+func (b *Budget) Allocate(n int64, where string) error {
+	b.used.mu.Lock()
+	over := n < 0 || b.used.decompressed+n > b.limits.MaxDecompressedSize
+
+	if !over {
+		b.used.decompressed += n
+	}
+
+	b.used.mu.Unlock()
+
+	if over {
+		return b.exceed(DecompressedSize, b.limits.MaxDecompressedSize, where)
+	}
+
+	return nil
+}
+
+// reserve takes up to n bytes from the decompressed budget, returning how many it took,
+// which is none once it's used up
+func (b *Budget) reserve(n int64) int64 {
+	b.used.mu.Lock()
+	defer b.used.mu.Unlock()
+
+	n = min(n, b.limits.MaxDecompressedSize-b.used.decompressed)
+
+	if n <= 0 {
+		return 0
+	}
+
+	b.used.decompressed += n
+
+	return n
+}
+
+// release gives back bytes reserved but not used
+func (b *Budget) release(n int64) {
+	b.used.mu.Lock()
+	defer b.used.mu.Unlock()
+
+	b.used.decompressed -= n
+}
+
+// Reader counts what's read from r against the decompressed budget, returning an
+// ExceededError instead of reading any more once it's used up. What's read before then
+// is still returned
+func (b *Budget) Reader(r io.Reader, where string) io.Reader {
+	return &budgetReader{r: r, budget: b, where: where}
+}
+
+type budgetReader struct {
+	r      io.Reader
+	budget *Budget
+	where  string
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// take the bytes from the budget before reading them, so nothing's read once it's
+	// used up and readers sharing it can't go over between them
+	reserved := br.budget.reserve(int64(len(p)))
+
+	if reserved == 0 {
+		return 0, br.budget.exceed(DecompressedSize, br.budget.limits.MaxDecompressedSize, br.where)
+	}
+
+	n, err := br.r.Read(p[:reserved])
+	br.budget.release(reserved - int64(n))
+
+	return n, err
+}
+
+// ReadAll reads r to the end, or up to max bytes, against the decompressed budget
+func (b *Budget) ReadAll(r io.Reader, max int64, where string) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(b.Reader(r, where), max))
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context the parsers take the budget from
+func WithBudget(ctx context.Context, budget *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// FromContext returns the context's budget, or a new one with the current limits if it
+// hasn't got one, e.g. when a parser's used on its own
+func FromContext(ctx context.Context) *Budget {
+	if budget, ok := ctx.Value(budgetKey{}).(*Budget); ok {
+		return budget
+	}
+
+	return NewBudget()
+}
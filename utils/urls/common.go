@@ -4,6 +4,7 @@ import (
 	"embed"
 	"fmt"
 	"strings"
+	"sync"
 
 	"file-inspector/utils"
 )
@@ -15,6 +16,39 @@ const (
 	alexafilePath = "alexa-top-100000.txt"
 )
 
+// DomainLists change which domains are counted as common
+type DomainLists struct {
+	// Common domains, and their subdomains, are counted as common, e.g. your own
+	Common []string
+
+	// Uncommon domains, and their subdomains, never are, even if they're in the built
+	// in list, e.g. file sharing sites
+	Uncommon []string
+
+	// NoBuiltin turns the Alexa list off, so only the Common domains are common
+	NoBuiltin bool
+}
+
+var (
+	domainListsMu sync.RWMutex
+	domainLists   DomainLists
+)
+
+// SetDomainLists changes which domains are counted as common by the checkers
+func SetDomainLists(lists DomainLists) {
+	domainListsMu.Lock()
+	defer domainListsMu.Unlock()
+
+	domainLists = lists
+}
+
+func getDomainLists() DomainLists {
+	domainListsMu.RLock()
+	defer domainListsMu.RUnlock()
+
+	return domainLists
+}
+
 type URLChecker interface {
 	Check(urlString string) (bool, error)
 	CountKnownDomains() int
@@ -54,6 +88,19 @@ func (c *CommonChecker) Check(urlString string) (bool, error) {
 	}
 
 	hostname := utils.GetHostFromURL(urlString)
+	lists := getDomainLists()
+
+	if inDomains(hostname, lists.Uncommon) {
+		return false, nil
+	}
+
+	if inDomains(hostname, lists.Common) {
+		return true, nil
+	}
+
+	if lists.NoBuiltin {
+		return false, nil
+	}
 
 	if _, ok := c.data[hostname]; ok {
 		return true, nil
@@ -62,6 +109,19 @@ func (c *CommonChecker) Check(urlString string) (bool, error) {
 	return false, nil
 }
 
+// inDomains returns true if the hostname is one of the domains or a subdomain of one
+func inDomains(hostname string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+
+		if domain != "" && (hostname == domain || strings.HasSuffix(hostname, "."+domain)) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func loadAlexTop1000() map[string]bool {
 	bytes, _ := alexaData.ReadFile(alexafilePath)
 	lines := strings.Split(string(bytes), "\n")
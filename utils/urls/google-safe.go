@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 )
 
 const (
@@ -25,9 +26,34 @@ func CheckGoogleSafeBrowsing(urlString string) (bool, error) {
 	return false, nil
 }
 
+// the key from the settings, used if the environment variable isn't set
+var (
+	googleAPIKeyMu sync.RWMutex
+	googleAPIKey   string
+)
+
+// SetGoogleAPIKey sets the key to use if the GOOGLE_API_KEY environment variable isn't set
+func SetGoogleAPIKey(key string) {
+	googleAPIKeyMu.Lock()
+	defer googleAPIKeyMu.Unlock()
+
+	googleAPIKey = key
+}
+
+func getGoogleAPIKey() string {
+	googleAPIKeyMu.RLock()
+	defer googleAPIKeyMu.RUnlock()
+
+	return googleAPIKey
+}
+
 func getGoogleAPIKeyFromEnv() (string, error) {
 	key := os.Getenv(google_api_key_env)
 
+	if key == "" {
+		key = getGoogleAPIKey()
+	}
+
 	if key == "" {
 		return "", fmt.Errorf("failed. We need a Google API key set as the environment variable %q. See https://cloud.google.com/docs/authentication/api-keys?hl=en&ref_topic=6262490&visit_id=638259789827230846-2716597661&rd=1 for more", google_api_key_env)
 	}
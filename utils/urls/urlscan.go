@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"text/tabwriter"
 	"time"
 
@@ -118,9 +119,34 @@ func SubmitToURLScan(host string) error {
 	return nil
 }
 
+// the key from the settings, used if the environment variable isn't set
+var (
+	urlscanAPIKeyMu sync.RWMutex
+	urlscanAPIKey   string
+)
+
+// SetURLScanAPIKey sets the key to use if the URLSCAN_API_KEY environment variable isn't set
+func SetURLScanAPIKey(key string) {
+	urlscanAPIKeyMu.Lock()
+	defer urlscanAPIKeyMu.Unlock()
+
+	urlscanAPIKey = key
+}
+
+func getURLScanAPIKey() string {
+	urlscanAPIKeyMu.RLock()
+	defer urlscanAPIKeyMu.RUnlock()
+
+	return urlscanAPIKey
+}
+
 func getURLScanAPIKeyFromEnv() (string, error) {
 	key := os.Getenv(urlscan_api_key_env)
 
+	if key == "" {
+		key = getURLScanAPIKey()
+	}
+
 	if key == "" {
 		return "", fmt.Errorf("failed. We need a URLScan API key set as the environment variable %q", urlscan_api_key_env)
 	}
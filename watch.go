@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"file-inspector/files/batch"
+	"file-inspector/files/report"
+	"file-inspector/files/watch"
+)
+
+// watchOptions holds the flags for the watch command
+type watchOptions struct {
+	analyseOptions
+	workers      int
+	outputDir    string
+	reportFormat string
+	settleTime   time.Duration
+}
+
+func newWatchFlagSet(programName string, output io.Writer, opts *watchOptions) *flag.FlagSet {
+	flags := newAnalyseFlagSet(programName, output, &opts.analyseOptions)
+
+	// shared with scan and serve, so it's not in the usage twice
+	flags.IntVar(&opts.workers, "workers", batch.DefaultWorkers, "scan, serve and watch: number of files to process at once")
+	addWatchFlags(flags, opts)
+
+	return flags
+}
+
+// addWatchFlags adds the flags only the watch command has
+func addWatchFlags(flags *flag.FlagSet, opts *watchOptions) {
+	flags.StringVar(&opts.outputDir, "out", "", "watch: folder to write reports to, instead of next to each file once it's moved")
+	flags.StringVar(&opts.reportFormat, "report-format", string(report.FormatJSON), "watch: report format: json, html, markdown, stix or misp")
+	flags.DurationVar(&opts.settleTime, "settle", watch.DefaultSettleTime, "watch: how long a file has to be left unchanged before it's analysed")
+}
+
+func runWatchCommand(programName string, args []string) int {
+	var opts watchOptions
+	flags := newWatchFlagSet(programName, os.Stderr, &opts)
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No folders provided to watch")
+		flags.Usage()
+		return exitUsage
+	}
+
+	format := report.Format(opts.reportFormat)
+
+	if format.Extension() == "" {
+		fmt.Fprintf(os.Stderr, "Unknown report format %q\n", opts.reportFormat)
+		return exitUsage
+	}
+
+	if err := applyAnalyseOptions(&opts.analyseOptions); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	watcher, err := watch.New(watch.Config{
+		Dirs:       flags.Args(),
+		OutputDir:  opts.outputDir,
+		Format:     format,
+		SettleTime: opts.settleTime,
+		Workers:    opts.workers,
+		Done:       printWatchOutcome,
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	// stop on Ctrl-C, leaving any file being analysed for next time
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintln(os.Stderr, "Watching for files, press Ctrl-C to stop")
+
+	if err := watcher.Run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitError
+	}
+
+	return exitCompleted
+}
+
+// printWatchOutcome writes a line for each file, e.g.
+// "phish.eml: Malicious (100/100), moved to reports/processed/phish.eml, report ..."
+func printWatchOutcome(outcome watch.Outcome) {
+	summary := "not analysed"
+
+	if outcome.Result != nil {
+		summary = outcome.Result.Summary()
+	}
+
+	line := fmt.Sprintf("%s: %s", outcome.Path, summary)
+
+	if outcome.MovedTo != "" {
+		line += fmt.Sprintf(", moved to %s", outcome.MovedTo)
+	}
+
+	if outcome.ReportPath != "" {
+		line += fmt.Sprintf(", report %s", outcome.ReportPath)
+	}
+
+	fmt.Fprintln(os.Stdout, line)
+
+	if outcome.Err != nil {
+		fmt.Fprintln(os.Stderr, outcome.Err.Error())
+	}
+}